@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Interpolation int
+
+const (
+	InterpolateLinear Interpolation = iota
+	InterpolateCatmullRom
+	InterpolateEase // Smoothstep in time, linear in value
+)
+
+func ParseInterpolation(name string) (Interpolation, error) {
+	switch name {
+	case "", "linear":
+		return InterpolateLinear, nil
+	case "catmull-rom":
+		return InterpolateCatmullRom, nil
+	case "ease":
+		return InterpolateEase, nil
+	}
+	return 0, fmt.Errorf("unknown interpolation %q (want linear, catmull-rom or ease)", name)
+}
+
+// Keyframe is a camera pose at a point in time. Interpolation applies to the
+// segment that starts at this keyframe. A FOV of zero leaves the camera's
+// field of view alone.
+type Keyframe struct {
+	Time          float32 // Seconds
+	Position      Vec3
+	Target        Vec3
+	Up            Vec3
+	FOV           float32 // Degrees
+	Interpolation Interpolation
+}
+
+// Timeline is a camera path sampled at FPS frames per second.
+type Timeline struct {
+	FPS       float32
+	Keyframes []Keyframe // Sorted by Time
+}
+
+func NewTimeline(fps float32, keyframes []Keyframe) *Timeline {
+	keyframes = append([]Keyframe(nil), keyframes...)
+	sort.SliceStable(keyframes, func(i, j int) bool { return keyframes[i].Time < keyframes[j].Time })
+	return &Timeline{FPS: fps, Keyframes: keyframes}
+}
+
+// FrameCount is the number of frames from the first to the last keyframe,
+// both included.
+func (t *Timeline) FrameCount() int {
+	if len(t.Keyframes) == 0 {
+		return 0
+	}
+	duration := t.Keyframes[len(t.Keyframes)-1].Time - t.Keyframes[0].Time
+	return int(duration*t.FPS+1e-3) + 1
+}
+
+func (t *Timeline) FrameTime(frame int) float32 {
+	return t.Keyframes[0].Time + float32(frame)/t.FPS
+}
+
+// Evaluate returns the interpolated pose at time, holding the first and last
+// keyframes outside the timeline.
+func (t *Timeline) Evaluate(time float32) Keyframe {
+	keys := t.Keyframes
+	if time <= keys[0].Time {
+		return keys[0]
+	}
+	if time >= keys[len(keys)-1].Time {
+		return keys[len(keys)-1]
+	}
+
+	i := sort.Search(len(keys), func(i int) bool { return keys[i].Time > time }) - 1
+	k0, k1 := keys[i], keys[i+1]
+	s := (time - k0.Time) / (k1.Time - k0.Time)
+
+	pose := Keyframe{Time: time, Interpolation: k0.Interpolation}
+	switch k0.Interpolation {
+	case InterpolateCatmullRom:
+		// Clamp the neighbours at both ends of the timeline.
+		prev, next := keys[max(i-1, 0)], keys[min(i+2, len(keys)-1)]
+		pose.Position = catmullRom(prev.Position, k0.Position, k1.Position, next.Position, s)
+		pose.Target = catmullRom(prev.Target, k0.Target, k1.Target, next.Target, s)
+		pose.Up = catmullRom(prev.Up, k0.Up, k1.Up, next.Up, s)
+		pose.FOV = catmullRom(Vec3{X: prev.FOV}, Vec3{X: k0.FOV}, Vec3{X: k1.FOV}, Vec3{X: next.FOV}, s).X
+		return pose
+	case InterpolateEase:
+		s = s * s * (3 - 2*s)
+	}
+	pose.Position = lerp(k0.Position, k1.Position, s)
+	pose.Target = lerp(k0.Target, k1.Target, s)
+	pose.Up = lerp(k0.Up, k1.Up, s)
+	pose.FOV = k0.FOV + (k1.FOV-k0.FOV)*s
+	return pose
+}
+
+// Apply moves the camera to the pose of the given frame.
+func (t *Timeline) Apply(camera *Camera, frame int) {
+	pose := t.Evaluate(t.FrameTime(frame))
+	camera.LookAt(pose.Position, pose.Target, pose.Up)
+	if pose.FOV > 0 {
+		camera.FOV = pose.FOV
+		camera.FocalLength = 0
+	}
+}
+
+func lerp(a, b Vec3, s float32) Vec3 {
+	return a.Add(b.Sub(a).Scale(s))
+}
+
+// catmullRom evaluates the uniform Catmull-Rom spline through p1 and p2.
+func catmullRom(p0, p1, p2, p3 Vec3, s float32) Vec3 {
+	s2 := s * s
+	s3 := s2 * s
+	return p0.Scale(-0.5*s3 + s2 - 0.5*s).
+		Add(p1.Scale(1.5*s3 - 2.5*s2 + 1)).
+		Add(p2.Scale(-1.5*s3 + 2*s2 + 0.5*s)).
+		Add(p3.Scale(0.5*s3 - 0.5*s2))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+func TestTimelineEvaluate(t *testing.T) {
+	up := Vec3{Y: 1}
+	keys := []Keyframe{
+		// Out of order, to be sorted.
+		{Time: 3, Position: Vec3{X: 4, Y: 2}, Target: Vec3{Z: 1}, Up: up, FOV: 40, Interpolation: InterpolateCatmullRom},
+		{Time: 0, Position: Vec3{}, Target: Vec3{Z: 1}, Up: up, FOV: 30, Interpolation: InterpolateLinear},
+		{Time: 2, Position: Vec3{X: 4}, Target: Vec3{Z: 3}, Up: up, FOV: 50, Interpolation: InterpolateEase},
+		{Time: 5, Position: Vec3{Y: 2}, Target: Vec3{Z: 1}, Up: Vec3{X: 1}, FOV: 60},
+	}
+	timeline := NewTimeline(30, keys)
+	for i := 1; i < len(timeline.Keyframes); i++ {
+		if timeline.Keyframes[i].Time < timeline.Keyframes[i-1].Time {
+			t.Fatalf("keyframes not sorted: %v", timeline.Keyframes)
+		}
+	}
+	if keys[0].Time != 3 {
+		t.Error("NewTimeline sorted the caller's keyframes")
+	}
+
+	// Keyframe times give the keyframes, whatever the interpolation into
+	// them; outside the timeline the ends are held.
+	for _, key := range keys {
+		got := timeline.Evaluate(key.Time)
+		if !near(got.Position, key.Position) || !near(got.Target, key.Target) || !near(got.Up, key.Up) || got.FOV != key.FOV {
+			t.Errorf("at %gs the pose is %+v, want the keyframe %+v", key.Time, got, key)
+		}
+	}
+	if got := timeline.Evaluate(-1); got != timeline.Keyframes[0] {
+		t.Errorf("before the timeline the pose is %+v, want the first keyframe", got)
+	}
+	if got := timeline.Evaluate(10); got != timeline.Keyframes[3] {
+		t.Errorf("after the timeline the pose is %+v, want the last keyframe", got)
+	}
+
+	tests := []struct {
+		name     string
+		time     float32
+		position Vec3
+		target   Vec3
+		fov      float32
+	}{
+		{"linear midpoint", 1, Vec3{X: 2}, Vec3{Z: 2}, 40},
+		{"linear quarter", 0.5, Vec3{X: 1}, Vec3{Z: 1.5}, 35},
+		// Smoothstep: 0.5 stays, 0.25 becomes 0.15625.
+		{"ease midpoint", 2.5, Vec3{X: 4, Y: 1}, Vec3{Z: 2}, 45},
+		{"ease quarter", 2.25, Vec3{X: 4, Y: 0.3125}, Vec3{Z: 2.6875}, 48.4375},
+		// (-p0 + 9 p1 + 9 p2 - p3) / 16, with the last keyframe standing
+		// in for the one after it.
+		{"Catmull-Rom midpoint", 4, Vec3{X: 2, Y: 2.125}, Vec3{Z: 0.875}, 49.375},
+	}
+	for _, test := range tests {
+		got := timeline.Evaluate(test.time)
+		if !near(got.Position, test.position) || !near(got.Target, test.target) || math32.Abs(got.FOV-test.fov) > 1e-4 {
+			t.Errorf("%s: pose at %gs is at %v looking at %v with FOV %g, want %v, %v, %g",
+				test.name, test.time, got.Position, got.Target, got.FOV, test.position, test.target, test.fov)
+		}
+		if got.Time != test.time {
+			t.Errorf("%s: pose is for %gs, want %gs", test.name, got.Time, test.time)
+		}
+	}
+}
+
+func TestTimelineFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		fps       float32
+		times     []float32
+		frames    int
+		lastFrame float32 // Time of the last frame
+	}{
+		{"no keyframes", 30, nil, 0, 0},
+		{"one keyframe", 30, []float32{2}, 1, 2},
+		{"one second", 30, []float32{0, 1}, 31, 1},
+		{"late start", 24, []float32{2, 3.5}, 37, 3.5},
+		// 0.1 s at 30 fps is 3 frames, give or take rounding.
+		{"inexact duration", 30, []float32{0, 0.1}, 4, 0.1},
+		// The last frame falls before the last keyframe.
+		{"fractional duration", 10, []float32{0, 1.05}, 11, 1},
+		{"fractional frame rate", 23.976, []float32{0, 1}, 24, 23 / 23.976},
+	}
+	for _, test := range tests {
+		var keys []Keyframe
+		for _, time := range test.times {
+			keys = append(keys, Keyframe{Time: time})
+		}
+		timeline := NewTimeline(test.fps, keys)
+		if got := timeline.FrameCount(); got != test.frames {
+			t.Errorf("%s: %d frames, want %d", test.name, got, test.frames)
+			continue
+		}
+		if test.frames == 0 {
+			continue
+		}
+		if got := timeline.FrameTime(0); got != test.times[0] {
+			t.Errorf("%s: first frame at %gs, want %gs", test.name, got, test.times[0])
+		}
+		if got := timeline.FrameTime(test.frames - 1); math32.Abs(got-test.lastFrame) > 1e-5 {
+			t.Errorf("%s: last frame at %gs, want %gs", test.name, got, test.lastFrame)
+		}
+	}
+}
+
+func TestParseFrameRange(t *testing.T) {
+	tests := []struct {
+		s           string
+		first, last int
+		ok          bool
+	}{
+		{"", 0, 9, true},
+		{"5", 5, 5, true},
+		{"0", 0, 0, true},
+		{"9", 9, 9, true},
+		{"3-7", 3, 7, true},
+		{"4-4", 4, 4, true},
+		{"0-9", 0, 9, true},
+		{"7-3", 0, 0, false},
+		{"-1", 0, 0, false},
+		{"10", 0, 0, false},
+		{"5-10", 0, 0, false},
+		{"3-", 0, 0, false},
+		{"a-b", 0, 0, false},
+		{"3 - 7", 0, 0, false},
+	}
+	for _, test := range tests {
+		first, last, err := parseFrameRange(test.s, 10)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("parseFrameRange(%q) = %d, %d, want an error", test.s, first, last)
+			}
+			continue
+		}
+		if err != nil || first != test.first || last != test.last {
+			t.Errorf("parseFrameRange(%q) = %d, %d, %v, want %d, %d", test.s, first, last, err, test.first, test.last)
+		}
+	}
+}
+
+// With -resume, frames whose files exist are finished and left alone.
+func TestAnimateResume(t *testing.T) {
+	dir := t.TempDir()
+	scene := filepath.Join(dir, "scene.json")
+	if err := os.WriteFile(scene, []byte(`{
+  "camera": { "position": [0, 0, -5], "fov": 40 },
+  "primitives": [{ "type": "sphere", "radius": 1, "material": { "diffuse": [0.5, 0.5, 0.5] } }],
+  "lights": [{ "type": "sun", "color": [1, 1, 1], "intensity": 1, "direction": [0, 1, -1] }],
+  "animation": {
+    "fps": 2,
+    "keyframes": [
+      { "time": 0, "position": [0, 0, -5], "target": [0, 0, 0] },
+      { "time": 1, "position": [5, 0, 0], "target": [0, 0, 0] }
+    ]
+  }
+}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "frames", "frame%d.pfm")
+	frame := func(i int) string { return fmt.Sprintf(out, i) }
+	animate := func(extra ...string) {
+		t.Helper()
+		args := []string{"-scene", scene, "-out", out, "-width", "8", "-height", "6", "-spp", "1", "-threads", "1", "-bvh-cache", ""}
+		if err := runAnimate(append(args, extra...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rendered := func(i int) bool {
+		data, err := os.ReadFile(frame(i))
+		return err == nil && bytes.HasPrefix(data, []byte("PF\n"))
+	}
+
+	animate()
+	for i := range 3 {
+		if !rendered(i) {
+			t.Fatalf("frame %d not written", i)
+		}
+	}
+
+	stale := []byte("left from an earlier run")
+	if err := os.WriteFile(frame(1), stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(frame(2)); err != nil {
+		t.Fatal(err)
+	}
+	animate("-resume")
+	if data, _ := os.ReadFile(frame(1)); !bytes.Equal(data, stale) {
+		t.Error("-resume rendered frame 1 again")
+	}
+	if !rendered(2) {
+		t.Error("-resume did not render the missing frame 2")
+	}
+
+	// Without -resume every frame in the range is rendered again.
+	animate("-frames", "1")
+	if !rendered(1) {
+		t.Error("frame 1 not rendered again without -resume")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("output directory holds %v, want the 3 frames", names)
+	}
+}
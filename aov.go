@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/chewxy/math32"
+)
+
+// AOV is an arbitrary output variable: a per-pixel buffer rendered alongside
+// the beauty pass for denoising and compositing.
+type AOV int
+
+const (
+	AOVBeauty   AOV = iota
+	AOVAlbedo       // Surface colour at the first hit
+	AOVNormal       // Shading normal at the first hit
+	AOVPosition     // World position of the first hit
+	AOVDepth        // Distance from the camera to the first hit, 0 on a miss
+	AOVMaterial     // Material index of the first hit, -1 on a miss
+	AOVObject       // Object index of the first hit, -1 on a miss
+	AOVDirect       // Light reaching the first hit straight from a light source
+	AOVIndirect     // Light reaching the camera after more than one bounce
+	AOVEmission     // Light emitted by the first hit, or the sky on a miss
+	AOVSamples      // Samples taken
+	AOVVariance     // Variance of the beauty samples
+	AOVNodes        // BVH nodes visited for the camera ray until it hit or gave up
+	AOVTests        // Triangles and primitives the camera ray was tested against
+)
+
+// aovInfo names every AOV and its channels in a multi-layer EXR.
+var aovInfo = [...]struct {
+	name     string
+	channels []string
+}{
+	AOVBeauty:   {"beauty", []string{"R", "G", "B"}},
+	AOVAlbedo:   {"albedo", []string{"R", "G", "B"}},
+	AOVNormal:   {"normal", []string{"X", "Y", "Z"}},
+	AOVPosition: {"position", []string{"X", "Y", "Z"}},
+	AOVDepth:    {"depth", []string{"Z"}},
+	AOVMaterial: {"material", []string{"id"}},
+	AOVObject:   {"object", []string{"id"}},
+	AOVDirect:   {"direct", []string{"R", "G", "B"}},
+	AOVIndirect: {"indirect", []string{"R", "G", "B"}},
+	AOVEmission: {"emission", []string{"R", "G", "B"}},
+	AOVSamples:  {"samples", []string{"count"}},
+	AOVVariance: {"variance", []string{"V"}},
+	AOVNodes:    {"nodes", []string{"count"}},
+	AOVTests:    {"tests", []string{"count"}},
+}
+
+// AOVNames lists the AOVs in the order the viewer cycles through them.
+var AOVNames = func() []string {
+	names := make([]string, len(aovInfo))
+	for i, info := range aovInfo {
+		names[i] = info.name
+	}
+	return names
+}()
+
+func (a AOV) String() string {
+	return aovInfo[a].name
+}
+
+func ParseAOV(name string) (AOV, error) {
+	for i, info := range aovInfo {
+		if strings.EqualFold(name, info.name) {
+			return AOV(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown AOV %q (want %s)", name, strings.Join(AOVNames, ", "))
+}
+
+// ParseAOVList parses a comma-separated list of AOV names.
+func ParseAOVList(list string) ([]AOV, error) {
+	var aovs []AOV
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		aov, err := ParseAOV(name)
+		if err != nil {
+			return nil, err
+		}
+		aovs = append(aovs, aov)
+	}
+	return aovs, nil
+}
+
+func (a AOV) Next() AOV {
+	return (a + 1) % AOV(len(aovInfo))
+}
+
+// ------------------------------------------------------------
+
+// AOVSample is what one camera ray saw besides its colour. TraceRay fills
+// it in for primary rays only; indirect light is whatever is left of the
+// colour once direct light and emission are taken out.
+type AOVSample struct {
+	Hit                      bool
+	Albedo, Normal, Position Vec3
+	Depth                    float32
+	Material, Object         int32
+	Direct, Emission         Vec3
+	Cost                     TraversalCost
+}
+
+// AOVAccumulator sums the AOV samples of one pixel. Geometric buffers
+// (albedo, normal, position and depth) are averaged over the samples that
+// hit something, lighting buffers over all samples. Indices cannot be
+// averaged, so the first hit's are kept.
+type AOVAccumulator struct {
+	Samples, Hits            int
+	Albedo, Normal, Position Vec3
+	Depth                    float32
+	Material, Object         int32
+	Direct, Indirect         Vec3
+	Emission                 Vec3
+	Nodes, Tests             int64
+}
+
+func (a *AOVAccumulator) Add(s *AOVSample, color Vec3) {
+	a.Samples++
+	a.Direct._Add(s.Direct)
+	a.Emission._Add(s.Emission)
+	a.Indirect._Add(color.Sub(s.Direct).Sub(s.Emission))
+	a.Nodes += int64(s.Cost.Nodes)
+	a.Tests += int64(s.Cost.Tests)
+
+	if !s.Hit {
+		return
+	}
+	if a.Hits == 0 {
+		a.Material, a.Object = s.Material, s.Object
+	}
+	a.Hits++
+	a.Albedo._Add(s.Albedo)
+	a.Normal._Add(s.Normal)
+	a.Position._Add(s.Position)
+	a.Depth += s.Depth
+}
+
+func (a *AOVAccumulator) Reset() {
+	*a = AOVAccumulator{}
+}
+
+// AOVValue resolves one AOV of the pixel. Scalar AOVs are returned in every
+// component so they can be written to RGB formats as grey.
+func (p *Pixel) AOVValue(aov AOV) Vec3 {
+	a := &p.AOV
+	samples := float32(max(1, a.Samples))
+	hits := float32(max(1, a.Hits))
+	grey := func(v float32) Vec3 { return Vec3{X: v, Y: v, Z: v} }
+
+	switch aov {
+	case AOVBeauty:
+		return p.Average()
+	case AOVAlbedo:
+		return a.Albedo.Scale(1 / hits)
+	case AOVNormal:
+		return a.Normal.Normalize()
+	case AOVPosition:
+		return a.Position.Scale(1 / hits)
+	case AOVDepth:
+		return grey(a.Depth / hits)
+	case AOVMaterial, AOVObject:
+		if a.Hits == 0 {
+			return grey(-1)
+		}
+		if aov == AOVMaterial {
+			return grey(float32(a.Material))
+		}
+		return grey(float32(a.Object))
+	case AOVDirect:
+		return a.Direct.Scale(1 / samples)
+	case AOVIndirect:
+		return a.Indirect.Scale(1 / samples)
+	case AOVEmission:
+		return a.Emission.Scale(1 / samples)
+	case AOVSamples:
+		return grey(float32(p.SampleCount))
+	case AOVVariance:
+		return grey(p.Variance)
+	case AOVNodes:
+		return grey(float32(a.Nodes) / samples)
+	case AOVTests:
+		return grey(float32(a.Tests) / samples)
+	}
+	return Vec3{}
+}
+
+// ------------------------------------------------------------
+
+// AOV returns the linear values of one AOV in image order, like Radiance.
+func (r *Renderer) AOV(aov AOV) []Vec3 {
+	if aov == AOVBeauty {
+		return r.Radiance()
+	}
+	width, height := r.Settings.Width, r.Settings.Height
+	out := make([]Vec3, width*height)
+	for y := range r.Pixels {
+		for x := range r.Pixels[y] {
+			pixel := &r.Pixels[y][x]
+			out[int(pixel.Y)*width+r.ImageX(pixel.X)] = pixel.AOVValue(aov)
+		}
+	}
+	return out
+}
+
+// AOVChannels returns the channels of a multi-layer EXR holding the given
+// AOVs. Beauty goes to the default layer (R, G, B), every other AOV to a
+// layer named after it, e.g. "normal.X" or "depth.Z".
+func (r *Renderer) AOVChannels(aovs []AOV) []EXRChannel {
+	var channels []EXRChannel
+	for _, aov := range aovs {
+		values := r.AOV(aov)
+		layer := RGBChannels(values)
+		names := aovInfo[aov].channels
+		for i, name := range names {
+			if aov != AOVBeauty {
+				name = aov.String() + "." + name
+			}
+			channels = append(channels, EXRChannel{Name: name, Data: layer[i].Data})
+		}
+	}
+	return channels
+}
+
+// SetDisplayAOV picks the AOV shown by PixelColor and Draw. Like the tone
+// mapping it only changes the display, so it is safe while rendering.
+func (r *Renderer) SetDisplayAOV(aov AOV) {
+	r.displayAOV.Store(int32(aov))
+}
+
+func (r *Renderer) DisplayAOV() AOV {
+	return AOV(r.displayAOV.Load())
+}
+
+// AOVColor maps one AOV of a pixel to a colour for display. Radiance goes
+// through the tone mapping; the other buffers get a fixed false-colour
+// mapping that needs nothing but the pixel itself, so the viewer can update
+// pixels one by one.
+func (r *Renderer) AOVColor(aov AOV, pixel *Pixel) color.RGBA {
+	value := pixel.AOVValue(aov)
+	grey := func(v float32) color.RGBA {
+		g := quantize(EncodeSRGB(v))
+		return color.RGBA{R: g, G: g, B: g, A: 255}
+	}
+
+	switch aov {
+	case AOVBeauty, AOVDirect, AOVIndirect, AOVEmission:
+		return r.toneMapping.Load().Apply(value)
+	case AOVVariance:
+		return r.toneMapping.Load().Apply(Vec3{X: 1, Y: 1, Z: 1}.Scale(math32.Sqrt(value.X)))
+	case AOVAlbedo:
+		return color.RGBA{R: quantize(EncodeSRGB(value.X)), G: quantize(EncodeSRGB(value.Y)), B: quantize(EncodeSRGB(value.Z)), A: 255}
+	case AOVNormal:
+		if pixel.AOV.Hits == 0 {
+			return color.RGBA{A: 255}
+		}
+		return color.RGBA{R: quantize(value.X*0.5 + 0.5), G: quantize(value.Y*0.5 + 0.5), B: quantize(value.Z*0.5 + 0.5), A: 255}
+	case AOVPosition:
+		if pixel.AOV.Hits == 0 {
+			return color.RGBA{A: 255}
+		}
+		lo, hi := r.bounds[0], r.bounds[1]
+		p := value.Sub(lo)
+		// Scenes flat along an axis, e.g. a lone ground plane, sit in the
+		// middle of it.
+		normalized := func(x, extent float32) uint8 {
+			if extent <= 0 {
+				return quantize(0.5)
+			}
+			return quantize(x / extent)
+		}
+		return color.RGBA{
+			R: normalized(p.X, hi.X-lo.X),
+			G: normalized(p.Y, hi.Y-lo.Y),
+			B: normalized(p.Z, hi.Z-lo.Z),
+			A: 255,
+		}
+	case AOVDepth:
+		// Near is white, the far side of the scene black.
+		if pixel.AOV.Hits == 0 {
+			return color.RGBA{A: 255}
+		}
+		lo, hi := r.bounds[0], r.bounds[1]
+		center := lo.Add(hi).Scale(0.5)
+		far := center.Sub(r.Scene.Camera.Position).Length() + hi.Sub(center).Length()
+		return grey(1 - value.X/far)
+	case AOVMaterial, AOVObject:
+		return idColor(int32(value.X))
+	case AOVSamples:
+		return grey(value.X / float32(r.Settings.MaxSamplesPerPixel))
+	case AOVNodes, AOVTests:
+		return heatColor(value.X)
+	}
+	return color.RGBA{A: 255}
+}
+
+// idColor gives every index a stable, random-looking colour and misses
+// black.
+func idColor(id int32) color.RGBA {
+	if id < 0 {
+		return color.RGBA{A: 255}
+	}
+	h := hash64(uint64(id))
+	return color.RGBA{R: 64 + uint8(h)%192, G: 64 + uint8(h>>8)%192, B: 64 + uint8(h>>16)%192, A: 255}
+}
+
+// DrawAOV resolves one AOV of the whole buffer into img.
+func (r *Renderer) DrawAOV(img *image.RGBA, aov AOV) {
+	for y := range r.Pixels {
+		for x := range r.Pixels[y] {
+			pixel := &r.Pixels[y][x]
+			img.SetRGBA(r.ImageX(pixel.X), int(pixel.Y), r.AOVColor(aov, pixel))
+		}
+	}
+}
+
+// sceneBounds returns the corners of the box around the instances and the
+// bounded primitives.
+func sceneBounds(instances []*Instance, primitives []*BVHTriangle) [2]Vec3 {
+	if len(instances) == 0 && len(primitives) == 0 {
+		return [2]Vec3{}
+	}
+	inf := math32.Inf(1)
+	lo, hi := Vec3{X: inf, Y: inf, Z: inf}, Vec3{X: -inf, Y: -inf, Z: -inf}
+	grow := func(a, b Vec3) {
+		lo = Vec3{X: min(lo.X, a.X), Y: min(lo.Y, a.Y), Z: min(lo.Z, a.Z)}
+		hi = Vec3{X: max(hi.X, b.X), Y: max(hi.Y, b.Y), Z: max(hi.Z, b.Z)}
+	}
+	for _, instance := range instances {
+		grow(instance.Bounds())
+	}
+	for _, p := range primitives {
+		grow(Vec3{X: p.MinX, Y: p.MinY, Z: p.MinZ}, Vec3{X: p.MaxX, Y: p.MaxY, Z: p.MaxZ})
+	}
+	return [2]Vec3{lo, hi}
+}
+
+// assignIDs numbers the objects and the distinct materials of the scene.
+// Instances are the objects in scene order and their triangles take the
+// instance's ID; primitives, in the triangle slots from meshSlots on,
+// follow them.
+func (vnmu *VNMU) assignIDs(instances []*Instance, meshSlots int) {
+	materialIndex := make(map[*Material]int32)
+	id := func(material *Material) int32 {
+		id, ok := materialIndex[material]
+		if !ok {
+			id = int32(len(materialIndex))
+			materialIndex[material] = id
+		}
+		return id
+	}
+
+	vnmu.ObjectIDs = make([]int32, len(vnmu.Materials))
+	vnmu.MaterialIDs = make([]int32, len(vnmu.Materials))
+	for slot, material := range vnmu.Materials {
+		vnmu.MaterialIDs[slot] = id(material)
+		vnmu.ObjectIDs[slot] = -1
+		if slot >= meshSlots {
+			vnmu.ObjectIDs[slot] = int32(len(instances) + slot - meshSlots)
+		}
+	}
+	for i, instance := range instances {
+		instance.ObjectID = int32(i)
+		if instance.Material != nil {
+			instance.MaterialID = id(instance.Material)
+		}
+	}
+}
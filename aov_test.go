@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+// Geometric buffers average the samples that hit something, so a pixel on
+// the edge of an object keeps the object's albedo, while lighting buffers
+// average every sample.
+func TestAOVAverages(t *testing.T) {
+	var pixel Pixel
+	hit := func(albedo, direct Vec3, material int32) {
+		pixel.AOV.Add(&AOVSample{
+			Hit: true, Albedo: albedo, Normal: Vec3{Y: 1}, Position: Vec3{X: 2},
+			Depth: 4, Material: material, Object: 1, Direct: direct,
+		}, direct)
+	}
+	miss := func(sky Vec3) { pixel.AOV.Add(&AOVSample{Emission: sky}, sky) }
+
+	if got := pixel.AOVValue(AOVMaterial); got != (Vec3{X: -1, Y: -1, Z: -1}) {
+		t.Errorf("material of an empty pixel is %v, want -1", got)
+	}
+	hit(Vec3{X: 0.8}, Vec3{X: 2}, 3)
+	miss(Vec3{Z: 4})
+	hit(Vec3{X: 0.4, Y: 0.2}, Vec3{X: 2}, 5)
+	miss(Vec3{Z: 4})
+
+	tests := []struct {
+		aov  AOV
+		want Vec3
+	}{
+		{AOVAlbedo, Vec3{X: 0.6, Y: 0.1}},
+		{AOVNormal, Vec3{Y: 1}},
+		{AOVPosition, Vec3{X: 2}},
+		{AOVDepth, Vec3{X: 4, Y: 4, Z: 4}},
+		{AOVMaterial, Vec3{X: 3, Y: 3, Z: 3}},
+		{AOVObject, Vec3{X: 1, Y: 1, Z: 1}},
+		{AOVDirect, Vec3{X: 1}},
+		{AOVEmission, Vec3{Z: 2}},
+		{AOVIndirect, Vec3{}},
+	}
+	for _, test := range tests {
+		if got := pixel.AOVValue(test.aov); !near(got, test.want) {
+			t.Errorf("%s is %v, want %v", AOVNames[test.aov], got, test.want)
+		}
+	}
+}
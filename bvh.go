@@ -11,6 +11,14 @@ type BVHTriangle struct {
 	X, Y, Z  int
 	Index    int
 
+	// Primitive, if set, makes this entry an analytic shape instead of the
+	// triangle A, B, C. It still owns a triangle slot through Index.
+	Primitive Primitive
+
+	// Instance is set on the world-space copy of a triangle hit through an
+	// instance; see Instance.Intersect.
+	Instance *Instance
+
 	MinX, MaxX float32
 	MinY, MaxY float32
 	MinZ, MaxZ float32
@@ -61,6 +69,25 @@ func (b *FauxBox) Grow(tri *BVHTriangle) {
 	}
 	b.TriangleCount += 1
 }
+
+// Union grows the box to take in other.
+func (b *FauxBox) Union(other FauxBox) {
+	if other.TriangleCount == 0 {
+		return
+	}
+	if b.TriangleCount == 0 {
+		*b = other
+		return
+	}
+	b.X1 = min(b.X1, other.X1)
+	b.X2 = max(b.X2, other.X2)
+	b.Y1 = min(b.Y1, other.Y1)
+	b.Y2 = max(b.Y2, other.Y2)
+	b.Z1 = min(b.Z1, other.Z1)
+	b.Z2 = max(b.Z2, other.Z2)
+	b.TriangleCount += other.TriangleCount
+}
+
 func (b *FauxBox) Area() float32 {
 	dx := b.X2 - b.X1
 	dy := b.Y2 - b.Y1
@@ -108,10 +135,31 @@ func (b *Box) Area() float32 {
 
 type BVHStats struct {
 	MaxDepth, MaxTris, MinTris, TotalLeafs, TotalNodes, TotalTriangles int
+
+	// SAHCost is the expected cost of a ray by the surface area heuristic;
+	// EPO is the end-point overlap, the cost of the triangle area lying in
+	// nodes that do not hold those triangles. See LinearBVH.Stats.
+	SAHCost, EPO float32
+
+	// LeafSizes counts the leaves by their number of entries.
+	LeafSizes []int
+
+	// Memory is the size in bytes of the nodes and the entries.
+	Memory int
 }
 
 func (b BVHStats) String() string {
-	return fmt.Sprintf("MaxDepth: %d\nMinTris: %d\nAverage Tris: %.2f\nMaxTris: %d\nTotalLeafs: %d\nTotalNodes: %d\nTotalTriangles: %d\n", b.MaxDepth, b.MinTris, float32(b.TotalTriangles)/float32(b.TotalLeafs), b.MaxTris, b.TotalLeafs, b.TotalNodes, b.TotalTriangles)
+	s := fmt.Sprintf("MaxDepth: %d\nMinTris: %d\nAverage Tris: %.2f\nMaxTris: %d\nTotalLeafs: %d\nTotalNodes: %d\nTotalTriangles: %d\n", b.MaxDepth, b.MinTris, float32(b.TotalTriangles)/float32(b.TotalLeafs), b.MaxTris, b.TotalLeafs, b.TotalNodes, b.TotalTriangles)
+	if b.LeafSizes == nil {
+		return s
+	}
+	s += fmt.Sprintf("SAHCost: %.2f\nEPO: %.2f\nMemory: %.1f MiB\nLeafSizes:", b.SAHCost, b.EPO, float64(b.Memory)/(1<<20))
+	for size, count := range b.LeafSizes {
+		if count > 0 {
+			s += fmt.Sprintf(" %d:%d", size, count)
+		}
+	}
+	return s + "\n"
 }
 
 func (box Box) GetStats(depth int) BVHStats {
@@ -145,109 +193,11 @@ func (box Box) GetStats(depth int) BVHStats {
 	return temp
 }
 
-func buildBVH(tris []*BVHTriangle, x1, x2, y1, y2, z1, z2 float32, threshold int, depth int, parentCost float32) *Box {
-	box := new(Box)
-	box.X1, box.X2, box.Y1, box.Y2, box.Z1, box.Z2 = x1, x2, y1, y2, z1, z2
-	box.Trianges = make([]*BVHTriangle, 0, len(tris))
-
-	if len(tris) <= threshold || depth <= 0 {
-		box.IsLeaf = true
-		box.Trianges = append(box.Trianges, tris...)
-		// box.Trianges = append(box.Trianges, tris...)
-		return box
-	}
-
-	starts := [3]float32{x1, y1, z1}
-	ends := [3]float32{x2, y2, z2}
-
-	bestCost := float32(math.MaxFloat32)
-	bestAxis := -1
-	bestSplitPoint := float32(-1.0)
-
-	for axis := range 3 {
-		l, r := starts[axis], ends[axis]
-		n := 128
-
-		for i := 0; i <= n; i += 1 {
-			mid := l + (r-l)*float32(i)/float32(n)
-
-			leftBox := FauxBox{}
-			rightBox := FauxBox{}
-
-			for _, tri := range tris {
-				// if tri.MinX < x1 || tri.MaxX > x2 ||
-				// 	tri.MinY < y1 || tri.MaxY > y2 ||
-				// 	tri.MinZ < z1 || tri.MaxZ > z2 {
-				// 	continue
-				// }
-
-				var centroid float32
-				switch axis {
-				case 0:
-					centroid = tri.Centroid.X
-				case 1:
-					centroid = tri.Centroid.Y
-				case 2:
-					centroid = tri.Centroid.Z
-				}
-
-				if centroid < mid {
-					leftBox.Grow(tri)
-				} else {
-					rightBox.Grow(tri)
-				}
-			}
-
-			cost := 1.0/8 + float32(leftBox.TriangleCount)*leftBox.Area() + float32(rightBox.TriangleCount)*rightBox.Area()
-			if cost < bestCost {
-				bestCost = cost
-				bestAxis = axis
-				bestSplitPoint = mid
-			}
-		}
-	}
-
-	if bestCost >= parentCost {
-		box.Trianges = make([]*BVHTriangle, 0, len(tris))
-		box.Trianges = append(box.Trianges, tris...)
-		box.IsLeaf = true
-		return box
-	}
-
-	var childA, childB *Box
-	bestLeftBox, bestRightBox := getSplit(tris, bestAxis, bestSplitPoint)
-	childA = buildBVH(bestLeftBox.Trianges, bestLeftBox.X1, bestLeftBox.X2, bestLeftBox.Y1, bestLeftBox.Y2, bestLeftBox.Z1, bestLeftBox.Z2, threshold, depth-1, bestCost)
-	childB = buildBVH(bestRightBox.Trianges, bestRightBox.X1, bestRightBox.X2, bestRightBox.Y1, bestRightBox.Y2, bestRightBox.Z1, bestRightBox.Z2, threshold, depth-1, bestCost)
-
-	if childA != nil {
-		box.Children = append(box.Children, childA)
-	}
-	if childB != nil {
-		box.Children = append(box.Children, childB)
-	}
-	return box
-}
-
-func getSplit(tris []*BVHTriangle, axis int, mid float32) (*Box, *Box) {
-	left := Box{}
-	right := Box{}
-
-	for _, tri := range tris {
-		centroids := [3]float32{tri.Centroid.X, tri.Centroid.Y, tri.Centroid.Z}
-
-		if centroids[axis] < mid {
-			left.Grow(tri)
-		} else {
-			right.Grow(tri)
-		}
-	}
-
-	return &left, &right
-}
-
-func BuildBVH(verts []Vec3, tris []int, x1, x2, y1, y2, z1, z2 float32, threshold int, depth int) *Box {
-	triangles := make([]*BVHTriangle, 0)
-	for i := 0; i < len(tris); i += 3 {
+// TriangleEntries makes BVH entries for the triangles in tris[from:to],
+// which index into verts. Each entry's Index is its offset in tris.
+func TriangleEntries(verts []Vec3, tris []int, from, to int) []*BVHTriangle {
+	triangles := make([]*BVHTriangle, 0, (to-from)/3)
+	for i := from; i < to; i += 3 {
 		a := verts[tris[i]]
 		b := verts[tris[i+1]]
 		c := verts[tris[i+2]]
@@ -271,7 +221,7 @@ func BuildBVH(verts []Vec3, tris []int, x1, x2, y1, y2, z1, z2 float32, threshol
 		})
 	}
 
-	return buildBVH(triangles, x1, x2, y1, y2, z1, z2, threshold, depth, math.MaxFloat32)
+	return triangles
 }
 
 func (box *Box) intersectAABB(ray *Ray, stepSize float32) float32 {
@@ -327,7 +277,7 @@ func (box *Box) CheckIntersection(ray *Ray, stepSize float32, vertices []Vec3, k
 		found := false
 
 		for _, tri := range box.Trianges {
-			intersects, t := IntersectSegmentTriangle(ray.Origin, ray.Direction, stepSize, tri.A, tri.B, tri.C)
+			intersects, t := tri.Intersect(*ray, stepSize)
 			if intersects && t < closest && t > 0 { // Make sure t > 0 (in front of ray)
 				closest = t
 				closestTriangle = tri
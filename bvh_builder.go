@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// BVHOptions control how BVHs are built. They trade build time against
+// traversal speed and do not change the image.
+type BVHOptions struct {
+	// Bins is the number of slices each axis of a node is cut into; the
+	// planes between them are the candidate splits.
+	Bins int
+
+	// MaxLeafSize is the most entries a leaf holds. Larger nodes are split
+	// even where the cost model would keep them whole.
+	MaxLeafSize int
+
+	// The cost model: the relative costs of visiting a node and of
+	// intersecting one entry.
+	TraversalCost, IntersectionCost float32
+
+	// Width is the number of children of the nodes that are traversed:
+	// 2 traverses the binary tree, 4 or 8 a WideBVH collapsed from it.
+	// The top-level tree is always binary.
+	Width int
+
+	// RebuildThreshold is how much the top-level tree's SAH cost may grow
+	// as objects move and it is refitted before it is rebuilt; 0 only
+	// refits. See TopLevelBVH.Update.
+	RebuildThreshold float32
+
+	// SpatialSplitBudget, if positive, lets BVHs split space as well as
+	// entries, adding up to this fraction of their entries again as extra
+	// references; see buildSpatial. It pays off for large, slanted
+	// triangles. The top-level tree is never split this way.
+	SpatialSplitBudget float32
+}
+
+func DefaultBVHOptions() BVHOptions {
+	return BVHOptions{
+		Bins:             16,
+		MaxLeafSize:      8,
+		TraversalCost:    1,
+		IntersectionCost: 1,
+		Width:            2,
+		RebuildThreshold: 1.5,
+	}
+}
+
+func (o BVHOptions) Validate() error {
+	switch {
+	case o.Bins < 2:
+		return errors.New("BVH needs at least 2 bins")
+	case o.MaxLeafSize < 1:
+		return errors.New("BVH leaf size must be positive")
+	case o.TraversalCost <= 0 || o.IntersectionCost <= 0:
+		return errors.New("BVH costs must be positive")
+	case o.Width != 2 && o.Width != 4 && o.Width != 8:
+		return errors.New("BVH width must be 2, 4 or 8")
+	case o.RebuildThreshold != 0 && o.RebuildThreshold < 1:
+		return errors.New("BVH rebuild threshold must be 0 or at least 1")
+	case o.SpatialSplitBudget < 0:
+		return errors.New("BVH spatial split budget cannot be negative")
+	}
+	return nil
+}
+
+const (
+	// maxBVHDepth keeps trees within the traversal stacks.
+	maxBVHDepth = 48
+
+	// Subtrees with at least this many entries are built on their own
+	// goroutine.
+	parallelBuildSize = 4096
+)
+
+// BuildBVH builds a tree over triangles and other entries, such as
+// primitives, by the surface area heuristic: each node is split at the
+// cheapest plane between bins of entry centroids, or becomes a leaf if
+// that is cheaper still. Bounds come from the entries. The order of
+// entries is changed. With a SpatialSplitBudget entries may be in more
+// than one leaf.
+func BuildBVH(entries []*BVHTriangle, options BVHOptions) *Box {
+	if options.SpatialSplitBudget > 0 {
+		return options.buildSpatial(entries)
+	}
+	return options.build(entries, 0, options.newBins())
+}
+
+// bvhBins is the scratch space of one building goroutine.
+type bvhBins struct {
+	bins       [3][]FauxBox
+	rightArea  []float32
+	rightCount []int
+}
+
+func (o *BVHOptions) newBins() *bvhBins {
+	b := &bvhBins{rightArea: make([]float32, o.Bins), rightCount: make([]int, o.Bins)}
+	for axis := range b.bins {
+		b.bins[axis] = make([]FauxBox, o.Bins)
+	}
+	return b
+}
+
+func (o *BVHOptions) build(entries []*BVHTriangle, depth int, scratch *bvhBins) *Box {
+	bounds := FauxBox{}
+	lo, hi := Vec3{X: INF, Y: INF, Z: INF}, Vec3{X: -INF, Y: -INF, Z: -INF}
+	for _, entry := range entries {
+		bounds.Grow(entry)
+		c := entry.Centroid
+		lo = Vec3{X: min(lo.X, c.X), Y: min(lo.Y, c.Y), Z: min(lo.Z, c.Z)}
+		hi = Vec3{X: max(hi.X, c.X), Y: max(hi.Y, c.Y), Z: max(hi.Z, c.Z)}
+	}
+
+	box := &Box{X1: bounds.X1, X2: bounds.X2, Y1: bounds.Y1, Y2: bounds.Y2, Z1: bounds.Z1, Z2: bounds.Z2}
+	leaf := func() *Box {
+		box.IsLeaf = true
+		box.Trianges = entries
+		return box
+	}
+
+	n := len(entries)
+	if n <= 1 || depth >= maxBVHDepth {
+		return leaf()
+	}
+	axis, split, cost := o.findSplit(entries, bounds.Area(), lo, hi, scratch)
+	if axis < 0 || (cost >= o.IntersectionCost*float32(n) && n <= o.MaxLeafSize) {
+		return leaf()
+	}
+
+	// Partition the entries around the split plane.
+	scale := o.binScale(lo, hi)
+	mid := 0
+	for i, entry := range entries {
+		if o.bins(entry.Centroid, lo, scale)[axis] <= split {
+			entries[i], entries[mid] = entries[mid], entries[i]
+			mid++
+		}
+	}
+
+	box.Children = make([]*Box, 2)
+	if n >= parallelBuildSize {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			box.Children[0] = o.build(entries[:mid], depth+1, o.newBins())
+		}()
+		box.Children[1] = o.build(entries[mid:], depth+1, scratch)
+		wg.Wait()
+	} else {
+		box.Children[0] = o.build(entries[:mid], depth+1, scratch)
+		box.Children[1] = o.build(entries[mid:], depth+1, scratch)
+	}
+	return box
+}
+
+// findSplit returns the axis and the last bin left of the cheapest split,
+// with its cost, or an axis of -1 if the centroids cannot be told apart.
+func (o *BVHOptions) findSplit(entries []*BVHTriangle, area float32, lo, hi Vec3, scratch *bvhBins) (axis, split int, cost float32) {
+	for a := range scratch.bins {
+		clear(scratch.bins[a])
+	}
+	scale := o.binScale(lo, hi)
+	for _, entry := range entries {
+		bins := o.bins(entry.Centroid, lo, scale)
+		scratch.bins[0][bins[0]].Grow(entry)
+		scratch.bins[1][bins[1]].Grow(entry)
+		scratch.bins[2][bins[2]].Grow(entry)
+	}
+
+	axis, cost = -1, INF
+	extent := [3]float32{hi.X - lo.X, hi.Y - lo.Y, hi.Z - lo.Z}
+	for a, bins := range scratch.bins {
+		if extent[a] <= 0 {
+			continue
+		}
+		right := FauxBox{}
+		for i := o.Bins - 1; i > 0; i-- {
+			right.Union(bins[i])
+			scratch.rightArea[i], scratch.rightCount[i] = right.Area(), right.TriangleCount
+		}
+		left := FauxBox{}
+		for i := 0; i < o.Bins-1; i++ {
+			left.Union(bins[i])
+			if left.TriangleCount == 0 || scratch.rightCount[i+1] == 0 {
+				continue
+			}
+			c := o.TraversalCost + o.IntersectionCost*
+				(float32(left.TriangleCount)*left.Area()+float32(scratch.rightCount[i+1])*scratch.rightArea[i+1])/area
+			if c < cost {
+				axis, split, cost = a, i, c
+			}
+		}
+	}
+	return axis, split, cost
+}
+
+// binScale maps centroid offsets from lo to bin numbers. Flat axes get a
+// scale of zero, which puts everything in the first bin.
+func (o *BVHOptions) binScale(lo, hi Vec3) Vec3 {
+	scale := func(extent float32) float32 {
+		if extent <= 0 {
+			return 0
+		}
+		return float32(o.Bins) / extent
+	}
+	return Vec3{X: scale(hi.X - lo.X), Y: scale(hi.Y - lo.Y), Z: scale(hi.Z - lo.Z)}
+}
+
+func (o *BVHOptions) bins(centroid, lo, scale Vec3) [3]int {
+	bin := func(c, lo, scale float32) int {
+		return min(max(int((c-lo)*scale), 0), o.Bins-1)
+	}
+	return [3]int{bin(centroid.X, lo.X, scale.X), bin(centroid.Y, lo.Y, scale.Y), bin(centroid.Z, lo.Z, scale.Z)}
+}
+
+// ------------------------------------------------------------
+
+// SAHCost is the expected cost of tracing a ray through the tree, given
+// that it hits the root box, by the surface area heuristic.
+func (box *LinearBVH) SAHCost(options BVHOptions) float32 {
+	return options.IntersectionCost*float32(len(box.Unbounded)) + treeCost(box.Nodes, options)
+}
+
+// treeCost is LinearBVH.SAHCost for the nodes alone.
+func treeCost(nodes []LinearBVHNode, options BVHOptions) float32 {
+	cost := float32(0)
+	root := nodes[0].Area()
+	for i := range nodes {
+		node := &nodes[i]
+		switch {
+		case root <= 0:
+			// A flat tree; every entry is tested.
+			if node.IsLeaf() {
+				cost += options.IntersectionCost * float32(node.Count)
+			}
+		case node.IsLeaf():
+			cost += options.IntersectionCost * float32(node.Count) * node.Area() / root
+		default:
+			cost += options.TraversalCost * node.Area() / root
+		}
+	}
+	return cost
+}
+
+// SAHCost is LinearBVH.SAHCost for the whole scene: the instances' leaves
+// cost as much as their mesh BVHs, and the primitives are added on.
+func (b *TopLevelBVH) SAHCost(options BVHOptions) float32 {
+	cost := b.Primitives.SAHCost(options)
+	root := b.Nodes[0].Area()
+	if root <= 0 {
+		return cost
+	}
+
+	meshCosts := make(map[*LinearBVH]float32)
+	for i := range b.Nodes {
+		node := &b.Nodes[i]
+		if !node.IsLeaf() {
+			cost += options.TraversalCost * node.Area() / root
+			continue
+		}
+		for _, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+			meshCost, ok := meshCosts[instance.BVH]
+			if !ok {
+				meshCost = instance.BVH.SAHCost(options)
+				meshCosts[instance.BVH] = meshCost
+			}
+			cost += (options.TraversalCost + meshCost) * instance.bounds.Area() / root
+		}
+	}
+	return cost
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A mesh cache file holds the meshes of an OBJ as LoadObj or
+// LoadObjMeshes returns them, each together with its BVH. It is laid out as
+//
+//	magic "PTMESH\x00\x00" | version uint32 | key [32]byte |
+//	counts [2]uint32 | materials (JSON) | meshes | crc32 of everything before
+//
+// and each mesh as
+//
+//	name length uint32 | name | counts [5]uint32 | vertices | normals |
+//	uvs | tris | triangle materials | node bounds | node fields |
+//	triangle order
+//
+// with every number little-endian. The file's counts are of meshes and the
+// bytes of JSON, whose materials the meshes share; a mesh's are of
+// vertices, tris, uvs, nodes and the BVH's references to triangles.
+// Vectors are stored as three float32s, a node's bounds as six and its
+// Offset and Count as two uint32s. The triangle order lists the mesh's
+// triangles in the order the leaves refer to them; with spatial splits a
+// triangle may be listed more than once.
+const (
+	meshCacheMagic   = "PTMESH\x00\x00"
+	meshCacheVersion = 5
+)
+
+// MeshCache keeps meshes and their BVHs in Dir, so a scene whose files
+// have not changed loads without parsing OBJs or building BVHs. Entries
+// are named by a hash of the OBJ and MTL contents, the scale, the crease
+// angle and Options; anything stale or unreadable is rebuilt and
+// rewritten.
+type MeshCache struct {
+	Dir     string
+	Options BVHOptions
+}
+
+// DefaultMeshCacheDir is the user's cache directory, or "" (no cache) if
+// there is none.
+func DefaultMeshCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "geoviz", "bvh")
+}
+
+// LoadObj is the package LoadObj with the mesh's BVH built, read from the
+// cache if possible. A nil cache, or one without a directory, only loads.
+func (c *MeshCache) LoadObj(path string, scale, creaseAngle float32) (*Mesh, error) {
+	meshes, err := c.load(path, scale, creaseAngle, true)
+	if err != nil {
+		return nil, err
+	}
+	return meshes[0], nil
+}
+
+// LoadObjMeshes is LoadObj for the package LoadObjMeshes: every object
+// and group of the OBJ as a mesh of its own. It is cached apart from the
+// merged mesh.
+func (c *MeshCache) LoadObjMeshes(path string, scale, creaseAngle float32) ([]*Mesh, error) {
+	return c.load(path, scale, creaseAngle, false)
+}
+
+func (c *MeshCache) load(path string, scale, creaseAngle float32, merge bool) ([]*Mesh, error) {
+	if c != nil {
+		if err := c.Options.Validate(); err != nil {
+			return nil, fmt.Errorf("loading obj: %w", err)
+		}
+	}
+	parse := func() ([]*Mesh, error) {
+		meshes, _, err := LoadObjMeshes(path, scale, creaseAngle)
+		if err != nil {
+			return nil, err
+		}
+		if merge {
+			meshes = []*Mesh{MergeMeshes(meshes)}
+		}
+		return meshes, nil
+	}
+	if c == nil || c.Dir == "" {
+		return parse()
+	}
+
+	key, err := c.key(path, scale, creaseAngle, merge)
+	if err != nil {
+		return nil, fmt.Errorf("loading obj: %w", err)
+	}
+	file := filepath.Join(c.Dir, hex.EncodeToString(key[:])+".bin")
+
+	meshes, err := readMeshCache(file, key)
+	switch {
+	case err == nil:
+		var materials []*Material
+		for _, mesh := range meshes {
+			materials = append(materials, mesh.Materials...)
+			mesh.BVHOptions = c.Options
+		}
+		for _, mat := range uniqueMaterials(materials) {
+			if err := loadMaterialTextures(mat, filepath.Dir(path)); err != nil {
+				return nil, err
+			}
+		}
+		return meshes, nil
+	case !errors.Is(err, os.ErrNotExist):
+		fmt.Printf("Rebuilding BVH cache of %s: %v\n", path, err)
+	}
+
+	if meshes, err = parse(); err != nil {
+		return nil, err
+	}
+	for _, mesh := range meshes {
+		entries := TriangleEntries(mesh.Vertices, mesh.Tris, 0, len(mesh.Tris))
+		mesh.BVH = ConstructLinearBVH(BuildBVH(entries, c.Options))
+		mesh.BVHOptions = c.Options
+	}
+	if err := writeMeshCache(file, key, meshes); err != nil {
+		fmt.Printf("Could not cache the BVH of %s: %v\n", path, err)
+	}
+	return meshes, nil
+}
+
+// key hashes everything the cached mesh and BVH depend on. The MTL files
+// are those the decoder may read: the mtllib ones and the one named like
+// the OBJ.
+func (c *MeshCache) key(path string, scale, creaseAngle float32, merge bool) ([32]byte, error) {
+	obj, err := os.ReadFile(path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	h := sha256.New()
+	write := func(data any) { binary.Write(h, binary.LittleEndian, data) }
+	write(uint32(meshCacheVersion))
+	write([]float32{scale, creaseAngle})
+	write(merge)
+	write([]int64{int64(c.Options.Bins), int64(c.Options.MaxLeafSize)})
+	// The cached tree is binary whatever Width is; it is collapsed later.
+	write([]float32{c.Options.TraversalCost, c.Options.IntersectionCost, c.Options.SpatialSplitBudget})
+	write(uint64(len(obj)))
+	h.Write(obj)
+
+	mtls := []string{strings.TrimSuffix(path, ".obj") + ".mtl"}
+	for line := range strings.Lines(string(obj)) {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "mtllib" {
+			mtls = append(mtls, filepath.Join(filepath.Dir(path), fields[1]))
+		}
+	}
+	for _, mtl := range mtls {
+		data, err := os.ReadFile(mtl)
+		if err != nil {
+			// A missing MTL is part of the key too: creating it changes it.
+			write(int64(-1))
+			continue
+		}
+		write(int64(len(data)))
+		h.Write(data)
+	}
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+func uniqueMaterials(materials []*Material) []*Material {
+	seen := make(map[*Material]bool)
+	var unique []*Material
+	for _, mat := range materials {
+		if !seen[mat] {
+			seen[mat] = true
+			unique = append(unique, mat)
+		}
+	}
+	return unique
+}
+
+// writeMeshCache writes meshes to path, next to it first and then renamed
+// into place so readers never see a partial file.
+func writeMeshCache(path string, key [32]byte, meshes []*Mesh) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Textures are loaded again on every run; only their names are kept.
+	var all []*Material
+	for _, mesh := range meshes {
+		all = append(all, mesh.Materials...)
+	}
+	materials := uniqueMaterials(all)
+	stored := make([]Material, len(materials))
+	index := make(map[*Material]uint32, len(materials))
+	for i, mat := range materials {
+		stored[i] = *mat
+		stored[i].HasImage, stored[i].DiffuseImage, stored[i].BumpImage, stored[i].NormalImage = false, nil, nil, nil
+		index[mat] = uint32(i)
+	}
+	materialJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(tmp, crc))
+	w.WriteString(meshCacheMagic)
+	for _, data := range []any{uint32(meshCacheVersion), key, [2]uint32{uint32(len(meshes)), uint32(len(materialJSON))}, materialJSON} {
+		binary.Write(w, binary.LittleEndian, data)
+	}
+	for _, mesh := range meshes {
+		writeCachedMesh(w, mesh, index)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := binary.Write(tmp, binary.LittleEndian, crc.Sum32()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeCachedMesh writes one mesh of a cache file; index numbers the
+// file's materials.
+func writeCachedMesh(w io.Writer, mesh *Mesh, index map[*Material]uint32) {
+	vectors := func(vs []Vec3) []float32 {
+		out := make([]float32, 0, 3*len(vs))
+		for _, v := range vs {
+			out = append(out, v.X, v.Y, v.Z)
+		}
+		return out
+	}
+	tris := make([]uint32, len(mesh.Tris))
+	for i, t := range mesh.Tris {
+		tris[i] = uint32(t)
+	}
+	triangleMaterials := make([]uint32, len(mesh.Materials))
+	for i, mat := range mesh.Materials {
+		triangleMaterials[i] = index[mat]
+	}
+	nodes := mesh.BVH.Nodes
+	bounds := make([]float32, 0, 6*len(nodes))
+	fields := make([]uint32, 0, 2*len(nodes))
+	for _, node := range nodes {
+		bounds = append(bounds, node.MinBounds.X, node.MinBounds.Y, node.MinBounds.Z, node.MaxBounds.X, node.MaxBounds.Y, node.MaxBounds.Z)
+		fields = append(fields, node.Offset, node.Count)
+	}
+	order := make([]uint32, len(mesh.BVH.Triangles))
+	for i, triangle := range mesh.BVH.Triangles {
+		order[i] = uint32(triangle.Index / 3)
+	}
+
+	for _, data := range []any{
+		uint32(len(mesh.Name)), []byte(mesh.Name),
+		[5]uint32{uint32(len(mesh.Vertices)), uint32(len(mesh.Tris)), uint32(len(mesh.UVs)), uint32(len(nodes)), uint32(len(order))},
+		vectors(mesh.Vertices), vectors(mesh.Normals), mesh.UVs,
+		tris, triangleMaterials, bounds, fields, order,
+	} {
+		binary.Write(w, binary.LittleEndian, data)
+	}
+}
+
+// readMeshCache reads the whole file at path at once and decodes it. It
+// fails with os.ErrNotExist if there is no file, and with another error
+// if the file is of another version or key, or corrupt.
+func readMeshCache(path string, key [32]byte) ([]*Mesh, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(meshCacheMagic) + 4 + 32 + 4*2
+	if len(data) < headerSize+4 || string(data[:len(meshCacheMagic)]) != meshCacheMagic {
+		return nil, errors.New("not a mesh cache file")
+	}
+	body, tail := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(tail) {
+		return nil, errors.New("cache is corrupt (checksum mismatch)")
+	}
+	r := &cacheReader{data: body[len(meshCacheMagic):]}
+	if version := r.uint32s(1)[0]; version != meshCacheVersion {
+		return nil, fmt.Errorf("unsupported cache version %d (want %d)", version, meshCacheVersion)
+	}
+	if !bytes.Equal(r.bytes(32), key[:]) {
+		return nil, errors.New("cache was made for other files")
+	}
+
+	counts := r.uint32s(2)
+	materialJSON := r.bytes(int(counts[1]))
+	if r.err != nil {
+		return nil, errors.New("cache is corrupt (wrong size)")
+	}
+	var stored []Material
+	if err := json.Unmarshal(materialJSON, &stored); err != nil {
+		return nil, fmt.Errorf("cache is corrupt: %w", err)
+	}
+	materials := make([]*Material, len(stored))
+	for i := range stored {
+		materials[i] = &stored[i]
+	}
+
+	var meshes []*Mesh
+	for range counts[0] {
+		mesh, err := readCachedMesh(r, materials)
+		if err != nil {
+			return nil, err
+		}
+		meshes = append(meshes, mesh)
+	}
+	if len(r.data) != 0 || len(meshes) == 0 {
+		return nil, errors.New("cache is corrupt (wrong size)")
+	}
+	return meshes, nil
+}
+
+// readCachedMesh decodes the next mesh of a cache file, whose materials
+// are given.
+func readCachedMesh(r *cacheReader, materials []*Material) (*Mesh, error) {
+	header := r.uint32s(1)
+	if r.err != nil {
+		return nil, errors.New("cache is corrupt (wrong size)")
+	}
+	name := r.bytes(int(header[0]))
+	counts := r.uint32s(5)
+	if r.err != nil {
+		return nil, errors.New("cache is corrupt (wrong size)")
+	}
+	vertexCount, indexCount, uvCount, nodeCount := int(counts[0]), int(counts[1]), int(counts[2]), int(counts[3])
+	triangleCount, referenceCount := indexCount/3, int(counts[4])
+
+	vertices := r.vectors(vertexCount)
+	normals := r.vectors(indexCount)
+	uvs := r.float32s(uvCount)
+	tris := r.uint32s(indexCount)
+	triangleMaterials := r.uint32s(triangleCount)
+	bounds := r.float32s(6 * nodeCount)
+	fields := r.uint32s(2 * nodeCount)
+	order := r.uint32s(referenceCount)
+	if r.err != nil {
+		return nil, errors.New("cache is corrupt (wrong size)")
+	}
+
+	mesh := &Mesh{
+		Name:      string(name),
+		Vertices:  vertices,
+		Tris:      make([]int, indexCount),
+		Normals:   normals,
+		Materials: make([]*Material, triangleCount),
+		UVs:       uvs,
+	}
+	for i, t := range tris {
+		if int(t) >= vertexCount {
+			return nil, errors.New("cache is corrupt (vertex index out of range)")
+		}
+		mesh.Tris[i] = int(t)
+	}
+	for i, m := range triangleMaterials {
+		if int(m) >= len(materials) {
+			return nil, errors.New("cache is corrupt (material index out of range)")
+		}
+		mesh.Materials[i] = materials[m]
+	}
+
+	bvh := &LinearBVH{Nodes: make([]LinearBVHNode, nodeCount), Triangles: make([]*BVHTriangle, referenceCount)}
+	for i := range bvh.Nodes {
+		b, f := bounds[6*i:], fields[2*i:]
+		node := LinearBVHNode{
+			MinBounds: Vec3{X: b[0], Y: b[1], Z: b[2]},
+			MaxBounds: Vec3{X: b[3], Y: b[4], Z: b[5]},
+			Offset:    f[0],
+			Count:     f[1],
+		}
+		if node.IsLeaf() && uint64(node.Offset)+uint64(node.Count) > uint64(referenceCount) ||
+			!node.IsLeaf() && (node.Offset <= uint32(i) || int(node.Offset) >= nodeCount || i+1 >= nodeCount) {
+			return nil, errors.New("cache is corrupt (bad BVH node)")
+		}
+		bvh.Nodes[i] = node
+	}
+	entries := TriangleEntries(mesh.Vertices, mesh.Tris, 0, indexCount)
+	for i, t := range order {
+		if int(t) >= triangleCount {
+			return nil, errors.New("cache is corrupt (triangle out of range)")
+		}
+		bvh.Triangles[i] = entries[t]
+	}
+	bvh.buildCorners()
+	mesh.BVH = bvh
+	return mesh, nil
+}
+
+// cacheReader decodes little-endian arrays from the front of data. After
+// the first short read it returns nil and keeps err set.
+type cacheReader struct {
+	data []byte
+	err  error
+}
+
+func (r *cacheReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *cacheReader) uint32s(n int) []uint32 {
+	if r.err != nil || n > len(r.data)/4 {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	out := make([]uint32, n)
+	for i := range out {
+		out[i] = binary.LittleEndian.Uint32(r.data[4*i:])
+	}
+	r.data = r.data[4*n:]
+	return out
+}
+
+func (r *cacheReader) float32s(n int) []float32 {
+	bits := r.uint32s(n)
+	if bits == nil {
+		return nil
+	}
+	out := make([]float32, n)
+	for i, b := range bits {
+		out[i] = math.Float32frombits(b)
+	}
+	return out
+}
+
+func (r *cacheReader) vectors(n int) []Vec3 {
+	floats := r.float32s(3 * n)
+	if floats == nil {
+		return nil
+	}
+	out := make([]Vec3, n)
+	for i := range out {
+		out[i] = Vec3{X: floats[3*i], Y: floats[3*i+1], Z: floats[3*i+2]}
+	}
+	return out
+}
+
+// rebased returns the mesh BVH with its entries moved to where
+// DecomposeObjects put the mesh: its first triangle at offset in tris.
+func (b *LinearBVH) rebased(tris []int, offset int) *LinearBVH {
+	if offset == 0 {
+		return b
+	}
+	out := &LinearBVH{Nodes: b.Nodes, Triangles: make([]*BVHTriangle, len(b.Triangles)), Corners: b.Corners}
+	moved := make([]BVHTriangle, len(b.Triangles))
+	for i, triangle := range b.Triangles {
+		moved[i] = *triangle
+		moved[i].Index += offset
+		moved[i].X, moved[i].Y, moved[i].Z = tris[moved[i].Index], tris[moved[i].Index+1], tris[moved[i].Index+2]
+		out.Triangles[i] = &moved[i]
+	}
+	return out
+}
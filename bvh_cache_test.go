@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeCacheObj writes an OBJ of n long, thin triangles running through a
+// 20-unit cube, and its MTL, to dir and returns the OBJ's path.
+func writeCacheObj(t *testing.T, dir string, n int) string {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(7, 8))
+	random := func(scale float32) Vec3 {
+		return Vec3{X: rng.Float32()*2 - 1, Y: rng.Float32()*2 - 1, Z: rng.Float32()*2 - 1}.Scale(scale)
+	}
+	var obj strings.Builder
+	obj.WriteString("mtllib cache.mtl\no Slanted\nusemtl Grey\n")
+	for i := range n {
+		a, b := random(10), random(10)
+		c := a.Add(random(0.3))
+		for _, v := range []Vec3{a, b, c} {
+			fmt.Fprintf(&obj, "v %g %g %g\n", v.X, v.Y, v.Z)
+		}
+		fmt.Fprintf(&obj, "f %d %d %d\n", 3*i+1, 3*i+2, 3*i+3)
+	}
+	path := filepath.Join(dir, "cache.obj")
+	if err := os.WriteFile(path, []byte(obj.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cache.mtl"), []byte("newmtl Grey\nKd 0.5 0.5 0.5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// cacheFile returns the entry c keeps the merged mesh of the OBJ at path
+// in, and the key it is written under.
+func cacheFile(t *testing.T, c *MeshCache, path string) (string, [32]byte) {
+	t.Helper()
+	key, err := c.key(path, 1, DefaultCreaseAngle, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(c.Dir, hex.EncodeToString(key[:])+".bin"), key
+}
+
+// sameMesh reports how got, read from a cache, differs from the mesh it
+// was written from, or "" if it does not.
+func sameMesh(got, want *Mesh) string {
+	switch {
+	case got.Name != want.Name:
+		return fmt.Sprintf("name %q, want %q", got.Name, want.Name)
+	case !slices.Equal(got.Vertices, want.Vertices) || !slices.Equal(got.Tris, want.Tris) ||
+		!slices.Equal(got.Normals, want.Normals) || !slices.Equal(got.UVs, want.UVs):
+		return "geometry differs"
+	case len(got.Materials) != len(want.Materials) || got.Materials[0].Name != want.Materials[0].Name:
+		return "materials differ"
+	case !slices.Equal(got.BVH.Nodes, want.BVH.Nodes):
+		return "BVH nodes differ"
+	case !slices.EqualFunc(got.BVH.Triangles, want.BVH.Triangles, func(a, b *BVHTriangle) bool { return *a == *b }):
+		return "BVH triangle order differs"
+	case !slices.Equal(got.BVH.Corners, want.BVH.Corners):
+		return "BVH corners differ"
+	}
+	return ""
+}
+
+// A tree with spatial splits refers to some triangles from several leaves;
+// the cache must keep every reference.
+func TestMeshCacheKeepsDuplicatedReferences(t *testing.T) {
+	dir := t.TempDir()
+	path := writeCacheObj(t, dir, 300)
+	options := DefaultBVHOptions()
+	options.SpatialSplitBudget = 1
+	c := &MeshCache{Dir: filepath.Join(dir, "cache"), Options: options}
+
+	built, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if references := len(built.BVH.Triangles); references <= len(built.Tris)/3 {
+		t.Fatalf("%d references to %d triangles; the tree has no spatial splits to cache", references, len(built.Tris)/3)
+	}
+	file, key := cacheFile(t, c, path)
+	cached, err := readMeshCache(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 1 {
+		t.Fatalf("cache holds %d meshes, want 1", len(cached))
+	}
+	if diff := sameMesh(cached[0], built); diff != "" {
+		t.Fatalf("cached mesh: %s", diff)
+	}
+
+	loaded, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := sameMesh(loaded, built); diff != "" {
+		t.Errorf("mesh loaded again: %s", diff)
+	}
+	if loaded.BVHOptions != options {
+		t.Errorf("mesh loaded with options %+v, want %+v", loaded.BVHOptions, options)
+	}
+}
+
+func TestMeshCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := writeCacheObj(t, dir, 200)
+	c := &MeshCache{Dir: filepath.Join(dir, "cache"), Options: DefaultBVHOptions()}
+
+	built, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, key := cacheFile(t, c, path)
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("no cache entry: %v", err)
+	}
+	loaded, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := sameMesh(loaded, built); diff != "" {
+		t.Fatalf("mesh loaded from the cache: %s", diff)
+	}
+
+	// The cache must not be read for other options.
+	other := *c
+	other.Options.MaxLeafSize = 2
+	if otherFile, _ := cacheFile(t, &other, path); otherFile == file {
+		t.Error("other BVH options share the cache entry")
+	}
+	if _, err := readMeshCache(file, [32]byte{}); err == nil {
+		t.Error("cache entry read under another key")
+	}
+	if _, err := readMeshCache(file, key); err != nil {
+		t.Errorf("cache entry unreadable: %v", err)
+	}
+}
+
+// Damaged or stale entries are rebuilt from the OBJ, never trusted and
+// never a panic.
+func TestMeshCacheRebuildsBadEntries(t *testing.T) {
+	dir := t.TempDir()
+	path := writeCacheObj(t, dir, 50)
+	c := &MeshCache{Dir: filepath.Join(dir, "cache"), Options: DefaultBVHOptions()}
+	built, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, key := cacheFile(t, c, path)
+	good, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	damage := map[string]func([]byte) []byte{
+		"byte flipped in the header": func(data []byte) []byte { data[3] ^= 0x40; return data },
+		"byte flipped in the key":    func(data []byte) []byte { data[20] ^= 1; return data },
+		"byte flipped in a mesh":     func(data []byte) []byte { data[len(data)/2] ^= 0x80; return data },
+		"byte flipped in the crc":    func(data []byte) []byte { data[len(data)-1] ^= 1; return data },
+		"truncated to the header":    func(data []byte) []byte { return data[:20] },
+		"truncated in a mesh":        func(data []byte) []byte { return data[:len(data)/2] },
+		"crc cut off":                func(data []byte) []byte { return data[:len(data)-4] },
+		"empty":                      func(data []byte) []byte { return nil },
+	}
+	for name, damage := range damage {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(file, damage(slices.Clone(good)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readMeshCache(file, key); err == nil {
+				t.Fatal("damaged entry read without an error")
+			}
+			mesh, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := sameMesh(mesh, built); diff != "" {
+				t.Errorf("rebuilt mesh: %s", diff)
+			}
+			if _, err := readMeshCache(file, key); err != nil {
+				t.Errorf("entry not rewritten: %v", err)
+			}
+		})
+	}
+
+	// A changed MTL makes a new entry with the new material, not a stale
+	// hit on the old one.
+	if err := os.WriteFile(filepath.Join(dir, "cache.mtl"), []byte("newmtl Grey\nKd 0.25 0.5 0.75\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := c.LoadObj(path, 1, DefaultCreaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffuse := changed.Materials[0].Diffuse; diffuse.R != 0.25 || diffuse.B != 0.75 {
+		t.Errorf("material after the MTL changed has diffuse %v", diffuse)
+	}
+	if newFile, _ := cacheFile(t, c, path); newFile == file {
+		t.Error("the MTL change kept the cache entry")
+	}
+}
+
+// Entries whose checksum matches but whose contents are wrong, as a
+// buggy writer could leave them, may read as other numbers or fail to
+// read, but must never panic.
+func TestMeshCacheRejectsInconsistentEntries(t *testing.T) {
+	dir := t.TempDir()
+	path := writeCacheObj(t, dir, 20)
+	c := &MeshCache{Dir: filepath.Join(dir, "cache"), Options: DefaultBVHOptions()}
+	if _, err := c.LoadObj(path, 1, DefaultCreaseAngle); err != nil {
+		t.Fatal(err)
+	}
+	file, key := cacheFile(t, c, path)
+	good, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	damaged := filepath.Join(dir, "damaged.bin")
+	body := len(good) - 4
+	for i := len(meshCacheMagic); i < body; i++ {
+		for _, flip := range []byte{0x01, 0x80, 0xff} {
+			data := slices.Clone(good)
+			data[i] ^= flip
+			binary.LittleEndian.PutUint32(data[body:], crc32.ChecksumIEEE(data[:body]))
+			if err := os.WriteFile(damaged, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("byte %d flipped by %#x: panic %v", i, flip, r)
+					}
+				}()
+				readMeshCache(damaged, key)
+			}()
+		}
+	}
+}
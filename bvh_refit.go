@@ -0,0 +1,44 @@
+package main
+
+// Refit recomputes the bounds of the top-level nodes bottom up from the
+// instances, after some of them have moved. The tree keeps its shape, so
+// it gets slower to traverse the further the instances are from where it
+// was built.
+func (b *TopLevelBVH) Refit() {
+	// Children come after their parent, so going backwards visits them
+	// first.
+	for i := len(b.Nodes) - 1; i >= 0; i-- {
+		node := &b.Nodes[i]
+		lo, hi := Vec3{X: INF, Y: INF, Z: INF}, Vec3{X: -INF, Y: -INF, Z: -INF}
+		grow := func(box *LinearBVHNode) {
+			lo = Vec3{X: min(lo.X, box.MinBounds.X), Y: min(lo.Y, box.MinBounds.Y), Z: min(lo.Z, box.MinBounds.Z)}
+			hi = Vec3{X: max(hi.X, box.MaxBounds.X), Y: max(hi.Y, box.MaxBounds.Y), Z: max(hi.Z, box.MaxBounds.Z)}
+		}
+		if node.IsLeaf() {
+			for _, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+				grow(&instance.bounds)
+			}
+		} else {
+			grow(&b.Nodes[i+1])
+			grow(&b.Nodes[node.Offset])
+		}
+		node.MinBounds, node.MaxBounds = lo, hi
+	}
+}
+
+// Update refits the tree after instances have moved, and rebuilds it once
+// the refitted tree's SAH cost is more than options.RebuildThreshold times
+// the cost it was built with. Only the top level is rebuilt; the mesh BVHs
+// are in object space and stay as they are. Update reports whether it
+// rebuilt the tree.
+func (b *TopLevelBVH) Update(options BVHOptions) bool {
+	if len(b.Instances) == 0 {
+		return false
+	}
+	b.Refit()
+	if options.RebuildThreshold == 0 || treeCost(b.Nodes, options) <= options.RebuildThreshold*b.builtCost {
+		return false
+	}
+	*b = *BuildTopLevelBVH(b.Instances, b.Primitives, options)
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// Moving an object must move what rays hit, whether the top-level tree is
+// only refitted or rebuilt.
+func TestMoveObject(t *testing.T) {
+	down := Vec3{Y: -1}
+	oldPlace, newPlace := Ray{Origin: Vec3{X: -3, Y: 5}, Direction: down}, Ray{Origin: Vec3{X: -3, Y: 5, Z: 10}, Direction: down}
+	objectAt := func(r *Renderer, ray Ray) (int32, float32) {
+		hit, tHit, tri := r.BVH.CheckIntersection(ray, INF)
+		if !hit || tri.Instance == nil {
+			return -1, 0
+		}
+		return tri.Instance.ObjectID, tHit
+	}
+
+	for _, threshold := range []float32{0, 1, 1.5} {
+		settings := goldenSettings()
+		settings.BVH.RebuildThreshold = threshold
+		r, err := NewRenderer(instancesScene(), settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The box at x = -3 is 1 high; the floor is at y = 0.
+		if object, tHit := objectAt(r, oldPlace); object != 1 || tHit != 4 {
+			t.Fatalf("threshold %g: before the move, the ray hits object %d at %g", threshold, object, tHit)
+		}
+		if err := r.MoveObject(1, Vec3{Z: 10}); err != nil {
+			t.Fatal(err)
+		}
+		if object, tHit := objectAt(r, newPlace); object != 1 || tHit != 4 {
+			t.Errorf("threshold %g: at the new place the ray hits object %d at %g, want the box at 4", threshold, object, tHit)
+		}
+		if object, tHit := objectAt(r, oldPlace); object != 0 || tHit != 5 {
+			t.Errorf("threshold %g: at the old place the ray hits object %d at %g, want the floor at 5", threshold, object, tHit)
+		}
+		if r.Scene.Meshes[1].Position != (Vec3{X: -3, Z: 10}) {
+			t.Errorf("threshold %g: object is at %v", threshold, r.Scene.Meshes[1].Position)
+		}
+		if err := r.MoveObject(len(r.Scene.Meshes), Vec3{}); err == nil {
+			t.Errorf("threshold %g: moving an object that does not exist succeeded", threshold)
+		}
+	}
+}
+
+// gridOfBoxes places unit boxes on a 10x10 grid, each its own instance.
+func gridOfBoxes(t *testing.T, options BVHOptions) *TopLevelBVH {
+	t.Helper()
+	mesh := &Mesh{}
+	mesh.addBox(Vec3{}, Vec3{X: 1, Y: 1, Z: 1}, diffuse(0.5, 0.5, 0.5))
+	entries := TriangleEntries(mesh.Vertices, mesh.Tris, 0, len(mesh.Tris))
+	bvh := ConstructLinearBVH(BuildBVH(entries, options))
+	lo, hi := entryBounds(bvh.Triangles)
+	var instances []*Instance
+	for x := range 10 {
+		for z := range 10 {
+			instance, err := NewInstance(bvh, lo, hi, Translation(Vec3{X: 2 * float32(x), Z: 2 * float32(z)}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			instance.ObjectID = int32(len(instances))
+			instances = append(instances, instance)
+		}
+	}
+	return BuildTopLevelBVH(instances, ConstructLinearBVH(BuildBVH(nil, options)), options)
+}
+
+func TestRefitAndRebuildThreshold(t *testing.T) {
+	// Shuffling the boxes across the grid leaves every node of the old
+	// tree spanning most of it.
+	shuffle := func(bvh *TopLevelBVH, seed uint64) {
+		rng := rand.New(rand.NewPCG(seed, 1))
+		for _, instance := range bvh.Instances {
+			x, z := rng.IntN(10), rng.IntN(10)
+			if err := instance.SetTransform(Translation(Vec3{X: 2*float32(x) + 0.5, Y: 3, Z: 2*float32(z) + 0.5})); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Every box must be found where it now is, from above.
+	check := func(name string, bvh *TopLevelBVH) {
+		for _, instance := range bvh.Instances {
+			top := instance.ObjectToWorld.Point(Vec3{X: 0.5, Y: 1, Z: 0.5})
+			hit, tHit, tri := bvh.CheckIntersection(Ray{Origin: top.Add(Vec3{Y: 10}), Direction: Vec3{Y: -1}}, INF)
+			if !hit || tHit != 10 {
+				t.Fatalf("%s: box %d missed at %v", name, instance.ObjectID, top)
+			}
+			// Boxes shuffled onto the same spot may hide each other.
+			if tri.Instance.ObjectToWorld != instance.ObjectToWorld {
+				t.Fatalf("%s: box %d hit at %v", name, tri.Instance.ObjectID, top)
+			}
+		}
+	}
+
+	options := DefaultBVHOptions()
+	tests := []struct {
+		name      string
+		threshold float32
+		rebuilds  bool
+	}{
+		{"never rebuilt", 0, false},
+		{"rebuilt past the threshold", 1.5, true},
+		{"threshold out of reach", 1e6, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options.RebuildThreshold = test.threshold
+			bvh := gridOfBoxes(t, options)
+			cost := bvh.SAHCost(options)
+			shuffle(bvh, 1)
+			if rebuilt := bvh.Update(options); rebuilt != test.rebuilds {
+				t.Fatalf("Update rebuilt the tree: %v, want %v", rebuilt, test.rebuilds)
+			}
+			check(test.name, bvh)
+			refitted := bvh.SAHCost(options)
+			if test.rebuilds && refitted > 1.5*cost {
+				t.Errorf("rebuilt tree costs %g, the tree before the move %g", refitted, cost)
+			}
+			if !test.rebuilds && refitted <= 1.5*cost {
+				t.Errorf("refitted tree costs %g, the tree before the move %g; the shuffle did not test much", refitted, cost)
+			}
+
+			// Nothing moved: a rebuilt tree is compared with its new cost.
+			if bvh.Update(options) {
+				t.Error("Update rebuilt the tree without a move")
+			}
+		})
+	}
+}
+
+// A refitted tree keeps its shape, so its bounds must still hold every
+// instance below them.
+func TestRefitBounds(t *testing.T) {
+	options := DefaultBVHOptions()
+	bvh := gridOfBoxes(t, options)
+	for i, instance := range bvh.Instances {
+		if err := instance.SetTransform(Translation(Vec3{X: float32(i % 7), Y: float32(i), Z: -float32(i % 3)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bvh.Refit()
+
+	var visit func(ptr uint32, lo, hi Vec3)
+	visit = func(ptr uint32, lo, hi Vec3) {
+		node := &bvh.Nodes[ptr]
+		inside := func(a, b Vec3) bool {
+			return a.X >= lo.X && a.Y >= lo.Y && a.Z >= lo.Z && b.X <= hi.X && b.Y <= hi.Y && b.Z <= hi.Z
+		}
+		if !inside(node.MinBounds, node.MaxBounds) {
+			t.Fatalf("node %d is not within its parent", ptr)
+		}
+		if node.IsLeaf() {
+			for _, instance := range bvh.Instances[node.Offset : node.Offset+node.Count] {
+				if a, b := instance.Bounds(); a.X < node.MinBounds.X || a.Y < node.MinBounds.Y || a.Z < node.MinBounds.Z ||
+					b.X > node.MaxBounds.X || b.Y > node.MaxBounds.Y || b.Z > node.MaxBounds.Z {
+					t.Fatalf("box %d lies outside leaf %d", instance.ObjectID, ptr)
+				}
+			}
+			return
+		}
+		visit(ptr+1, node.MinBounds, node.MaxBounds)
+		visit(node.Offset, node.MinBounds, node.MaxBounds)
+	}
+	visit(0, bvh.Nodes[0].MinBounds, bvh.Nodes[0].MaxBounds)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"unsafe"
+)
+
+// Stats measures the tree: its shape, its cost by the surface area
+// heuristic, its end-point overlap and the memory it takes.
+//
+// The SAH cost assumes rays hit every box in proportion to its area,
+// which overlapping boxes get wrong: a ray entering two siblings where
+// they overlap must search both. The EPO (Aila, Karras and Laine, "On
+// Quality Metrics of Bounding Volume Hierarchies", 2013) adds up, for
+// every node, the area of the triangles lying in its box that it does not
+// hold, weighted like the SAH by what visiting the node costs and
+// relative to the area of all triangles. It is zero for a tree whose
+// boxes never overlap the triangles of other subtrees.
+func (box *LinearBVH) Stats(options BVHOptions) BVHStats {
+	stats, _ := box.stats(options)
+	return stats
+}
+
+// stats also returns the area of the triangles, which weights the tree's
+// EPO in a scene's.
+func (box *LinearBVH) stats(options BVHOptions) (BVHStats, float32) {
+	stats := BVHStats{
+		MinTris:   math.MaxInt,
+		LeafSizes: []int{},
+		SAHCost:   box.SAHCost(options),
+		Memory:    box.memory(),
+	}
+	if len(box.Nodes) == 0 {
+		stats.MinTris = 0
+		return stats, 0
+	}
+
+	type entry struct{ node, depth int }
+	stack := []entry{{0, 1}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := &box.Nodes[e.node]
+		stats.TotalNodes++
+		if !node.IsLeaf() {
+			stack = append(stack, entry{e.node + 1, e.depth + 1}, entry{int(node.Offset), e.depth + 1})
+			continue
+		}
+		count := int(node.Count)
+		stats.MaxDepth = max(stats.MaxDepth, e.depth)
+		stats.TotalLeafs++
+		stats.TotalTriangles += count
+		stats.MinTris = min(stats.MinTris, count)
+		stats.MaxTris = max(stats.MaxTris, count)
+		for len(stats.LeafSizes) <= count {
+			stats.LeafSizes = append(stats.LeafSizes, 0)
+		}
+		stats.LeafSizes[count]++
+	}
+
+	epo, area := box.endPointOverlap(options)
+	stats.EPO = epo
+	return stats, area
+}
+
+// memory is the size of the nodes, wide nodes included, and of the
+// entries of the tree.
+func (box *LinearBVH) memory() int {
+	entries := make(map[*BVHTriangle]bool)
+	for _, tri := range box.Triangles {
+		entries[tri] = true
+	}
+	for _, tri := range box.Unbounded {
+		entries[tri] = true
+	}
+	size := len(box.Nodes)*int(unsafe.Sizeof(LinearBVHNode{})) +
+		(len(box.Triangles)+len(box.Unbounded))*int(unsafe.Sizeof(&BVHTriangle{})) +
+		len(entries)*int(unsafe.Sizeof(BVHTriangle{})) +
+		len(box.Corners)*int(unsafe.Sizeof(TriangleCorners{}))
+	if w := box.Wide; w != nil {
+		// Eight numbers of four bytes per child slot.
+		size += len(w.Child) * 8 * 4
+	}
+	return size
+}
+
+// endPointOverlap returns the EPO of the tree, see Stats, and the area of
+// its triangles. Primitives have no triangle to clip and are left out.
+func (box *LinearBVH) endPointOverlap(options BVHOptions) (float32, float32) {
+	// The entries under a node are a range of Triangles, as convert lays
+	// them out depth first. Children come after their parents.
+	ranges := make([][2]uint32, len(box.Nodes))
+	for i := len(box.Nodes) - 1; i >= 0; i-- {
+		node := &box.Nodes[i]
+		if node.IsLeaf() {
+			ranges[i] = [2]uint32{node.Offset, node.Offset + node.Count}
+		} else {
+			ranges[i] = [2]uint32{ranges[i+1][0], ranges[node.Offset][1]}
+		}
+	}
+
+	// Spatial splits may put a triangle in several leaves.
+	places := make(map[int][]uint32)
+	var triangles []*BVHTriangle
+	for i, tri := range box.Triangles {
+		if tri.Primitive != nil {
+			continue
+		}
+		if _, ok := places[tri.Index]; !ok {
+			triangles = append(triangles, tri)
+		}
+		places[tri.Index] = append(places[tri.Index], uint32(i))
+	}
+
+	var overlap, total float32
+	stack := make([]uint32, 0, 64)
+	for _, tri := range triangles {
+		area := triangleArea(tri.A, tri.B, tri.C)
+		if area <= 0 {
+			continue
+		}
+		total += area
+		lo := Vec3{X: min(tri.A.X, tri.B.X, tri.C.X), Y: min(tri.A.Y, tri.B.Y, tri.C.Y), Z: min(tri.A.Z, tri.B.Z, tri.C.Z)}
+		hi := Vec3{X: max(tri.A.X, tri.B.X, tri.C.X), Y: max(tri.A.Y, tri.B.Y, tri.C.Y), Z: max(tri.A.Z, tri.B.Z, tri.C.Z)}
+
+		stack = append(stack[:0], 0)
+		for len(stack) > 0 {
+			ptr := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			node := &box.Nodes[ptr]
+			if !overlaps(node, lo, hi) {
+				continue
+			}
+			if !node.IsLeaf() {
+				stack = append(stack, ptr+1, node.Offset)
+			}
+
+			held := false
+			for _, place := range places[tri.Index] {
+				held = held || ranges[ptr][0] <= place && place < ranges[ptr][1]
+			}
+			if held {
+				continue
+			}
+			cost := options.TraversalCost
+			if node.IsLeaf() {
+				cost = options.IntersectionCost * float32(node.Count)
+			}
+			overlap += cost * clippedArea(tri.A, tri.B, tri.C, node)
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return overlap / total, total
+}
+
+func overlaps(node *LinearBVHNode, lo, hi Vec3) bool {
+	return lo.X <= node.MaxBounds.X && hi.X >= node.MinBounds.X &&
+		lo.Y <= node.MaxBounds.Y && hi.Y >= node.MinBounds.Y &&
+		lo.Z <= node.MaxBounds.Z && hi.Z >= node.MinBounds.Z
+}
+
+func triangleArea(a, b, c Vec3) float32 {
+	return b.Sub(a).Cross(c.Sub(a)).Length() / 2
+}
+
+// clippedArea is the area of the part of the triangle ABC inside the
+// node's box.
+func clippedArea(a, b, c Vec3, node *LinearBVHNode) float32 {
+	// Each plane adds at most one corner.
+	var buf [2][9]Vec3
+	polygon := append(buf[0][:0], a, b, c)
+	for axis := range 3 {
+		polygon = clipPolygon(polygon, buf[1][:0], axis, node.MinBounds.axis(axis), 1)
+		polygon = clipPolygon(polygon, buf[0][:0], axis, node.MaxBounds.axis(axis), -1)
+	}
+	if len(polygon) < 3 {
+		return 0
+	}
+	var sum Vec3
+	for i := 1; i+1 < len(polygon); i++ {
+		sum._Add(polygon[i].Sub(polygon[0]).Cross(polygon[i+1].Sub(polygon[0])))
+	}
+	return sum.Length() / 2
+}
+
+// clipPolygon appends to out the part of the polygon on the side of the
+// plane at pos along axis that side, 1 or -1, points to.
+func clipPolygon(polygon, out []Vec3, axis int, pos, side float32) []Vec3 {
+	for i, p := range polygon {
+		q := polygon[(i+1)%len(polygon)]
+		dp, dq := side*(p.axis(axis)-pos), side*(q.axis(axis)-pos)
+		if dp >= 0 {
+			out = append(out, p)
+		}
+		if (dp >= 0) != (dq >= 0) {
+			out = append(out, p.Lerp(q, dp/(dp-dq)))
+		}
+	}
+	return out
+}
+
+// ------------------------------------------------------------
+
+// Stats is LinearBVH.Stats for the whole scene: the top-level tree, whose
+// leaves go on into the mesh BVHs, and the primitives. Every distinct mesh
+// BVH is counted once, and the EPO is that of the mesh and primitive BVHs
+// weighted by the area of their triangles.
+func (b *TopLevelBVH) Stats(options BVHOptions) BVHStats {
+	stats := BVHStats{LeafSizes: []int{}}
+	var epo, totalArea float32
+	add := func(tree BVHStats, area float32) {
+		stats.merge(tree)
+		epo += tree.EPO * area
+		totalArea += area
+	}
+	if primitives, area := b.Primitives.stats(options); len(b.Primitives.Triangles) > 0 {
+		add(primitives, area)
+	} else {
+		stats.Memory += primitives.Memory
+	}
+
+	meshes := make(map[*LinearBVH]BVHStats)
+	for _, instance := range b.Instances {
+		if _, ok := meshes[instance.BVH]; ok {
+			continue
+		}
+		mesh, area := instance.BVH.stats(options)
+		meshes[instance.BVH] = mesh
+		add(mesh, area)
+	}
+	if totalArea > 0 {
+		stats.EPO = epo / totalArea
+	}
+
+	// A mesh's depth counts from the top-level leaf it hangs off.
+	type entry struct{ node, depth int }
+	stack := []entry{{0, 1}}
+	for len(stack) > 0 && len(b.Nodes) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := &b.Nodes[e.node]
+		if !node.IsLeaf() {
+			stack = append(stack, entry{e.node + 1, e.depth + 1}, entry{int(node.Offset), e.depth + 1})
+			continue
+		}
+		for _, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+			stats.MaxDepth = max(stats.MaxDepth, e.depth+meshes[instance.BVH].MaxDepth)
+		}
+	}
+
+	stats.TotalNodes += len(b.Nodes)
+	stats.Memory += len(b.Nodes)*int(unsafe.Sizeof(LinearBVHNode{})) +
+		len(b.Instances)*int(unsafe.Sizeof(&Instance{})+unsafe.Sizeof(Instance{}))
+	stats.SAHCost = b.SAHCost(options)
+	return stats
+}
+
+// merge adds the nodes, leaves and memory of other to s.
+func (s *BVHStats) merge(other BVHStats) {
+	s.MaxDepth = max(s.MaxDepth, other.MaxDepth)
+	if other.TotalLeafs > 0 {
+		if s.TotalLeafs == 0 {
+			s.MinTris, s.MaxTris = other.MinTris, other.MaxTris
+		}
+		s.MinTris = min(s.MinTris, other.MinTris)
+		s.MaxTris = max(s.MaxTris, other.MaxTris)
+	}
+	s.TotalLeafs += other.TotalLeafs
+	s.TotalNodes += other.TotalNodes
+	s.TotalTriangles += other.TotalTriangles
+	for len(s.LeafSizes) < len(other.LeafSizes) {
+		s.LeafSizes = append(s.LeafSizes, 0)
+	}
+	for size, count := range other.LeafSizes {
+		s.LeafSizes[size] += count
+	}
+	s.Memory += other.Memory
+}
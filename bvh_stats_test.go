@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+// twoLeaves is a tree of a root and two leaves of one triangle each, with
+// the second leaf's box stretched down to x = secondFrom.
+func twoLeaves(first, second [3]Vec3, secondFrom float32) *LinearBVH {
+	entries := TriangleEntries([]Vec3{first[0], first[1], first[2], second[0], second[1], second[2]}, []int{0, 1, 2, 3, 4, 5}, 0, 6)
+	leaf := func(i int) LinearBVHNode {
+		lo, hi := entryBounds(entries[i : i+1])
+		return LinearBVHNode{MinBounds: lo, MaxBounds: hi, Offset: uint32(i), Count: 1}
+	}
+	left, right := leaf(0), leaf(1)
+	right.MinBounds.X = secondFrom
+	root := LinearBVHNode{
+		MinBounds: Vec3{X: min(left.MinBounds.X, right.MinBounds.X), Y: min(left.MinBounds.Y, right.MinBounds.Y), Z: min(left.MinBounds.Z, right.MinBounds.Z)},
+		MaxBounds: Vec3{X: max(left.MaxBounds.X, right.MaxBounds.X), Y: max(left.MaxBounds.Y, right.MaxBounds.Y), Z: max(left.MaxBounds.Z, right.MaxBounds.Z)},
+		Offset:    2,
+		Count:     innerNode,
+	}
+	bvh := &LinearBVH{Nodes: []LinearBVHNode{root, left, right}, Triangles: entries}
+	bvh.buildCorners()
+	return bvh
+}
+
+func TestEndPointOverlap(t *testing.T) {
+	// Areas 2 and 0.5.
+	big := [3]Vec3{{}, {X: 2}, {Y: 2}}
+	small := [3]Vec3{{X: 3}, {X: 4}, {X: 3, Y: 1}}
+	costly := DefaultBVHOptions()
+	costly.IntersectionCost = 2
+
+	tests := []struct {
+		name       string
+		secondFrom float32
+		options    BVHOptions
+		epo        float32
+	}{
+		{"disjoint leaves", 3, DefaultBVHOptions(), 0},
+		{"boxes touching", 2, DefaultBVHOptions(), 0},
+		// The second leaf's box holds the corner of the big triangle right
+		// of x = 1, of area 0.5, out of 2.5 in all.
+		{"overlap", 1, DefaultBVHOptions(), 0.5 / 2.5},
+		{"overlap weighted by the leaf's cost", 1, costly, 2 * 0.5 / 2.5},
+		// The box stays within y = 1, leaving out the big triangle's apex.
+		{"big triangle below y = 1 in the second box", -1, DefaultBVHOptions(), 1.5 / 2.5},
+	}
+	for _, test := range tests {
+		stats := twoLeaves(big, small, test.secondFrom).Stats(test.options)
+		if math32.Abs(stats.EPO-test.epo) > 1e-5 {
+			t.Errorf("%s: EPO %g, want %g", test.name, stats.EPO, test.epo)
+		}
+	}
+}
+
+func TestClippedArea(t *testing.T) {
+	// Area 1, with its apex over the origin.
+	flat := [3]Vec3{{X: -1}, {X: 1}, {Y: 1}}
+	// Area √2, leaning back.
+	tilted := [3]Vec3{{X: -1}, {X: 1}, {Y: 1, Z: 1}}
+	box := func(lo, hi Vec3) *LinearBVHNode { return &LinearBVHNode{MinBounds: lo, MaxBounds: hi} }
+	everywhere := Vec3{X: 10, Y: 10, Z: 10}
+
+	tests := []struct {
+		name     string
+		triangle [3]Vec3
+		node     *LinearBVHNode
+		area     float32
+	}{
+		{"inside", flat, box(everywhere.Scale(-1), everywhere), 1},
+		{"outside", flat, box(Vec3{X: 2, Y: -10, Z: -10}, everywhere), 0},
+		{"half inside", flat, box(Vec3{Y: -10, Z: -10}, everywhere), 0.5},
+		{"band without the apex", flat, box(everywhere.Scale(-1), Vec3{X: 10, Y: 0.5, Z: 10}), 0.75},
+		{"apex only", flat, box(Vec3{X: -10, Y: 0.5, Z: -10}, everywhere), 0.25},
+		{"quarter", flat, box(Vec3{Y: 0.5, Z: -10}, everywhere), 0.125},
+		{"tilted, half inside", tilted, box(Vec3{Y: -10, Z: -10}, everywhere), math32.Sqrt2 / 2},
+		{"tilted, cut by depth", tilted, box(everywhere.Scale(-1), Vec3{X: 10, Y: 10, Z: 0.5}), 0.75 * math32.Sqrt2},
+		{"in the plane of a flat box", flat, box(Vec3{X: -10, Y: -10}, Vec3{X: 10, Y: 10}), 1},
+	}
+	for _, test := range tests {
+		a, b, c := test.triangle[0], test.triangle[1], test.triangle[2]
+		if got := clippedArea(a, b, c, test.node); math32.Abs(got-test.area) > 1e-5 {
+			t.Errorf("%s: area %g, want %g", test.name, got, test.area)
+		}
+	}
+}
+
+// With spatial splits some triangles are in several leaves, and are
+// counted in each.
+func TestStatsCountSpatialReferences(t *testing.T) {
+	entries := slantedEntries(rand.New(rand.NewPCG(9, 10)))
+	options := DefaultBVHOptions()
+	options.SpatialSplitBudget = 1
+	bvh := ConstructLinearBVH(BuildBVH(entries, options))
+	stats := bvh.Stats(options)
+
+	if len(bvh.Triangles) <= len(entries) {
+		t.Fatalf("%d references to %d entries; the tree has no spatial splits", len(bvh.Triangles), len(entries))
+	}
+	if stats.TotalTriangles != len(bvh.Triangles) {
+		t.Errorf("TotalTriangles %d, want the %d references", stats.TotalTriangles, len(bvh.Triangles))
+	}
+	if stats.TotalNodes != len(bvh.Nodes) {
+		t.Errorf("TotalNodes %d, want %d", stats.TotalNodes, len(bvh.Nodes))
+	}
+	leaves, references, smallest, largest := 0, 0, -1, 0
+	for size, count := range stats.LeafSizes {
+		leaves += count
+		references += size * count
+		if count > 0 {
+			if smallest < 0 {
+				smallest = size
+			}
+			largest = size
+		}
+	}
+	if leaves != stats.TotalLeafs || references != stats.TotalTriangles {
+		t.Errorf("LeafSizes %v count %d leaves of %d entries, want %d of %d",
+			stats.LeafSizes, leaves, references, stats.TotalLeafs, stats.TotalTriangles)
+	}
+	if smallest != stats.MinTris || largest != stats.MaxTris {
+		t.Errorf("leaves of %d to %d entries, LeafSizes says %d to %d", stats.MinTris, stats.MaxTris, smallest, largest)
+	}
+	if stats.EPO < 0 || math32.IsNaN(stats.EPO) {
+		t.Errorf("EPO %g", stats.EPO)
+	}
+
+	// In a scene every distinct mesh BVH counts once, however many
+	// instances it has.
+	lo, hi := entryBounds(bvh.Triangles)
+	var instances []*Instance
+	for x := range 3 {
+		instance, err := NewInstance(bvh, lo, hi, Translation(Vec3{X: 30 * float32(x)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, instance)
+	}
+	scene := BuildTopLevelBVH(instances, ConstructLinearBVH(BuildBVH(nil, options)), options)
+	sceneStats := scene.Stats(options)
+	if sceneStats.TotalTriangles != stats.TotalTriangles || sceneStats.TotalLeafs != stats.TotalLeafs {
+		t.Errorf("scene has %d entries in %d leaves, want the mesh's %d in %d",
+			sceneStats.TotalTriangles, sceneStats.TotalLeafs, stats.TotalTriangles, stats.TotalLeafs)
+	}
+	if want := stats.TotalNodes + len(scene.Nodes); sceneStats.TotalNodes != want {
+		t.Errorf("scene has %d nodes, want %d", sceneStats.TotalNodes, want)
+	}
+	if math32.Abs(sceneStats.EPO-stats.EPO) > 1e-5 {
+		t.Errorf("scene EPO %g, want the mesh's %g", sceneStats.EPO, stats.EPO)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// benchmarkBVH is a field of spheres, about 150k triangles, with rays
+// from a surrounding shell towards random points among them. Half of the
+// rays miss everything, half end on or behind a sphere.
+func benchmarkBVH(b *testing.B) (*LinearBVH, []Ray) {
+	b.Helper()
+	mesh := &Mesh{}
+	material := diffuse(0.5, 0.5, 0.5)
+	for x := range 8 {
+		for z := range 8 {
+			mesh.addSphere(Vec3{X: float32(x)*3 - 10.5, Z: float32(z)*3 - 10.5}, 1.2, 24, 48, material)
+		}
+	}
+	entries := TriangleEntries(mesh.Vertices, mesh.Tris, 0, len(mesh.Tris))
+	bvh := ConstructLinearBVH(BuildBVH(entries, DefaultBVHOptions()))
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	point := func(radius float32) Vec3 {
+		v := Vec3{X: rng.Float32()*2 - 1, Y: rng.Float32()*2 - 1, Z: rng.Float32()*2 - 1}
+		return v.Normalize().Scale(radius * rng.Float32())
+	}
+	rays := make([]Ray, 4096)
+	for i := range rays {
+		origin := point(1).Normalize().Scale(30)
+		rays[i] = Ray{Origin: origin, Direction: point(14).Sub(origin).Normalize()}
+	}
+	b.ResetTimer()
+	return bvh, rays
+}
+
+// benchmarkScene places the benchmark mesh in a scene as a rotated
+// instance.
+func benchmarkScene(bvh *LinearBVH) *TopLevelBVH {
+	lo, hi := entryBounds(bvh.Triangles)
+	instance, err := NewInstance(bvh, lo, hi, Rotation(0, 0.3, 0))
+	if err != nil {
+		panic(err)
+	}
+	primitives := ConstructLinearBVH(BuildBVH(nil, DefaultBVHOptions()))
+	return BuildTopLevelBVH([]*Instance{instance}, primitives, DefaultBVHOptions())
+}
+
+// The benchmarks run for every traversal width, e.g.
+//
+//	go test -run '^$' -bench 'ClosestHit/width=4'
+var benchmarkWidths = []int{2, 4, 8}
+
+func BenchmarkClosestHit(b *testing.B) {
+	bvh, rays := benchmarkBVH(b)
+	for _, width := range benchmarkWidths {
+		bvh.Collapse(width)
+		b.Run(fmt.Sprintf("width=%d/mesh", width), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				bvh.CheckIntersection(rays[i%len(rays)], 100)
+			}
+		})
+		scene := benchmarkScene(bvh)
+		b.Run(fmt.Sprintf("width=%d/scene", width), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				scene.CheckIntersection(rays[i%len(rays)], 100)
+			}
+		})
+	}
+}
+
+func BenchmarkShadow(b *testing.B) {
+	bvh, rays := benchmarkBVH(b)
+	for _, width := range benchmarkWidths {
+		bvh.Collapse(width)
+		b.Run(fmt.Sprintf("width=%d/mesh", width), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				bvh.QuickCheckIntersection(rays[i%len(rays)], 30)
+			}
+		})
+		scene := benchmarkScene(bvh)
+		b.Run(fmt.Sprintf("width=%d/scene", width), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				scene.QuickCheckIntersection(rays[i%len(rays)], 30)
+			}
+		})
+	}
+}
+
+// coherentRays are the rays of a pinhole camera looking at the benchmark
+// mesh, ordered in 8x8 blocks of neighbouring pixels.
+func coherentRays() []Ray {
+	const size = 256
+	origin := Vec3{Y: 8, Z: -30}
+	rays := make([]Ray, 0, size*size)
+	for by := 0; by < size; by += 8 {
+		for bx := 0; bx < size; bx += 8 {
+			for y := by; y < by+8; y++ {
+				for x := bx; x < bx+8; x++ {
+					target := Vec3{X: float32(x)/size*28 - 14, Y: float32(y)/size*28 - 14}
+					rays = append(rays, Ray{Origin: origin, Direction: target.Sub(origin).Normalize()})
+				}
+			}
+		}
+	}
+	return rays
+}
+
+func BenchmarkPacket(b *testing.B) {
+	bvh, _ := benchmarkBVH(b)
+	rays := coherentRays()
+	scene := benchmarkScene(bvh)
+	var packet rayPacket
+	b.Run("closest/single", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			for _, ray := range rays[i*packetSize%len(rays):][:packetSize] {
+				scene.CheckIntersection(ray, 100)
+			}
+		}
+	})
+	b.Run("closest/packet", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			packet.reset(rays[i*packetSize%len(rays):][:packetSize], 100)
+			scene.nearestPacket(&packet)
+		}
+	})
+	b.Run("shadow/single", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			for _, ray := range rays[i*packetSize%len(rays):][:packetSize] {
+				scene.QuickCheckIntersection(ray, 100)
+			}
+		}
+	})
+	b.Run("shadow/packet", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			packet.reset(rays[i*packetSize%len(rays):][:packetSize], 100)
+			scene.occludesPacket(&packet)
+		}
+	})
+}
+
+// Wide trees must find exactly the hits of the binary tree they were
+// collapsed from, both in a mesh BVH and through instances of it.
+func TestWideBVHMatchesBinary(t *testing.T) {
+	mesh := &Mesh{}
+	material := diffuse(0.5, 0.5, 0.5)
+	for x := range 4 {
+		for z := range 4 {
+			mesh.addSphere(Vec3{X: float32(x)*3 - 4.5, Z: float32(z)*3 - 4.5}, 1.2, 12, 24, material)
+		}
+	}
+	mesh.addBox(Vec3{X: -8, Y: -2, Z: -8}, Vec3{X: 8, Y: -1.5, Z: 8}, material)
+	entries := TriangleEntries(mesh.Vertices, mesh.Tris, 0, len(mesh.Tris))
+	bvh := ConstructLinearBVH(BuildBVH(entries, DefaultBVHOptions()))
+	lo, hi := entryBounds(bvh.Triangles)
+	var instances []*Instance
+	for i, transform := range []Mat4{Identity(), Translation(Vec3{Y: 5}).Mul(Rotation(0, 0.3, 0)), Translation(Vec3{X: 12}).Mul(Scaling(Vec3{X: 0.5, Y: 2, Z: 1}))} {
+		instance, err := NewInstance(bvh, lo, hi, transform)
+		if err != nil {
+			t.Fatalf("instance %d: %v", i, err)
+		}
+		instances = append(instances, instance)
+	}
+	scene := BuildTopLevelBVH(instances, ConstructLinearBVH(BuildBVH(nil, DefaultBVHOptions())), DefaultBVHOptions())
+
+	// Rays from a shell around the scene and from inside it, towards
+	// random points among the spheres.
+	rng := rand.New(rand.NewPCG(3, 4))
+	point := func(radius float32) Vec3 {
+		v := Vec3{X: rng.Float32()*2 - 1, Y: rng.Float32()*2 - 1, Z: rng.Float32()*2 - 1}
+		return v.Normalize().Scale(radius * rng.Float32())
+	}
+	rays := make([]Ray, 50000)
+	for i := range rays {
+		origin := point(1).Normalize().Scale(30)
+		if i%2 == 1 {
+			origin = point(10)
+		}
+		rays[i] = Ray{Origin: origin, Direction: point(10).Sub(origin).Normalize()}
+	}
+
+	type result struct {
+		hit, occluded           bool
+		t                       float32
+		index                   int
+		sceneHit, sceneOccluded bool
+		sceneT                  float32
+		sceneIndex              int
+		instance                *Instance
+	}
+	trace := func() []result {
+		results := make([]result, len(rays))
+		for i, ray := range rays {
+			r := &results[i]
+			var tri *BVHTriangle
+			if r.hit, r.t, tri = bvh.CheckIntersection(ray, 100); r.hit {
+				r.index = tri.Index
+			}
+			r.occluded = bvh.QuickCheckIntersection(ray, 20)
+			var sceneTri BVHTriangle
+			r.sceneHit, r.sceneT, sceneTri = scene.CheckIntersection(ray, 100)
+			r.sceneIndex, r.instance = sceneTri.Index, sceneTri.Instance
+			r.sceneOccluded = scene.QuickCheckIntersection(ray, 20)
+		}
+		return results
+	}
+
+	bvh.Collapse(2)
+	binary := trace()
+	hits := 0
+	for _, r := range binary {
+		if r.hit {
+			hits++
+		}
+	}
+	if hits < len(rays)/10 || hits > len(rays)*9/10 {
+		t.Fatalf("%d of %d rays hit the mesh; the rays do not test much", hits, len(rays))
+	}
+	for _, width := range benchmarkWidths[1:] {
+		bvh.Collapse(width)
+		if bvh.Wide == nil {
+			t.Fatalf("width %d: no wide tree", width)
+		}
+		for i, got := range trace() {
+			if got != binary[i] {
+				t.Fatalf("width %d: ray %d finds %+v, the binary tree %+v", width, i, got, binary[i])
+			}
+		}
+	}
+}
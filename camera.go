@@ -12,6 +12,100 @@ type Camera struct {
 	Right            Vec3
 	Up               Vec3
 	FrustrumDistance float32
+
+	// Projection. FocalLength (mm) together with the sensor size takes
+	// precedence over FOV (vertical, degrees). With neither set, the image
+	// plane spans [-1, 1] vertically at FrustrumDistance.
+	FOV                       float32
+	FocalLength               float32
+	SensorWidth, SensorHeight float32 // mm, 36x24 if unset
+
+	// Thin lens. An ApertureRadius of zero is a pinhole. ApertureBlades of
+	// 3 or more gives a polygonal aperture (and bokeh), rotated by
+	// BladeRotation degrees.
+	ApertureRadius float32
+	FocusDistance  float32
+	ApertureBlades int
+	BladeRotation  float32
+}
+
+// VerticalFOV returns the vertical field of view in radians for an image of
+// the given aspect ratio (width / height). With a focal length the image is
+// fitted inside the sensor.
+func (c *Camera) VerticalFOV(aspect float32) float32 {
+	switch {
+	case c.FocalLength > 0:
+		sensorWidth, sensorHeight := c.SensorWidth, c.SensorHeight
+		if sensorWidth <= 0 || sensorHeight <= 0 {
+			sensorWidth, sensorHeight = 36, 24
+		}
+		height := sensorHeight
+		if aspect > sensorWidth/sensorHeight {
+			height = sensorWidth / aspect
+		}
+		return 2 * math32.Atan(height/(2*c.FocalLength))
+	case c.FOV > 0:
+		return c.FOV * 0.0174533
+	}
+	return 2 * math32.Atan(1/c.FrustrumDistance)
+}
+
+// GenerateRay returns the primary ray through the image plane point (x, y),
+// both in [-1, 1] with y running along Up. lensU and lensV are uniform
+// random numbers used to pick a point on the aperture.
+func (c *Camera) GenerateRay(x, y, aspect, lensU, lensV float32) Ray {
+	tanHalf := math32.Tan(c.VerticalFOV(aspect) / 2)
+	direction := c.Forward.
+		Add(c.Right.Scale(x * tanHalf * aspect)).
+		Add(c.Up.Scale(y * tanHalf)).
+		Normalize()
+
+	if c.ApertureRadius <= 0 || c.FocusDistance <= 0 {
+		return NewRay(c.Position, direction)
+	}
+
+	// Every ray through the lens meets the pinhole ray on the focal plane.
+	focus := c.Position.Add(direction.Scale(c.FocusDistance / direction.Dot(c.Forward)))
+	lx, ly := c.sampleAperture(lensU, lensV)
+	origin := c.Position.Add(c.Right.Scale(lx * c.ApertureRadius)).Add(c.Up.Scale(ly * c.ApertureRadius))
+	return NewRay(origin, focus.Sub(origin).Normalize())
+}
+
+// sampleAperture maps two uniform numbers to a uniformly distributed point on
+// the unit disc, or on the regular polygon inscribed in it.
+func (c *Camera) sampleAperture(u, v float32) (float32, float32) {
+	if c.ApertureBlades < 3 {
+		return concentricDisc(u, v)
+	}
+
+	// Pick a wedge of the polygon, then a point in that triangle.
+	blades := float32(c.ApertureBlades)
+	wedge := min(math32.Floor(u*blades), blades-1)
+	u = u*blades - wedge
+
+	a, b := math32.Sqrt(u), v
+	step := 2 * math32.Pi / blades
+	angle0 := c.BladeRotation*0.0174533 + wedge*step
+	angle1 := angle0 + step
+	x := a * ((1-b)*math32.Cos(angle0) + b*math32.Cos(angle1))
+	y := a * ((1-b)*math32.Sin(angle0) + b*math32.Sin(angle1))
+	return x, y
+}
+
+// concentricDisc is Shirley and Chiu's area-preserving square to disc map.
+func concentricDisc(u, v float32) (float32, float32) {
+	a, b := 2*u-1, 2*v-1
+	if a == 0 && b == 0 {
+		return 0, 0
+	}
+
+	var r, phi float32
+	if math32.Abs(a) > math32.Abs(b) {
+		r, phi = a, math32.Pi/4*(b/a)
+	} else {
+		r, phi = b, math32.Pi/2-math32.Pi/4*(a/b)
+	}
+	return r * math32.Cos(phi), r * math32.Sin(phi)
 }
 
 func (c *Camera) SphericalAround(center Vec3, radius, phi, theta float32) {
@@ -22,10 +116,15 @@ func (c *Camera) SphericalAround(center Vec3, radius, phi, theta float32) {
 		Z: center.Z + radius*math32.Sin(theta)*math32.Sin(phi),
 	}
 
-	c.Forward = center.Sub(c.Position).Normalize()
-
 	// Define world up vector (assuming Y-up coordinate system)
-	worldUp := Vec3{X: 0, Y: 1, Z: 0}
+	c.LookAt(c.Position, center, Vec3{X: 0, Y: 1, Z: 0})
+}
+
+// LookAt places the camera at position facing target, with worldUp pointing
+// up on screen.
+func (c *Camera) LookAt(position, target, worldUp Vec3) {
+	c.Position = position
+	c.Forward = target.Sub(position).Normalize()
 
 	// Calculate right vector: worldUp × forward
 	c.Right = worldUp.Cross(c.Forward).Normalize()
@@ -0,0 +1,83 @@
+package main
+
+// cameraHit is what a packet traced ahead of TraceRay for a camera ray:
+// its first hit and what finding it cost and, unless sunLight is -1,
+// whether the shadow ray from the hit towards that sun is blocked.
+// TraceRay takes them from it rather than tracing the same rays again.
+type cameraHit struct {
+	hit  bool
+	t    float32
+	tri  BVHTriangle
+	cost TraversalCost
+
+	sunLight    int // Index in Scene.Lights
+	sunShadowed bool
+}
+
+// samplePacket takes the next n samples of the pixel, at most packetSize.
+// The camera rays are traced as one packet, then the shadow rays from
+// their hits towards the first sun as another, and then each sample is
+// finished by TraceRay. Each sample is started twice, to generate its ray
+// and to trace it, so it sees the same numbers as without packets.
+func (r *Renderer) samplePacket(pixel *Pixel, sampler Sampler, packet *rayPacket, n int) {
+	var rays [packetSize]Ray
+	for i := range n {
+		sampler.StartSample(pixel.X, pixel.Y, pixel.SampleCount+i)
+		rays[i] = r.cameraRay(pixel, sampler)
+	}
+
+	stepSize := r.Settings.StepSize
+	packet.reset(rays[:n], stepSize)
+	r.BVH.nearestPacket(packet)
+	var hits [packetSize]cameraHit
+	for i := range n {
+		hit := &hits[i]
+		hit.hit, hit.t, hit.tri = r.BVH.hit(packet.hits[i])
+		hit.cost = packet.queries[i].cost
+		hit.sunLight = -1
+	}
+
+	if sunLight, sun := r.firstSun(); sun != nil {
+		// The shadow rays HandleDiffuseMaterial would trace from the hits.
+		var shadowRays [packetSize]Ray
+		var ids [packetSize]int
+		count := 0
+		for i := range n {
+			hit := &hits[i]
+			if !hit.hit {
+				continue
+			}
+			hit.sunLight = sunLight
+			p := hit.tri.SurfacePoint(rays[i].Origin.Add(rays[i].Direction.Scale(hit.t)))
+			shadowRays[count] = Ray{
+				Origin:    OffsetRayOrigin(p, hit.tri.GeometricNormal(p), sun.Direction),
+				Direction: sun.Direction,
+			}
+			ids[count] = i
+			count++
+		}
+		if count > 0 {
+			packet.reset(shadowRays[:count], stepSize)
+			r.BVH.occludesPacket(packet)
+			for k := range count {
+				hits[ids[k]].sunShadowed = packet.done[k]
+			}
+		}
+	}
+
+	for i := range n {
+		sampler.StartSample(pixel.X, pixel.Y, pixel.SampleCount)
+		r.cameraRay(pixel, sampler)
+		r.addSample(pixel, sampler, rays[i], &hits[i])
+	}
+}
+
+// firstSun returns the first sun of the scene and its index, or nil.
+func (r *Renderer) firstSun() (int, *Sun) {
+	for i, light := range r.Scene.Lights {
+		if sun, ok := light.Object.(*Sun); ok {
+			return i, sun
+		}
+	}
+	return -1, nil
+}
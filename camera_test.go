@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+const degrees = math32.Pi / 180
+
+// rayAngles returns the angles of a ray's direction off the camera's
+// forward axis, horizontally and vertically.
+func rayAngles(c *Camera, ray Ray) (horizontal, vertical float32) {
+	forward := ray.Direction.Dot(c.Forward)
+	return math32.Atan(ray.Direction.Dot(c.Right) / forward), math32.Atan(ray.Direction.Dot(c.Up) / forward)
+}
+
+func TestCameraFieldOfView(t *testing.T) {
+	camera := lookAt(Vec3{X: 1, Y: 2, Z: -3}, Vec3{Y: 1}, 40)
+	halfFOV := 20 * degrees
+	for _, aspect := range []float32{1, 16.0 / 9, 0.5} {
+		halfWidth := math32.Atan(aspect * math32.Tan(halfFOV))
+		for _, corner := range [][2]float32{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}} {
+			horizontal, vertical := rayAngles(camera, camera.GenerateRay(corner[0], corner[1], aspect, 0.5, 0.5))
+			if math32.Abs(vertical-corner[1]*halfFOV) > 1e-5 {
+				t.Errorf("aspect %g: corner %v is %g° off the axis vertically, want %g°",
+					aspect, corner, vertical/degrees, corner[1]*halfFOV/degrees)
+			}
+			if math32.Abs(horizontal-corner[0]*halfWidth) > 1e-5 {
+				t.Errorf("aspect %g: corner %v is %g° off the axis horizontally, want %g°",
+					aspect, corner, horizontal/degrees, corner[0]*halfWidth/degrees)
+			}
+		}
+	}
+
+	// Without a field of view the image plane spans [-1, 1] at
+	// FrustrumDistance.
+	plane := &Camera{Forward: Vec3{Z: 1}, Right: Vec3{X: 1}, Up: Vec3{Y: 1}, FrustrumDistance: 2}
+	if got := plane.GenerateRay(1, 1, 1.5, 0.5, 0.5).Direction; !near(got, Vec3{X: 1.5, Y: 1, Z: 2}.Normalize()) {
+		t.Errorf("corner ray without a field of view points along %v", got)
+	}
+}
+
+func TestCameraFocalLength(t *testing.T) {
+	tests := []struct {
+		name                      string
+		focalLength               float32
+		sensorWidth, sensorHeight float32
+		aspect                    float32
+		// Half the sensor's width and height the image covers, in mm.
+		halfWidth, halfHeight float32
+	}{
+		{"full frame, 3:2", 50, 36, 24, 1.5, 18, 12},
+		{"default sensor", 50, 0, 0, 1.5, 18, 12},
+		// A wider image than the sensor is cropped at the top and bottom,
+		// a narrower one at the sides.
+		{"full frame, 2:1", 50, 36, 24, 2, 18, 9},
+		{"full frame, square", 35, 36, 24, 1, 12, 12},
+		{"super 35, 16:9", 24, 24.89, 14, 16.0 / 9, 7 * 16.0 / 9, 7},
+	}
+	for _, test := range tests {
+		camera := lookAt(Vec3{}, Vec3{X: 1, Y: 1, Z: 5}, 90)
+		camera.FocalLength = test.focalLength
+		camera.SensorWidth, camera.SensorHeight = test.sensorWidth, test.sensorHeight
+
+		fov := 2 * math32.Atan(test.halfHeight/test.focalLength)
+		if got := camera.VerticalFOV(test.aspect); math32.Abs(got-fov) > 1e-5 {
+			t.Errorf("%s: vertical field of view %g°, want %g°", test.name, got/degrees, fov/degrees)
+		}
+		horizontal, vertical := rayAngles(camera, camera.GenerateRay(1, 1, test.aspect, 0.5, 0.5))
+		if want := math32.Atan(test.halfWidth / test.focalLength); math32.Abs(horizontal-want) > 1e-5 {
+			t.Errorf("%s: corner %g° off the axis horizontally, want %g°", test.name, horizontal/degrees, want/degrees)
+		}
+		if want := fov / 2; math32.Abs(vertical-want) > 1e-5 {
+			t.Errorf("%s: corner %g° off the axis vertically, want %g°", test.name, vertical/degrees, want/degrees)
+		}
+
+		// The focal length overrides FOV, and the equivalent FOV gives
+		// the same rays.
+		equivalent := lookAt(Vec3{}, Vec3{X: 1, Y: 1, Z: 5}, fov/degrees)
+		for _, point := range [][2]float32{{1, 1}, {-0.3, 0.7}} {
+			got := camera.GenerateRay(point[0], point[1], test.aspect, 0.5, 0.5).Direction
+			want := equivalent.GenerateRay(point[0], point[1], test.aspect, 0.5, 0.5).Direction
+			if !near(got, want) {
+				t.Errorf("%s: ray through %v points along %v, with the equivalent field of view %v", test.name, point, got, want)
+			}
+		}
+	}
+}
+
+// Every ray through a pixel leaves from a different point of the lens and
+// meets the pinhole ray on the focus plane.
+func TestThinLensFocus(t *testing.T) {
+	rng := rand.New(rand.NewPCG(11, 12))
+	for _, blades := range []int{0, 6} {
+		camera := lookAt(Vec3{X: 2, Y: 3, Z: -6}, Vec3{Y: 1}, 35)
+		camera.ApertureRadius, camera.FocusDistance, camera.ApertureBlades = 0.4, 7, blades
+		pinhole := *camera
+		pinhole.ApertureRadius = 0
+
+		for range 50 {
+			x, y, aspect := rng.Float32()*2-1, rng.Float32()*2-1, float32(1.5)
+			center := pinhole.GenerateRay(x, y, aspect, 0.5, 0.5)
+			want := center.Origin.Add(center.Direction.Scale(camera.FocusDistance / center.Direction.Dot(camera.Forward)))
+			spread := float32(0)
+			for range 20 {
+				ray := camera.GenerateRay(x, y, aspect, rng.Float32(), rng.Float32())
+				offset := ray.Origin.Sub(camera.Position)
+				if math32.Abs(offset.Dot(camera.Forward)) > 1e-5 || offset.Length() > camera.ApertureRadius*(1+1e-5) {
+					t.Fatalf("%d blades: ray leaves from %v, off the lens", blades, ray.Origin)
+				}
+				spread = max(spread, offset.Length())
+
+				// Where the ray crosses the focus plane.
+				distance := camera.FocusDistance - offset.Dot(camera.Forward)
+				got := ray.Origin.Add(ray.Direction.Scale(distance / ray.Direction.Dot(camera.Forward)))
+				if got.Sub(want).Length() > 1e-4 {
+					t.Fatalf("%d blades: ray through %g,%g meets the focus plane at %v, want %v", blades, x, y, got, want)
+				}
+			}
+			if spread < camera.ApertureRadius/4 {
+				t.Fatalf("%d blades: rays leave from within %g of the centre of the lens", blades, spread)
+			}
+		}
+	}
+}
+
+func TestApertureShape(t *testing.T) {
+	rng := rand.New(rand.NewPCG(13, 14))
+	for _, blades := range []int{0, 3, 5, 6, 9} {
+		for _, rotation := range []float32{0, 17, 90} {
+			camera := &Camera{ApertureBlades: blades, BladeRotation: rotation}
+			var sum [2]float32
+			furthest := float32(0)
+			const n = 20000
+			for i := range n {
+				// Include the edges of the unit square.
+				u, v := rng.Float32(), rng.Float32()
+				if i < 4 {
+					u, v = float32(i%2), float32(i/2)
+				}
+				x, y := camera.sampleAperture(u, v)
+				sum[0] += x
+				sum[1] += y
+				furthest = max(furthest, math32.Hypot(x, y))
+				if !insideAperture(camera, x, y) {
+					t.Fatalf("%d blades at %g°: %g,%g from %g,%g lies outside the aperture", blades, rotation, x, y, u, v)
+				}
+			}
+			// Uniform samples are centred, and reach out to the corners.
+			if math32.Abs(sum[0]/n) > 0.02 || math32.Abs(sum[1]/n) > 0.02 {
+				t.Errorf("%d blades at %g°: samples centred on %g,%g", blades, rotation, sum[0]/n, sum[1]/n)
+			}
+			if furthest < 0.97 {
+				t.Errorf("%d blades at %g°: no sample further out than %g", blades, rotation, furthest)
+			}
+		}
+	}
+}
+
+// insideAperture reports whether x, y lies in the camera's aperture: the
+// unit disc, or the regular polygon inscribed in it.
+func insideAperture(c *Camera, x, y float32) bool {
+	const epsilon = 1e-5
+	if c.ApertureBlades < 3 {
+		return x*x+y*y <= 1+epsilon
+	}
+	step := 2 * math32.Pi / float32(c.ApertureBlades)
+	for i := range c.ApertureBlades {
+		angle0 := c.BladeRotation*degrees + float32(i)*step
+		angle1 := angle0 + step
+		ax, ay := math32.Cos(angle0), math32.Sin(angle0)
+		bx, by := math32.Cos(angle1), math32.Sin(angle1)
+		// The polygon winds anticlockwise, so the inside is to the left
+		// of every edge.
+		if (bx-ax)*(y-ay)-(by-ay)*(x-ax) < -epsilon {
+			return false
+		}
+	}
+	return true
+}
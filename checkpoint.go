@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/g3n/engine/math32"
+)
+
+// A checkpoint file is laid out as
+//
+//	magic "PTCKPT\x00\x00" | version uint32 | fingerprint [32]byte |
+//	width uint32 | height uint32 | pixels | crc32 of everything before
+//
+// with every number little-endian. Pixels are stored row by row in buffer
+// order (not image order) as checkpointPixelSize bytes each: the sample
+// count as a uint64 and 13 float32s of the estimate, then the AOV
+// accumulator's sample and hit counts as uint64s, its 19 float32s, its
+// material and object IDs as int32s and its traversal costs as int64s.
+const (
+	checkpointMagic     = "PTCKPT\x00\x00"
+	checkpointVersion   = 3
+	checkpointPixelSize = 8 + 4*13 + 8*2 + 4*19 + 4*2 + 8*2
+)
+
+var ErrCheckpointMismatch = errors.New("checkpoint was made for a different scene or render settings")
+
+// Fingerprint identifies everything that decides what a converged pixel
+// looks like: the scene description, its geometry, materials, textures and
+// lights, the camera and the settings that change the estimate. Materials
+// and lights are hashed as loaded, so edits to MTL or glTF files count too.
+// Sample budgets, thread count and tone mapping are left out so a resumed
+// render can change them.
+func (r *Renderer) Fingerprint() [32]byte {
+	h := sha256.New()
+	write := func(data any) { binary.Write(h, binary.LittleEndian, data) }
+
+	h.Write(r.Scene.Description)
+
+	camera := r.Scene.Camera
+	write([]Vec3{camera.Position, camera.Forward, camera.Right, camera.Up})
+	write([]float32{
+		camera.FrustrumDistance, camera.FOV, camera.FocalLength, camera.SensorWidth, camera.SensorHeight,
+		camera.ApertureRadius, camera.FocusDistance, float32(camera.ApertureBlades), camera.BladeRotation,
+	})
+
+	s := r.Settings
+	write([]int64{int64(s.Width), int64(s.Height), int64(s.Bounces), int64(s.ScatterRays), int64(s.MaxSteps)})
+	write([]float32{s.StepSize, s.Ambient})
+	h.Write([]byte(s.Sampler))
+	write(s.Seed)
+
+	write(r.VNMU.Vertices)
+	write(r.VNMU.Normals)
+	write(r.VNMU.UVs)
+	// The BVH reorders triangles; hash their indices in mesh order. Index
+	// is the offset of the triangle in the flat index list.
+	triangles := r.BVH.Triangles()
+	count := 0
+	for _, triangle := range triangles {
+		count = max(count, triangle.Index+3)
+	}
+	indices := make([]int64, count)
+	for _, triangle := range triangles {
+		copy(indices[triangle.Index:], []int64{int64(triangle.X), int64(triangle.Y), int64(triangle.Z)})
+	}
+	write(indices)
+	for _, instance := range r.BVH.Instances {
+		write(instance.ObjectToWorld)
+	}
+
+	images := make(map[*CachedImage][32]byte)
+	for _, material := range r.VNMU.Materials {
+		writeMaterial(h, material, images)
+	}
+	for _, instance := range r.BVH.Instances {
+		writeMaterial(h, instance.Material, images)
+	}
+	for _, light := range r.Scene.Lights {
+		write(light.Position)
+		switch l := light.Object.(type) {
+		case *Sun:
+			h.Write([]byte("sun"))
+			write([]Vec3{l.Color, l.Direction})
+			write(l.Intensity)
+		case *PointLight:
+			h.Write([]byte("point"))
+			write(l.Color)
+			write(l.Intensity)
+		}
+	}
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// writeMaterial hashes the shading fields of m, if set, and its textures.
+// Texture contents are hashed once each and remembered in images, so an
+// image edited under the same path still changes the fingerprint.
+func writeMaterial(h io.Writer, m *Material, images map[*CachedImage][32]byte) {
+	write := func(data any) { binary.Write(h, binary.LittleEndian, data) }
+	if m == nil {
+		write(false)
+		return
+	}
+	write(true)
+	write(int64(m.Illum))
+	write([]float32{m.Opacity, m.Refraction, m.Shininess, m.NormalScale})
+	for _, c := range []math32.Color{m.Ambient, m.Diffuse, m.Specular, m.Emissive} {
+		write([]float32{c.R, c.G, c.B})
+	}
+	io.WriteString(h, m.MapKd+"\x00"+m.MapBump+"\x00")
+	write(m.HasImage)
+	for _, img := range []*CachedImage{m.DiffuseImage, m.BumpImage, m.NormalImage} {
+		if img == nil {
+			write(false)
+			continue
+		}
+		sum, ok := images[img]
+		if !ok {
+			content := sha256.New()
+			binary.Write(content, binary.LittleEndian, []int64{int64(img.Width), int64(img.Height)})
+			binary.Write(content, binary.LittleEndian, img.pixelsSlice)
+			content.Sum(sum[:0])
+			images[img] = sum
+		}
+		write(true)
+		h.Write(sum[:])
+	}
+}
+
+// SaveCheckpoint writes the pixel buffer to path. The workers must be
+// stopped so the snapshot is consistent. The file is written next to path
+// and renamed into place, so a crash never leaves a truncated checkpoint.
+func (r *Renderer) SaveCheckpoint(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(tmp, crc))
+
+	fingerprint := r.Fingerprint()
+	w.WriteString(checkpointMagic)
+	binary.Write(w, binary.LittleEndian, uint32(checkpointVersion))
+	w.Write(fingerprint[:])
+	binary.Write(w, binary.LittleEndian, [2]uint32{uint32(r.Settings.Width), uint32(r.Settings.Height)})
+
+	var buf [checkpointPixelSize]byte
+	for y := range r.Pixels {
+		for x := range r.Pixels[y] {
+			encodeCheckpointPixel(buf[:], &r.Pixels[y][x])
+			w.Write(buf[:])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := binary.Write(tmp, binary.LittleEndian, crc.Sum32()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint replaces the pixel buffer with the one stored at path. It
+// refuses checkpoints of another version, resolution or fingerprint and
+// leaves the buffer untouched if anything is wrong.
+func (r *Renderer) LoadCheckpoint(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	headerSize := len(checkpointMagic) + 4 + 32 + 8
+	if len(data) < headerSize+4 || string(data[:len(checkpointMagic)]) != checkpointMagic {
+		return errors.New("not a checkpoint file")
+	}
+	body, tail := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(tail) {
+		return errors.New("checkpoint is corrupt (checksum mismatch)")
+	}
+
+	header := body[len(checkpointMagic):headerSize]
+	if version := binary.LittleEndian.Uint32(header); version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d (want %d)", version, checkpointVersion)
+	}
+	width := int(binary.LittleEndian.Uint32(header[36:]))
+	height := int(binary.LittleEndian.Uint32(header[40:]))
+	if width != r.Settings.Width || height != r.Settings.Height {
+		return fmt.Errorf("%w: checkpoint is %dx%d, render is %dx%d",
+			ErrCheckpointMismatch, width, height, r.Settings.Width, r.Settings.Height)
+	}
+	fingerprint := r.Fingerprint()
+	if !bytes.Equal(header[4:36], fingerprint[:]) {
+		return ErrCheckpointMismatch
+	}
+
+	pixels := body[headerSize:]
+	if len(pixels) != width*height*checkpointPixelSize {
+		return fmt.Errorf("checkpoint has %d bytes of pixels, want %d", len(pixels), width*height*checkpointPixelSize)
+	}
+
+	var samples int64
+	for y := range r.Pixels {
+		for x := range r.Pixels[y] {
+			pixel := &r.Pixels[y][x]
+			decodeCheckpointPixel(pixels[:checkpointPixelSize], pixel)
+			pixels = pixels[checkpointPixelSize:]
+			samples += int64(pixel.SampleCount)
+		}
+	}
+	r.Samples.Store(samples)
+	return nil
+}
+
+// pixelCursor reads or writes the fields of a stored pixel in turn.
+type pixelCursor []byte
+
+func (c *pixelCursor) putInt64(v int64) {
+	binary.LittleEndian.PutUint64(*c, uint64(v))
+	*c = (*c)[8:]
+}
+
+func (c *pixelCursor) putInt32(v int32) {
+	binary.LittleEndian.PutUint32(*c, uint32(v))
+	*c = (*c)[4:]
+}
+
+func (c *pixelCursor) putFloat32(values ...float32) {
+	for _, v := range values {
+		binary.LittleEndian.PutUint32(*c, math.Float32bits(v))
+		*c = (*c)[4:]
+	}
+}
+
+func (c *pixelCursor) putVec3(v Vec3) {
+	c.putFloat32(v.X, v.Y, v.Z)
+}
+
+func (c *pixelCursor) int64() int64 {
+	v := int64(binary.LittleEndian.Uint64(*c))
+	*c = (*c)[8:]
+	return v
+}
+
+func (c *pixelCursor) int32() int32 {
+	v := int32(binary.LittleEndian.Uint32(*c))
+	*c = (*c)[4:]
+	return v
+}
+
+func (c *pixelCursor) float32() float32 {
+	v := math.Float32frombits(binary.LittleEndian.Uint32(*c))
+	*c = (*c)[4:]
+	return v
+}
+
+func (c *pixelCursor) vec3() Vec3 {
+	return Vec3{X: c.float32(), Y: c.float32(), Z: c.float32()}
+}
+
+func encodeCheckpointPixel(buf []byte, p *Pixel) {
+	c := pixelCursor(buf)
+	c.putInt64(int64(p.SampleCount))
+	c.putFloat32(p.R, p.G, p.B, p.Variance)
+	c.putVec3(p.M2)
+	c.putVec3(p.Mean)
+	c.putFloat32(p.MinLuminance, p.MaxLuminance, p.Contrast)
+
+	a := &p.AOV
+	c.putInt64(int64(a.Samples))
+	c.putInt64(int64(a.Hits))
+	for _, v := range []Vec3{a.Albedo, a.Normal, a.Position, a.Direct, a.Indirect, a.Emission} {
+		c.putVec3(v)
+	}
+	c.putFloat32(a.Depth)
+	c.putInt32(a.Material)
+	c.putInt32(a.Object)
+	c.putInt64(a.Nodes)
+	c.putInt64(a.Tests)
+}
+
+func decodeCheckpointPixel(buf []byte, p *Pixel) {
+	c := pixelCursor(buf)
+	p.SampleCount = int(c.int64())
+	p.R, p.G, p.B, p.Variance = c.float32(), c.float32(), c.float32(), c.float32()
+	p.M2 = c.vec3()
+	p.Mean = c.vec3()
+	p.MinLuminance, p.MaxLuminance, p.Contrast = c.float32(), c.float32(), c.float32()
+
+	a := &p.AOV
+	a.Samples = int(c.int64())
+	a.Hits = int(c.int64())
+	for _, v := range []*Vec3{&a.Albedo, &a.Normal, &a.Position, &a.Direct, &a.Indirect, &a.Emission} {
+		*v = c.vec3()
+	}
+	a.Depth = c.float32()
+	a.Material = c.int32()
+	a.Object = c.int32()
+	a.Nodes = c.int64()
+	a.Tests = c.int64()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+// A render stopped halfway, checkpointed and resumed must end exactly
+// where an uninterrupted one does, AOVs included.
+func TestCheckpointResumesAOVs(t *testing.T) {
+	scene := goldenScenes[0]
+	// Packets count traversal steps per packet, which depend on how the
+	// samples are split into passes; single rays count the same either way.
+	samples := func(n int) func(*RenderSettings) {
+		return func(s *RenderSettings) {
+			s.SamplesPerPixel, s.MaxSamplesPerPixel = n, n
+			s.Packets = false
+		}
+	}
+	straight := renderGolden(t, scene, 1, samples(8))
+
+	path := filepath.Join(t.TempDir(), "render.ckpt")
+	if err := renderGolden(t, scene, 1, samples(4)).SaveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	settings := goldenSettings()
+	settings.Threads = 1
+	samples(8)(&settings)
+	resumed, err := NewRenderer(scene.build(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.LoadCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resumed.Samples.Load(), int64(4*settings.Width*settings.Height); got != want {
+		t.Fatalf("resumed with %d samples, want %d", got, want)
+	}
+	resumed.Run(nil, nil)
+
+	hits := 0
+	for y := range straight.Pixels {
+		for x := range straight.Pixels[y] {
+			want, got := &straight.Pixels[y][x], &resumed.Pixels[y][x]
+			if got.AOV != want.AOV {
+				t.Fatalf("pixel %d,%d AOVs are %+v resumed, %+v straight", x, y, got.AOV, want.AOV)
+			}
+			if got.R != want.R || got.G != want.G || got.B != want.B {
+				t.Fatalf("pixel %d,%d is %v resumed, %v straight", x, y,
+					[3]float32{got.R, got.G, got.B}, [3]float32{want.R, want.G, want.B})
+			}
+			hits += got.AOV.Hits
+		}
+	}
+	if hits == 0 {
+		t.Fatal("no pixel hit anything; the AOVs were not exercised")
+	}
+	for _, aov := range AOVNames {
+		parsed, err := ParseAOV(aov)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, got := straight.AOVImage(parsed), resumed.AOVImage(parsed)
+		for i := range want.Pix {
+			if got.Pix[i] != want.Pix[i] {
+				t.Fatalf("%s AOV differs at byte %d", aov, i)
+			}
+		}
+	}
+}
+
+func TestCheckpointPixelRoundTrip(t *testing.T) {
+	pixel := Pixel{
+		R: 1, G: 2, B: 3, SampleCount: 17, Variance: 0.5,
+		M2: Vec3{X: 4, Y: 5, Z: 6}, Mean: Vec3{X: 7, Y: 8, Z: 9},
+		MinLuminance: 0.1, MaxLuminance: 10, Contrast: 0.25,
+		AOV: AOVAccumulator{
+			Samples: 17, Hits: 12,
+			Albedo: Vec3{X: 1}, Normal: Vec3{Y: 2}, Position: Vec3{Z: 3},
+			Depth:    42,
+			Material: 3, Object: -1,
+			Direct: Vec3{X: 4}, Indirect: Vec3{Y: 5}, Emission: Vec3{Z: 6},
+			Nodes: 1 << 40, Tests: 123456,
+		},
+	}
+	var buf [checkpointPixelSize]byte
+	encodeCheckpointPixel(buf[:], &pixel)
+	var decoded Pixel
+	decodeCheckpointPixel(buf[:], &decoded)
+	decoded.X, decoded.Y = pixel.X, pixel.Y
+	if decoded.AOV != pixel.AOV {
+		t.Errorf("AOVs decoded as %+v, want %+v", decoded.AOV, pixel.AOV)
+	}
+	if decoded.R != pixel.R || decoded.SampleCount != pixel.SampleCount || decoded.Mean != pixel.Mean ||
+		decoded.M2 != pixel.M2 || decoded.Contrast != pixel.Contrast || decoded.MaxLuminance != pixel.MaxLuminance {
+		t.Errorf("pixel decoded as %+v, want %+v", &decoded, &pixel)
+	}
+}
+
+// A checkpoint of one scene must not be resumed into another, however
+// small the difference, and a refused load leaves the buffer alone.
+func TestCheckpointRefusesOtherScenes(t *testing.T) {
+	few := func(s *RenderSettings) { s.SamplesPerPixel, s.MaxSamplesPerPixel = 2, 2 }
+	dir := t.TempDir()
+	// checkpoint renders the sun-plane scene, edited by edit if set, and
+	// saves a checkpoint of it.
+	checkpoint := func(name string, edit func(*Scene)) string {
+		build := func() *Scene {
+			scene := sunPlaneScene()
+			if edit != nil {
+				edit(scene)
+			}
+			return scene
+		}
+		path := filepath.Join(dir, name+".ckpt")
+		if err := renderGolden(t, goldenScene{build: build}, 1, few).SaveCheckpoint(path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	// textured gives the floor a grey texture of the given shade, always
+	// under the same path.
+	textured := func(shade uint8) func(*Scene) {
+		return func(s *Scene) {
+			img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+			draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{shade, shade, shade, 255}}, image.Point{}, draw.Src)
+			cached := CacheImage(img)
+			material := s.Meshes[0].Mesh.Materials[0]
+			material.MapKd, material.HasImage, material.DiffuseImage = "floor.png", true, &cached
+		}
+	}
+	plain, withTexture := checkpoint("plain", nil), checkpoint("textured", textured(100))
+
+	tests := []struct {
+		name       string
+		checkpoint string
+		settings   func(*RenderSettings)
+		scene      func(*Scene)
+		accepted   bool
+	}{
+		{"unchanged", plain, nil, nil, true},
+		{"same texture", withTexture, nil, textured(100), true},
+		{"more bounces", plain, func(s *RenderSettings) { s.Bounces++ }, nil, false},
+		{"other seed", plain, func(s *RenderSettings) { s.Seed++ }, nil, false},
+		{"diffuse colour", plain, nil, func(s *Scene) { s.Meshes[0].Mesh.Materials[0].Diffuse.G += 0.1 }, false},
+		{"emission", plain, nil, func(s *Scene) { s.Meshes[0].Mesh.Materials[0].Emissive.R = 1 }, false},
+		{"index of refraction", plain, nil, func(s *Scene) { s.Meshes[0].Mesh.Materials[0].Refraction = 1.33 }, false},
+		{"texture path", plain, nil, func(s *Scene) { s.Meshes[0].Mesh.Materials[0].MapKd = "floor.png" }, false},
+		{"texture contents", withTexture, nil, textured(200), false},
+		{"sun intensity", plain, nil, func(s *Scene) { s.Lights[0].Object.(*Sun).Intensity *= 2 }, false},
+		{"sun direction", plain, nil, func(s *Scene) { s.Lights[0].Object.(*Sun).Direction = Vec3{Y: 1} }, false},
+		{"point light added", plain, nil, func(s *Scene) {
+			s.Lights = append(s.Lights, &GameObject[Light]{Position: Vec3{Y: 5}, Object: &PointLight{Color: Vec3{X: 1, Y: 1, Z: 1}, Intensity: 10}})
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scene := sunPlaneScene()
+			if test.scene != nil {
+				test.scene(scene)
+			}
+			settings := goldenSettings()
+			settings.Threads = 1
+			settings.SamplesPerPixel, settings.MaxSamplesPerPixel = 1, 1
+			if test.settings != nil {
+				test.settings(&settings)
+			}
+			r, err := NewRenderer(scene, settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Run(nil, nil)
+			before, samples := r.Image(), r.Samples.Load()
+
+			err = r.LoadCheckpoint(test.checkpoint)
+			if test.accepted {
+				if err != nil {
+					t.Fatalf("the checkpoint of the same scene is refused: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrCheckpointMismatch) {
+				t.Fatalf("LoadCheckpoint = %v, want %v", err, ErrCheckpointMismatch)
+			}
+			if r.Samples.Load() != samples || !bytes.Equal(r.Image().Pix, before.Pix) {
+				t.Error("the refused checkpoint changed the pixel buffer")
+			}
+		})
+	}
+}
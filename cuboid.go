@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/chewxy/math32"
+)
+
+// Cuboid is an axis-aligned box. Every face is textured once, with u and v
+// along its two axes in x, y, z order.
+type Cuboid struct {
+	Min, Max Vec3
+}
+
+func (c *Cuboid) Bounds() (Vec3, Vec3) {
+	return c.Min, c.Max
+}
+
+func (c *Cuboid) Intersect(ray Ray, tMax float32) (bool, float32) {
+	inverse := ray.Direction.Inverse()
+	t1 := c.Min.Sub(ray.Origin).ComponentMul(inverse)
+	t2 := c.Max.Sub(ray.Origin).ComponentMul(inverse)
+
+	near := max(min(t1.X, t2.X), min(t1.Y, t2.Y), min(t1.Z, t2.Z))
+	far := min(max(t1.X, t2.X), max(t1.Y, t2.Y), max(t1.Z, t2.Z))
+	if near > far {
+		return false, 0
+	}
+
+	// From inside the box the ray leaves through the far face.
+	t := near
+	if t <= primitiveEpsilon {
+		t = far
+	}
+	if t <= primitiveEpsilon || t > tMax {
+		return false, 0
+	}
+	return true, t
+}
+
+// Surface picks the face the point is closest to.
+func (c *Cuboid) Surface(p Vec3) (Vec3, float32, float32) {
+	size := c.Max.Sub(c.Min)
+	local := p.Sub(c.Min)
+	rel := [3]float32{local.X / size.X, local.Y / size.Y, local.Z / size.Z}
+
+	axis, side, best := 0, float32(0), float32(math32.MaxFloat32)
+	for i, r := range rel {
+		if d := math32.Abs(r); d < best {
+			axis, side, best = i, -1, d
+		}
+		if d := math32.Abs(1 - r); d < best {
+			axis, side, best = i, 1, d
+		}
+	}
+
+	var normal Vec3
+	var u, v float32
+	switch axis {
+	case 0:
+		normal, u, v = Vec3{X: side}, rel[1], rel[2]
+	case 1:
+		normal, u, v = Vec3{Y: side}, rel[0], rel[2]
+	default:
+		normal, u, v = Vec3{Z: side}, rel[0], rel[1]
+	}
+	return normal, u, v
+}
+
+func (c *Cuboid) Area() float32 {
+	d := c.Max.Sub(c.Min)
+	return 2 * (d.X*d.Y + d.Y*d.Z + d.Z*d.X)
+}
+
+// Sample picks a face with probability proportional to its area, reusing u
+// to place the point on it.
+func (c *Cuboid) Sample(u, v float32) (Vec3, Vec3) {
+	lo := [3]float32{c.Min.X, c.Min.Y, c.Min.Z}
+	hi := [3]float32{c.Max.X, c.Max.Y, c.Max.Z}
+	size := [3]float32{hi[0] - lo[0], hi[1] - lo[1], hi[2] - lo[2]}
+	faces := [3]float32{size[1] * size[2], size[0] * size[2], size[0] * size[1]} // Faces across x, y, z
+
+	x := u * 2 * (faces[0] + faces[1] + faces[2])
+	axis := 0
+	for axis < 2 && x >= 2*faces[axis] {
+		x -= 2 * faces[axis]
+		axis++
+	}
+
+	point, normal := lo, [3]float32{}
+	normal[axis] = -1
+	if x >= faces[axis] {
+		x -= faces[axis]
+		point[axis], normal[axis] = hi[axis], 1
+	}
+	a, b := (axis+1)%3, (axis+2)%3
+	point[a] += min(x/faces[axis], oneMinusEpsilon) * size[a]
+	point[b] += v * size[b]
+	return Vec3{X: point[0], Y: point[1], Z: point[2]}, Vec3{X: normal[0], Y: normal[1], Z: normal[2]}
+}
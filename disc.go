@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+
+	"github.com/chewxy/math32"
+)
+
+// Disc is a flat circle. Its texture is polar: u runs around the rim from
+// the tangent, v from the centre outwards.
+type Disc struct {
+	Position, Normal Vec3
+	Radius           float32
+
+	tangent, bitangent Vec3
+}
+
+func NewDisc(position, normal, tangent Vec3, radius float32) *Disc {
+	d := &Disc{Position: position, Normal: normal.Normalize(), Radius: radius}
+	d.tangent, d.bitangent = orthonormalBasis(d.Normal, tangent)
+	return d
+}
+
+func (d *Disc) Bounds() (Vec3, Vec3) {
+	n := d.Normal
+	extent := Vec3{
+		X: d.Radius * math32.Sqrt(max(0, 1-n.X*n.X)),
+		Y: d.Radius * math32.Sqrt(max(0, 1-n.Y*n.Y)),
+		Z: d.Radius * math32.Sqrt(max(0, 1-n.Z*n.Z)),
+	}
+	return d.Position.Sub(extent), d.Position.Add(extent)
+}
+
+func (d *Disc) Intersect(ray Ray, tMax float32) (bool, float32) {
+	denominator := d.Normal.Dot(ray.Direction)
+	if math32.Abs(denominator) < 1e-8 {
+		return false, 0
+	}
+	t := d.Normal.Dot(d.Position.Sub(ray.Origin)) / denominator
+	if t <= primitiveEpsilon || t > tMax {
+		return false, 0
+	}
+	local := ray.Origin.Add(ray.Direction.Scale(t)).Sub(d.Position)
+	if local.Dot(local) > d.Radius*d.Radius {
+		return false, 0
+	}
+	return true, t
+}
+
+func (d *Disc) Surface(p Vec3) (Vec3, float32, float32) {
+	local := p.Sub(d.Position)
+	x, y := local.Dot(d.tangent), local.Dot(d.bitangent)
+	u := 0.5 + math32.Atan2(y, x)/(2*math.Pi)
+	v := math32.Sqrt(x*x+y*y) / d.Radius
+	return d.Normal, u, v
+}
+
+func (d *Disc) Area() float32 {
+	return math.Pi * d.Radius * d.Radius
+}
+
+func (d *Disc) Sample(u, v float32) (Vec3, Vec3) {
+	x, y := concentricDisc(u, v)
+	point := d.Position.
+		Add(d.tangent.Scale(x * d.Radius)).
+		Add(d.bitangent.Scale(y * d.Radius))
+	return point, d.Normal
+}
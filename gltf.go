@@ -0,0 +1,960 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chewxy/math32"
+	g3nmath "github.com/g3n/engine/math32"
+)
+
+// The parts of a glTF 2.0 document the importer reads. Indices into the
+// document's arrays are pointers where glTF lets them be absent.
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Textures    []struct {
+		Source *int `json:"source"`
+	} `json:"textures"`
+	Images  []gltfImage  `json:"images"`
+	Cameras []gltfCamera `json:"cameras"`
+
+	Extensions struct {
+		Lights *struct {
+			Lights []gltfLight `json:"lights"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+	ExtensionsRequired []string `json:"extensionsRequired"`
+}
+
+type gltfNode struct {
+	Name        string       `json:"name"`
+	Children    []int        `json:"children"`
+	Mesh        *int         `json:"mesh"`
+	Camera      *int         `json:"camera"`
+	Matrix      *[16]float32 `json:"matrix"` // Column-major
+	Translation *[3]float32  `json:"translation"`
+	Rotation    *[4]float32  `json:"rotation"` // Quaternion x, y, z, w
+	Scale       *[3]float32  `json:"scale"`
+
+	Extensions struct {
+		Light *struct {
+			Light int `json:"light"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type gltfMesh struct {
+	Name       string `json:"name"`
+	Primitives []struct {
+		Attributes map[string]int `json:"attributes"`
+		Indices    *int           `json:"indices"`
+		Material   *int           `json:"material"`
+		Mode       *int           `json:"mode"`
+	} `json:"primitives"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltfTextureInfo struct {
+	Index    int      `json:"index"`
+	TexCoord int      `json:"texCoord"`
+	Scale    *float32 `json:"scale"` // Normal textures only
+}
+
+type gltfMaterial struct {
+	Name string `json:"name"`
+	PBR  struct {
+		BaseColorFactor          *[4]float32      `json:"baseColorFactor"`
+		BaseColorTexture         *gltfTextureInfo `json:"baseColorTexture"`
+		MetallicFactor           *float32         `json:"metallicFactor"`
+		RoughnessFactor          *float32         `json:"roughnessFactor"`
+		MetallicRoughnessTexture *gltfTextureInfo `json:"metallicRoughnessTexture"`
+	} `json:"pbrMetallicRoughness"`
+	NormalTexture    *gltfTextureInfo `json:"normalTexture"`
+	OcclusionTexture *gltfTextureInfo `json:"occlusionTexture"`
+	EmissiveTexture  *gltfTextureInfo `json:"emissiveTexture"`
+	EmissiveFactor   [3]float32       `json:"emissiveFactor"`
+
+	Extensions struct {
+		EmissiveStrength *struct {
+			EmissiveStrength float32 `json:"emissiveStrength"`
+		} `json:"KHR_materials_emissive_strength"`
+		IOR *struct {
+			IOR float32 `json:"ior"`
+		} `json:"KHR_materials_ior"`
+	} `json:"extensions"`
+}
+
+type gltfImage struct {
+	URI        string `json:"uri"`
+	BufferView *int   `json:"bufferView"`
+}
+
+type gltfCamera struct {
+	Type        string `json:"type"`
+	Perspective *struct {
+		YFOV float32 `json:"yfov"` // Radians
+	} `json:"perspective"`
+}
+
+type gltfLight struct {
+	Type      string      `json:"type"` // "directional", "point" or "spot"
+	Color     *[3]float32 `json:"color"`
+	Intensity *float32    `json:"intensity"`
+}
+
+// gltfExtensions are the extensions a file may require and still load.
+var gltfExtensions = map[string]bool{
+	"KHR_lights_punctual":             true,
+	"KHR_materials_emissive_strength": true,
+	"KHR_materials_ior":               true,
+}
+
+// gltfFile is a glTF document being imported, with what has been loaded
+// from it so far.
+type gltfFile struct {
+	doc      gltfDocument
+	dir      string
+	bin      []byte // The binary chunk of a GLB
+	warnings []string
+
+	buffers   map[int][]byte
+	images    map[int]image.Image
+	meshes    map[int]*Mesh
+	materials map[int]*Material // The default material under -1
+}
+
+// ImportGLTF loads the default scene of the glTF 2.0 file at path: a .gltf
+// with its buffers and images embedded or in files next to it, or a .glb.
+// Every node with a mesh becomes an object placed by the node's world
+// transform, and the nodes of a mesh share one Mesh. KHR_lights_punctual
+// lights become lights, spot lights as point lights, and the camera of the
+// first node with a perspective camera becomes Camera, nil if there is
+// none. See gltfFile.material for how materials are converted.
+func ImportGLTF(path string) (*Scene, error) {
+	f, err := openGLTF(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading gltf: %w", err)
+	}
+	scene, err := f.scene()
+	for _, warning := range f.warnings {
+		fmt.Printf("%s: %s\n", filepath.Base(path), warning)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	return scene, nil
+}
+
+func openGLTF(path string) (*gltfFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &gltfFile{
+		dir:       filepath.Dir(path),
+		buffers:   make(map[int][]byte),
+		images:    make(map[int]image.Image),
+		meshes:    make(map[int]*Mesh),
+		materials: make(map[int]*Material),
+	}
+	if bytes.HasPrefix(data, []byte("glTF")) {
+		if data, f.bin, err = splitGLB(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, &f.doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(f.doc.Asset.Version, "2.") {
+		return nil, fmt.Errorf("unsupported glTF version %q", f.doc.Asset.Version)
+	}
+	for _, extension := range f.doc.ExtensionsRequired {
+		if !gltfExtensions[extension] {
+			return nil, fmt.Errorf("required extension %s is not supported", extension)
+		}
+	}
+	return f, nil
+}
+
+// splitGLB returns the JSON and binary chunks of a GLB file: a header of
+// magic, version and length, then chunks of a length, a type and data,
+// all little-endian.
+func splitGLB(data []byte) (jsonChunk, bin []byte, err error) {
+	if len(data) < 12 {
+		return nil, nil, errors.New("truncated GLB header")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, nil, fmt.Errorf("unsupported GLB version %d", version)
+	}
+	if length := binary.LittleEndian.Uint32(data[8:]); uint64(length) > uint64(len(data)) {
+		return nil, nil, errors.New("truncated GLB file")
+	} else {
+		data = data[12:length]
+	}
+	for len(data) >= 8 {
+		size, kind := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		if uint64(size) > uint64(len(data)-8) {
+			return nil, nil, errors.New("truncated GLB chunk")
+		}
+		chunk := data[8 : 8+size]
+		switch kind {
+		case 0x4E4F534A: // "JSON"
+			if jsonChunk == nil {
+				jsonChunk = chunk
+			}
+		case 0x004E4942: // "BIN\0"
+			if bin == nil {
+				bin = chunk
+			}
+		}
+		data = data[8+size:]
+	}
+	if jsonChunk == nil {
+		return nil, nil, errors.New("GLB file without a JSON chunk")
+	}
+	return jsonChunk, bin, nil
+}
+
+func (f *gltfFile) warn(format string, args ...any) {
+	f.warnings = append(f.warnings, fmt.Sprintf(format, args...))
+}
+
+// scene walks the node trees of the default scene, or of the first one,
+// or of every node no other node has as a child if there are no scenes.
+func (f *gltfFile) scene() (*Scene, error) {
+	var roots []int
+	switch {
+	case f.doc.Scene != nil:
+		if *f.doc.Scene < 0 || *f.doc.Scene >= len(f.doc.Scenes) {
+			return nil, fmt.Errorf("scene %d does not exist", *f.doc.Scene)
+		}
+		roots = f.doc.Scenes[*f.doc.Scene].Nodes
+	case len(f.doc.Scenes) > 0:
+		roots = f.doc.Scenes[0].Nodes
+	default:
+		child := make([]bool, len(f.doc.Nodes))
+		for _, node := range f.doc.Nodes {
+			for _, c := range node.Children {
+				if c >= 0 && c < len(child) {
+					child[c] = true
+				}
+			}
+		}
+		for i := range f.doc.Nodes {
+			if !child[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+
+	scene := &Scene{}
+	visited := make([]bool, len(f.doc.Nodes))
+	var visit func(index int, parent Mat4) error
+	visit = func(index int, parent Mat4) error {
+		if index < 0 || index >= len(f.doc.Nodes) {
+			return fmt.Errorf("node %d does not exist", index)
+		}
+		if visited[index] {
+			return fmt.Errorf("node %d is reached twice; nodes must form trees", index)
+		}
+		visited[index] = true
+		node := &f.doc.Nodes[index]
+		world := parent.Mul(node.local())
+
+		if node.Mesh != nil {
+			mesh, err := f.mesh(*node.Mesh)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", index, err)
+			}
+			if mesh != nil {
+				transform := world
+				scene.Meshes = append(scene.Meshes, &GameObject[any]{Mesh: mesh, Transform: &transform})
+			}
+		}
+		if node.Camera != nil && scene.Camera == nil {
+			camera, err := f.camera(*node.Camera, world)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", index, err)
+			}
+			scene.Camera = camera
+		}
+		if light := node.Extensions.Light; light != nil {
+			object, err := f.light(light.Light, world)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", index, err)
+			}
+			if object != nil {
+				scene.Lights = append(scene.Lights, object)
+			}
+		}
+		for _, child := range node.Children {
+			if err := visit(child, world); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		if err := visit(root, Identity()); err != nil {
+			return nil, err
+		}
+	}
+	return scene, nil
+}
+
+// local is the node's transform relative to its parent.
+func (n *gltfNode) local() Mat4 {
+	if n.Matrix != nil {
+		var m Mat4
+		for i := range 4 {
+			for j := range 4 {
+				m[i][j] = n.Matrix[4*j+i]
+			}
+		}
+		return m
+	}
+	m := Identity()
+	if n.Translation != nil {
+		m = Translation(vec(*n.Translation))
+	}
+	if n.Rotation != nil {
+		m = m.Mul(quaternionMatrix(*n.Rotation))
+	}
+	if n.Scale != nil {
+		m = m.Mul(Scaling(vec(*n.Scale)))
+	}
+	return m
+}
+
+// quaternionMatrix is the rotation by the quaternion x, y, z, w.
+func quaternionMatrix(q [4]float32) Mat4 {
+	length := math32.Sqrt(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])
+	if length == 0 {
+		return Identity()
+	}
+	x, y, z, w := q[0]/length, q[1]/length, q[2]/length, q[3]/length
+	return Mat4{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w), 0},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w), 0},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y), 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// mesh converts mesh index, or returns nil if it has no triangles. Its
+// primitives become one Mesh; those without normals get flat ones, as
+// glTF asks.
+func (f *gltfFile) mesh(index int) (*Mesh, error) {
+	if mesh, ok := f.meshes[index]; ok {
+		return mesh, nil
+	}
+	if index < 0 || index >= len(f.doc.Meshes) {
+		return nil, fmt.Errorf("mesh %d does not exist", index)
+	}
+	desc := f.doc.Meshes[index]
+	mesh := &Mesh{Name: desc.Name}
+	var flat []int
+	for p, primitive := range desc.Primitives {
+		field := fmt.Sprintf("mesh %d primitive %d", index, p)
+		mode := 4
+		if primitive.Mode != nil {
+			mode = *primitive.Mode
+		}
+		position, ok := primitive.Attributes["POSITION"]
+		if mode < 4 || mode > 6 || !ok {
+			f.warn("%s: skipped, as it has no triangles", field)
+			continue
+		}
+
+		positions, err := f.floats(position, 3)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		count := len(positions) / 3
+		var normals, uvs []float32
+		if a, ok := primitive.Attributes["NORMAL"]; ok {
+			if normals, err = f.floats(a, 3); err == nil && len(normals) != 3*count {
+				err = errors.New("NORMAL and POSITION differ in length")
+			}
+		}
+		if a, ok := primitive.Attributes["TEXCOORD_0"]; ok && err == nil {
+			if uvs, err = f.floats(a, 2); err == nil && len(uvs) != 2*count {
+				err = errors.New("TEXCOORD_0 and POSITION differ in length")
+			}
+		}
+		var indices []int
+		if primitive.Indices != nil && err == nil {
+			indices, err = f.indices(*primitive.Indices)
+		} else {
+			indices = make([]int, count)
+			for i := range indices {
+				indices[i] = i
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		for _, i := range indices {
+			if i >= count {
+				return nil, fmt.Errorf("%s: index %d out of range", field, i)
+			}
+		}
+		material, err := f.material(primitive.Material)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+
+		base := len(mesh.Vertices)
+		for i := range count {
+			mesh.Vertices = append(mesh.Vertices, Vec3{X: positions[3*i], Y: positions[3*i+1], Z: positions[3*i+2]})
+		}
+		if uvs != nil && mesh.UVs == nil {
+			// Triangles of earlier primitives without UVs get zeros.
+			mesh.UVs = make([]float32, 6*len(mesh.Materials))
+		}
+		for _, tri := range gltfTriangles(indices, mode) {
+			if tri[0] == tri[1] || tri[1] == tri[2] || tri[2] == tri[0] {
+				continue
+			}
+			for _, v := range tri {
+				mesh.Tris = append(mesh.Tris, base+v)
+				var normal Vec3
+				if normals != nil {
+					normal = Vec3{X: normals[3*v], Y: normals[3*v+1], Z: normals[3*v+2]}.Normalize()
+				}
+				mesh.Normals = append(mesh.Normals, normal)
+				if uvs != nil {
+					mesh.UVs = append(mesh.UVs, uvs[2*v], uvs[2*v+1])
+				} else if mesh.UVs != nil {
+					mesh.UVs = append(mesh.UVs, 0, 0)
+				}
+			}
+			if normals == nil {
+				flat = append(flat, len(mesh.Materials))
+			}
+			mesh.Materials = append(mesh.Materials, material)
+		}
+	}
+
+	if len(mesh.Tris) == 0 {
+		mesh = nil
+	} else {
+		generateNormals(mesh, make([]int, len(mesh.Materials)), flat, 0)
+	}
+	f.meshes[index] = mesh
+	return mesh, nil
+}
+
+// gltfTriangles lists the triangles of a primitive of the given mode:
+// 4 for separate triangles, 5 for a strip and 6 for a fan.
+func gltfTriangles(indices []int, mode int) [][3]int {
+	var tris [][3]int
+	switch mode {
+	case 4:
+		for i := 0; i+2 < len(indices); i += 3 {
+			tris = append(tris, [3]int{indices[i], indices[i+1], indices[i+2]})
+		}
+	case 5:
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				tris = append(tris, [3]int{indices[i], indices[i+1], indices[i+2]})
+			} else {
+				tris = append(tris, [3]int{indices[i], indices[i+2], indices[i+1]})
+			}
+		}
+	case 6:
+		for i := 1; i+1 < len(indices); i++ {
+			tris = append(tris, [3]int{indices[i], indices[i+1], indices[0]})
+		}
+	}
+	return tris
+}
+
+var (
+	gltfComponentSizes = map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}
+	gltfTypeComponents = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4}
+)
+
+// floats reads accessor index, whose elements have n components, as
+// float32s. Normalized integers are mapped to [0, 1] or [-1, 1].
+func (f *gltfFile) floats(index, n int) ([]float32, error) {
+	accessor, data, stride, err := f.accessor(index, n)
+	if err != nil {
+		return nil, err
+	}
+	size := gltfComponentSizes[accessor.ComponentType]
+	out := make([]float32, accessor.Count*n)
+	for i := range accessor.Count {
+		for j := range n {
+			p := data[i*stride+j*size:]
+			var v, scale float32
+			switch accessor.ComponentType {
+			case 5126:
+				v = math.Float32frombits(binary.LittleEndian.Uint32(p))
+			case 5121:
+				v, scale = float32(p[0]), 255
+			case 5120:
+				v, scale = float32(int8(p[0])), 127
+			case 5123:
+				v, scale = float32(binary.LittleEndian.Uint16(p)), 65535
+			case 5122:
+				v, scale = float32(int16(binary.LittleEndian.Uint16(p))), 32767
+			case 5125:
+				v, scale = float32(binary.LittleEndian.Uint32(p)), 4294967295
+			}
+			if accessor.Normalized && scale != 0 {
+				v = max(v/scale, -1)
+			}
+			out[i*n+j] = v
+		}
+	}
+	return out, nil
+}
+
+// indices reads accessor index as vertex indices.
+func (f *gltfFile) indices(index int) ([]int, error) {
+	accessor, data, stride, err := f.accessor(index, 1)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int, accessor.Count)
+	for i := range out {
+		p := data[i*stride:]
+		switch accessor.ComponentType {
+		case 5121:
+			out[i] = int(p[0])
+		case 5123:
+			out[i] = int(binary.LittleEndian.Uint16(p))
+		case 5125:
+			out[i] = int(binary.LittleEndian.Uint32(p))
+		default:
+			return nil, fmt.Errorf("accessor %d: indices must be unsigned integers", index)
+		}
+	}
+	return out, nil
+}
+
+// accessor checks that accessor index has elements of n components and
+// returns it with its data, from its first element on, and the stride
+// between elements. An accessor without a buffer view is all zeros.
+func (f *gltfFile) accessor(index, n int) (gltfAccessor, []byte, int, error) {
+	if index < 0 || index >= len(f.doc.Accessors) {
+		return gltfAccessor{}, nil, 0, fmt.Errorf("accessor %d does not exist", index)
+	}
+	accessor := f.doc.Accessors[index]
+	size, ok := gltfComponentSizes[accessor.ComponentType]
+	if !ok {
+		return accessor, nil, 0, fmt.Errorf("accessor %d: unknown component type %d", index, accessor.ComponentType)
+	}
+	if gltfTypeComponents[accessor.Type] != n {
+		return accessor, nil, 0, fmt.Errorf("accessor %d: is %s, want %d components", index, accessor.Type, n)
+	}
+	if accessor.Sparse != nil {
+		return accessor, nil, 0, fmt.Errorf("accessor %d: sparse accessors are not supported", index)
+	}
+	if accessor.Count < 0 {
+		return accessor, nil, 0, fmt.Errorf("accessor %d: negative count", index)
+	}
+
+	elementSize := size * n
+	if accessor.BufferView == nil {
+		return accessor, make([]byte, accessor.Count*elementSize), elementSize, nil
+	}
+	view, stride, err := f.bufferView(*accessor.BufferView)
+	if err != nil {
+		return accessor, nil, 0, fmt.Errorf("accessor %d: %w", index, err)
+	}
+	if stride == 0 {
+		stride = elementSize
+	}
+	if accessor.ByteOffset < 0 || accessor.ByteOffset > len(view) ||
+		accessor.Count > 0 && accessor.ByteOffset+(accessor.Count-1)*stride+elementSize > len(view) {
+		return accessor, nil, 0, fmt.Errorf("accessor %d lies outside its buffer view", index)
+	}
+	return accessor, view[accessor.ByteOffset:], stride, nil
+}
+
+// bufferView returns the data of buffer view index and its stride, 0 if
+// the elements are packed.
+func (f *gltfFile) bufferView(index int) ([]byte, int, error) {
+	if index < 0 || index >= len(f.doc.BufferViews) {
+		return nil, 0, fmt.Errorf("buffer view %d does not exist", index)
+	}
+	view := f.doc.BufferViews[index]
+	buffer, err := f.buffer(view.Buffer)
+	if err != nil {
+		return nil, 0, err
+	}
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > len(buffer) {
+		return nil, 0, fmt.Errorf("buffer view %d lies outside its buffer", index)
+	}
+	return buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], view.ByteStride, nil
+}
+
+// buffer returns buffer index: the binary chunk of a GLB for a first
+// buffer without a URI, or the data of its URI.
+func (f *gltfFile) buffer(index int) ([]byte, error) {
+	if data, ok := f.buffers[index]; ok {
+		return data, nil
+	}
+	if index < 0 || index >= len(f.doc.Buffers) {
+		return nil, fmt.Errorf("buffer %d does not exist", index)
+	}
+	desc := f.doc.Buffers[index]
+	var data []byte
+	var err error
+	switch {
+	case desc.URI != "":
+		data, err = f.readURI(desc.URI)
+	case index == 0 && f.bin != nil:
+		data = f.bin
+	default:
+		err = errors.New("no data")
+	}
+	if err == nil && len(data) < desc.ByteLength {
+		err = fmt.Errorf("%d bytes, want %d", len(data), desc.ByteLength)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("buffer %d: %w", index, err)
+	}
+	f.buffers[index] = data
+	return data, nil
+}
+
+// readURI reads a base64 data URI, or a file relative to the glTF file.
+func (f *gltfFile) readURI(uri string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(uri, "data:"); ok {
+		header, payload, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return nil, errors.New("only base64 data URIs are supported")
+		}
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	path, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(resolvePath(f.dir, filepath.FromSlash(path)))
+}
+
+// texture returns the image of texture info. Only the first set of
+// texture coordinates is loaded, so textures are read with it.
+func (f *gltfFile) texture(info *gltfTextureInfo) (image.Image, error) {
+	if info.Index < 0 || info.Index >= len(f.doc.Textures) {
+		return nil, fmt.Errorf("texture %d does not exist", info.Index)
+	}
+	if info.TexCoord != 0 {
+		f.warn("texture %d: read with TEXCOORD_0 instead of TEXCOORD_%d", info.Index, info.TexCoord)
+	}
+	source := f.doc.Textures[info.Index].Source
+	if source == nil {
+		return nil, fmt.Errorf("texture %d has no PNG or JPEG image", info.Index)
+	}
+	index := *source
+	if img, ok := f.images[index]; ok {
+		return img, nil
+	}
+	if index < 0 || index >= len(f.doc.Images) {
+		return nil, fmt.Errorf("image %d does not exist", index)
+	}
+
+	desc := f.doc.Images[index]
+	var data []byte
+	var err error
+	switch {
+	case desc.BufferView != nil:
+		data, _, err = f.bufferView(*desc.BufferView)
+	case desc.URI != "":
+		data, err = f.readURI(desc.URI)
+	default:
+		err = errors.New("no data")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("image %d: %w", index, err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("image %d: %w", index, err)
+	}
+	f.images[index] = img
+	return img, nil
+}
+
+// material converts material index, or glTF's default material if it is
+// nil. A Material has one diffuse and one specular colour and one
+// roughness, so
+//   - the diffuse colour is the base colour less its metallic part, and
+//     the base colour texture is the diffuse map, with the factors baked
+//     in;
+//   - the specular colour goes from 4% grey for dielectrics to the base
+//     colour for metals, and the roughness sets the shininess;
+//   - the metallic-roughness and emissive textures are averaged into their
+//     factors, and the occlusion texture is left out, as the path tracer
+//     finds occlusion by itself;
+//   - the normal texture is kept as a normal map.
+//
+// Each texture that is averaged or left out gets a warning, as the
+// material then looks the same all over where the file meant it not to.
+func (f *gltfFile) material(index *int) (*Material, error) {
+	key := -1
+	var desc gltfMaterial
+	if index != nil {
+		key = *index
+		if key < 0 || key >= len(f.doc.Materials) {
+			return nil, fmt.Errorf("material %d does not exist", key)
+		}
+		desc = f.doc.Materials[key]
+	}
+	if mat, ok := f.materials[key]; ok {
+		return mat, nil
+	}
+	fail := func(err error) (*Material, error) {
+		return nil, fmt.Errorf("material %d: %w", key, err)
+	}
+
+	pbr := desc.PBR
+	baseColor := [4]float32{1, 1, 1, 1}
+	if pbr.BaseColorFactor != nil {
+		baseColor = *pbr.BaseColorFactor
+	}
+	metallic, roughness := float32(1), float32(1)
+	if pbr.MetallicFactor != nil {
+		metallic = *pbr.MetallicFactor
+	}
+	if pbr.RoughnessFactor != nil {
+		roughness = *pbr.RoughnessFactor
+	}
+	if pbr.MetallicRoughnessTexture != nil {
+		img, err := f.texture(pbr.MetallicRoughnessTexture)
+		if err != nil {
+			return fail(err)
+		}
+		f.warn("material %d: metallic-roughness texture averaged into the metallic and roughness factors", key)
+		average := averageColor(img, false)
+		roughness *= average.Y
+		metallic *= average.Z
+	}
+
+	mat := &Material{
+		Name:       desc.Name,
+		Illum:      2,
+		Opacity:    baseColor[3],
+		Refraction: 1.5,
+	}
+	if ior := desc.Extensions.IOR; ior != nil {
+		mat.Refraction = ior.IOR
+	}
+
+	albedo := Vec3{X: baseColor[0], Y: baseColor[1], Z: baseColor[2]}
+	diffuse := albedo.Scale(1 - metallic)
+	if pbr.BaseColorTexture != nil {
+		img, err := f.texture(pbr.BaseColorTexture)
+		if err != nil {
+			return fail(err)
+		}
+		baked := CacheImage(scaledImage(img, diffuse))
+		mat.DiffuseImage = &baked
+		mat.HasImage = true
+		average := averageColor(img, true)
+		albedo = albedo.ComponentMul(average)
+		diffuse = diffuse.ComponentMul(average)
+	}
+	mat.Diffuse = gltfColor(diffuse)
+	mat.Specular = gltfColor(Vec3{X: 0.04, Y: 0.04, Z: 0.04}.Lerp(albedo, metallic))
+	mat.Shininess = 100 * (1/max(roughness, 0.01) - 1)
+
+	emissive := vec(desc.EmissiveFactor)
+	if strength := desc.Extensions.EmissiveStrength; strength != nil {
+		emissive = emissive.Scale(strength.EmissiveStrength)
+	}
+	if desc.EmissiveTexture != nil && emissive != (Vec3{}) {
+		img, err := f.texture(desc.EmissiveTexture)
+		if err != nil {
+			return fail(err)
+		}
+		f.warn("material %d: emissive texture averaged into the emissive colour", key)
+		emissive = emissive.ComponentMul(averageColor(img, true))
+	}
+	if desc.OcclusionTexture != nil {
+		f.warn("material %d: occlusion texture ignored", key)
+	}
+	mat.Emissive = gltfColor(emissive)
+
+	if desc.NormalTexture != nil {
+		img, err := f.texture(desc.NormalTexture)
+		if err != nil {
+			return fail(err)
+		}
+		normalMap := CacheImage(img)
+		mat.NormalImage = &normalMap
+		mat.NormalScale = 1
+		if desc.NormalTexture.Scale != nil {
+			mat.NormalScale = *desc.NormalTexture.Scale
+		}
+		mat.HasImage = true
+	}
+
+	f.materials[key] = mat
+	return mat, nil
+}
+
+func gltfColor(v Vec3) g3nmath.Color {
+	return g3nmath.Color{R: v.X, G: v.Y, B: v.Z}
+}
+
+// averageColor is the mean colour of img, decoded from sRGB as the tracer
+// decodes diffuse maps if srgb is set. Large images are sampled on a grid.
+func averageColor(img image.Image, srgb bool) Vec3 {
+	bounds := img.Bounds()
+	step := max(1, bounds.Dx()/256, bounds.Dy()/256)
+	var sum Vec3
+	var count float32
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, _ := img.At(x, y).RGBA()
+			c := Vec3{X: float32(r) / 65535, Y: float32(g) / 65535, Z: float32(b) / 65535}
+			if srgb {
+				c = Vec3{X: math32.Pow(c.X, 2.2), Y: math32.Pow(c.Y, 2.2), Z: math32.Pow(c.Z, 2.2)}
+			}
+			sum._Add(c)
+			count++
+		}
+	}
+	if count == 0 {
+		return Vec3{X: 1, Y: 1, Z: 1}
+	}
+	return sum.Scale(1 / count)
+}
+
+// scaledImage multiplies the linear colours of the sRGB image img by
+// factor, or returns img if factor is white.
+func scaledImage(img image.Image, factor Vec3) image.Image {
+	if factor == (Vec3{X: 1, Y: 1, Z: 1}) {
+		return img
+	}
+	var tables [3][256]uint8
+	for channel, scale := range [3]float32{factor.X, factor.Y, factor.Z} {
+		for i := range 256 {
+			linear := math32.Pow(float32(i)/255, 2.2) * max(0, scale)
+			tables[channel][i] = uint8(min(1, math32.Pow(linear, 1/2.2))*255 + 0.5)
+		}
+	}
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			i := out.PixOffset(x, y)
+			out.Pix[i] = tables[0][r>>8]
+			out.Pix[i+1] = tables[1][g>>8]
+			out.Pix[i+2] = tables[2][b>>8]
+			out.Pix[i+3] = uint8(a >> 8)
+		}
+	}
+	return out
+}
+
+// camera converts camera index, seen from a node at world. glTF cameras
+// look down -Z with +X right and +Y up; Right here points to the left of
+// the image, which is written mirrored, and Up down it.
+func (f *gltfFile) camera(index int, world Mat4) (*Camera, error) {
+	if index < 0 || index >= len(f.doc.Cameras) {
+		return nil, fmt.Errorf("camera %d does not exist", index)
+	}
+	desc := f.doc.Cameras[index]
+	if desc.Type != "perspective" || desc.Perspective == nil {
+		f.warn("camera %d: skipped, as only perspective cameras are supported", index)
+		return nil, nil
+	}
+	return &Camera{
+		Position:         world.Point(Vec3{}),
+		Forward:          world.Vector(Vec3{Z: -1}).Normalize(),
+		Right:            world.Vector(Vec3{X: -1}).Normalize(),
+		Up:               world.Vector(Vec3{Y: -1}).Normalize(),
+		FrustrumDistance: 2,
+		FOV:              desc.Perspective.YFOV * 180 / math32.Pi,
+	}, nil
+}
+
+// light converts KHR_lights_punctual light index, placed by a node at
+// world. Intensities are used as they are.
+func (f *gltfFile) light(index int, world Mat4) (*GameObject[Light], error) {
+	lights := f.doc.Extensions.Lights
+	if lights == nil || index < 0 || index >= len(lights.Lights) {
+		return nil, fmt.Errorf("light %d does not exist", index)
+	}
+	desc := lights.Lights[index]
+	color := Vec3{X: 1, Y: 1, Z: 1}
+	if desc.Color != nil {
+		color = vec(*desc.Color)
+	}
+	intensity := float32(1)
+	if desc.Intensity != nil {
+		intensity = *desc.Intensity
+	}
+
+	switch desc.Type {
+	case "directional":
+		// The light shines down -Z; a Sun's Direction points at the sun.
+		return &GameObject[Light]{Object: &Sun{
+			Color:     color,
+			Direction: world.Vector(Vec3{Z: 1}).Normalize(),
+			Intensity: intensity,
+		}}, nil
+	case "spot":
+		f.warn("light %d: spot light placed as a point light", index)
+		fallthrough
+	case "point":
+		return &GameObject[Light]{
+			Position: world.Point(Vec3{}),
+			Object:   &PointLight{Color: color, Intensity: intensity},
+		}, nil
+	}
+	f.warn("light %d: skipped, as its type %q is unknown", index, desc.Type)
+	return nil, nil
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+// gltfFixture builds a small glTF document and its one buffer.
+type gltfFixture struct {
+	doc map[string]any
+	bin []byte
+}
+
+func newGLTFFixture() *gltfFixture {
+	return &gltfFixture{doc: map[string]any{"asset": map[string]any{"version": "2.0"}}}
+}
+
+func (g *gltfFixture) add(key string, value any) int {
+	list, _ := g.doc[key].([]any)
+	g.doc[key] = append(list, value)
+	return len(list)
+}
+
+// view appends data to the buffer, 4-byte aligned, as a buffer view with
+// the given stride, 0 for packed elements.
+func (g *gltfFixture) view(data []byte, stride int) int {
+	for len(g.bin)%4 != 0 {
+		g.bin = append(g.bin, 0)
+	}
+	view := map[string]any{"buffer": 0, "byteOffset": len(g.bin), "byteLength": len(data)}
+	if stride != 0 {
+		view["byteStride"] = stride
+	}
+	g.bin = append(g.bin, data...)
+	return g.add("bufferViews", view)
+}
+
+func (g *gltfFixture) accessor(view, offset, componentType, count int, kind string, normalized bool) int {
+	return g.add("accessors", map[string]any{
+		"bufferView": view, "byteOffset": offset, "componentType": componentType,
+		"count": count, "type": kind, "normalized": normalized,
+	})
+}
+
+// floats adds a packed float accessor of elements of the given type.
+func (g *gltfFixture) floats(kind string, values ...float32) int {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return g.accessor(g.view(data, 0), 0, 5126, len(values)/gltfTypeComponents[kind], kind, false)
+}
+
+// indices adds a packed unsigned short index accessor.
+func (g *gltfFixture) indices(values ...int) int {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	return g.accessor(g.view(data, 0), 0, 5123, len(values), "SCALAR", false)
+}
+
+// quad adds a mesh of the unit square in the z = 0 plane, facing +z.
+func (g *gltfFixture) quad(material *int) int {
+	primitive := map[string]any{
+		"attributes": map[string]int{"POSITION": g.floats("VEC3", 0, 0, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0)},
+		"indices":    g.indices(0, 1, 2, 0, 2, 3),
+	}
+	if material != nil {
+		primitive["material"] = *material
+	}
+	return g.add("meshes", map[string]any{"primitives": []any{primitive}})
+}
+
+// json encodes the document, its buffer at uri, or the GLB binary chunk
+// if uri is empty.
+func (g *gltfFixture) json(t *testing.T, uri string) []byte {
+	t.Helper()
+	if len(g.bin) > 0 {
+		buffer := map[string]any{"byteLength": len(g.bin)}
+		if uri != "" {
+			buffer["uri"] = uri
+		}
+		g.doc["buffers"] = []any{buffer}
+	}
+	data, err := json.Marshal(g.doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// gltf writes the document as a .gltf with its buffer as a data URI.
+func (g *gltfFixture) gltf(t *testing.T) string {
+	t.Helper()
+	data := g.json(t, "data:application/octet-stream;base64,"+base64.StdEncoding.EncodeToString(g.bin))
+	path := filepath.Join(t.TempDir(), "fixture.gltf")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// glb writes the document as a .glb with the buffer as its binary chunk.
+func (g *gltfFixture) glb(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.glb")
+	if err := os.WriteFile(path, glbBytes(g.json(t, ""), g.bin), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// glbChunk is a GLB chunk: its length, type and data padded to 4 bytes.
+func glbChunk(kind uint32, data []byte, pad byte) []byte {
+	for len(data)%4 != 0 {
+		data = append(data, pad)
+	}
+	chunk := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = binary.LittleEndian.AppendUint32(chunk, kind)
+	return append(chunk, data...)
+}
+
+func glbBytes(jsonChunk, bin []byte, extra ...[]byte) []byte {
+	chunks := glbChunk(0x4E4F534A, jsonChunk, ' ')
+	for _, chunk := range extra {
+		chunks = append(chunks, chunk...)
+	}
+	if bin != nil {
+		chunks = append(chunks, glbChunk(0x004E4942, bin, 0)...)
+	}
+	data := append([]byte("glTF"), 2, 0, 0, 0)
+	data = binary.LittleEndian.AppendUint32(data, uint32(12+len(chunks)))
+	return append(data, chunks...)
+}
+
+// loadGLTF loads the scene at path and its warnings, failing the test on
+// an error.
+func loadGLTF(t *testing.T, path string) (*Scene, []string) {
+	t.Helper()
+	f, err := openGLTF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scene, err := f.scene()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scene, f.warnings
+}
+
+func TestSplitGLB(t *testing.T) {
+	jsonChunk, bin := []byte(`{"asset":{"version":"2.0"}}`), []byte{1, 2, 3, 4, 5}
+	valid := glbBytes(jsonChunk, bin, glbChunk(0x12345678, []byte("unknown"), 0))
+	badVersion := slices.Clone(valid)
+	badVersion[4] = 1
+	tooLong := slices.Clone(valid)
+	binary.LittleEndian.PutUint32(tooLong[8:], uint32(len(valid)+1))
+	binOnly := append([]byte("glTF"), 2, 0, 0, 0)
+	binOnly = binary.LittleEndian.AppendUint32(binOnly, 12+8+8)
+	binOnly = append(binOnly, glbChunk(0x004E4942, make([]byte, 8), 0)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"JSON, an unknown chunk and BIN", valid, ""},
+		{"truncated header", valid[:10], "truncated GLB header"},
+		{"version 1", badVersion, "unsupported GLB version 1"},
+		{"length past the end", tooLong, "truncated GLB file"},
+		{"truncated chunk", func() []byte {
+			data := slices.Clone(valid[:len(valid)-2])
+			binary.LittleEndian.PutUint32(data[8:], uint32(len(data)))
+			return data
+		}(), "truncated GLB chunk"},
+		{"no JSON chunk", binOnly, "GLB file without a JSON chunk"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotJSON, gotBin, err := splitGLB(test.data)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Chunks are padded to 4 bytes: the JSON with spaces, BIN with
+			// zeros.
+			if string(bytes.TrimRight(gotJSON, " ")) != string(jsonChunk) {
+				t.Errorf("JSON chunk %q, want %q", gotJSON, jsonChunk)
+			}
+			if !bytes.Equal(gotBin[:len(bin)], bin) || len(gotBin) != 8 {
+				t.Errorf("BIN chunk %v, want %v padded to 8 bytes", gotBin, bin)
+			}
+		})
+	}
+}
+
+// A GLB and the same document as a .gltf with a data URI load the same.
+func TestGLTFBinaryAndText(t *testing.T) {
+	g := newGLTFFixture()
+	g.doc["nodes"] = []any{map[string]any{"mesh": g.quad(nil)}}
+	for _, path := range []string{g.glb(t), g.gltf(t)} {
+		scene, _ := loadGLTF(t, path)
+		if len(scene.Meshes) != 1 {
+			t.Fatalf("%s: %d objects, want 1", filepath.Ext(path), len(scene.Meshes))
+		}
+		mesh := scene.Meshes[0].Mesh
+		if len(mesh.Tris) != 6 || mesh.Vertices[2] != (Vec3{X: 1, Y: 1}) {
+			t.Errorf("%s: loaded %v %v", filepath.Ext(path), mesh.Vertices, mesh.Tris)
+		}
+	}
+}
+
+// Positions and texture coordinates interleaved in one strided view, with
+// normalized integer texture coordinates and normals.
+func TestGLTFAccessors(t *testing.T) {
+	g := newGLTFFixture()
+	// Each vertex: a float VEC3 position, then normalized unsigned short
+	// UVs and normalized byte normals, 20 bytes in all.
+	const stride = 20
+	positions := [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}
+	uvs := [][2]uint16{{0, 65535}, {65535, 0}, {32768, 0}}
+	normals := [][3]int8{{0, 0, 127}, {0, 0, -128}, {127, -127, 0}}
+	var data []byte
+	for i := range positions {
+		for _, v := range positions[i] {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+		}
+		data = binary.LittleEndian.AppendUint16(data, uvs[i][0])
+		data = binary.LittleEndian.AppendUint16(data, uvs[i][1])
+		data = append(data, byte(normals[i][0]), byte(normals[i][1]), byte(normals[i][2]), 0)
+	}
+	view := g.view(data, stride)
+	position := g.accessor(view, 0, 5126, 3, "VEC3", false)
+	uv := g.accessor(view, 12, 5123, 3, "VEC2", true)
+	normal := g.accessor(view, 16, 5120, 3, "VEC3", true)
+	raw := g.accessor(view, 12, 5123, 3, "VEC2", false)
+	bytesIndices := g.accessor(g.view([]byte{2, 1, 0}, 0), 0, 5121, 3, "SCALAR", false)
+	outside := g.accessor(view, 12, 5126, 3, "VEC3", false)
+	g.doc["meshes"] = []any{map[string]any{"primitives": []any{map[string]any{
+		"attributes": map[string]int{"POSITION": position, "TEXCOORD_0": uv, "NORMAL": normal},
+	}}}}
+
+	f, err := openGLTF(g.glb(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		accessor int
+		n        int
+		want     []float32
+	}{
+		{"strided floats", position, 3, []float32{0, 0, 0, 1, 0, 0, 0, 1, 0}},
+		{"normalized unsigned", uv, 2, []float32{0, 1, 1, 0, 32768.0 / 65535, 0}},
+		{"normalized signed", normal, 3, []float32{0, 0, 1, 0, 0, -1, 1, -1, 0}},
+		{"unnormalized", raw, 2, []float32{0, 65535, 65535, 0, 32768, 0}},
+	}
+	for _, test := range tests {
+		got, err := f.floats(test.accessor, test.n)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: read %v, want %v", test.name, got, test.want)
+		}
+	}
+	if got, err := f.indices(bytesIndices); err != nil || !slices.Equal(got, []int{2, 1, 0}) {
+		t.Errorf("byte indices read as %v, %v", got, err)
+	}
+	if _, err := f.floats(outside, 3); err == nil || !strings.Contains(err.Error(), "outside its buffer view") {
+		t.Errorf("accessor running past its view: error %v", err)
+	}
+	if _, err := f.floats(position, 2); err == nil {
+		t.Error("VEC3 accessor read as VEC2 without an error")
+	}
+
+	mesh, err := f.mesh(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(mesh.Normals[1], Vec3{Z: -1}) || !near(mesh.Normals[2], Vec3{X: 1, Y: -1}.Normalize()) {
+		t.Errorf("normals %v", mesh.Normals)
+	}
+	if !slices.Equal(mesh.UVs, []float32{0, 1, 1, 0, 32768.0 / 65535, 0}) {
+		t.Errorf("UVs %v", mesh.UVs)
+	}
+}
+
+func TestGLTFTriangles(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    int
+		indices []int
+		want    [][3]int
+	}{
+		{"triangles", 4, []int{0, 1, 2, 3, 4, 5, 6}, [][3]int{{0, 1, 2}, {3, 4, 5}}},
+		// Every other strip triangle is flipped to keep the winding.
+		{"strip", 5, []int{0, 1, 2, 3, 4}, [][3]int{{0, 1, 2}, {1, 3, 2}, {2, 3, 4}}},
+		{"fan", 6, []int{0, 1, 2, 3}, [][3]int{{1, 2, 0}, {2, 3, 0}}},
+		{"too short a strip", 5, []int{0, 1}, nil},
+		{"lines", 1, []int{0, 1, 2}, nil},
+	}
+	for _, test := range tests {
+		if got := gltfTriangles(test.indices, test.mode); !slices.Equal(got, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// A strip and a fan over the same square both face +z, as it is wound
+	// counter-clockwise seen from there; lines are skipped with a warning.
+	g := newGLTFFixture()
+	corners := g.floats("VEC3", 0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 1, 0)
+	primitive := func(mode int, indices ...int) map[string]any {
+		return map[string]any{"attributes": map[string]int{"POSITION": corners}, "indices": g.indices(indices...), "mode": mode}
+	}
+	g.doc["meshes"] = []any{map[string]any{"primitives": []any{
+		primitive(5, 0, 1, 2, 3),
+		primitive(6, 0, 1, 3, 2),
+		primitive(1, 0, 1),
+	}}}
+	g.doc["nodes"] = []any{map[string]any{"mesh": 0}}
+	scene, warnings := loadGLTF(t, g.gltf(t))
+	mesh := scene.Meshes[0].Mesh
+	if len(mesh.Tris) != 4*3 {
+		t.Fatalf("%d triangles, want 4", len(mesh.Tris)/3)
+	}
+	for i := 0; i < len(mesh.Tris); i += 3 {
+		a, b, c := mesh.Vertices[mesh.Tris[i]], mesh.Vertices[mesh.Tris[i+1]], mesh.Vertices[mesh.Tris[i+2]]
+		if b.Sub(a).Cross(c.Sub(a)).Z <= 0 || !near(mesh.Normals[i], Vec3{Z: 1}) {
+			t.Errorf("triangle %d, %v %v %v with normal %v, does not face +z", i/3, a, b, c, mesh.Normals[i])
+		}
+	}
+	if !slices.Equal(warnings, []string{"mesh 0 primitive 2: skipped, as it has no triangles"}) {
+		t.Errorf("warnings %q", warnings)
+	}
+}
+
+func TestGLTFNodeTransforms(t *testing.T) {
+	g := newGLTFFixture()
+	quad := g.quad(nil)
+	s := float32(math.Sqrt(0.5))
+	g.doc["nodes"] = []any{
+		// Translate by x, turn 90 degrees about y, then double.
+		map[string]any{
+			"translation": []float32{1, 0, 0}, "rotation": []float32{0, s, 0, s}, "scale": []float32{2, 2, 2},
+			"children": []int{1}, "mesh": quad,
+		},
+		// A column-major matrix moving the child along z.
+		map[string]any{"matrix": []float32{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1}, "mesh": quad},
+		// Not in the scene.
+		map[string]any{"mesh": quad},
+	}
+	g.doc["scene"] = 0
+	g.doc["scenes"] = []any{map[string]any{"nodes": []int{0}}}
+	scene, _ := loadGLTF(t, g.gltf(t))
+	if len(scene.Meshes) != 2 {
+		t.Fatalf("%d objects, want the 2 of the scene", len(scene.Meshes))
+	}
+	if scene.Meshes[0].Mesh != scene.Meshes[1].Mesh {
+		t.Error("nodes of one mesh got separate Meshes")
+	}
+	tests := []struct {
+		object   int
+		from, to Vec3
+	}{
+		{0, Vec3{}, Vec3{X: 1}},
+		{0, Vec3{X: 1}, Vec3{X: 1, Z: -2}},
+		{0, Vec3{Z: 1}, Vec3{X: 3}},
+		{1, Vec3{}, Vec3{X: 3}},
+		{1, Vec3{X: 1}, Vec3{X: 3, Z: -2}},
+	}
+	for _, test := range tests {
+		if got := scene.Meshes[test.object].Transform.Point(test.from); !near(got, test.to) {
+			t.Errorf("object %d moves %v to %v, want %v", test.object, test.from, got, test.to)
+		}
+	}
+}
+
+func TestGLTFDefaultMaterial(t *testing.T) {
+	g := newGLTFFixture()
+	g.doc["nodes"] = []any{map[string]any{"mesh": g.quad(nil)}, map[string]any{"mesh": g.quad(nil)}}
+	scene, _ := loadGLTF(t, g.gltf(t))
+	a, b := scene.Meshes[0].Mesh.Materials[0], scene.Meshes[1].Mesh.Materials[0]
+	if a != b {
+		t.Error("the default material is converted twice")
+	}
+	// White, fully metallic and fully rough.
+	if a.Diffuse.R != 0 || a.Specular.R != 1 || a.Specular.B != 1 || a.Shininess != 0 ||
+		a.Opacity != 1 || a.Refraction != 1.5 || a.HasImage || a.Emissive.R != 0 {
+		t.Errorf("default material %+v", a)
+	}
+}
+
+func TestGLTFCamera(t *testing.T) {
+	g := newGLTFFixture()
+	s := float32(math.Sqrt(0.5))
+	g.doc["cameras"] = []any{
+		map[string]any{"type": "orthographic", "orthographic": map[string]float32{"xmag": 1, "ymag": 1}},
+		map[string]any{"type": "perspective", "perspective": map[string]float32{"yfov": math32.Pi / 4}},
+	}
+	g.doc["nodes"] = []any{
+		map[string]any{"camera": 0},
+		// Turned 90 degrees about y, so it looks down -x.
+		map[string]any{"camera": 1, "translation": []float32{1, 2, 3}, "rotation": []float32{0, s, 0, s}},
+	}
+	scene, warnings := loadGLTF(t, g.gltf(t))
+	camera := scene.Camera
+	if camera == nil {
+		t.Fatal("no camera")
+	}
+	// The same basis Camera.LookAt gives for an upright camera.
+	want := lookAt(Vec3{X: 1, Y: 2, Z: 3}, Vec3{Y: 2, Z: 3}, 45)
+	if camera.Position != want.Position || !near(camera.Forward, want.Forward) ||
+		!near(camera.Right, want.Right) || !near(camera.Up, want.Up) {
+		t.Errorf("camera at %v looking %v, right %v, up %v; want %v, %v, %v, %v",
+			camera.Position, camera.Forward, camera.Right, camera.Up, want.Position, want.Forward, want.Right, want.Up)
+	}
+	if math32.Abs(camera.FOV-45) > 1e-4 {
+		t.Errorf("FOV %g, want 45", camera.FOV)
+	}
+	if !slices.Equal(warnings, []string{"camera 0: skipped, as only perspective cameras are supported"}) {
+		t.Errorf("warnings %q", warnings)
+	}
+}
+
+func TestGLTFLights(t *testing.T) {
+	g := newGLTFFixture()
+	s := float32(math.Sqrt(0.5))
+	g.doc["extensionsUsed"] = []string{"KHR_lights_punctual"}
+	g.doc["extensions"] = map[string]any{"KHR_lights_punctual": map[string]any{"lights": []any{
+		map[string]any{"type": "directional", "color": []float32{1, 0.5, 0.25}, "intensity": 3},
+		map[string]any{"type": "point"},
+		map[string]any{"type": "spot", "intensity": 5},
+		map[string]any{"type": "area"},
+	}}}
+	light := func(index int, node map[string]any) map[string]any {
+		node["extensions"] = map[string]any{"KHR_lights_punctual": map[string]int{"light": index}}
+		return node
+	}
+	g.doc["nodes"] = []any{
+		// Turned -90 degrees about x, so it shines straight down.
+		light(0, map[string]any{"rotation": []float32{-s, 0, 0, s}}),
+		light(1, map[string]any{"translation": []float32{1, 2, 3}}),
+		light(2, map[string]any{"translation": []float32{0, 4, 0}}),
+		light(3, map[string]any{}),
+	}
+	scene, warnings := loadGLTF(t, g.gltf(t))
+	if len(scene.Lights) != 3 {
+		t.Fatalf("%d lights, want 3", len(scene.Lights))
+	}
+	sun, ok := scene.Lights[0].Object.(*Sun)
+	if !ok || !near(sun.Direction, Vec3{Y: 1}) || sun.Color != (Vec3{X: 1, Y: 0.5, Z: 0.25}) || sun.Intensity != 3 {
+		t.Errorf("directional light became %#v", scene.Lights[0].Object)
+	}
+	point, ok := scene.Lights[1].Object.(*PointLight)
+	if !ok || scene.Lights[1].Position != (Vec3{X: 1, Y: 2, Z: 3}) || point.Color != (Vec3{X: 1, Y: 1, Z: 1}) || point.Intensity != 1 {
+		t.Errorf("point light became %#v at %v", scene.Lights[1].Object, scene.Lights[1].Position)
+	}
+	spot, ok := scene.Lights[2].Object.(*PointLight)
+	if !ok || scene.Lights[2].Position != (Vec3{Y: 4}) || spot.Intensity != 5 {
+		t.Errorf("spot light became %#v at %v", scene.Lights[2].Object, scene.Lights[2].Position)
+	}
+	want := []string{"light 2: spot light placed as a point light", `light 3: skipped, as its type "area" is unknown`}
+	if !slices.Equal(warnings, want) {
+		t.Errorf("warnings %q, want %q", warnings, want)
+	}
+}
+
+// Triangles of primitives without TEXCOORD_0 get zero UVs once any
+// primitive of the mesh has them, so UVs stay in step with Tris.
+func TestGLTFMissingTexcoords(t *testing.T) {
+	g := newGLTFFixture()
+	corners := g.floats("VEC3", 0, 0, 0, 1, 0, 0, 0, 1, 0)
+	uvs := g.floats("VEC2", 0.25, 0.5, 0.75, 0.5, 0.25, 1)
+	without := map[string]any{"attributes": map[string]int{"POSITION": corners}}
+	with := map[string]any{"attributes": map[string]int{"POSITION": corners, "TEXCOORD_0": uvs}}
+	g.doc["meshes"] = []any{
+		map[string]any{"primitives": []any{without, with, without}},
+		map[string]any{"primitives": []any{without}},
+	}
+	g.doc["nodes"] = []any{map[string]any{"mesh": 0}, map[string]any{"mesh": 1}}
+	scene, _ := loadGLTF(t, g.gltf(t))
+
+	mixed := scene.Meshes[0].Mesh
+	want := []float32{0, 0, 0, 0, 0, 0, 0.25, 0.5, 0.75, 0.5, 0.25, 1, 0, 0, 0, 0, 0, 0}
+	if len(mixed.Tris) != 9 || !slices.Equal(mixed.UVs, want) {
+		t.Errorf("UVs %v for %d triangles, want %v", mixed.UVs, len(mixed.Tris)/3, want)
+	}
+	if untextured := scene.Meshes[1].Mesh; untextured.UVs != nil {
+		t.Errorf("mesh without texture coordinates got UVs %v", untextured.UVs)
+	}
+}
+
+// Textures the material model has no room for are averaged or dropped,
+// each with a warning.
+func TestGLTFTextureWarnings(t *testing.T) {
+	g := newGLTFFixture()
+	// Half the pixels are black, half are (0, 255, 255): an average
+	// roughness and metalness of 0.5.
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(1, 0, color.NRGBA{G: 255, B: 255, A: 255})
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatal(err)
+	}
+	g.doc["images"] = []any{map[string]any{"bufferView": g.view(encoded.Bytes(), 0), "mimeType": "image/png"}}
+	g.doc["textures"] = []any{map[string]any{"source": 0}}
+	texture := map[string]int{"index": 0}
+	g.doc["materials"] = []any{map[string]any{
+		"pbrMetallicRoughness": map[string]any{"metallicRoughnessTexture": texture},
+		"emissiveTexture":      texture,
+		"emissiveFactor":       []float32{1, 1, 1},
+		"occlusionTexture":     texture,
+	}}
+	material := 0
+	g.doc["nodes"] = []any{map[string]any{"mesh": g.quad(&material)}}
+	scene, warnings := loadGLTF(t, g.glb(t))
+
+	want := []string{
+		"material 0: metallic-roughness texture averaged into the metallic and roughness factors",
+		"material 0: emissive texture averaged into the emissive colour",
+		"material 0: occlusion texture ignored",
+	}
+	if !slices.Equal(warnings, want) {
+		t.Errorf("warnings %q, want %q", warnings, want)
+	}
+	mat := scene.Meshes[0].Mesh.Materials[0]
+	if math32.Abs(mat.Shininess-100) > 0.01 || math32.Abs(mat.Diffuse.R-0.5) > 1e-4 {
+		t.Errorf("shininess %g and diffuse %v, want the averaged factors' 100 and 0.5", mat.Shininess, mat.Diffuse)
+	}
+	if math32.Abs(mat.Emissive.R) > 1e-4 || math32.Abs(mat.Emissive.G-0.5) > 1e-4 {
+		t.Errorf("emissive %v, want 0, 0.5, 0.5", mat.Emissive)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aquilax/go-perlin"
+	"github.com/chewxy/math32"
+	g3nmath "github.com/g3n/engine/math32"
+)
+
+// Regenerate the references with
+//
+//	go test -run TestGolden -update
+//
+// and check the new images in testdata/golden before committing them.
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+const (
+	goldenDir      = "testdata/golden"
+	goldenFailures = "testdata/failures"
+
+	// Renders are bit-identical on one machine; the tolerances absorb
+	// floating point differences between architectures (e.g. fused
+	// multiply-add on arm64).
+	maxRMSE     = 0.03
+	minMeanSSIM = 0.9
+)
+
+type goldenScene struct {
+	name     string
+	build    func() *Scene
+	settings func(*RenderSettings)
+}
+
+var goldenScenes = []goldenScene{
+	{name: "cornell-box", build: cornellBoxScene},
+	{name: "glass-sphere", build: glassSphereScene},
+	{name: "sun-plane", build: sunPlaneScene},
+	{name: "instances", build: instancesScene},
+	{
+		name:  "black-hole",
+		build: blackHoleScene,
+		settings: func(s *RenderSettings) {
+			// The geodesic integrator marches in steps, so the step size
+			// and count decide how far rays travel.
+			s.StepSize = 0.25
+			s.MaxSteps = 200
+			s.Bounces = 0
+		},
+	},
+}
+
+func goldenSettings() RenderSettings {
+	settings := DefaultRenderSettings()
+	settings.Width, settings.Height = 64, 48
+	settings.SamplesPerPixel = 16
+	settings.MaxSamplesPerPixel = 16
+	settings.Adaptive = false
+	settings.Threads = 4
+	settings.Sampler = "sobol"
+	settings.Seed = 1
+
+	// Scenes without a black hole only march once: a step longer than the
+	// scene turns the ray marcher into a plain closest-hit query.
+	settings.StepSize = 1000
+	settings.MaxSteps = 2
+	return settings
+}
+
+func renderGolden(t testing.TB, scene goldenScene, threads int, options ...func(*RenderSettings)) *Renderer {
+	settings := goldenSettings()
+	if scene.settings != nil {
+		scene.settings(&settings)
+	}
+	settings.Threads = threads
+	for _, option := range options {
+		option(&settings)
+	}
+
+	renderer, err := NewRenderer(scene.build(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer.Run(nil, nil)
+	return renderer
+}
+
+// Every traversal width renders the same scenes, so they are checked
+// against the same references.
+func TestGolden(t *testing.T) {
+	for _, scene := range goldenScenes {
+		for _, width := range benchmarkWidths {
+			t.Run(fmt.Sprintf("%s/width=%d", scene.name, width), func(t *testing.T) {
+				if *update && width != 2 {
+					t.Skip("references are written from the binary tree")
+				}
+				img := renderGolden(t, scene, 4, func(s *RenderSettings) {
+					s.BVH.Width = width
+				}).Image()
+				checkGolden(t, scene.name, fmt.Sprintf("%s.width-%d", scene.name, width), img)
+			})
+		}
+	}
+}
+
+// checkGolden compares img with the reference of the named scene, or
+// replaces the reference with -update. A mismatch is written to
+// testdata/failures under the failure name.
+func checkGolden(t *testing.T, name, failure string, img image.Image) {
+	path := filepath.Join(goldenDir, name+".png")
+
+	if *update {
+		if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writePNG(path, img); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("updated %s", path)
+		return
+	}
+
+	want, err := readPNG(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if want.Bounds() != img.Bounds() {
+		t.Fatalf("reference is %v, render is %v", want.Bounds(), img.Bounds())
+	}
+
+	rmse := imageRMSE(img, want)
+	ssim := meanSSIM(img, want)
+	if rmse <= maxRMSE && ssim >= minMeanSSIM {
+		return
+	}
+
+	t.Errorf("render differs from %s: RMSE %.4f (max %.4f), mean SSIM %.4f (min %.4f)",
+		path, rmse, maxRMSE, ssim, minMeanSSIM)
+	if err := os.MkdirAll(goldenFailures, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	actualPath := filepath.Join(goldenFailures, failure+".actual.png")
+	diffPath := filepath.Join(goldenFailures, failure+".diff.png")
+	if err := writePNG(actualPath, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePNG(diffPath, diffImage(img, want)); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("wrote %s and %s", actualPath, diffPath)
+}
+
+// The sampler is seeded per pixel and sample, so the thread count must not
+// change a single bit of the result.
+func TestRenderIsIndependentOfThreadCount(t *testing.T) {
+	scene := goldenScenes[0]
+	one := renderGolden(t, scene, 1).Radiance()
+	many := renderGolden(t, scene, 7).Radiance()
+	for i := range one {
+		if one[i] != many[i] {
+			t.Fatalf("pixel %d is %v with 1 thread and %v with 7", i, one[i], many[i])
+		}
+	}
+}
+
+// Packets must find exactly the hits single rays find, whatever the
+// traversal width.
+func TestPacketsMatchSingleRays(t *testing.T) {
+	for _, scene := range goldenScenes {
+		for _, width := range benchmarkWidths {
+			t.Run(fmt.Sprintf("%s/width=%d", scene.name, width), func(t *testing.T) {
+				render := func(packets bool) []Vec3 {
+					return renderGolden(t, scene, 1, func(s *RenderSettings) {
+						s.SamplesPerPixel, s.MaxSamplesPerPixel = 4, 4
+						s.Packets = packets
+						s.BVH.Width = width
+					}).Radiance()
+				}
+				packets, single := render(true), render(false)
+				for i := range single {
+					if packets[i] != single[i] {
+						t.Fatalf("pixel %d is %v with packets and %v without", i, packets[i], single[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+// ------------------------------------------------------------
+// Image comparison
+
+func readPNG(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+func channels(c color.Color) [3]float64 {
+	r, g, b, _ := c.RGBA()
+	return [3]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+}
+
+// imageRMSE is the root mean square error of the 8-bit sRGB values, scaled
+// to [0, 1].
+func imageRMSE(a, b image.Image) float64 {
+	bounds := a.Bounds()
+	var sum float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca, cb := channels(a.At(x, y)), channels(b.At(x, y))
+			for i := range ca {
+				d := ca[i] - cb[i]
+				sum += d * d
+			}
+		}
+	}
+	return math.Sqrt(sum / float64(3*bounds.Dx()*bounds.Dy()))
+}
+
+func luma(img image.Image, x, y int) float64 {
+	c := channels(img.At(x, y))
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+// meanSSIM is the structural similarity of the luma of both images,
+// averaged over every 7x7 window. Unlike RMSE it is sensitive to shifted
+// edges and missing detail rather than to noise of the same strength.
+func meanSSIM(a, b image.Image) float64 {
+	const (
+		window = 7
+		c1     = 0.01 * 0.01
+		c2     = 0.03 * 0.03
+	)
+
+	bounds := a.Bounds()
+	var total float64
+	var count int
+	for y := bounds.Min.Y; y+window <= bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x+window <= bounds.Max.X; x++ {
+			var meanA, meanB float64
+			for j := range window {
+				for i := range window {
+					meanA += luma(a, x+i, y+j)
+					meanB += luma(b, x+i, y+j)
+				}
+			}
+			n := float64(window * window)
+			meanA /= n
+			meanB /= n
+
+			var varA, varB, cov float64
+			for j := range window {
+				for i := range window {
+					da := luma(a, x+i, y+j) - meanA
+					db := luma(b, x+i, y+j) - meanB
+					varA += da * da
+					varB += db * db
+					cov += da * db
+				}
+			}
+			varA /= n - 1
+			varB /= n - 1
+			cov /= n - 1
+
+			total += (2*meanA*meanB + c1) * (2*cov + c2) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			count++
+		}
+	}
+	return total / float64(count)
+}
+
+// diffImage shows the absolute per-channel difference, amplified four times.
+func diffImage(a, b image.Image) *image.RGBA {
+	bounds := a.Bounds()
+	diff := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca, cb := channels(a.At(x, y)), channels(b.At(x, y))
+			var d [3]uint8
+			for i := range ca {
+				d[i] = uint8(min(1, 4*math.Abs(ca[i]-cb[i])) * 255)
+			}
+			diff.SetRGBA(x, y, color.RGBA{R: d[0], G: d[1], B: d[2], A: 255})
+		}
+	}
+	return diff
+}
+
+// ------------------------------------------------------------
+// Procedural scenes.
+
+func diffuse(r, g, b float32) *Material {
+	return &Material{Name: "Diffuse", Diffuse: g3nmath.Color{R: r, G: g, B: b}}
+}
+
+func emissive(r, g, b float32) *Material {
+	return &Material{Name: "Light", Emissive: g3nmath.Color{R: r, G: g, B: b}}
+}
+
+func (m *Mesh) addTriangle(a, b, c, na, nb, nc Vec3, material *Material) {
+	base := len(m.Vertices)
+	m.Vertices = append(m.Vertices, a, b, c)
+	m.Tris = append(m.Tris, base, base+1, base+2)
+	m.Normals = append(m.Normals, na, nb, nc)
+	m.UVs = append(m.UVs, 0, 0, 0, 0, 0, 0)
+	m.Materials = append(m.Materials, material)
+}
+
+// addQuad adds the quad a, b, c, d (in order around its edge) facing the
+// side its winding and the given normal agree on.
+func (m *Mesh) addQuad(a, b, c, d, normal Vec3, material *Material) {
+	m.addTriangle(a, b, c, normal, normal, normal, material)
+	m.addTriangle(a, c, d, normal, normal, normal, material)
+}
+
+// addBox adds an axis-aligned box with outward normals.
+func (m *Mesh) addBox(lo, hi Vec3, material *Material) {
+	corner := func(x, y, z int) Vec3 {
+		c := lo
+		if x == 1 {
+			c.X = hi.X
+		}
+		if y == 1 {
+			c.Y = hi.Y
+		}
+		if z == 1 {
+			c.Z = hi.Z
+		}
+		return c
+	}
+	m.addQuad(corner(0, 1, 0), corner(1, 1, 0), corner(1, 1, 1), corner(0, 1, 1), Vec3{Y: 1}, material)
+	m.addQuad(corner(0, 0, 0), corner(0, 0, 1), corner(1, 0, 1), corner(1, 0, 0), Vec3{Y: -1}, material)
+	m.addQuad(corner(0, 0, 0), corner(1, 0, 0), corner(1, 1, 0), corner(0, 1, 0), Vec3{Z: -1}, material)
+	m.addQuad(corner(0, 0, 1), corner(0, 1, 1), corner(1, 1, 1), corner(1, 0, 1), Vec3{Z: 1}, material)
+	m.addQuad(corner(0, 0, 0), corner(0, 1, 0), corner(0, 1, 1), corner(0, 0, 1), Vec3{X: -1}, material)
+	m.addQuad(corner(1, 0, 0), corner(1, 0, 1), corner(1, 1, 1), corner(1, 1, 0), Vec3{X: 1}, material)
+}
+
+// addSphere adds a UV sphere with smooth outward normals.
+func (m *Mesh) addSphere(center Vec3, radius float32, rings, segments int, material *Material) {
+	point := func(ring, segment int) Vec3 {
+		theta := math32.Pi * float32(ring) / float32(rings)
+		phi := 2 * math32.Pi * float32(segment) / float32(segments)
+		return Vec3{
+			X: math32.Sin(theta) * math32.Cos(phi),
+			Y: math32.Cos(theta),
+			Z: math32.Sin(theta) * math32.Sin(phi),
+		}
+	}
+	for ring := range rings {
+		for segment := range segments {
+			n00, n01 := point(ring, segment), point(ring, segment+1)
+			n10, n11 := point(ring+1, segment), point(ring+1, segment+1)
+			p := func(n Vec3) Vec3 { return center.Add(n.Scale(radius)) }
+			if ring > 0 {
+				m.addTriangle(p(n00), p(n01), p(n11), n00, n01, n11, material)
+			}
+			if ring < rings-1 {
+				m.addTriangle(p(n00), p(n11), p(n10), n00, n11, n10, material)
+			}
+		}
+	}
+}
+
+// addAnnulus adds a flat ring in the XZ plane facing up.
+func (m *Mesh) addAnnulus(center Vec3, inner, outer float32, segments int, material *Material) {
+	up := Vec3{Y: 1}
+	point := func(radius float32, segment int) Vec3 {
+		phi := 2 * math32.Pi * float32(segment) / float32(segments)
+		return center.Add(Vec3{X: radius * math32.Cos(phi), Z: radius * math32.Sin(phi)})
+	}
+	for segment := range segments {
+		m.addQuad(point(inner, segment), point(inner, segment+1), point(outer, segment+1), point(outer, segment), up, material)
+	}
+}
+
+func lookAt(position, target Vec3, fov float32) *Camera {
+	camera := &Camera{FOV: fov, FrustrumDistance: 2}
+	camera.LookAt(position, target, Vec3{Y: 1})
+	return camera
+}
+
+func meshObject(mesh *Mesh) []*GameObject[any] {
+	return []*GameObject[any]{{Mesh: mesh}}
+}
+
+func cornellBoxScene() *Scene {
+	white := diffuse(0.73, 0.73, 0.73)
+	mesh := &Mesh{}
+
+	// Walls face into the box.
+	mesh.addQuad(Vec3{-1, -1, -1}, Vec3{-1, -1, 1}, Vec3{1, -1, 1}, Vec3{1, -1, -1}, Vec3{Y: 1}, white)
+	mesh.addQuad(Vec3{-1, 1, -1}, Vec3{1, 1, -1}, Vec3{1, 1, 1}, Vec3{-1, 1, 1}, Vec3{Y: -1}, white)
+	mesh.addQuad(Vec3{-1, -1, 1}, Vec3{-1, 1, 1}, Vec3{1, 1, 1}, Vec3{1, -1, 1}, Vec3{Z: -1}, white)
+	mesh.addQuad(Vec3{-1, -1, -1}, Vec3{-1, 1, -1}, Vec3{-1, 1, 1}, Vec3{-1, -1, 1}, Vec3{X: 1}, diffuse(0.65, 0.05, 0.05))
+	mesh.addQuad(Vec3{1, -1, -1}, Vec3{1, -1, 1}, Vec3{1, 1, 1}, Vec3{1, 1, -1}, Vec3{X: -1}, diffuse(0.12, 0.45, 0.15))
+
+	// Ceiling light, facing down.
+	mesh.addQuad(Vec3{-0.3, 0.99, -0.3}, Vec3{0.3, 0.99, -0.3}, Vec3{0.3, 0.99, 0.3}, Vec3{-0.3, 0.99, 0.3}, Vec3{Y: -1}, emissive(15, 15, 15))
+
+	mesh.addBox(Vec3{-0.6, -1, -0.1}, Vec3{-0.1, 0.2, 0.5}, white)
+	mesh.addBox(Vec3{0.15, -1, -0.5}, Vec3{0.65, -0.45, 0}, white)
+
+	return &Scene{
+		Camera: lookAt(Vec3{0, 0, -3.8}, Vec3{}, 40),
+		Meshes: meshObject(mesh),
+	}
+}
+
+func glassSphereScene() *Scene {
+	mesh := &Mesh{}
+	mesh.addQuad(Vec3{-4, -1, -4}, Vec3{-4, -1, 4}, Vec3{4, -1, 4}, Vec3{4, -1, -4}, Vec3{Y: 1}, diffuse(0.6, 0.6, 0.6))
+	mesh.addQuad(Vec3{-4, -1, 2}, Vec3{-4, 3, 2}, Vec3{0, 3, 2}, Vec3{0, -1, 2}, Vec3{Z: -1}, diffuse(0.7, 0.2, 0.1))
+	mesh.addQuad(Vec3{0, -1, 2}, Vec3{0, 3, 2}, Vec3{4, 3, 2}, Vec3{4, -1, 2}, Vec3{Z: -1}, diffuse(0.1, 0.3, 0.7))
+	mesh.addSphere(Vec3{}, 1, 12, 24, &Material{Name: "Glass", Refraction: 1.5})
+
+	return &Scene{
+		Camera: lookAt(Vec3{0, 1, -4.5}, Vec3{}, 45),
+		Meshes: meshObject(mesh),
+		Lights: []*GameObject[Light]{{
+			Object: &Sun{Color: Vec3{1, 1, 1}, Direction: Vec3{0.4, 1, -0.3}.Normalize(), Intensity: 2},
+		}},
+		Skybox: &GradientSkybox{
+			GroundColor:  Vec3{0.3, 0.3, 0.3},
+			HorizonColor: Vec3{0.8, 0.9, 1},
+			ZenithColor:  Vec3{0.2, 0.5, 1},
+			Intensity:    1,
+		},
+	}
+}
+
+func sunPlaneScene() *Scene {
+	mesh := &Mesh{}
+	mesh.addQuad(Vec3{-20, 0, -20}, Vec3{-20, 0, 20}, Vec3{20, 0, 20}, Vec3{20, 0, -20}, Vec3{Y: 1}, diffuse(0.5, 0.5, 0.45))
+	mesh.addBox(Vec3{-1, 0, -1}, Vec3{1, 2, 1}, diffuse(0.8, 0.3, 0.2))
+	mesh.addSphere(Vec3{2.5, 0.75, -1}, 0.75, 10, 20, diffuse(0.2, 0.4, 0.8))
+
+	return &Scene{
+		Camera: lookAt(Vec3{3, 4, -8}, Vec3{0, 0.5, 0}, 40),
+		Meshes: meshObject(mesh),
+		Lights: []*GameObject[Light]{{
+			Object: &Sun{Color: Vec3{1, 0.95, 0.9}, Direction: Vec3{-0.5, 1, -0.4}.Normalize(), Intensity: 3},
+		}},
+		Skybox: &GradientSkybox{
+			GroundColor:  Vec3{0.3, 0.3, 0.3},
+			HorizonColor: Vec3{0.8, 0.9, 1},
+			ZenithColor:  Vec3{0.2, 0.5, 1},
+			Intensity:    0.5,
+		},
+	}
+}
+
+// instancesScene places one box mesh several times, rotated, stretched and
+// sheared, next to a separate floor mesh.
+func instancesScene() *Scene {
+	floor := &Mesh{}
+	floor.addQuad(Vec3{-20, 0, -20}, Vec3{-20, 0, 20}, Vec3{20, 0, 20}, Vec3{20, 0, -20}, Vec3{Y: 1}, diffuse(0.5, 0.5, 0.45))
+	box := &Mesh{}
+	box.addBox(Vec3{-0.5, 0, -0.5}, Vec3{0.5, 1, 0.5}, diffuse(0.2, 0.4, 0.8))
+
+	rotated := Rotation(0, math32.Pi/4, 0)
+	stretched := Scaling(Vec3{0.5, 3, 0.5})
+	sheared := Mat4{{1, 0.8, 0, 0}, {0, 1.5, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	return &Scene{
+		Camera: lookAt(Vec3{0, 4, -9}, Vec3{0, 0.8, 0}, 40),
+		Meshes: []*GameObject[any]{
+			{Mesh: floor},
+			{Mesh: box, Position: Vec3{X: -3}},
+			{Mesh: box, Position: Vec3{X: -1}, Transform: &rotated},
+			{Mesh: box, Position: Vec3{X: 1}, Transform: &stretched},
+			{Mesh: box, Position: Vec3{X: 3}, Transform: &sheared, Material: diffuse(0.8, 0.2, 0.1)},
+		},
+		Lights: []*GameObject[Light]{{
+			Object: &Sun{Color: Vec3{1, 0.95, 0.9}, Direction: Vec3{-0.5, 1, -0.4}.Normalize(), Intensity: 3},
+		}},
+		Skybox: &GradientSkybox{
+			GroundColor:  Vec3{0.3, 0.3, 0.3},
+			HorizonColor: Vec3{0.8, 0.9, 1},
+			ZenithColor:  Vec3{0.2, 0.5, 1},
+			Intensity:    0.5,
+		},
+	}
+}
+
+func blackHoleScene() *Scene {
+	mesh := &Mesh{}
+	mesh.addAnnulus(Vec3{}, 3, 6, 48, &Material{Name: "AccretionDisk", Emissive: g3nmath.Color{R: 1, G: 0.5, B: 0.1}})
+
+	return &Scene{
+		Camera: lookAt(Vec3{0, 2.5, -14}, Vec3{}, 50),
+		Meshes: meshObject(mesh),
+		Skybox: &GradientSkybox{
+			GroundColor:  Vec3{0.05, 0.02, 0.1},
+			HorizonColor: Vec3{0.6, 0.4, 0.9},
+			ZenithColor:  Vec3{0.05, 0.1, 0.4},
+			Intensity:    1,
+		},
+		BlackHoles: []*BlackHole{{
+			Rs: 1,
+			AccretionDisk: &AccretionDisk{
+				InnerRadius: 3,
+				OuterRadius: 6,
+				NoiseGen:    perlin.NewPerlin(2, 2, 4, 1),
+			},
+		}},
+	}
+}
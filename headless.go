@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runHeadless renders a scene without opening a window:
+//
+//	go run . render -scene scenes/sponza.json -width 1280 -height 720 -spp 256 -out sponza.png
+func runHeadless(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	common := addCommonFlags(flags)
+	out := flags.String("out", "render.png", "output image path (.png, .hdr, .exr or .pfm)")
+	checkpoint := flags.String("checkpoint", "", "periodically save the pixel buffer to this file")
+	checkpointEvery := flags.Duration("checkpoint-every", 5*time.Minute, "interval between checkpoints")
+	resume := flags.Bool("resume", false, "continue from the -checkpoint file if it exists")
+	if err := common.parse(args); err != nil {
+		return err
+	}
+	settings := common.settings
+
+	if ext := filepath.Ext(*out); !IsSupportedOutput(ext) {
+		return fmt.Errorf("unsupported output format %q", ext)
+	}
+	if *resume && *checkpoint == "" {
+		return errors.New("-resume needs a -checkpoint file")
+	}
+
+	scene, err := LoadSceneFile(common.scenePath, common.meshCache())
+	if err != nil {
+		return err
+	}
+	renderer, err := NewRenderer(scene, settings)
+	if err != nil {
+		return err
+	}
+	if common.bvhStats {
+		fmt.Print(renderer.BVH.Stats(settings.BVH))
+	}
+
+	if *resume {
+		switch err := renderer.LoadCheckpoint(*checkpoint); {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Println("No checkpoint at", *checkpoint+", starting from scratch")
+		case err != nil:
+			return fmt.Errorf("resuming from %s: %w", *checkpoint, err)
+		default:
+			fmt.Printf("Resumed from %s (%s samples)\n", *checkpoint, Humanize(renderer.Samples.Load()))
+		}
+	}
+
+	fmt.Printf("Rendering %dx%d, %d spp, %d bounces on %d threads\n",
+		settings.Width, settings.Height, settings.MaxSamplesPerPixel, settings.Bounces, settings.Threads)
+
+	done := make(chan struct{})
+	start := time.Now()
+	go reportProgress(renderer, start, common.progress, done)
+	err = renderWithCheckpoints(renderer, *checkpoint, *checkpointEvery)
+	close(done)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Finished in %s (%s samples)\n", time.Since(start).Round(time.Millisecond), Humanize(renderer.Samples.Load()))
+
+	if err := common.save(*out, renderer); err != nil {
+		return fmt.Errorf("writing image: %w", err)
+	}
+	fmt.Println("Wrote", *out)
+	return nil
+}
+
+// commonFlags are the flags shared by the render and animate commands.
+type commonFlags struct {
+	flags      *flag.FlagSet
+	settings   RenderSettings
+	scenePath  string
+	toneMapper string
+	exposure   float64
+	exrFloat   bool
+	aovList    string
+	aovs       []AOV
+	aovFiles   bool
+	progress   time.Duration
+	cacheDir   string
+	spatial    float64
+	bvhStats   bool
+}
+
+func addCommonFlags(flags *flag.FlagSet) *commonFlags {
+	c := &commonFlags{flags: flags, settings: DefaultRenderSettings()}
+	c.settings.Threads = max(1, runtime.NumCPU()-1)
+
+	flags.StringVar(&c.scenePath, "scene", "scenes/empty.json", "scene file, .gltf or .glb to render")
+	flags.BoolVar(&c.exrFloat, "exr-float", false, "write 32-bit float instead of half channels to .exr")
+	flags.StringVar(&c.toneMapper, "tonemap", c.settings.ToneMapping.Operator.Name(), "tone mapper for .png output ("+strings.Join(ToneMapperNames, ", ")+")")
+	flags.Float64Var(&c.exposure, "exposure", 0, "exposure adjustment in stops")
+	flags.StringVar(&c.aovList, "aovs", "", "comma-separated AOVs to write besides the beauty pass ("+strings.Join(AOVNames[1:], ", ")+")")
+	flags.BoolVar(&c.aovFiles, "aov-files", false, "write AOVs to their own files even when the output is .exr")
+	flags.DurationVar(&c.progress, "progress", 2*time.Second, "interval between progress reports")
+	flags.IntVar(&c.settings.Width, "width", c.settings.Width, "image width in pixels")
+	flags.IntVar(&c.settings.Height, "height", c.settings.Height, "image height in pixels")
+	flags.IntVar(&c.settings.MaxSamplesPerPixel, "spp", c.settings.MaxSamplesPerPixel, "maximum samples per pixel")
+	flags.IntVar(&c.settings.Bounces, "bounces", c.settings.Bounces, "maximum number of bounces")
+	flags.IntVar(&c.settings.Threads, "threads", c.settings.Threads, "number of render goroutines")
+	flags.StringVar(&c.settings.Sampler, "sampler", c.settings.Sampler, "sample generator ("+strings.Join(SamplerNames, ", ")+")")
+	flags.Uint64Var(&c.settings.Seed, "seed", c.settings.Seed, "random seed")
+	flags.IntVar(&c.settings.BVH.Bins, "bvh-bins", c.settings.BVH.Bins, "candidate BVH split bins per axis")
+	flags.IntVar(&c.settings.BVH.Width, "bvh-width", c.settings.BVH.Width, "children per traversed BVH node (2, 4 or 8)")
+	flags.Float64Var(&c.spatial, "bvh-spatial", 0, "extra BVH references spatial splits may add, as a fraction of the triangles (0 disables them)")
+	flags.BoolVar(&c.settings.Packets, "packets", c.settings.Packets, "trace camera and sun shadow rays in packets")
+	flags.BoolVar(&c.bvhStats, "bvh-stats", false, "print the shape, SAH cost, EPO and memory of the BVHs")
+	flags.StringVar(&c.cacheDir, "bvh-cache", DefaultMeshCacheDir(), "directory caching meshes and their BVHs (empty to disable)")
+	return c
+}
+
+func (c *commonFlags) meshCache() *MeshCache {
+	return &MeshCache{Dir: c.cacheDir, Options: c.settings.BVH}
+}
+
+// parse parses args and finishes the render settings.
+func (c *commonFlags) parse(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if c.flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(c.flags.Args(), " "))
+	}
+
+	c.settings.SamplesPerPixel = min(c.settings.SamplesPerPixel, c.settings.MaxSamplesPerPixel)
+	operator, err := ParseToneMapper(c.toneMapper)
+	if err != nil {
+		return err
+	}
+	c.settings.ToneMapping = ToneMapping{Operator: operator, Exposure: float32(c.exposure)}
+	c.settings.BVH.SpatialSplitBudget = float32(c.spatial)
+	// Meshes get their BVHs while the scene loads, before NewRenderer
+	// checks the settings.
+	if err := c.settings.BVH.Validate(); err != nil {
+		return err
+	}
+	if c.aovs, err = ParseAOVList(c.aovList); err != nil {
+		return err
+	}
+	// The beauty pass is always written.
+	c.aovs = slices.DeleteFunc(c.aovs, func(aov AOV) bool { return aov == AOVBeauty })
+	return nil
+}
+
+// save writes the beauty pass and the requested AOVs. With an .exr output
+// the AOVs become layers of it, otherwise each goes to its own file named
+// like path with the AOV before the extension, e.g. render.normal.png. The
+// main image is written last, under a temporary name that is then renamed,
+// so once it exists the whole set is complete.
+func (c *commonFlags) save(path string, r *Renderer) error {
+	ext := filepath.Ext(path)
+	layered := strings.EqualFold(ext, ".exr") && !c.aovFiles
+	if !layered {
+		for _, aov := range c.aovs {
+			aovPath := strings.TrimSuffix(path, ext) + "." + aov.String() + ext
+			if err := SaveAOV(aovPath, r, aov, !c.exrFloat); err != nil {
+				return fmt.Errorf("writing %s AOV: %w", aov, err)
+			}
+		}
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	var err error
+	if layered && len(c.aovs) > 0 {
+		err = SaveLayeredEXR(tmp, r, append([]AOV{AOVBeauty}, c.aovs...), !c.exrFloat)
+	} else {
+		err = SaveRender(tmp, r, !c.exrFloat)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// renderWithCheckpoints runs the renderer to completion. With a checkpoint
+// path it pauses the workers every interval to save the buffer, saves once
+// more at the end, and saves before giving up on SIGINT or SIGTERM.
+func renderWithCheckpoints(renderer *Renderer, path string, interval time.Duration) error {
+	if path == "" {
+		renderer.Run(nil, nil)
+		return nil
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	save := func() error {
+		st := time.Now()
+		if err := renderer.SaveCheckpoint(path); err != nil {
+			return fmt.Errorf("writing checkpoint: %w", err)
+		}
+		fmt.Println("Checkpoint saved to", path, "in", time.Since(st).Round(time.Millisecond))
+		return nil
+	}
+
+	for {
+		stop := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			renderer.Run(stop, nil)
+			close(finished)
+		}()
+
+		select {
+		case <-finished:
+			return save()
+		case <-tick:
+			close(stop)
+			<-finished
+			if err := save(); err != nil {
+				return err
+			}
+		case sig := <-interrupt:
+			close(stop)
+			<-finished
+			if err := save(); err != nil {
+				return err
+			}
+			return fmt.Errorf("interrupted by %v; resume with -resume", sig)
+		}
+	}
+}
+
+func reportProgress(renderer *Renderer, start time.Time, interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	total := renderer.Settings.TotalSamples()
+	resumed := renderer.Samples.Load()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			samples := renderer.Samples.Load()
+			elapsed := time.Since(start).Seconds()
+			speed := float64(samples-resumed) / max(elapsed, 1e-3)
+			fmt.Printf("%5.1f%%  %s/%s samples  %s samples/s  %s elapsed\n",
+				float64(samples*100)/float64(total), Humanize(samples), Humanize(total),
+				Humanize(speed), time.Since(start).Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"github.com/chewxy/math32"
+)
+
+// heatmapMax is the count at the hot end of the heatmaps of traversal
+// cost. The scale is logarithmic, so a count twice another is always the
+// same step hotter, whatever the scene.
+const heatmapMax = 4096
+
+// heatmapStops are the colours of the heatmaps from cold to hot, after
+// the inferno colour map: dark for cheap rays, bright for expensive ones.
+var heatmapStops = [...]Vec3{
+	{X: 0, Y: 0, Z: 4},
+	{X: 87, Y: 16, Z: 110},
+	{X: 188, Y: 55, Z: 84},
+	{X: 249, Y: 142, Z: 9},
+	{X: 252, Y: 255, Z: 164},
+}
+
+// heatmapPosition places a count on the heatmap scale, from 0 for none to
+// 1 for heatmapMax or more.
+func heatmapPosition(count float32) float32 {
+	return min(1, math32.Log2(1+max(0, count))/math32.Log2(1+heatmapMax))
+}
+
+// heatColor is the false colour of a count.
+func heatColor(count float32) color.RGBA {
+	return heatmapColorAt(heatmapPosition(count))
+}
+
+func heatmapColorAt(x float32) color.RGBA {
+	x *= float32(len(heatmapStops) - 1)
+	i := min(int(x), len(heatmapStops)-2)
+	c := heatmapStops[i].Lerp(heatmapStops[i+1], x-float32(i))
+	return color.RGBA{R: uint8(c.X + 0.5), G: uint8(c.Y + 0.5), B: uint8(c.Z + 0.5), A: 255}
+}
+
+// heatmapLegend is the key to the heatmaps the viewer shows in a corner:
+// a bar running from cold to hot, labelled with the counts along it.
+func heatmapLegend(aov AOV, x, y float32) fyne.CanvasObject {
+	const width, height = 200, 10
+	bar := image.NewRGBA(image.Rect(0, 0, width, 1))
+	for i := range width {
+		bar.SetRGBA(i, 0, heatmapColorAt(float32(i)/(width-1)))
+	}
+	barImage := canvas.NewImageFromImage(bar)
+	barImage.ScaleMode = canvas.ImageScalePixels
+	barImage.Move(fyne.NewPos(x, y+13))
+	barImage.Resize(fyne.NewSize(width, height))
+
+	title := canvas.NewText(aov.String()+" per camera ray", color.White)
+	title.TextSize = 10
+	title.Move(fyne.NewPos(x, y))
+	legend := container.NewWithoutLayout(title, barImage)
+
+	for count := 1; count <= heatmapMax; count *= 8 {
+		text := fmt.Sprint(count)
+		if count == heatmapMax {
+			text += "+"
+		}
+		label := canvas.NewText(text, color.White)
+		label.TextSize = 10
+		label.Move(fyne.NewPos(x+heatmapPosition(float32(count))*(width-1), y+13+height))
+		legend.Add(label)
+	}
+	return legend
+}
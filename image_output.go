@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Radiance returns the linear, unclamped mean of every pixel in image order
+// (row-major, top row first).
+func (r *Renderer) Radiance() []Vec3 {
+	width, height := r.Settings.Width, r.Settings.Height
+	out := make([]Vec3, width*height)
+	for y := range r.Pixels {
+		for x := range r.Pixels[y] {
+			pixel := &r.Pixels[y][x]
+			if pixel.SampleCount == 0 {
+				continue
+			}
+			out[int(pixel.Y)*width+r.ImageX(pixel.X)] = pixel.Mean
+		}
+	}
+	return out
+}
+
+// SaveRender writes the beauty pass to path, picking the format from the
+// file extension: .png, .hdr (Radiance RGBE), .exr (OpenEXR) or .pfm.
+func SaveRender(path string, r *Renderer, halfEXR bool) error {
+	return SaveAOV(path, r, AOVBeauty, halfEXR)
+}
+
+// SaveAOV writes one AOV to path like SaveRender. PNG gets the viewer's
+// display mapping; the other formats keep the linear values.
+func SaveAOV(path string, r *Renderer, aov AOV, halfEXR bool) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !IsSupportedOutput(ext) {
+		return fmt.Errorf("unsupported output format %q", ext)
+	}
+	if ext == ".png" {
+		return writePNG(path, r.AOVImage(aov))
+	}
+
+	width, height := r.Settings.Width, r.Settings.Height
+	pixels := r.AOV(aov)
+	return writeFile(path, func(w io.Writer) error {
+		switch ext {
+		case ".hdr":
+			return WriteRadianceHDR(w, width, height, pixels)
+		case ".exr":
+			return WriteOpenEXR(w, width, height, RGBChannels(pixels), halfEXR)
+		case ".pfm":
+			return WritePFM(w, width, height, pixels)
+		}
+		return nil
+	})
+}
+
+// SaveLayeredEXR writes several AOVs as the layers of one OpenEXR file; see
+// Renderer.AOVChannels for the channel names.
+func SaveLayeredEXR(path string, r *Renderer, aovs []AOV, half bool) error {
+	channels := r.AOVChannels(aovs)
+	return writeFile(path, func(w io.Writer) error {
+		return WriteOpenEXR(w, r.Settings.Width, r.Settings.Height, channels, half)
+	})
+}
+
+// writeFile creates path and hands a buffered writer to write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func IsSupportedOutput(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".png", ".hdr", ".exr", ".pfm":
+		return true
+	}
+	return false
+}
+
+// ------------------------------------------------------------
+
+// WriteRadianceHDR writes uncompressed RGBE scanlines, top row first.
+func WriteRadianceHDR(w io.Writer, width, height int, pixels []Vec3) error {
+	if len(pixels) != width*height {
+		return fmt.Errorf("hdr: have %d pixels for a %dx%d image", len(pixels), width, height)
+	}
+	if _, err := fmt.Fprintf(w, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", height, width); err != nil {
+		return err
+	}
+
+	scanline := make([]byte, 4*width)
+	for y := range height {
+		for x := range width {
+			rgbe := toRGBE(pixels[y*width+x])
+			copy(scanline[4*x:], rgbe[:])
+		}
+		if _, err := w.Write(scanline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toRGBE packs a colour into a shared-exponent RGBE quadruple.
+func toRGBE(c Vec3) [4]byte {
+	r, g, b := max(0, c.X), max(0, c.Y), max(0, c.Z)
+	v := float64(max(r, g, b))
+	if v < 1e-32 {
+		return [4]byte{}
+	}
+	mantissa, exponent := math.Frexp(v)
+	scale := mantissa * 256 / v
+	return [4]byte{
+		byte(float64(r) * scale),
+		byte(float64(g) * scale),
+		byte(float64(b) * scale),
+		byte(exponent + 128),
+	}
+}
+
+// ------------------------------------------------------------
+
+// WritePFM writes a colour Portable Float Map. PFM stores the bottom row
+// first; a negative scale marks little-endian data.
+func WritePFM(w io.Writer, width, height int, pixels []Vec3) error {
+	if len(pixels) != width*height {
+		return fmt.Errorf("pfm: have %d pixels for a %dx%d image", len(pixels), width, height)
+	}
+	if _, err := fmt.Fprintf(w, "PF\n%d %d\n-1.0\n", width, height); err != nil {
+		return err
+	}
+
+	row := make([]byte, 12*width)
+	for y := height - 1; y >= 0; y-- {
+		for x := range width {
+			p := pixels[y*width+x]
+			binary.LittleEndian.PutUint32(row[12*x:], math.Float32bits(p.X))
+			binary.LittleEndian.PutUint32(row[12*x+4:], math.Float32bits(p.Y))
+			binary.LittleEndian.PutUint32(row[12*x+8:], math.Float32bits(p.Z))
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+
+// EXRChannel is one named plane of an OpenEXR image, row-major, top row
+// first.
+type EXRChannel struct {
+	Name string
+	Data []float32
+}
+
+func RGBChannels(pixels []Vec3) []EXRChannel {
+	r := make([]float32, len(pixels))
+	g := make([]float32, len(pixels))
+	b := make([]float32, len(pixels))
+	for i, p := range pixels {
+		r[i], g[i], b[i] = p.X, p.Y, p.Z
+	}
+	return []EXRChannel{{"R", r}, {"G", g}, {"B", b}}
+}
+
+const (
+	exrMagic      = 20000630
+	exrPixelHalf  = 1
+	exrPixelFloat = 2
+)
+
+// WriteOpenEXR writes a single-part, uncompressed scanline OpenEXR file with
+// one scanline per block. Channels are stored as half or 32-bit floats.
+func WriteOpenEXR(w io.Writer, width, height int, channels []EXRChannel, half bool) error {
+	for _, ch := range channels {
+		if len(ch.Data) != width*height {
+			return fmt.Errorf("exr: channel %s has %d values for a %dx%d image", ch.Name, len(ch.Data), width, height)
+		}
+	}
+
+	// The format requires channels in alphabetical order.
+	channels = append([]EXRChannel(nil), channels...)
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+
+	pixelType, pixelSize := int32(exrPixelFloat), 4
+	if half {
+		pixelType, pixelSize = exrPixelHalf, 2
+	}
+
+	var header []byte
+	u32 := func(v uint32) { header = binary.LittleEndian.AppendUint32(header, v) }
+	i32 := func(v int32) { u32(uint32(v)) }
+	f32 := func(v float32) { u32(math.Float32bits(v)) }
+	str := func(s string) { header = append(header, s...); header = append(header, 0) }
+	attribute := func(name, kind string, size int) { str(name); str(kind); i32(int32(size)) }
+
+	u32(exrMagic)
+	u32(2) // Version 2, single-part scanline
+
+	chlistSize := 1
+	for _, ch := range channels {
+		chlistSize += len(ch.Name) + 1 + 16
+	}
+	attribute("channels", "chlist", chlistSize)
+	for _, ch := range channels {
+		str(ch.Name)
+		i32(pixelType)
+		header = append(header, 0, 0, 0, 0) // pLinear + reserved
+		i32(1)                              // xSampling
+		i32(1)                              // ySampling
+	}
+	header = append(header, 0)
+
+	attribute("compression", "compression", 1)
+	header = append(header, 0) // NO_COMPRESSION
+
+	for _, window := range []string{"dataWindow", "displayWindow"} {
+		attribute(window, "box2i", 16)
+		i32(0)
+		i32(0)
+		i32(int32(width - 1))
+		i32(int32(height - 1))
+	}
+
+	attribute("lineOrder", "lineOrder", 1)
+	header = append(header, 0) // INCREASING_Y
+
+	attribute("pixelAspectRatio", "float", 4)
+	f32(1)
+
+	attribute("screenWindowCenter", "v2f", 8)
+	f32(0)
+	f32(0)
+
+	attribute("screenWindowWidth", "float", 4)
+	f32(1)
+
+	header = append(header, 0) // End of header
+
+	// Offset table: one entry per scanline block.
+	blockSize := 8 + width*pixelSize*len(channels)
+	offset := uint64(len(header) + 8*height)
+	for range height {
+		header = binary.LittleEndian.AppendUint64(header, offset)
+		offset += uint64(blockSize)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	block := make([]byte, blockSize)
+	for y := range height {
+		binary.LittleEndian.PutUint32(block[0:], uint32(y))
+		binary.LittleEndian.PutUint32(block[4:], uint32(blockSize-8))
+		p := 8
+		for _, ch := range channels {
+			for _, v := range ch.Data[y*width : (y+1)*width] {
+				if half {
+					binary.LittleEndian.PutUint16(block[p:], floatToHalf(v))
+				} else {
+					binary.LittleEndian.PutUint32(block[p:], math.Float32bits(v))
+				}
+				p += pixelSize
+			}
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// floatToHalf converts to IEEE 754 binary16, rounding to nearest even and
+// saturating to infinity.
+func floatToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int32(bits>>23) & 0xff
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case exponent == 0xff: // Inf or NaN
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exponent-127 > 15: // Too large
+		return sign | 0x7c00
+	case exponent-127 >= -14: // Normal
+		half := uint32(exponent-127+15)<<10 | mantissa>>13
+		// Round to nearest even; a carry into the exponent is still correct.
+		round := mantissa & 0x1fff
+		if round > 0x1000 || (round == 0x1000 && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	case exponent-127 >= -25: // Subnormal
+		mantissa |= 0x800000
+		shift := uint32(-1 - (exponent - 127))
+		half := mantissa >> shift
+		rest := mantissa & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if rest > halfway || (rest == halfway && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	return sign
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// halfToFloat decodes an IEEE 754 binary16 value.
+func halfToFloat(h uint16) float32 {
+	sign := float32(1)
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exponent, mantissa := int(h>>10)&0x1f, float64(h&0x3ff)
+	switch exponent {
+	case 0:
+		return sign * float32(math.Ldexp(mantissa, -24))
+	case 0x1f:
+		if mantissa != 0 {
+			return float32(math.NaN())
+		}
+		return sign * float32(math.Inf(1))
+	}
+	return sign * float32(math.Ldexp(1024+mantissa, exponent-25))
+}
+
+// testImage is a small image of positive, zero and large values, with every
+// pixel different so a wrong row or channel order shows.
+func testImage(width, height int) []Vec3 {
+	rng := rand.New(rand.NewPCG(3, 4))
+	pixels := make([]Vec3, width*height)
+	for i := range pixels {
+		scale := float32(math.Pow(10, float64(i%7-3)))
+		pixels[i] = Vec3{X: rng.Float32(), Y: rng.Float32(), Z: rng.Float32()}.Scale(scale)
+	}
+	pixels[0] = Vec3{}
+	pixels[1] = Vec3{X: 1, Y: 0.5, Z: 0.25}
+	pixels[2] = Vec3{X: 5000, Y: 1e-3, Z: 0}
+	return pixels
+}
+
+func TestFloatToHalf(t *testing.T) {
+	tests := []struct {
+		f    float32
+		half uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.5, 0x3800},
+		{65504, 0x7bff},
+		{0x1p-14, 0x0400},     // Smallest normal
+		{0x1p-24, 0x0001},     // Smallest subnormal
+		{0x1.8p-24, 0x0002},   // Halfway, rounded to even
+		{0x1p-25, 0x0000},     // Halfway to the smallest subnormal, rounded to even
+		{0x1.8p-25, 0x0001},   // Past halfway
+		{0x1p-26, 0x0000},     // Underflow
+		{1 + 0x1p-11, 0x3c00}, // Halfway, rounded to even
+		{1 + 0x3p-11, 0x3c02},
+		{0x1.ffcp-15, 0x0400}, // Largest subnormal rounded up into the normals
+		{65519, 0x7bff},
+		{65520, 0x7c00}, // Halfway past the largest half: overflow
+		{1e6, 0x7c00},
+		{-1e6, 0xfc00},
+		{float32(math.Inf(1)), 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+	}
+	for _, test := range tests {
+		if got := floatToHalf(test.f); got != test.half {
+			t.Errorf("floatToHalf(%g) = %#04x, want %#04x", test.f, got, test.half)
+		}
+	}
+	if h := floatToHalf(float32(math.NaN())); h&0x7c00 != 0x7c00 || h&0x3ff == 0 {
+		t.Errorf("floatToHalf(NaN) = %#04x, not a NaN", h)
+	}
+
+	// Every half but the NaNs converts back to itself.
+	for h := range uint32(0x10000) {
+		half := uint16(h)
+		if half&0x7c00 == 0x7c00 && half&0x3ff != 0 {
+			continue
+		}
+		if got := floatToHalf(halfToFloat(half)); got != half {
+			t.Fatalf("%#04x converts to %g and back to %#04x", half, halfToFloat(half), got)
+		}
+	}
+
+	// Every float within range goes to the nearest half.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 100000 {
+		f := float32(math.Ldexp(rng.Float64()*2-1, rng.IntN(43)-27))
+		half := floatToHalf(f)
+		got := halfToFloat(half)
+		for _, neighbour := range []uint16{half - 1, half + 1} {
+			if n := halfToFloat(neighbour); half&0x7fff != 0 && neighbour&0x7c00 != 0x7c00 &&
+				math.Abs(float64(n-f)) < math.Abs(float64(got-f)) {
+				t.Fatalf("floatToHalf(%g) = %g, but %g is nearer", f, got, n)
+			}
+		}
+	}
+}
+
+func TestWriteRadianceHDR(t *testing.T) {
+	width, height := 5, 3
+	pixels := testImage(width, height)
+	var buf bytes.Buffer
+	if err := WriteRadianceHDR(&buf, width, height, pixels); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(&buf)
+	header := ""
+	for !strings.HasSuffix(header, "\n\n") {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		header += line
+	}
+	if !strings.HasPrefix(header, "#?RADIANCE\n") || !strings.Contains(header, "FORMAT=32-bit_rle_rgbe\n") {
+		t.Fatalf("header %q", header)
+	}
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("-Y %d +X %d\n", height, width); resolution != want {
+		t.Fatalf("resolution %q, want %q", resolution, want)
+	}
+
+	data := make([]byte, 4*width*height)
+	if n, _ := r.Read(data); n != len(data) || r.Buffered() != 0 {
+		t.Fatalf("%d bytes of pixels, %d left over; want %d", n, r.Buffered(), len(data))
+	}
+	for i, want := range pixels {
+		rgbe := data[4*i : 4*i+4]
+		if rgbe[3] == 0 {
+			if want != (Vec3{}) {
+				t.Errorf("pixel %d is black, want %v", i, want)
+			}
+			continue
+		}
+		// Mantissas are truncated to 8 bits of the largest channel.
+		scale := math.Ldexp(1, int(rgbe[3])-136)
+		largest := max(want.X, want.Y, want.Z)
+		if rgbe[0] < 128 && rgbe[1] < 128 && rgbe[2] < 128 {
+			t.Errorf("pixel %d: no mantissa of %v is normalised", i, rgbe)
+		}
+		for c, v := range []float32{want.X, want.Y, want.Z} {
+			got := float64(rgbe[c]) * scale
+			if d := float64(v) - got; d < 0 || d > float64(largest)/128 {
+				t.Errorf("pixel %d channel %d reads %g, want %g", i, c, got, v)
+			}
+		}
+	}
+
+	if toRGBE(Vec3{X: -1, Y: 2}) != toRGBE(Vec3{Y: 2}) {
+		t.Error("negative channels are not clamped to zero")
+	}
+	if err := WriteRadianceHDR(&buf, width, height, pixels[1:]); err == nil {
+		t.Error("wrote an image with a pixel missing")
+	}
+}
+
+func TestWritePFM(t *testing.T) {
+	width, height := 4, 3
+	pixels := testImage(width, height)
+	pixels[3] = Vec3{X: -2, Y: float32(math.Inf(1)), Z: 1e-40}
+	var buf bytes.Buffer
+	if err := WritePFM(&buf, width, height, pixels); err != nil {
+		t.Fatal(err)
+	}
+
+	var w, h int
+	var scale float64
+	if _, err := fmt.Fscanf(&buf, "PF\n%d %d\n%g\n", &w, &h, &scale); err != nil {
+		t.Fatal(err)
+	}
+	if w != width || h != height {
+		t.Fatalf("size %dx%d, want %dx%d", w, h, width, height)
+	}
+	if scale >= 0 {
+		t.Fatalf("scale %g; little-endian data needs a negative one", scale)
+	}
+	if buf.Len() != 12*width*height {
+		t.Fatalf("%d bytes of pixels, want %d", buf.Len(), 12*width*height)
+	}
+	data := buf.Bytes()
+	for y := range height {
+		for x := range width {
+			// Rows are stored bottom row first.
+			at := 12 * ((height-1-y)*width + x)
+			got := Vec3{
+				X: math.Float32frombits(binary.LittleEndian.Uint32(data[at:])),
+				Y: math.Float32frombits(binary.LittleEndian.Uint32(data[at+4:])),
+				Z: math.Float32frombits(binary.LittleEndian.Uint32(data[at+8:])),
+			}
+			if want := pixels[y*width+x]; got != want {
+				t.Errorf("pixel %d,%d reads %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	if err := WritePFM(&buf, width, height, pixels[1:]); err == nil {
+		t.Error("wrote an image with a pixel missing")
+	}
+}
+
+// exrFile is a decoded single-part scanline OpenEXR file.
+type exrFile struct {
+	attributes map[string]exrAttribute
+	channels   []string
+	pixelTypes []int32
+	// values are the decoded channels, in the file's order.
+	values [][]float32
+}
+
+type exrAttribute struct {
+	kind string
+	data []byte
+}
+
+// readEXR decodes what WriteOpenEXR writes: an uncompressed scanline file
+// of one scanline per block, checking the offset table on the way.
+func readEXR(t *testing.T, data []byte, width, height int) *exrFile {
+	t.Helper()
+	if binary.LittleEndian.Uint32(data) != exrMagic {
+		t.Fatal("no OpenEXR magic number")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		t.Fatalf("version field %#x, want 2 for a single-part scanline file", version)
+	}
+	p := 8
+	str := func() string {
+		end := bytes.IndexByte(data[p:], 0)
+		if end < 0 {
+			t.Fatal("unterminated string in the header")
+		}
+		s := string(data[p : p+end])
+		p += end + 1
+		return s
+	}
+	file := &exrFile{attributes: make(map[string]exrAttribute)}
+	for {
+		name := str()
+		if name == "" {
+			break
+		}
+		kind := str()
+		size := int(binary.LittleEndian.Uint32(data[p:]))
+		p += 4
+		file.attributes[name] = exrAttribute{kind, data[p : p+size]}
+		p += size
+	}
+
+	chlist := file.attributes["channels"].data
+	for len(chlist) > 1 {
+		end := bytes.IndexByte(chlist, 0)
+		file.channels = append(file.channels, string(chlist[:end]))
+		file.pixelTypes = append(file.pixelTypes, int32(binary.LittleEndian.Uint32(chlist[end+1:])))
+		if xs, ys := binary.LittleEndian.Uint32(chlist[end+9:]), binary.LittleEndian.Uint32(chlist[end+13:]); xs != 1 || ys != 1 {
+			t.Fatalf("channel %s sampled %d,%d", chlist[:end], xs, ys)
+		}
+		chlist = chlist[end+17:]
+	}
+	if len(chlist) != 1 || chlist[0] != 0 {
+		t.Fatal("channel list not terminated")
+	}
+
+	offsets := make([]uint64, height)
+	for y := range offsets {
+		offsets[y] = binary.LittleEndian.Uint64(data[p:])
+		p += 8
+	}
+	file.values = make([][]float32, len(file.channels))
+	for c := range file.values {
+		file.values[c] = make([]float32, width*height)
+	}
+	for y, offset := range offsets {
+		if int(offset) != p {
+			t.Fatalf("scanline %d at offset %d, want %d", y, offset, p)
+		}
+		if got := int(binary.LittleEndian.Uint32(data[p:])); got != y {
+			t.Fatalf("block at scanline %d says %d", y, got)
+		}
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		p += 8
+		end := p + size
+		for c, pixelType := range file.pixelTypes {
+			for x := range width {
+				v := &file.values[c][y*width+x]
+				if pixelType == exrPixelHalf {
+					*v = halfToFloat(binary.LittleEndian.Uint16(data[p:]))
+					p += 2
+				} else {
+					*v = math.Float32frombits(binary.LittleEndian.Uint32(data[p:]))
+					p += 4
+				}
+			}
+		}
+		if p != end {
+			t.Fatalf("scanline %d is %d bytes, its block says %d", y, size+p-end, size)
+		}
+	}
+	if p != len(data) {
+		t.Fatalf("%d bytes after the last scanline", len(data)-p)
+	}
+	return file
+}
+
+func TestWriteOpenEXR(t *testing.T) {
+	width, height := 5, 4
+	pixels := testImage(width, height)
+	pixels[4] = Vec3{X: -3, Y: 6e4, Z: 1e-6}
+	depth := make([]float32, width*height)
+	for i := range depth {
+		depth[i] = float32(i) * 0.75
+	}
+	// Out of order, to be sorted.
+	channels := append([]EXRChannel{{"depth.Z", depth}}, RGBChannels(pixels)...)
+
+	for _, half := range []bool{false, true} {
+		t.Run(fmt.Sprintf("half=%v", half), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteOpenEXR(&buf, width, height, channels, half); err != nil {
+				t.Fatal(err)
+			}
+			file := readEXR(t, buf.Bytes(), width, height)
+
+			box := binary.LittleEndian.AppendUint32(nil, 0)
+			box = binary.LittleEndian.AppendUint32(box, 0)
+			box = binary.LittleEndian.AppendUint32(box, uint32(width-1))
+			box = binary.LittleEndian.AppendUint32(box, uint32(height-1))
+			one := binary.LittleEndian.AppendUint32(nil, math.Float32bits(1))
+			required := map[string]exrAttribute{
+				"compression":        {"compression", []byte{0}},
+				"dataWindow":         {"box2i", box},
+				"displayWindow":      {"box2i", box},
+				"lineOrder":          {"lineOrder", []byte{0}},
+				"pixelAspectRatio":   {"float", one},
+				"screenWindowCenter": {"v2f", make([]byte, 8)},
+				"screenWindowWidth":  {"float", one},
+			}
+			for name, want := range required {
+				got, ok := file.attributes[name]
+				if !ok {
+					t.Errorf("no %s attribute", name)
+				} else if got.kind != want.kind || !bytes.Equal(got.data, want.data) {
+					t.Errorf("%s is %s %v, want %s %v", name, got.kind, got.data, want.kind, want.data)
+				}
+			}
+			if kind := file.attributes["channels"].kind; kind != "chlist" {
+				t.Errorf("channels is a %s", kind)
+			}
+
+			if want := []string{"B", "G", "R", "depth.Z"}; !slices.Equal(file.channels, want) {
+				t.Fatalf("channels %v, want %v", file.channels, want)
+			}
+			pixelType := int32(exrPixelFloat)
+			if half {
+				pixelType = exrPixelHalf
+			}
+			for c, got := range file.pixelTypes {
+				if got != pixelType {
+					t.Errorf("channel %s has pixel type %d, want %d", file.channels[c], got, pixelType)
+				}
+			}
+
+			source := map[string][]float32{}
+			for _, ch := range channels {
+				source[ch.Name] = ch.Data
+			}
+			for c, name := range file.channels {
+				for i, want := range source[name] {
+					got := file.values[c][i]
+					if !half && got != want {
+						t.Errorf("%s[%d] reads %g, want %g", name, i, got, want)
+					}
+					// Halves keep 11 significant bits, and subnormals a
+					// fixed step of 2^-24.
+					if d := math.Abs(float64(got - want)); half && d > max(math.Abs(float64(want))*0x1p-11, 0x1p-25) {
+						t.Errorf("%s[%d] reads %g, want %g", name, i, got, want)
+					}
+				}
+			}
+		})
+	}
+
+	if err := WriteOpenEXR(&bytes.Buffer{}, width, height, []EXRChannel{{"R", depth[1:]}}, false); err == nil {
+		t.Error("wrote a channel with a value missing")
+	}
+}
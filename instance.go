@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/chewxy/math32"
+)
+
+// Instance places a mesh in the world. The mesh's BVH is built once, in
+// object space, and shared by all of its instances: rays are moved into the
+// mesh's space rather than the mesh into the world.
+type Instance struct {
+	BVH *LinearBVH
+
+	ObjectToWorld, WorldToObject Mat4
+
+	// Material, if set, replaces the mesh's materials.
+	Material *Material
+
+	// For the AOVs; MaterialID is only used with Material.
+	ObjectID, MaterialID int32
+
+	bounds   LinearBVHNode // In world space, tested before the ray is moved
+	local    LinearBVHNode // The mesh's bounds in object space
+	identity bool
+}
+
+// NewInstance places bvh, whose triangles lie within lo and hi, with the
+// given transform.
+func NewInstance(bvh *LinearBVH, lo, hi Vec3, objectToWorld Mat4) (*Instance, error) {
+	instance := &Instance{BVH: bvh, local: LinearBVHNode{MinBounds: lo, MaxBounds: hi}}
+	if err := instance.SetTransform(objectToWorld); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// SetTransform moves the instance. The tree it is in must be refitted
+// before it is traversed again; see TopLevelBVH.Update.
+func (inst *Instance) SetTransform(objectToWorld Mat4) error {
+	worldToObject, ok := objectToWorld.Inverse()
+	if !ok {
+		return fmt.Errorf("transform %v cannot be inverted", objectToWorld)
+	}
+	inst.ObjectToWorld = objectToWorld
+	inst.WorldToObject = worldToObject
+	inst.identity = objectToWorld.IsIdentity()
+
+	lo, hi := inst.local.MinBounds, inst.local.MaxBounds
+	inf := math32.Inf(1)
+	worldLo, worldHi := Vec3{X: inf, Y: inf, Z: inf}, Vec3{X: -inf, Y: -inf, Z: -inf}
+	for _, corner := range [8]Vec3{
+		{X: lo.X, Y: lo.Y, Z: lo.Z}, {X: hi.X, Y: lo.Y, Z: lo.Z}, {X: lo.X, Y: hi.Y, Z: lo.Z}, {X: hi.X, Y: hi.Y, Z: lo.Z},
+		{X: lo.X, Y: lo.Y, Z: hi.Z}, {X: hi.X, Y: lo.Y, Z: hi.Z}, {X: lo.X, Y: hi.Y, Z: hi.Z}, {X: hi.X, Y: hi.Y, Z: hi.Z},
+	} {
+		p := objectToWorld.Point(corner)
+		worldLo = Vec3{X: min(worldLo.X, p.X), Y: min(worldLo.Y, p.Y), Z: min(worldLo.Z, p.Z)}
+		worldHi = Vec3{X: max(worldHi.X, p.X), Y: max(worldHi.Y, p.Y), Z: max(worldHi.Z, p.Z)}
+	}
+	inst.bounds = LinearBVHNode{MinBounds: worldLo, MaxBounds: worldHi}
+	return nil
+}
+
+func (inst *Instance) Bounds() (Vec3, Vec3) {
+	return inst.bounds.MinBounds, inst.bounds.MaxBounds
+}
+
+// objectQuery moves the ray into object space. The direction keeps the
+// scale of the transform so distances along the ray stay the same. Its
+// cost starts from zero.
+func (inst *Instance) objectQuery(q *rayQuery) rayQuery {
+	if inst.identity {
+		object := *q
+		object.cost = TraversalCost{}
+		return object
+	}
+	return newRayQuery(Ray{
+		Origin:    inst.WorldToObject.Point(q.Origin),
+		Direction: inst.WorldToObject.Vector(q.Direction),
+	})
+}
+
+// nearest records in h any hit on the instance, in slot, closer than h.
+func (inst *Instance) nearest(q *rayQuery, slot int, h *nearestHit) {
+	if inst.bounds.intersectAABB(q, h.t) == INF {
+		return
+	}
+	object := inst.objectQuery(q)
+	inst.BVH.nearest(&object, slot, h)
+	q.cost.Add(object.cost)
+}
+
+// WorldTriangle returns a copy of tri, a triangle of the instance's mesh,
+// with its corners moved to world space and Instance set, so the tracer
+// can shade it like any other; the rest of its fields stay in object
+// space.
+func (inst *Instance) WorldTriangle(tri *BVHTriangle) BVHTriangle {
+	world := *tri
+	if !inst.identity {
+		world.A = inst.ObjectToWorld.Point(tri.A)
+		world.B = inst.ObjectToWorld.Point(tri.B)
+		world.C = inst.ObjectToWorld.Point(tri.C)
+	}
+	world.Instance = inst
+	return world
+}
+
+func (inst *Instance) occludes(q *rayQuery, tMax float32) bool {
+	if inst.bounds.intersectAABB(q, tMax) == INF {
+		return false
+	}
+	object := inst.objectQuery(q)
+	return inst.BVH.occludes(&object, tMax)
+}
+
+// NormalToWorld carries an object-space normal to world space; it is not
+// normalised.
+func (inst *Instance) NormalToWorld(n Vec3) Vec3 {
+	return inst.WorldToObject.TransposedVector(n)
+}
+
+// buildInstances builds a BVH for every distinct mesh of the objects,
+// whose triangles DecomposeObjects laid out at offsets in tris, unless the
+// mesh comes with one built with the same options, and
+// places an instance of it for each object. Emissive triangles are added
+// per instance.
+func (vnmu *VNMU) buildInstances(objects []*GameObject[any], tris []int, offsets map[*Mesh]int, options BVHOptions) ([]*Instance, error) {
+	type meshBVH struct {
+		bvh    *LinearBVH
+		lo, hi Vec3
+	}
+	bvhs := make(map[*Mesh]meshBVH)
+
+	instances := make([]*Instance, 0, len(objects))
+	for i, object := range objects {
+		offset := offsets[object.Mesh]
+		mesh, ok := bvhs[object.Mesh]
+		if !ok {
+			if object.Mesh.BVH != nil && object.Mesh.BVHOptions == options {
+				mesh.bvh = object.Mesh.BVH.rebased(tris, offset)
+				mesh.lo, mesh.hi = entryBounds(mesh.bvh.Triangles)
+			} else {
+				entries := TriangleEntries(vnmu.Vertices, tris, offset, offset+len(object.Mesh.Tris))
+				mesh.lo, mesh.hi = entryBounds(entries)
+				mesh.bvh = ConstructLinearBVH(BuildBVH(entries, options))
+			}
+			mesh.bvh.Collapse(options.Width)
+			bvhs[object.Mesh] = mesh
+		}
+
+		instance, err := NewInstance(mesh.bvh, mesh.lo, mesh.hi, object.ObjectToWorld())
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", i, err)
+		}
+		instance.Material = object.Material
+		instances = append(instances, instance)
+
+		for j := offset; j < offset+len(object.Mesh.Tris); j += 3 {
+			material := vnmu.Materials[j/3]
+			if object.Material != nil {
+				material = object.Material
+			}
+			if material.Emissive.R > 0 || material.Emissive.G > 0 || material.Emissive.B > 0 {
+				vnmu.EmissiveTriangles = append(vnmu.EmissiveTriangles, EmissiveTriangle{
+					VertexIndices: [3]int{tris[j], tris[j+1], tris[j+2]},
+					NormalIndices: [3]int{j, j + 1, j + 2},
+					MaterialIndex: j / 3,
+					Instance:      instance,
+				})
+			}
+		}
+	}
+	return instances, nil
+}
+
+// entryBounds returns the box around the entries, or an empty box at the
+// origin if there are none.
+func entryBounds(entries []*BVHTriangle) (Vec3, Vec3) {
+	if len(entries) == 0 {
+		return Vec3{}, Vec3{}
+	}
+	box := FauxBox{}
+	for _, entry := range entries {
+		box.Grow(entry)
+	}
+	return Vec3{X: box.X1, Y: box.Y1, Z: box.Z1}, Vec3{X: box.X2, Y: box.Y2, Z: box.Z2}
+}
+
+// ------------------------------------------------------------
+
+// TopLevelBVH is the scene's BVH: a tree over the instances, whose leaves
+// descend into the mesh BVHs, next to a BVH of the primitives in world
+// space.
+type TopLevelBVH struct {
+	Nodes      []LinearBVHNode
+	Instances  []*Instance // In the order the leaves refer to them
+	Primitives *LinearBVH
+
+	// builtCost is the SAH cost of Nodes as they were built; see Update.
+	builtCost float32
+}
+
+func BuildTopLevelBVH(instances []*Instance, primitives *LinearBVH, options BVHOptions) *TopLevelBVH {
+	entries := make([]*BVHTriangle, len(instances))
+	for i, instance := range instances {
+		lo, hi := instance.Bounds()
+		entries[i] = &BVHTriangle{
+			Index:    i,
+			Centroid: lo.Add(hi).Scale(0.5),
+			MinX:     lo.X, MaxX: hi.X,
+			MinY: lo.Y, MaxY: hi.Y,
+			MinZ: lo.Z, MaxZ: hi.Z,
+		}
+	}
+	// The entries are boxes rather than triangles and cannot be clipped.
+	options.SpatialSplitBudget = 0
+	tree := ConstructLinearBVH(BuildBVH(entries, options))
+
+	bvh := &TopLevelBVH{Nodes: tree.Nodes, Primitives: primitives, builtCost: treeCost(tree.Nodes, options)}
+	for _, entry := range tree.Triangles {
+		bvh.Instances = append(bvh.Instances, instances[entry.Index])
+	}
+	return bvh
+}
+
+// Triangles returns the entries of the primitives and of every distinct
+// mesh BVH once.
+func (b *TopLevelBVH) Triangles() []*BVHTriangle {
+	triangles := append([]*BVHTriangle{}, b.Primitives.Unbounded...)
+	triangles = append(triangles, b.Primitives.Triangles...)
+	seen := make(map[*LinearBVH]bool)
+	for _, instance := range b.Instances {
+		if !seen[instance.BVH] {
+			seen[instance.BVH] = true
+			triangles = append(triangles, instance.BVH.Triangles...)
+		}
+	}
+	return triangles
+}
+
+// CheckIntersection returns the closest hit within stepSize. Triangles
+// of instances are returned as Instance.WorldTriangle makes them.
+func (b *TopLevelBVH) CheckIntersection(ray Ray, stepSize float32) (bool, float32, BVHTriangle) {
+	return b.CountedIntersection(ray, stepSize, nil)
+}
+
+// CountedIntersection is CheckIntersection that adds the work it took to
+// cost, if set.
+func (b *TopLevelBVH) CountedIntersection(ray Ray, stepSize float32, cost *TraversalCost) (bool, float32, BVHTriangle) {
+	q := newRayQuery(ray)
+	h := nearestHit{t: stepSize, rank: noHit}
+	b.nearest(&q, &h)
+	if cost != nil {
+		cost.Add(q.cost)
+	}
+	return b.hit(h)
+}
+
+func (b *TopLevelBVH) nearest(q *rayQuery, h *nearestHit) {
+	b.Primitives.nearest(q, 0, h)
+
+	var stack [64]uint32
+	stack[0] = 0
+	nptr := 1
+
+	for nptr > 0 {
+		ptr := stack[nptr-1]
+		node := &b.Nodes[ptr]
+		nptr--
+		q.cost.Nodes++
+
+		if node.IsLeaf() {
+			for i, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+				instance.nearest(q, int(node.Offset)+i+1, h)
+			}
+			continue
+		}
+
+		firstChildDistance := b.Nodes[ptr+1].intersectAABB(q, h.t)
+		secondChildDistance := b.Nodes[node.Offset].intersectAABB(q, h.t)
+		i, j := ptr+1, node.Offset
+		if firstChildDistance > secondChildDistance {
+			i, j = j, i
+			firstChildDistance, secondChildDistance = secondChildDistance, firstChildDistance
+		}
+		if firstChildDistance == INF {
+			continue
+		}
+		if secondChildDistance != INF {
+			stack[nptr] = j
+			nptr++
+		}
+		stack[nptr] = i
+		nptr++
+	}
+}
+
+// hit returns the hit h records as CheckIntersection does.
+func (b *TopLevelBVH) hit(h nearestHit) (bool, float32, BVHTriangle) {
+	if h.rank == noHit {
+		return false, 0, BVHTriangle{}
+	}
+	slot := int(h.rank >> 32)
+	if slot == 0 {
+		return true, h.t, *b.Primitives.entry(h.rank)
+	}
+	instance := b.Instances[slot-1]
+	return true, h.t, instance.WorldTriangle(instance.BVH.entry(h.rank))
+}
+
+func (b *TopLevelBVH) QuickCheckIntersection(ray Ray, stepSize float32) bool {
+	q := newRayQuery(ray)
+	return b.occludes(&q, stepSize)
+}
+
+func (b *TopLevelBVH) occludes(q *rayQuery, stepSize float32) bool {
+	if b.Primitives.occludes(q, stepSize) {
+		return true
+	}
+
+	var stack [64]uint32
+	stack[0] = 0
+	nptr := 1
+
+	for nptr > 0 {
+		ptr := stack[nptr-1]
+		node := &b.Nodes[ptr]
+		nptr--
+
+		if node.IsLeaf() {
+			for _, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+				if instance.occludes(q, stepSize) {
+					return true
+				}
+			}
+			continue
+		}
+
+		if b.Nodes[ptr+1].intersectAABB(q, stepSize) != INF {
+			stack[nptr] = ptr + 1
+			nptr++
+		}
+		if b.Nodes[node.Offset].intersectAABB(q, stepSize) != INF {
+			stack[nptr] = node.Offset
+			nptr++
+		}
+	}
+	return false
+}
@@ -2,7 +2,7 @@ package main
 
 type Light interface {
 	isLight()
-	Sample(ray Ray, normal Vec3, bvh *LinearBVH, stepSize float32, lightPos Vec3) Vec3
+	Sample(ray Ray, normal Vec3, bvh *TopLevelBVH, stepSize float32, lightPos Vec3) Vec3
 	// Sample(origin, direction, normal Vec3) Vec3
 }
 
@@ -15,13 +15,17 @@ type Sun struct {
 }
 
 func (s *Sun) isLight() {}
-func (s *Sun) Sample(ray Ray, normal Vec3, bvh *LinearBVH, stepSize float32, lightPos Vec3) Vec3 {
-	ndotr := ray.Direction.Dot(normal)
-	if ndotr < 0 {
+func (s *Sun) Sample(ray Ray, normal Vec3, bvh *TopLevelBVH, stepSize float32, lightPos Vec3) Vec3 {
+	if ray.Direction.Dot(normal) < 0 {
 		return Vec3{}
 	}
-	shadow := bvh.QuickCheckIntersection(ray, stepSize)
-	if shadow {
+	return s.Shade(ray, normal, bvh.QuickCheckIntersection(ray, stepSize))
+}
+
+// Shade is Sample for a shadow ray already traced, as part of a packet.
+func (s *Sun) Shade(ray Ray, normal Vec3, shadowed bool) Vec3 {
+	ndotr := ray.Direction.Dot(normal)
+	if ndotr < 0 || shadowed {
 		return Vec3{}
 	}
 	return s.Color.Scale(ndotr * s.Intensity)
@@ -35,7 +39,7 @@ type PointLight struct {
 }
 
 func (s *PointLight) isLight() {}
-func (s *PointLight) Sample(ray Ray, normal Vec3, bvh *LinearBVH, stepSize float32, lightPos Vec3) Vec3 {
+func (s *PointLight) Sample(ray Ray, normal Vec3, bvh *TopLevelBVH, stepSize float32, lightPos Vec3) Vec3 {
 	toLight := lightPos.Sub(ray.Origin)
 	distance := toLight.Length()
 	toLight._Normalize()
@@ -53,7 +57,7 @@ func (s *PointLight) Sample(ray Ray, normal Vec3, bvh *LinearBVH, stepSize float
 	return s.Color.Scale(ndotl * s.Intensity * attenuation)
 }
 
-func (s *PointLight) Sample2(ray Ray, normal Vec3, bvh *LinearBVH, stepSize float32, lightPos Vec3) Vec3 {
+func (s *PointLight) Sample2(ray Ray, normal Vec3, bvh *TopLevelBVH, stepSize float32, lightPos Vec3) Vec3 {
 	// 1. Create direction FROM hit point TO light
 	toLight := lightPos.Sub(ray.Origin)
 	distance := toLight.Length()
@@ -1,248 +1,374 @@
 package main
 
-import (
-	"math"
-
-	"github.com/chewxy/math32"
-)
+import "math"
 
 const INF = math.MaxFloat32
 
+// innerNode is the Count of nodes that are not leaves.
+const innerNode = math.MaxUint32
+
+// LinearBVHNode is one node of a flattened tree, 32 bytes so two share a
+// cache line. An inner node's first child follows it; Offset is its
+// second child. A leaf's entries are Count entries from Offset.
 type LinearBVHNode struct {
-	// Bounds               [6]float32
 	MinBounds, MaxBounds Vec3
 
-	IsLeaf bool
+	Offset uint32
+	Count  uint32
+}
 
-	TriangleOffset uint32
-	TriangleCount  uint32
+func (l *LinearBVHNode) IsLeaf() bool {
+	return l.Count != innerNode
+}
 
-	SecondChildOffset uint32
+// rayQuery is a ray prepared for traversal: the reciprocal of its
+// direction and, per axis, whether it points backwards, so box tests know
+// which face is near without comparing, and its shear for triangle tests.
+// Closest-hit traversals count their work in cost.
+type rayQuery struct {
+	Origin, Direction, InverseDirection Vec3
+	Negative                            [3]bool
+	shear                               rayShear
+	cost                                TraversalCost
 }
 
-func (l *LinearBVHNode) intersectAABB(ray Ray, stepSize float32) float32 {
-	inverseDirection := ray.Direction.Inverse()
-	leftBounds := l.MinBounds.Sub(ray.Origin).ComponentMul(inverseDirection)
-	rightBounds := l.MaxBounds.Sub(ray.Origin).ComponentMul(inverseDirection)
+// TraversalCost is the work of finding a closest hit: the nodes the ray
+// entered and the entries it was tested against.
+type TraversalCost struct {
+	Nodes, Tests int32
+}
 
-	// inverseDirectionX := 1.0 / ray.Direction.X
-	// Unrolled loop - no arrays!
-	t1 := leftBounds.X  //(l.Bounds[0] - ray.Origin.X) * inverseDirection.X
-	t2 := rightBounds.X // (l.Bounds[3] - ray.Origin.X) * inverseDirection.X
-	if t1 > t2 {
-		t1, t2 = t2, t1
-	}
+func (c *TraversalCost) Add(other TraversalCost) {
+	c.Nodes += other.Nodes
+	c.Tests += other.Tests
+}
 
-	tMin := t1
-	tMax := t2
-	// inverseDirectionY := 1.0 / ray.Direction.Y
-	t1 = leftBounds.Y  //(l.Bounds[1] - ray.Origin.Y) * inverseDirection.Y
-	t2 = rightBounds.Y //(l.Bounds[4] - ray.Origin.Y) * inverseDirection.Y
-	if t1 > t2 {
-		t1, t2 = t2, t1
+func newRayQuery(ray Ray) rayQuery {
+	inverse := ray.Direction.Inverse()
+	return rayQuery{
+		Origin:           ray.Origin,
+		Direction:        ray.Direction,
+		InverseDirection: inverse,
+		Negative:         [3]bool{inverse.X < 0, inverse.Y < 0, inverse.Z < 0},
+		shear:            newRayShear(ray.Direction),
 	}
-	tMin = max(tMin, t1)
-	tMax = min(tMax, t2)
-
-	// if tMin > tMax {
-	// 	return maxfloat32
-	// }
+}
 
-	// inverseDirectionZ := 1.0 / ray.Direction.Z
-	t1 = leftBounds.Z  // (l.Bounds[2] - ray.Origin.Z) * inverseDirection.Z
-	t2 = rightBounds.Z // (l.Bounds[5] - ray.Origin.Z) * inverseDirection.Z
-	if t1 > t2 {
-		t1, t2 = t2, t1
+// intersectAABB returns the distance at which the ray enters the box,
+// zero if it starts inside, or INF if it misses it within stepSize.
+func (l *LinearBVHNode) intersectAABB(q *rayQuery, stepSize float32) float32 {
+	nearX, farX := l.MinBounds.X, l.MaxBounds.X
+	if q.Negative[0] {
+		nearX, farX = farX, nearX
+	}
+	nearY, farY := l.MinBounds.Y, l.MaxBounds.Y
+	if q.Negative[1] {
+		nearY, farY = farY, nearY
+	}
+	nearZ, farZ := l.MinBounds.Z, l.MaxBounds.Z
+	if q.Negative[2] {
+		nearZ, farZ = farZ, nearZ
 	}
 
-	tMin = max(tMin, t1)
-	tMax = min(tMax, t2)
+	tMin := max(
+		(nearX-q.Origin.X)*q.InverseDirection.X,
+		(nearY-q.Origin.Y)*q.InverseDirection.Y,
+		(nearZ-q.Origin.Z)*q.InverseDirection.Z,
+	)
+	tMax := min(
+		(farX-q.Origin.X)*q.InverseDirection.X,
+		(farY-q.Origin.Y)*q.InverseDirection.Y,
+		(farZ-q.Origin.Z)*q.InverseDirection.Z,
+	)
 	if tMin > min(tMax, stepSize) || tMax < 0 {
 		return INF
 	}
+	return max(0, tMin)
+}
 
-	// // Clamp to stepSize
-	// if tMin > stepSize {
-	// 	return INF
-	// }
+func (l *LinearBVHNode) Area() float32 {
+	d := l.MaxBounds.Sub(l.MinBounds)
+	return 2 * (d.X*d.Y + d.Y*d.Z + d.Z*d.X)
+}
 
-	return max(0, tMin)
+// TriangleCorners is a triangle as the intersection tests want it. The
+// corners are kept rather than edges, so triangles sharing an edge compute
+// it from the same values.
+type TriangleCorners struct {
+	A, B, C Vec3
 }
 
 type LinearBVH struct {
 	Nodes     []LinearBVHNode
 	Triangles []*BVHTriangle
-}
 
-func convert(root *Box, obj *LinearBVH) {
-	node := new(LinearBVHNode)
-	node.IsLeaf = root.IsLeaf
-	// node.Bounds[0] = root.X1
-	// node.Bounds[1] = root.Y1
-	// node.Bounds[2] = root.Z1
+	// Corners holds the triangles of Triangles in the same order, so
+	// leaves are tested from one contiguous array. It is nil if the tree
+	// holds primitives, which are tested through their entries.
+	Corners []TriangleCorners
 
-	// node.Bounds[3] = root.X2
-	// node.Bounds[4] = root.Y2
-	// node.Bounds[5] = root.Z2
+	// Unbounded entries, such as infinite planes, do not fit in the tree
+	// and are tested against every ray.
+	Unbounded []*BVHTriangle
 
-	node.MinBounds = Vec3{X: root.X1, Y: root.Y1, Z: root.Z1}
-	node.MaxBounds = Vec3{X: root.X2, Y: root.Y2, Z: root.Z2}
+	// Wide, if set, is traversed instead of Nodes; see Collapse.
+	Wide *WideBVH
+}
 
+// Every inner node of the trees BuildBVH makes has two children.
+func convert(root *Box, obj *LinearBVH) {
+	node := LinearBVHNode{
+		MinBounds: Vec3{X: root.X1, Y: root.Y1, Z: root.Z1},
+		MaxBounds: Vec3{X: root.X2, Y: root.Y2, Z: root.Z2},
+		Count:     innerNode,
+	}
 	if root.IsLeaf {
-		node.TriangleOffset = uint32(len(obj.Triangles))
+		node.Offset = uint32(len(obj.Triangles))
 		obj.Triangles = append(obj.Triangles, root.Trianges...)
-		node.TriangleCount = uint32(len(root.Trianges))
+		node.Count = uint32(len(root.Trianges))
 	}
 
 	ptr := len(obj.Nodes)
-	obj.Nodes = append(obj.Nodes, *node)
+	obj.Nodes = append(obj.Nodes, node)
 	if !root.IsLeaf {
 		convert(root.Children[0], obj)
-		if len(root.Children) == 2 {
-			obj.Nodes[ptr].SecondChildOffset = uint32(len(obj.Nodes))
-			convert(root.Children[1], obj)
-		}
+		obj.Nodes[ptr].Offset = uint32(len(obj.Nodes))
+		convert(root.Children[1], obj)
 	}
 }
 
 func ConstructLinearBVH(root *Box) *LinearBVH {
 	node := new(LinearBVH)
 	convert(root, node)
+	node.buildCorners()
 	return node
 }
 
+// buildCorners fills Corners from Triangles, unless there are primitives.
+func (box *LinearBVH) buildCorners() {
+	box.Corners = nil
+	corners := make([]TriangleCorners, len(box.Triangles))
+	for i, tri := range box.Triangles {
+		if tri.Primitive != nil {
+			return
+		}
+		corners[i] = TriangleCorners{A: tri.A, B: tri.B, C: tri.C}
+	}
+	box.Corners = corners
+}
+
 // ----------------------------------------------------------------------
 
 func (box *LinearBVH) CheckIntersection(ray Ray, stepSize float32) (bool, float32, *BVHTriangle) {
-	stack := make([]uint32, 64)
-	stack[0] = 0
-	nptr := 1
+	q := newRayQuery(ray)
+	return box.closestHit(&q, stepSize)
+}
 
-	best_t := stepSize
-	var best_tri *BVHTriangle = nil
+func (box *LinearBVH) closestHit(q *rayQuery, stepSize float32) (bool, float32, *BVHTriangle) {
+	h := nearestHit{t: stepSize, rank: noHit}
+	box.nearest(q, 0, &h)
+	if h.rank == noHit {
+		return false, 0, nil
+	}
+	return true, h.t, box.entry(h.rank)
+}
 
-	for nptr > 0 {
-		ptr := uint32(stack[nptr-1])
-		node := box.Nodes[ptr]
-		nptr--
+// nearestHit is the closest hit of a ray so far. Hits at the same distance
+// are told apart by rank, so the hit found does not depend on the order
+// the trees are traversed in, which differs between single rays and
+// packets.
+type nearestHit struct {
+	t    float32
+	rank uint64
+}
 
-		if node.IsLeaf {
-			for i := 0; i < int(node.TriangleCount); i++ {
-				tri := box.Triangles[node.TriangleOffset+uint32(i)]
-				intersects, t := IntersectSegmentTriangle(ray.Origin, ray.Direction, best_t, tri.A, tri.B, tri.C)
-				if intersects && t < best_t && t > 0 { // Make sure t > 0 (in front of ray)
-					best_t = t
-					best_tri = tri
-				}
-			}
-		} else {
-			firstChildDistance := box.Nodes[ptr+1].intersectAABB(ray, best_t)
-			if node.SecondChildOffset == 0 { // Only one child!
-				if firstChildDistance < best_t {
-					stack[nptr] = ptr + 1
-					nptr++
-				}
-			} else { // Two children
-				secondChildDistance := box.Nodes[node.SecondChildOffset].intersectAABB(ray, best_t)
-				i, j := ptr+1, node.SecondChildOffset
-				if firstChildDistance > secondChildDistance {
-					i, j = j, i
-					firstChildDistance, secondChildDistance = secondChildDistance, firstChildDistance
-				}
-				if firstChildDistance == INF {
-					continue
-				}
-
-				if secondChildDistance < best_t {
-					stack[nptr] = j
-					nptr++
-				}
-				stack[nptr] = i
-				nptr++
-			}
-		}
+// noHit is the rank of a nearestHit without a hit; it loses every tie.
+const noHit = math.MaxUint64
+
+// hitRank ranks an entry of the BVH in slot: slot 0 is the primitives and
+// the instances follow in the order of TopLevelBVH.Instances. The
+// unbounded entries of a BVH come before those in its tree.
+func hitRank(slot, entry int) uint64 {
+	return uint64(slot)<<32 | uint64(entry)
+}
+
+// update records the hit if it is closer than h, or as close and of a
+// lower rank.
+func (h *nearestHit) update(t float32, rank uint64) {
+	if t < h.t || t == h.t && rank < h.rank {
+		h.t, h.rank = t, rank
 	}
+}
 
-	if best_tri == nil {
-		return false, 0, nil
+// entry returns the entry of box that rank refers to.
+func (box *LinearBVH) entry(rank uint64) *BVHTriangle {
+	entry := int(rank & math.MaxUint32)
+	if entry < len(box.Unbounded) {
+		return box.Unbounded[entry]
 	}
-	return true, best_t, best_tri
+	return box.Triangles[entry-len(box.Unbounded)]
 }
 
-// Möller-Trumbore without barycentric coords
-func FastIntersectShadowTriangle(origin, dir Vec3, tmax float32, v0, v1, v2 Vec3) bool {
-	edge1 := v1.Sub(v0)
-	edge2 := v2.Sub(v0)
-	h := dir.Cross(edge2)
-	a := edge1.X*h.X + edge1.Y*h.Y + edge1.Z*h.Z
+// nearest records in h any hit on the entries of box, the BVH in slot,
+// closer than h.
+func (box *LinearBVH) nearest(q *rayQuery, slot int, h *nearestHit) {
+	box.nearestUnbounded(q, slot, h)
+	base := hitRank(slot, len(box.Unbounded))
+	if box.Wide != nil {
+		box.Wide.nearest(box, q, base, h)
+	} else if len(box.Nodes) > 0 {
+		box.nearestEntry(q, base, h)
+	}
+}
 
-	if math32.Abs(a) < 0.00001 {
-		return false
+func (box *LinearBVH) nearestUnbounded(q *rayQuery, slot int, h *nearestHit) {
+	ray := Ray{Origin: q.Origin, Direction: q.Direction}
+	q.cost.Tests += int32(len(box.Unbounded))
+	for i, tri := range box.Unbounded {
+		if intersects, t := tri.Intersect(ray, h.t); intersects {
+			h.update(t, hitRank(slot, i))
+		}
 	}
+}
 
-	// f := 1.0 / a
-	s := origin.Sub(v0)
-	u := (s.X*h.X + s.Y*h.Y + s.Z*h.Z) / a
+// nearestEntry records hits on the entries of the tree, whose ranks start
+// at base.
+func (box *LinearBVH) nearestEntry(q *rayQuery, base uint64, h *nearestHit) {
+	var stack [64]uint32
+	stack[0] = 0
+	nptr := 1
 
-	if u < 0.0 || u > 1.0 {
-		return false
+	for nptr > 0 {
+		ptr := stack[nptr-1]
+		node := &box.Nodes[ptr]
+		nptr--
+		q.cost.Nodes++
+
+		if node.IsLeaf() {
+			box.intersectLeaf(q, node.Offset, node.Count, base, h)
+			continue
+		}
+
+		firstChildDistance := box.Nodes[ptr+1].intersectAABB(q, h.t)
+		secondChildDistance := box.Nodes[node.Offset].intersectAABB(q, h.t)
+		i, j := ptr+1, node.Offset
+		if firstChildDistance > secondChildDistance {
+			i, j = j, i
+			firstChildDistance, secondChildDistance = secondChildDistance, firstChildDistance
+		}
+		if firstChildDistance == INF {
+			continue
+		}
+		// A child entered at exactly h.t may still hold a hit of lower
+		// rank.
+		if secondChildDistance != INF {
+			stack[nptr] = j
+			nptr++
+		}
+		stack[nptr] = i
+		nptr++
 	}
+}
 
-	q := s.Cross(edge1)
-	v := (dir.X*q.X + dir.Y*q.Y + dir.Z*q.Z) / a
+// intersectLeaf tests the count entries from offset, whose ranks start at
+// base.
+func (box *LinearBVH) intersectLeaf(q *rayQuery, offset, count uint32, base uint64, h *nearestHit) {
+	q.cost.Tests += int32(count)
+	for i := offset; i < offset+count; i++ {
+		var intersects bool
+		var t float32
+		if box.Corners != nil {
+			c := &box.Corners[i]
+			intersects, t = intersectWatertight(q.Origin, &q.shear, h.t, c.A, c.B, c.C)
+		} else {
+			intersects, t = box.Triangles[i].Intersect(Ray{Origin: q.Origin, Direction: q.Direction}, h.t)
+		}
+		if intersects {
+			h.update(t, base+uint64(i))
+		}
+	}
+}
 
-	if v < 0.0 || u+v > 1.0 {
-		return false
+func (box *LinearBVH) occludesLeaf(q *rayQuery, offset, count uint32, stepSize float32) bool {
+	for i := offset; i < offset+count; i++ {
+		if box.Corners != nil {
+			c := &box.Corners[i]
+			if hit, t := intersectWatertight(q.Origin, &q.shear, stepSize, c.A, c.B, c.C); hit && t < stepSize {
+				return true
+			}
+		} else if box.Triangles[i].Occludes(Ray{Origin: q.Origin, Direction: q.Direction}, stepSize) {
+			return true
+		}
 	}
+	return false
+}
 
-	t := (edge2.X*q.X + edge2.Y*q.Y + edge2.Z*q.Z) / a
-	return t > 0.00001 && t < tmax
+func (box *LinearBVH) occludesUnbounded(q *rayQuery, stepSize float32) bool {
+	ray := Ray{Origin: q.Origin, Direction: q.Direction}
+	for _, tri := range box.Unbounded {
+		if tri.Occludes(ray, stepSize) {
+			return true
+		}
+	}
+	return false
 }
 
 func (box *LinearBVH) QuickCheckIntersection(ray Ray, stepSize float32) bool {
+	q := newRayQuery(ray)
+	return box.occludes(&q, stepSize)
+}
+
+func (box *LinearBVH) occludes(q *rayQuery, stepSize float32) bool {
+	if box.occludesUnbounded(q, stepSize) {
+		return true
+	}
+	if box.Wide != nil {
+		return box.Wide.occludes(box, q, stepSize)
+	}
+	if len(box.Nodes) == 0 {
+		return false
+	}
+
 	var stack [64]uint32
 	stack[0] = 0
 	nptr := 1
 
 	for nptr > 0 {
-		ptr := uint32(stack[nptr-1])
-		node := box.Nodes[ptr]
+		ptr := stack[nptr-1]
+		node := &box.Nodes[ptr]
 		nptr--
 
-		if node.IsLeaf {
-			for i := 0; i < int(node.TriangleCount); i++ {
-				tri := box.Triangles[node.TriangleOffset+uint32(i)]
-				intersects := FastIntersectShadowTriangle(ray.Origin, ray.Direction, stepSize, tri.A, tri.B, tri.C)
-				if intersects {
-					return true
-				}
+		if node.IsLeaf() {
+			if box.occludesLeaf(q, node.Offset, node.Count, stepSize) {
+				return true
 			}
-		} else {
-			// Test both children
-			dist1 := box.Nodes[ptr+1].intersectAABB(ray, stepSize)
-			dist2 := box.Nodes[node.SecondChildOffset].intersectAABB(ray, stepSize)
-
-			// Push to stack in distance order (far to near)
-			if dist1 < stepSize && dist2 < stepSize {
-				// Both hit - push furthest first
-				if dist1 < dist2 {
-					stack[nptr] = node.SecondChildOffset
-					nptr++
-					stack[nptr] = ptr + 1
-					nptr++
-				} else {
-					stack[nptr] = ptr + 1
-					nptr++
-					stack[nptr] = node.SecondChildOffset
-					nptr++
-				}
-			} else if dist1 < stepSize {
+			continue
+		}
+
+		// Test both children
+		dist1 := box.Nodes[ptr+1].intersectAABB(q, stepSize)
+		dist2 := box.Nodes[node.Offset].intersectAABB(q, stepSize)
+
+		// Push to stack in distance order (far to near)
+		if dist1 != INF && dist2 != INF {
+			// Both hit - push furthest first
+			if dist1 < dist2 {
+				stack[nptr] = node.Offset
+				nptr++
+				stack[nptr] = ptr + 1
+				nptr++
+			} else {
 				stack[nptr] = ptr + 1
 				nptr++
-			} else if dist2 < stepSize {
-				stack[nptr] = node.SecondChildOffset
+				stack[nptr] = node.Offset
 				nptr++
 			}
+		} else if dist1 != INF {
+			stack[nptr] = ptr + 1
+			nptr++
+		} else if dist2 != INF {
+			stack[nptr] = node.Offset
+			nptr++
 		}
 	}
 
@@ -411,262 +411,104 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"log"
 	"math"
-	"math/rand"
 	"os"
 	"runtime/pprof"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
-	"github.com/aquilax/go-perlin"
 	"github.com/chewxy/math32"
 )
 
 var threadCount = 16 // Number of goroutines to use for rendering
 
 func main() {
-	a := app.New()
-	w := a.NewWindow("Path Tracer")
-
-	width := 512
-	height := 512
-
-	showStats := true
-
-	bounces := 2
-	samplesPerPixel := 32
-	maxSamplesPerPixel := 32
-	scatterRays := 1
-	ambient := float32(0.0)
-	maxSteps := 3000
-	stepSize := float32(1.0)
-
-	splitsX := 4
-	splitsY := 4
-	splitSizeX := float32(width / splitsX)
-	splitSizeY := float32(height / splitsY)
-
-	samples := atomic.Int64{}
-	totalSamples := width * height * maxSamplesPerPixel
-
-	pixelBuffer := make([][]Pixel, height)
-	for i := range pixelBuffer {
-		pixelBuffer[i] = make([]Pixel, width)
-		for j := range pixelBuffer[i] {
-			pixelBuffer[i][j] = Pixel{
-				X: uint32(j),
-				Y: uint32(i),
-			}
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"render":  runHeadless,
+			"animate": runAnimate,
 		}
-	}
-	tiles := make([]*Tile, threadCount)
-	for i := range threadCount {
-		x := (i % splitsX) * int(splitSizeX)
-		y := (i / splitsX) * int(splitSizeY)
-
-		pixels := make([]*Pixel, 0)
-		for h := range int(math32.Floor(splitSizeY)) {
-			for w := range int(math32.Floor(splitSizeX)) {
-				pixels = append(pixels, &pixelBuffer[y+h][w+x])
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
 			}
-		}
-
-		tiles[i] = &Tile{
-			X:      0,
-			Y:      0,
-			Width:  uint32(width),
-			Height: uint32(height),
-			Pixels: pixels,
+			return
 		}
 	}
 
-	// ---------------------------- SPONZA ---------------------------------
-	sponzaScene := Scene{}
-	sponzaScene.Camera = &Camera{
-		Position:         Vec3{X: -3.2, Y: 0.5, Z: 21},
-		Forward:          Vec3{X: 0, Y: 0, Z: -1}.Normalize(),
-		Right:            Vec3{X: 1, Y: 0, Z: 0},
-		Up:               Vec3{X: 0, Y: -1, Z: 0},
-		FrustrumDistance: 2,
+	if err := runViewer(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	sponzaScene.Camera.ApplyRotation((170.0)*0.0174533, (165.0)*0.0174533)
-	sponzaMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\sponza.obj", 1.5)
-	// sponzaMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\SponzaDebug.obj", 1.5)
-	sponzaScene.Meshes = append(sponzaScene.Meshes, &GameObject[any]{
-		Position: Vec3{Z: 0},
-		Mesh:     sponzaMesh,
-	})
-	sponzaScene.Lights = append(
-		sponzaScene.Lights,
-		&GameObject[Light]{
-			Object: &Sun{
-				Direction: Vec3{X: 0.1, Y: 1, Z: 0.1}.Normalize(),
-				Intensity: 3,
-				Color:     Vec3{}.Ones(),
-			},
-		},
-	)
-	sponzaScene.Skybox = &GradientSkybox{
-		GroundColor:  Vec3{X: 76, Y: 76, Z: 76}.Scale(1.0 / 255),
-		HorizonColor: Vec3{X: 200, Y: 230, Z: 255}.Scale(1.0 / 255),
-		ZenithColor:  Vec3{X: 50, Y: 120, Z: 255}.Scale(1.0 / 255),
-		Intensity:    4,
+}
+
+func runViewer(args []string) error {
+	flags := flag.NewFlagSet("viewer", flag.ContinueOnError)
+	scenePath := flags.String("scene", "scenes/empty.json", "scene file, .gltf or .glb to open")
+	toneMapper := flags.String("tonemap", DefaultToneMapping().Operator.Name(), "initial tone mapper ("+strings.Join(ToneMapperNames, ", ")+")")
+	exposure := flags.Float64("exposure", 0, "initial exposure adjustment in stops")
+	aovName := flags.String("aov", "beauty", "initially displayed AOV ("+strings.Join(AOVNames, ", ")+")")
+	bvhBins := flags.Int("bvh-bins", DefaultBVHOptions().Bins, "candidate BVH split bins per axis")
+	bvhWidth := flags.Int("bvh-width", DefaultBVHOptions().Width, "children per traversed BVH node (2, 4 or 8)")
+	bvhSpatial := flags.Float64("bvh-spatial", 0, "extra BVH references spatial splits may add, as a fraction of the triangles (0 disables them)")
+	bvhRebuild := flags.Float64("bvh-rebuild", float64(DefaultBVHOptions().RebuildThreshold), "growth of the SAH cost of the top-level BVH, refitted as objects move, at which it is rebuilt (0 to only refit)")
+	cacheDir := flags.String("bvh-cache", DefaultMeshCacheDir(), "directory caching meshes and their BVHs (empty to disable)")
+	bvhStats := flags.Bool("bvh-stats", false, "print the shape, SAH cost, EPO and memory of the BVHs")
+	packets := flags.Bool("packets", DefaultRenderSettings().Packets, "trace camera and sun shadow rays in packets")
+	if err := flags.Parse(args); err != nil {
+		return err
 	}
-	// sponzaScene.Lights = append(sponzaScene.Lights, &GameObject[Light]{
-	// 	Object: &Sun{
-	// 		Direction: Vec3{X: 0.1, Y: 1, Z: 0.1}.Normalize(),
-	// 		Intensity: 1,
-	// 		Color:     Vec3{Z: 1},
-	// 	},
-	// })
-
-	// ---------------------------- CORNELL SPHERE ---------------------------------
-	cornellSphereScene := Scene{}
-	cornellSphereScene.Camera = &Camera{
-		Position:         Vec3{X: 0, Y: 0.7, Z: -2.2},
-		Forward:          Vec3{X: 0, Y: 0, Z: -1}.Normalize(),
-		Right:            Vec3{X: 1, Y: 0, Z: 0},
-		Up:               Vec3{X: 0, Y: -1, Z: 0},
-		FrustrumDistance: 2,
+	operator, err := ParseToneMapper(*toneMapper)
+	if err != nil {
+		return err
 	}
-	cornellSphereScene.Camera.ApplyRotation(0.0*0.0174533, 180.0*0.0174533)
-	cornellMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\CornellSphere.obj", 1)
-	cornellSphereScene.Meshes = append(cornellSphereScene.Meshes, &GameObject[any]{
-		Position: Vec3{Z: 0},
-		Mesh:     cornellMesh,
-	})
-	cornellSphereScene.Skybox = &SolidColorSkybox{
-		Color: Vec3{}.Scale(0.2),
+	displayAOV, err := ParseAOV(*aovName)
+	if err != nil {
+		return err
 	}
-	cornellSphereScene.BlackHoles = append(cornellSphereScene.BlackHoles, &BlackHole{
-		Position: Vec3{X: 0.1, Y: 0.5, Z: 0},
-		Rs:       0.05,
-	})
 
-	// // ---------------------------- Refractions ---------------------------------
-	// refractionsScene := Scene{}
-	// refractionsScene.Camera = &Camera{
-	// 	Position:         Vec3{X: 0, Y: 2, Z: 3},
-	// 	Forward:          Vec3{X: 0, Y: 0, Z: 1}.Normalize(),
-	// 	Right:            Vec3{X: -1, Y: 0, Z: 0},
-	// 	Up:               Vec3{X: 0, Y: -1, Z: 0},
-	// 	FrustrumDistance: 2,
-	// }
-	// refractionsScene.Camera.ApplyRotation(0.0*0.0174533, 180.0*0.0174533)
-	// refractionMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\Refrac\\Transparents.obj", 1)
-	// refractionsScene.Meshes = append(refractionsScene.Meshes, &GameObject[any]{
-	// 	Position: Vec3{Z: 0},
-	// 	Mesh:     refractionMesh,
-	// })
-	// refractionsScene.Skybox = &GradientSkybox{
-	// 	GroundColor:  Vec3{X: 76, Y: 76, Z: 76}.Scale(1.0 / 255),
-	// 	HorizonColor: Vec3{X: 200, Y: 230, Z: 255}.Scale(1.0 / 255),
-	// 	ZenithColor:  Vec3{X: 50, Y: 120, Z: 255}.Scale(1.0 / 255),
-	// 	Intensity:    1,
-	// }
-	// refractionsScene.Lights = append(refractionsScene.Lights, &GameObject[Light]{
-	// 	Object: &Sun{
-	// 		Direction: Vec3{Y: 1, Z: .3},
-	// 		Intensity: 1,
-	// 		Color:     Vec3{}.Ones(),
-	// 	},
-	// })
-
-	// ---------------------------- Chai ---------------------------------
-	chaiScene := Scene{}
-	chaiScene.Camera = &Camera{
-		Position:         Vec3{X: 0, Y: 15, Z: 15},
-		Forward:          Vec3{X: 0, Y: 0, Z: 1}.Normalize(),
-		Right:            Vec3{X: -1, Y: 0, Z: 0},
-		Up:               Vec3{X: 0, Y: -1, Z: 0},
-		FrustrumDistance: 2,
-	}
-	chaiScene.Camera.ApplyRotation(0.0*0.0174533, 220.0*0.0174533)
-	chaiMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\Pick.obj", 1)
-	chaiScene.Meshes = append(chaiScene.Meshes, &GameObject[any]{
-		Position: Vec3{Z: 0},
-		Mesh:     chaiMesh,
-	})
-	chaiScene.Skybox = &SolidColorSkybox{
-		Color: Vec3{},
+	settings := DefaultRenderSettings()
+	settings.ToneMapping = ToneMapping{Operator: operator, Exposure: float32(*exposure)}
+	settings.BVH.Bins = *bvhBins
+	settings.BVH.Width = *bvhWidth
+	settings.BVH.SpatialSplitBudget = float32(*bvhSpatial)
+	settings.BVH.RebuildThreshold = float32(*bvhRebuild)
+	settings.Packets = *packets
+	if err := settings.BVH.Validate(); err != nil {
+		return err
 	}
 
-	// ---------------------------- Glasses ---------------------------------
-	glassesScene := Scene{}
-	glassesScene.Camera = &Camera{
-		Position:         Vec3{X: 0, Y: 2, Z: 3},
-		Forward:          Vec3{X: 0, Y: 0, Z: 1}.Normalize(),
-		Right:            Vec3{X: -1, Y: 0, Z: 0},
-		Up:               Vec3{X: 0, Y: -1, Z: 0},
-		FrustrumDistance: 2,
-	}
-	glassesScene.Camera.ApplyRotation(0.0*0.0174533, 180.0*0.0174533)
-	// glassesMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\Marble.obj", 1)
-	glassesMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\Pick2.obj", 1)
-	glassesScene.Meshes = append(glassesScene.Meshes, &GameObject[any]{
-		Position: Vec3{Z: 0},
-		Mesh:     glassesMesh,
-	})
-	glassesScene.Skybox = &SolidColorSkybox{
-		Color: Vec3{},
+	scene, err := LoadSceneFile(*scenePath, &MeshCache{Dir: *cacheDir, Options: settings.BVH})
+	if err != nil {
+		return err
 	}
+	width, height := settings.Width, settings.Height
+	showStats := true
 
-	// ----------------------------------------------- Empty Scene ---------------------------------------------
-	var theta float32 = 90.0 * 0.0174533
-	var phi float32 = 83.0 * 0.0174533
-	emptyScene := Scene{}
-	emptyScene.Camera = &Camera{
-		Position:         Vec3{X: 0, Y: 100, Z: 1500},
-		Forward:          Vec3{X: 0, Y: 0, Z: 1}.Normalize(),
-		Right:            Vec3{X: -1, Y: 0, Z: 0},
-		Up:               Vec3{X: 0, Y: -1, Z: 0},
-		FrustrumDistance: 2,
+	renderer, err := NewRenderer(scene, settings)
+	if err != nil {
+		return err
+	}
+	renderer.SetDisplayAOV(displayAOV)
+	if *bvhStats {
+		fmt.Print(renderer.BVH.Stats(settings.BVH))
 	}
-	accretionMesh, _, _ := LoadObj("C:\\Users\\smpsm\\OneDrive\\Documents\\Accretion.obj", 170.0)
-	emptyScene.Meshes = append(emptyScene.Meshes, &GameObject[any]{
-		Position: Vec3{Z: 0},
-		Mesh:     accretionMesh,
-	})
-	// emptyScene.Camera.ApplyRotation(0.0*0.0174533, 185.0*0.0174533)
-	emptyScene.Camera.SphericalAround(Vec3{}, 1500, theta, phi)
-	emptyScene.Skybox = NewImageSkybox("C:\\Users\\smpsm\\Downloads\\HDR_subdued_blue_nebulae.png", 0.1)
-	// emptyScene.Skybox = &SolidColorSkybox{
-	// 	Color: Vec3{}.Ones().Scale(0.003),
-	// }
-	// emptyScene.Skybox = &GradientSkybox{
-	// 	GroundColor:  Vec3{X: 76, Y: 76, Z: 76}.Scale(1.0 / 255),
-	// 	HorizonColor: Vec3{X: 200, Y: 230, Z: 255}.Scale(1.0 / 255),
-	// 	ZenithColor:  Vec3{X: 50, Y: 120, Z: 255}.Scale(1.0 / 255),
-	// 	Intensity:    1,
-	// }
-	emptyScene.BlackHoles = append(emptyScene.BlackHoles, &BlackHole{
-		Position: Vec3{X: 0, Y: 0, Z: 0},
-		Rs:       100,
-		AccretionDisk: &AccretionDisk{
-			InnerRadius: 300,
-			OuterRadius: 450,
-			NoiseGen:    perlin.NewPerlin(2, 2, 4, 0),
-		},
-	})
-
-	// ----------------------------------------------- SCENE SELECTOR ---------------------------------------------
 
-	// scene := sponzaScene
-	// scene := cornellSphereScene
-	scene := emptyScene
-	// scene := refractionsScene
-	// scene := chaiScene
-	// scene := glassesScene
+	orbit := scene.Orbit
+	animation := scene.Animation
+	animationFrame := 0
 	camera := scene.Camera
 
 	var sunLight *Sun
@@ -676,6 +518,9 @@ func main() {
 		}
 	}
 
+	a := app.New()
+	w := a.NewWindow("Path Tracer")
+
 	// Set up the window
 	w.Resize(fyne.NewSize(float32(width), float32(height)))
 	w.SetFixedSize(true)
@@ -683,17 +528,42 @@ func main() {
 
 	// Initial black image
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			img.Set(x, y, color.RGBA{R: 0, G: 0, B: 0, A: 255})
+	clearImage := func() {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, color.RGBA{R: 0, G: 0, B: 0, A: 255})
+			}
 		}
 	}
+	clearImage()
 
-	dirty := true
+	dirty := false
 	dirtyMutex := &sync.Mutex{}
 
+	// The selected object and its depth in front of the camera. Moves are
+	// collected here and applied once the workers have stopped.
+	selected, selectedDepth := -1, float32(0)
+	moves := make(map[int]Vec3)
+
 	w.SetContent(canvas.NewImageFromImage(img))
 
+	// Click an object to select it and drag it to move it across the view.
+	pointer := newPointerLayer(func(x, y float32) {
+		dirtyMutex.Lock()
+		defer dirtyMutex.Unlock()
+		selected, selectedDepth = renderer.Pick(int(x), int(y))
+		if selected >= 0 {
+			fmt.Println("Selected object", selected, "at", scene.Meshes[selected].Position)
+		}
+	}, func(dx, dy float32) {
+		dirtyMutex.Lock()
+		defer dirtyMutex.Unlock()
+		if selected >= 0 {
+			moves[selected] = moves[selected].Add(renderer.ScreenOffset(dx, dy, selectedDepth))
+			dirty = true
+		}
+	})
+
 	// Add keystroke handling
 	w.Canvas().SetOnTypedKey(func(key *fyne.KeyEvent) {
 		dirtyMutex.Lock()
@@ -719,28 +589,56 @@ func main() {
 			camera.Position = camera.Position.Add(camera.Up.Scale(-0.1))
 			dirty = true
 
-		// case fyne.KeyI:
-		// 	rotX -= 0.01
-		// 	camera.SetRotationFromAngles(float32(rotY), float32(rotX))
-		// 	dirty = true
-		// case fyne.KeyK:
-		// 	rotX += 0.01
-		// 	camera.SetRotationFromAngles(float32(rotY), float32(rotX))
-		// 	dirty = true
 		case fyne.KeyJ:
-			theta -= 10 * 0.0174533
-			camera.SphericalAround(Vec3{}, 1500, float32(theta), float32(phi))
-			dirty = true
+			if orbit != nil {
+				orbit.Theta -= 10 * 0.0174533
+				orbit.Apply(camera)
+				dirty = true
+			}
 		case fyne.KeyL:
-			theta += 10 * 0.0174533
-			camera.SphericalAround(Vec3{}, 1500, float32(theta), float32(phi))
-			dirty = true
+			if orbit != nil {
+				orbit.Theta += 10 * 0.0174533
+				orbit.Apply(camera)
+				dirty = true
+			}
+
+		// Step through the camera animation.
+		case fyne.KeyN:
+			if animation != nil {
+				animationFrame = min(animationFrame+1, animation.FrameCount()-1)
+				animation.Apply(camera, animationFrame)
+				fmt.Println("Animation frame", animationFrame)
+				dirty = true
+			}
+		case fyne.KeyB:
+			if animation != nil {
+				animationFrame = max(animationFrame-1, 0)
+				animation.Apply(camera, animationFrame)
+				fmt.Println("Animation frame", animationFrame)
+				dirty = true
+			}
+
+		// Move the selected object towards and away from the camera.
+		case fyne.KeyI:
+			if selected >= 0 {
+				moves[selected] = moves[selected].Add(camera.Forward.Scale(0.1))
+				selectedDepth += 0.1
+				dirty = true
+			}
+		case fyne.KeyK:
+			if selected >= 0 {
+				moves[selected] = moves[selected].Add(camera.Forward.Scale(-0.1))
+				selectedDepth -= 0.1
+				dirty = true
+			}
+		case fyne.KeyEscape:
+			selected = -1
 
 		case fyne.KeyPageUp:
-			bounces++
+			renderer.Settings.Bounces++
 			dirty = true
 		case fyne.KeyPageDown:
-			bounces--
+			renderer.Settings.Bounces = max(0, renderer.Settings.Bounces-1)
 			dirty = true
 
 		case fyne.KeyUp:
@@ -764,193 +662,115 @@ func main() {
 				dirty = true
 			}
 
+		// Tone mapping only changes how the buffer is displayed, so the
+		// accumulated samples are kept.
+		case fyne.KeyT:
+			toneMapping := renderer.ToneMapping()
+			toneMapping.Operator = NextToneMapper(toneMapping.Operator)
+			renderer.SetToneMapping(toneMapping)
+			renderer.Draw(img)
+		case fyne.KeyLeftBracket:
+			toneMapping := renderer.ToneMapping()
+			toneMapping.Exposure -= 0.5
+			renderer.SetToneMapping(toneMapping)
+			renderer.Draw(img)
+		case fyne.KeyRightBracket:
+			toneMapping := renderer.ToneMapping()
+			toneMapping.Exposure += 0.5
+			renderer.SetToneMapping(toneMapping)
+			renderer.Draw(img)
+
+		// Cycle the displayed AOV; like tone mapping this keeps the samples.
+		case fyne.KeyV:
+			renderer.SetDisplayAOV(renderer.DisplayAOV().Next())
+			renderer.Draw(img)
+
 		case fyne.KeyH:
 			showStats = !showStats
 
 		case fyne.KeySpace:
-			f, err := os.Create("img.png")
-			if err != nil {
-				panic(err)
-			}
-			defer f.Close()
-			if err = png.Encode(f, img); err != nil {
-				log.Printf("failed to encode: %v", err)
+			if err := writePNG("img.png", img); err != nil {
+				log.Printf("failed to save image: %v", err)
 			}
 		}
 	})
 
 	w.Show()
 
-	vertices, tris, normals, materials, uvs, emissives := DecomposeObjects(scene.Meshes)
-	println(len(vertices), len(tris), len(normals))
-
-	vnmu := &VNMU{
-		Vertices:          vertices,
-		Normals:           normals,
-		Materials:         materials,
-		UVs:               uvs,
-		EmissiveTriangles: emissives,
-	}
-
-	fmt.Println("BVH Building...")
-
 	cpuFile, err := os.Create("cpu.prof")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	// defer cpuFile.Close()
-
-	bvhSt := time.Now()
-	bvhx := BuildBVH(vertices, tris, -1000, 1000, -1000, 1000, -1000, 1000, 4, 42)
-	bvhSSt := time.Since(bvhSt).Milliseconds()
-	// pprof.StopCPUProfile()
-
-	fmt.Println("BVH Built in", bvhSSt, "ms")
-	fmt.Println(bvhx.GetStats(1))
-	linearBVH := ConstructLinearBVH(bvhx)
-
-	startTime := time.Now()
-	// iteration := atomic.Int64{}
-	// tileIndex := atomic.Int64{}
-
-	// randomSampling := true
-
 	pprof.StartCPUProfile(cpuFile)
 	go func() {
 		time.Sleep(30 * time.Second)
 		pprof.StopCPUProfile()
+		cpuFile.Close()
 	}()
 
-	// Channel to signal render workers to stop
-	stopRendering := make(chan bool)
-	renderingActive := atomic.Bool{}
-	renderingActive.Store(true)
-
-	totalRaysTraced := 0
-
-	// Calculate function now runs continuously
-	calculate := func(doneCh chan bool, tileIndex int) {
-		defer func() {
-			doneCh <- true
-		}()
+	startTime := time.Now()
 
+	// Render controller: runs the workers until they converge or the view
+	// changes, then starts over with a clean buffer.
+	restart := make(chan struct{}, 1)
+	stopRendering := make(chan struct{})
+	go func() {
 		for {
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				renderer.Run(stop, func(pixel *Pixel) {
+					img.Set(renderer.ImageX(pixel.X), int(pixel.Y), renderer.PixelColor(pixel))
+				})
+				close(done)
+			}()
+
 			select {
 			case <-stopRendering:
+				close(stop)
+				<-done
 				return
-			default:
-				// if !renderingActive.Load() {
-				// 	time.Sleep(time.Millisecond)
-				// 	continue
-				// }
-
-				// pixel := tiles[tileIndex].GetLeastSampledPixel(maxSamplesPerPixel)
-				pixel := tiles[tileIndex].GetNoisiestPixel(maxSamplesPerPixel)
-				if pixel == nil {
-					fmt.Println("Tile", tileIndex, "completed rendering.")
+			case <-restart:
+				close(stop)
+				<-done
+			case <-done:
+				// Converged; wait for the view to change.
+				select {
+				case <-restart:
+				case <-stopRendering:
 					return
 				}
-				thisSamples := samplesPerPixel
-				// if pixel.SampleCount == 0 {
-				// 	thisSamples = samplesPerPixel
-				// }
-				// if pixel.SampleCount+thisSamples > maxSamplesPerPixel {
-				// 	thisSamples = maxSamplesPerPixel - pixel.SampleCount
-				// }
-
-				for range thisSamples {
-					rx := (float32(pixel.X) + rand.Float32()) / float32(width)
-					ry := (float32(pixel.Y) + rand.Float32()) / float32(height)
-
-					px := (rx - 0.5) * 2
-					py := (ry - 0.5) * 2
-					rayOrigin := camera.Position.Add(camera.Forward.Scale(camera.FrustrumDistance)).Add(camera.Up.Scale(py)).Add(camera.Right.Scale(px))
-					rayDirection := Vec3{
-						X: rayOrigin.X - camera.Position.X,
-						Y: rayOrigin.Y - camera.Position.Y,
-						Z: rayOrigin.Z - camera.Position.Z,
-					}.Normalize()
-
-					ray := NewRay(camera.Position, rayDirection)
-					rayColor := TraceRay(ray, stepSize, linearBVH, maxSteps, bounces, scatterRays, vnmu, ambient, &scene, 0, Vec3{}, false, NewRefractiveIndexTracker(1.0), 1.0)
-
-					// Accumulate color
-					pixel.AddSample(rayColor)
-					samples.Add(1)
-					if pixel.SampleCount > maxSamplesPerPixel {
-						break
-					}
-				}
-
-				// Calculate running average
-				avgR := pixel.R / float32(pixel.SampleCount)
-				avgG := pixel.G / float32(pixel.SampleCount)
-				avgB := pixel.B / float32(pixel.SampleCount)
-
-				avgColor := Vec3{
-					X: avgR,
-					Y: avgG,
-					Z: avgB,
-				}.ToRGBA()
-
-				img.Set(width-int(pixel.X), int(pixel.Y), avgColor)
-			}
-		}
-	}
-
-	// Start render workers that run continuously
-	doneCh := make(chan bool, threadCount)
-
-	go func() {
-		count := threadCount - 1
-		j := 0
-		for {
-			<-doneCh
-			count++
-
-			if count != threadCount {
-				continue
 			}
 
-			j++
-			f, err := os.Create(fmt.Sprintf("orbit/frame%d.png", j))
-			if err != nil {
-				panic(err)
-			}
-			if err = png.Encode(f, img); err != nil {
-				log.Printf("failed to encode: %v", err)
-				os.Exit(1)
+			dirtyMutex.Lock()
+			for object, offset := range moves {
+				if err := renderer.MoveObject(object, offset); err != nil {
+					log.Printf("failed to move object: %v", err)
+				}
 			}
-			f.Close()
-
-			theta += 1 * 0.0174533
-			count = 0
-			scene.Camera.SphericalAround(Vec3{}, 1500, theta, phi)
-			dirty = true
-			fmt.Println(">>", theta)
-
-			time.Sleep(time.Second)
-			fmt.Println("Starting render loop")
+			clear(moves)
+			dirtyMutex.Unlock()
 
-			for i := 0; i < threadCount; i++ {
-				go calculate(doneCh, i)
-			}
+			renderer.Reset()
+			clearImage()
+			startTime = time.Now()
+			raysTraced.Store(0)
+			recentRaysTraced.Store(0)
 		}
 	}()
-	// Start initial render
-	doneCh <- true
 
 	// Display update loop - runs at fixed 30 FPS
 	lastStatsUpdate := time.Now()
 	maxRaysSpeed := float32(0.0)
 	minRaysSpeed := float32(math32.MaxFloat32)
-	endTime := time.Now()
+	totalSamples := settings.TotalSamples()
 	go func() {
 		displayTicker := time.NewTicker(time.Second / 30) // 30 FPS
 		defer displayTicker.Stop()
 
 		for range displayTicker.C {
 			dirtyMutex.Lock()
+			selectedObject := selected
 			if dirty {
 				fmt.Println("!Dirty Checked!")
 				fmt.Println("Camera:")
@@ -958,46 +778,25 @@ func main() {
 				fmt.Println("  Fwd: ", camera.Forward)
 				fmt.Println("  Right: ", camera.Right)
 				fmt.Println("  Up: ", camera.Up)
-				// fmt.Println("Sun:")
-				// fmt.Println("  Dir: ", sunDirection)
 				fmt.Println("-----------------------------------")
 
-				// Pause rendering while we clear
-				renderingActive.Store(false)
-				time.Sleep(100 * time.Millisecond) // Give workers time to pause
-
 				// Clear stats
 				maxRaysSpeed = 0.0
 				minRaysSpeed = math.MaxFloat32
-				totalRaysTraced = 0
-				raysTraced.Store(0)
-				recentRaysTraced.Store(0)
-
-				// Clear image and pixel buffer
-				for y := 0; y < height; y++ {
-					for x := 0; x < width; x++ {
-						img.Set(x, y, color.RGBA{R: 0, G: 0, B: 0, A: 255})
-						pixelBuffer[y][x].Lock.Lock()
-						pixelBuffer[y][x].R, pixelBuffer[y][x].G, pixelBuffer[y][x].B = 0, 0, 0
-						pixelBuffer[y][x].SampleCount = 0
-						pixelBuffer[y][x].Lock.Unlock()
-					}
-				}
 
-				// Resume rendering
-				renderingActive.Store(true)
+				select {
+				case restart <- struct{}{}:
+				default:
+				}
 				dirty = false
 			}
 			dirtyMutex.Unlock()
 
 			// Update the display
 			fyne.Do(func() {
-				if renderingActive.Load() {
-					endTime = time.Now()
-				}
-
-				totalRaysTraced = int(raysTraced.Load())
-				dt := float32(endTime.Sub(startTime).Abs().Milliseconds())
+				samples := renderer.Samples.Load()
+				totalRaysTraced := raysTraced.Load()
+				dt := float32(time.Since(startTime).Milliseconds())
 				averageRaysSpeed := float32(totalRaysTraced*1000) / dt
 
 				newImage := canvas.NewImageFromImage(img)
@@ -1023,14 +822,14 @@ func main() {
 					text.Move(fyne.NewPos(5, 10))
 					container.Add(text)
 
-					completionText := canvas.NewText(fmt.Sprintf("%s/%s samples (%.1f%%)", Humanize(samples.Load()), Humanize(totalSamples), (float32(samples.Load()*100)/float32(totalSamples))), color.White)
+					completionText := canvas.NewText(fmt.Sprintf("%s/%s samples (%.1f%%)", Humanize(samples), Humanize(totalSamples), (float32(samples*100)/float32(totalSamples))), color.White)
 					completionText.TextSize = 10
 					completionText.Move(fyne.NewPos(5, 23))
 					container.Add(completionText)
 
 					secondsSinceStart := dt / 1000.0
-					samplingSpeed := max(1.0, float32(samples.Load())/float32(secondsSinceStart))
-					secondsToCompletion := totalSamples / int(samplingSpeed)
+					samplingSpeed := max(1.0, float32(samples)/float32(secondsSinceStart))
+					secondsToCompletion := int(float32(totalSamples) / samplingSpeed)
 					remainingSecs := secondsToCompletion % 60
 					remainingMinutes := secondsToCompletion / 60
 
@@ -1040,7 +839,24 @@ func main() {
 					timeElapsedText.TextSize = 10
 					timeElapsedText.Move(fyne.NewPos(5, 36))
 					container.Add(timeElapsedText)
+
+					toneMappingText := canvas.NewText(renderer.DisplayAOV().String()+", "+renderer.ToneMapping().String(), color.White)
+					toneMappingText.TextSize = 10
+					toneMappingText.Move(fyne.NewPos(5, 49))
+					container.Add(toneMappingText)
+
+					if selectedObject >= 0 {
+						selectedText := canvas.NewText(fmt.Sprintf("Object %d selected", selectedObject), color.White)
+						selectedText.TextSize = 10
+						selectedText.Move(fyne.NewPos(5, 62))
+						container.Add(selectedText)
+					}
+				}
+				if aov := renderer.DisplayAOV(); aov == AOVNodes || aov == AOVTests {
+					container.Add(heatmapLegend(aov, 5, float32(height)-40))
 				}
+				pointer.Resize(fyne.NewSize(float32(width), float32(height)))
+				container.Add(pointer)
 				w.SetContent(container)
 			})
 		}
@@ -1051,4 +867,17 @@ func main() {
 
 	// Clean up
 	close(stopRendering)
+	return nil
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
@@ -1,9 +1,18 @@
 package main
 
 type Mesh struct {
+	// Name is the OBJ object or group the mesh was loaded from, if any.
+	Name string
+
 	Vertices  []Vec3
 	Tris      []int
 	Normals   []Vec3
 	Materials []*Material
 	UVs       []float32
+
+	// BVH, if set, is a tree over the mesh's triangles alone, with Index
+	// counted from its first triangle, built with BVHOptions. MeshCache
+	// loads it with the mesh so the renderer need not build it again.
+	BVH        *LinearBVH
+	BVHOptions BVHOptions
 }
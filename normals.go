@@ -0,0 +1,66 @@
+package main
+
+import "github.com/chewxy/math32"
+
+// DefaultCreaseAngle, in degrees, lets smoothing groups alone decide which
+// edges are smooth.
+const DefaultCreaseAngle = 180
+
+// generateNormals sets the corner normals of the triangles of mesh listed
+// in missing. A triangle in smoothing group 0 is flat; in any other group
+// each corner gets the average of the normals of the triangles of that
+// group around its vertex, weighted by their angle at the vertex, leaving
+// out those turned more than creaseAngle degrees from the triangle's own.
+// groups holds the smoothing group of every triangle of mesh.
+func generateNormals(mesh *Mesh, groups []int, missing []int, creaseAngle float32) {
+	if len(missing) == 0 {
+		return
+	}
+
+	triangles := len(mesh.Tris) / 3
+	faceNormals := make([]Vec3, triangles)
+	angles := make([][3]float32, triangles)
+	around := make([][]int, len(mesh.Vertices))
+	for t := range triangles {
+		a, b, c := mesh.Vertices[mesh.Tris[3*t]], mesh.Vertices[mesh.Tris[3*t+1]], mesh.Vertices[mesh.Tris[3*t+2]]
+		faceNormals[t] = b.Sub(a).Cross(c.Sub(a)).Normalize()
+		angles[t] = [3]float32{cornerAngle(a, b, c), cornerAngle(b, c, a), cornerAngle(c, a, b)}
+		if groups[t] != 0 {
+			for k := range 3 {
+				v := mesh.Tris[3*t+k]
+				around[v] = append(around[v], t)
+			}
+		}
+	}
+
+	minCos := math32.Cos(creaseAngle * math32.Pi / 180)
+	for _, t := range missing {
+		for k := range 3 {
+			normal := faceNormals[t]
+			if groups[t] != 0 {
+				v := mesh.Tris[3*t+k]
+				var sum Vec3
+				for _, other := range around[v] {
+					if groups[other] != groups[t] || faceNormals[other].Dot(faceNormals[t]) < minCos {
+						continue
+					}
+					for j := range 3 {
+						if mesh.Tris[3*other+j] == v {
+							sum._Add(faceNormals[other].Scale(angles[other][j]))
+						}
+					}
+				}
+				if sum.Length() > 0 {
+					normal = sum.Normalize()
+				}
+			}
+			mesh.Normals[3*t+k] = normal
+		}
+	}
+}
+
+// cornerAngle is the angle of the triangle ABC at A, in radians.
+func cornerAngle(a, b, c Vec3) float32 {
+	u, v := b.Sub(a).Normalize(), c.Sub(a).Normalize()
+	return math32.Acos(max(-1, min(1, u.Dot(v))))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+// foldObj is two triangles sharing the edge from (0, 0, 0) to (1, 0, 0):
+// the first in the z = 0 plane, the second folded 30 degrees from it. The
+// smoothing statements before each face and their normals are filled in.
+const foldObj = `mtllib fold.mtl
+o Fold
+v 0 0 0
+v 1 0 0
+v 0 1 0
+v 0.5 -1 0.57735
+vn 0 0 1
+usemtl Grey
+%s
+f 1%s 2%s 3%s
+%s
+f 2 1 4
+`
+
+func loadFold(t *testing.T, first, second string, firstNormals bool, creaseAngle float32) *Mesh {
+	t.Helper()
+	dir := t.TempDir()
+	normal := ""
+	if firstNormals {
+		normal = "//1"
+	}
+	obj := fmt.Sprintf(foldObj, first, normal, normal, normal, second)
+	if err := os.WriteFile(filepath.Join(dir, "fold.obj"), []byte(obj), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fold.mtl"), []byte("newmtl Grey\nKd 0.5 0.5 0.5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	meshes, _, err := LoadObjMeshes(filepath.Join(dir, "fold.obj"), 1, creaseAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meshes) != 1 || len(meshes[0].Tris) != 6 {
+		t.Fatalf("loaded %d meshes, want one of two triangles", len(meshes))
+	}
+	return meshes[0]
+}
+
+func TestGeneratedNormals(t *testing.T) {
+	flat := Vec3{Z: 1}
+	folded := Vec3{Y: 0.57735, Z: 1}.Normalize()
+	// On the shared edge the first triangle has a 90 and a 45 degree
+	// corner, the second two of about 66.6 degrees, so the smooth normals
+	// there lean towards the face with the wider corner.
+	o, x, d := Vec3{}, Vec3{X: 1}, Vec3{X: 0.5, Y: -1, Z: 0.57735}
+	smooth := func(angleFirst, angleSecond float32) Vec3 {
+		return flat.Scale(angleFirst).Add(folded.Scale(angleSecond)).Normalize()
+	}
+	atOrigin := smooth(math32.Pi/2, cornerAngle(o, x, d))
+	atX := smooth(math32.Pi/4, cornerAngle(x, o, d))
+
+	tests := []struct {
+		name          string
+		first, second string
+		creaseAngle   float32
+		smoothed      bool
+	}{
+		{"s off", "s off", "s off", 180, false},
+		{"s 0", "s 0", "s 0", 180, false},
+		{"no s", "", "", 180, false},
+		{"one group", "s 1", "s 1", 180, true},
+		{"s on", "s on", "s on", 180, true},
+		{"two groups meet at a hard edge", "s 1", "s 2", 180, false},
+		{"one face flat", "s 1", "s off", 180, false},
+		{"fold sharper than the crease angle", "s 1", "s 1", 20, false},
+		{"fold within the crease angle", "s 1", "s 1", 40, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mesh := loadFold(t, test.first, test.second, false, test.creaseAngle)
+			for corner, normal := range mesh.Normals {
+				face, position := corner/3, mesh.Vertices[mesh.Tris[corner]]
+				want := flat
+				if face == 1 {
+					want = folded
+				}
+				if test.smoothed {
+					switch position {
+					case Vec3{}:
+						want = atOrigin
+					case Vec3{X: 1}:
+						want = atX
+					}
+				}
+				if !near(normal, want) {
+					t.Errorf("face %d at %v: normal %v, want %v", face, position, normal, want)
+				}
+			}
+		})
+	}
+}
+
+// Normals in the file are kept; only the faces without them get generated
+// ones, smoothed with their neighbours whether or not those had normals.
+func TestGeneratedNormalsKeepGivenOnes(t *testing.T) {
+	mesh := loadFold(t, "s 1", "s 1", true, 180)
+	for k := range 3 {
+		if !near(mesh.Normals[k], Vec3{Z: 1}) {
+			t.Errorf("given normal %d became %v", k, mesh.Normals[k])
+		}
+	}
+	folded := Vec3{Y: 0.57735, Z: 1}.Normalize()
+	for k := 3; k < 6; k++ {
+		position := mesh.Vertices[mesh.Tris[k]]
+		if on := position.Y == 0; on == near(mesh.Normals[k], folded) {
+			t.Errorf("generated normal at %v is %v; want it smoothed only on the shared edge", position, mesh.Normals[k])
+		}
+	}
+}
+
+func TestObjWarnings(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		want []string
+	}{
+		{"faces with normals", "o A\nv 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 1\nf 1//1 2//1 3//1\n", nil},
+		{
+			"faces without normals, once per object",
+			"o A\nv 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\nf 3 2 1\no B\nf 1 2 3\n",
+			[]string{`obj(5): faces of "A" without normals; they will be generated`, `obj(8): faces of "B" without normals; they will be generated`},
+		},
+		{
+			"smoothing group that is not a number",
+			"o A\nv 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 1\ns yes\nf 1//1 2//1 3//1\n",
+			[]string{`obj(6): smoothing group "yes" is not a number; using 1`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dec, err := DecodeReader(strings.NewReader(test.obj), strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(dec.Warnings, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("warnings %q, want %q", dec.Warnings, test.want)
+			}
+		})
+	}
+}
+
+func TestSmoothingGroups(t *testing.T) {
+	tests := []struct {
+		statement string
+		group     int
+	}{
+		{"s off", 0},
+		{"s 0", 0},
+		{"s on", 1},
+		{"s 1", 1},
+		{"s 7", 7},
+		{"s nonsense", 1},
+	}
+	for _, test := range tests {
+		obj := "o A\nv 0 0 0\nv 1 0 0\nv 0 1 0\n" + test.statement + "\nf 1 2 3\n"
+		dec, err := DecodeReader(strings.NewReader(obj), strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := dec.Objects[0].Faces[0].Group; got != test.group {
+			t.Errorf("%q: face in group %d, want %d", test.statement, got, test.group)
+		}
+	}
+}
@@ -40,6 +40,7 @@ type Decoder struct {
 	objCurrent    *Object              // current object
 	matCurrent    *Material            // current material
 	smoothCurrent bool                 // current smooth state
+	smoothGroup   int                  // current smoothing group, 0 if off
 	mtlDir        string               // Directory of material file
 }
 
@@ -48,6 +49,7 @@ type Object struct {
 	Name      string   // Object name
 	Faces     []Face   // Faces
 	materials []string // Materials used in this object
+	noNormals bool     // Some face has no normals (warned about)
 }
 
 // Face contains all information about an object face
@@ -57,6 +59,7 @@ type Face struct {
 	Normals  []int  // Indices to the face normals
 	Material string // Material name
 	Smooth   bool   // Smooth face
+	Group    int    // Smoothing group, 0 if not smooth
 }
 
 // Material contains all information about an object material
@@ -76,6 +79,11 @@ type Material struct {
 	HasImage     bool
 	DiffuseImage *CachedImage
 	BumpImage    *CachedImage
+
+	// NormalImage is a tangent-space normal map, with +Y up the image,
+	// whose X and Y are scaled by NormalScale.
+	NormalImage *CachedImage
+	NormalScale float32
 }
 
 // Light gray default material used as when other materials cannot be loaded.
@@ -594,6 +602,7 @@ func (dec *Decoder) parseFace(fields []string) error {
 		// dec.matCurrent = defaultMat
 	}
 	face.Smooth = dec.smoothCurrent
+	face.Group = dec.smoothGroup
 
 	for pos, f := range fields {
 
@@ -663,6 +672,10 @@ func (dec *Decoder) parseFace(fields []string) error {
 			}
 		} else {
 			face.Normals[pos] = invINDEX
+			if !dec.objCurrent.noNormals {
+				dec.objCurrent.noNormals = true
+				dec.appendWarn(objType, fmt.Sprintf("faces of %q without normals; they will be generated", dec.objCurrent.Name))
+			}
 		}
 	}
 	// Appends this face to the current object
@@ -699,7 +712,7 @@ func (dec *Decoder) parseUsemtl(fields []string) error {
 }
 
 // parseSmooth parses a "s" decription line:
-// s <0|1>
+// s <group|off|on>
 func (dec *Decoder) parseSmooth(fields []string) error {
 
 	if len(fields) < 1 {
@@ -708,9 +721,16 @@ func (dec *Decoder) parseSmooth(fields []string) error {
 
 	if fields[0] == "0" || fields[0] == "off" {
 		dec.smoothCurrent = false
+		dec.smoothGroup = 0
 		return nil
 	}
 	dec.smoothCurrent = true
+	dec.smoothGroup = 1
+	if group, err := strconv.Atoi(fields[0]); err == nil && group > 0 {
+		dec.smoothGroup = group
+	} else if fields[0] != "on" {
+		dec.appendWarn(objType, fmt.Sprintf("smoothing group %q is not a number; using 1", fields[0]))
+	}
 	return nil
 }
 
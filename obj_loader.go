@@ -16,55 +16,78 @@ func tile(val float32) float32 {
 	return vf
 }
 
-func LoadObj(path string, scaleFactor float32) (*Mesh, *Decoder, error) {
-	object, err := Decode(path, "")
+func loadTexture(path string) (CachedImage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return CachedImage{}, fmt.Errorf("loading texture: %w", err)
+	}
+	defer file.Close()
 
-	for _, m := range object.Warnings {
-		println(m)
+	imag, _, err := image.Decode(file)
+	if err != nil {
+		return CachedImage{}, fmt.Errorf("decoding texture %s: %w", path, err)
 	}
+	return CacheImage(imag), nil
+}
 
-	// Load images
-	for _, mat := range object.Materials {
-		imagList := []string{mat.MapBump, mat.MapKd}
-
-		for j, texPath := range imagList {
-			if texPath == "" {
-				continue
-			} else {
-				file, err := os.Open(texPath)
-				if err != nil {
-					fmt.Println("Error loading texture", texPath, ":", err)
-					os.Exit(1)
-				}
+// loadMaterialTextures loads the bump and diffuse maps of mat. Texture
+// paths in the MTL are relative to dir, the OBJ's directory.
+func loadMaterialTextures(mat *Material, dir string) error {
+	imagList := []string{mat.MapBump, mat.MapKd}
 
-				imag, _, err := image.Decode(file)
-				if err != nil {
-					fmt.Println("Error while decoding file")
-					fmt.Println(err)
-					os.Exit(1)
-				}
+	for j, texPath := range imagList {
+		if texPath == "" {
+			continue
+		}
+		texPath = resolvePath(dir, texPath)
+		cachedImage, err := loadTexture(texPath)
+		if err != nil {
+			return err
+		}
 
-				cachedImage := CacheImage(imag)
-				images[texPath] = cachedImage
-				if j == 0 {
-					mat.BumpImage = &cachedImage
-				} else {
-					mat.DiffuseImage = &cachedImage
-				}
-				mat.HasImage = true
-			}
+		images[texPath] = cachedImage
+		if j == 0 {
+			mat.BumpImage = &cachedImage
+		} else {
+			mat.DiffuseImage = &cachedImage
 		}
+		mat.HasImage = true
 	}
+	return nil
+}
 
+// LoadObj loads the OBJ at path as one mesh, with all its objects and
+// groups merged. Faces without normals get them generated, with hard edges
+// where smoothing groups change or faces meet at more than creaseAngle
+// degrees; see generateNormals.
+func LoadObj(path string, scaleFactor, creaseAngle float32) (*Mesh, *Decoder, error) {
+	meshes, object, err := LoadObjMeshes(path, scaleFactor, creaseAngle)
 	if err != nil {
 		return nil, nil, err
 	}
+	return MergeMeshes(meshes), object, nil
+}
 
-	vertices := make([]Vec3, 0)
-	tris := make([]int, 0)
-	normals := make([]Vec3, 0)
-	mats := make([]*Material, 0)
-	uvs := make([]float32, 0)
+// LoadObjMeshes loads every object and group of the OBJ at path as a mesh
+// named after it, in the order they first appear. Objects of the same
+// name, such as a group resumed further down the file, make one mesh;
+// objects without faces make none. Each mesh holds only the vertices its
+// faces use.
+func LoadObjMeshes(path string, scaleFactor, creaseAngle float32) ([]*Mesh, *Decoder, error) {
+	object, err := Decode(path, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading obj: %w", err)
+	}
+
+	for _, m := range object.Warnings {
+		println(m)
+	}
+
+	for _, mat := range object.Materials {
+		if err := loadMaterialTextures(mat, object.mtlDir); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	object_normals := make([]Vec3, 0)
 	for i := 0; i < len(object.Normals); i += 3 {
@@ -75,37 +98,96 @@ func LoadObj(path string, scaleFactor float32) (*Mesh, *Decoder, error) {
 		}.Normalize())
 	}
 
-	for i := 0; i < len(object.Vertices); i += 3 {
-		vertices = append(vertices, Vec3{X: float32(object.Vertices[i]) * float32(scaleFactor), Y: float32(object.Vertices[i+1]) * float32(scaleFactor), Z: float32(object.Vertices[i+2]) * float32(scaleFactor)})
-	}
+	var meshes []*Mesh
+	byName := make(map[string]*Mesh)
+	// The index of each OBJ vertex in the mesh being built, by mesh.
+	remaps := make(map[*Mesh]map[int]int)
+	// The smoothing group of every triangle and the triangles without
+	// normals, by mesh.
+	groups := make(map[*Mesh][]int)
+	missing := make(map[*Mesh][]int)
+	for _, obj := range object.Objects {
+		if len(obj.Faces) == 0 {
+			continue
+		}
+		mesh, ok := byName[obj.Name]
+		if !ok {
+			mesh = &Mesh{Name: obj.Name}
+			byName[obj.Name] = mesh
+			remaps[mesh] = make(map[int]int)
+			meshes = append(meshes, mesh)
+		}
+		remap := remaps[mesh]
 
-	for _, face := range object.Objects[0].Faces {
-		tris = append(tris, face.Vertices...)
-		mats = append(mats, object.Materials[face.Material])
-
-		if len(object.Uvs) > 0 {
-			us := make([]float32, 3)
-			vs := make([]float32, 3)
-			for i := range 3 {
-				uvIndex := face.Uvs[i]
-				u := (float32(object.Uvs[uvIndex*2]))         // X coordinate
-				v := 1.0 - (float32(object.Uvs[uvIndex*2+1])) // Y coordinate
-				us[i] = u
-				vs[i] = v
-				uvs = append(uvs, us[i], vs[i])
+		for _, face := range obj.Faces {
+			hasNormals := true
+			for _, n := range face.Normals {
+				hasNormals = hasNormals && n != invINDEX
+			}
+			corners := make([]Vec3, len(face.Vertices))
+			for i, v := range face.Vertices {
+				corners[i] = Vec3{X: float32(object.Vertices[3*v]), Y: float32(object.Vertices[3*v+1]), Z: float32(object.Vertices[3*v+2])}
+			}
+			for _, tri := range triangulate(corners) {
+				for _, i := range tri {
+					v := face.Vertices[i]
+					index, ok := remap[v]
+					if !ok {
+						index = len(mesh.Vertices)
+						remap[v] = index
+						mesh.Vertices = append(mesh.Vertices, corners[i].Scale(scaleFactor))
+					}
+					mesh.Tris = append(mesh.Tris, index)
+
+					if len(object.Uvs) > 0 {
+						// Corners without a vt get zeros, keeping UVs in step
+						// with Tris.
+						var u, v float32
+						if uvIndex := face.Uvs[i]; uvIndex != invINDEX {
+							u = (float32(object.Uvs[uvIndex*2]))         // X coordinate
+							v = 1.0 - (float32(object.Uvs[uvIndex*2+1])) // Y coordinate
+						}
+						mesh.UVs = append(mesh.UVs, u, v)
+					}
+					if hasNormals {
+						mesh.Normals = append(mesh.Normals, object_normals[face.Normals[i]])
+					} else {
+						mesh.Normals = append(mesh.Normals, Vec3{})
+					}
+				}
+				if !hasNormals {
+					missing[mesh] = append(missing[mesh], len(mesh.Materials))
+				}
+				groups[mesh] = append(groups[mesh], face.Group)
+				mesh.Materials = append(mesh.Materials, object.Materials[face.Material])
 			}
 		}
-
-		normals = append(normals, object_normals[face.Normals[0]])
-		normals = append(normals, object_normals[face.Normals[1]])
-		normals = append(normals, object_normals[face.Normals[2]])
 	}
+	if len(meshes) == 0 {
+		return nil, nil, fmt.Errorf("loading %s: no objects in file", path)
+	}
+	for _, mesh := range meshes {
+		generateNormals(mesh, groups[mesh], missing[mesh], creaseAngle)
+	}
+	return meshes, object, nil
+}
 
-	return &Mesh{
-		Vertices:  vertices,
-		Tris:      tris,
-		Normals:   normals,
-		Materials: mats,
-		UVs:       uvs,
-	}, object, nil
+// MergeMeshes joins meshes into one, unnamed and without a BVH. A single
+// mesh is returned as it is.
+func MergeMeshes(meshes []*Mesh) *Mesh {
+	if len(meshes) == 1 {
+		return meshes[0]
+	}
+	merged := &Mesh{}
+	for _, mesh := range meshes {
+		offset := len(merged.Vertices)
+		merged.Vertices = append(merged.Vertices, mesh.Vertices...)
+		for _, t := range mesh.Tris {
+			merged.Tris = append(merged.Tris, offset+t)
+		}
+		merged.Normals = append(merged.Normals, mesh.Normals...)
+		merged.Materials = append(merged.Materials, mesh.Materials...)
+		merged.UVs = append(merged.UVs, mesh.UVs...)
+	}
+	return merged
 }
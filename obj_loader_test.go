@@ -0,0 +1,227 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// partsObj has objects and groups in the ways OBJ files mix them: a group
+// without faces, a group nested in an object, materials switched within
+// an object, and an object resumed at the end of the file that uses a
+// vertex written for another.
+const partsObj = `mtllib parts.mtl
+o Body
+usemtl Red
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+f 1 2 3 4
+g Empty
+o Wheel
+usemtl Black
+v 2 0 0
+v 3 0 0
+v 3 1 0
+f 5 6 7
+g Door
+usemtl Blue
+v 4 0 0
+v 5 0 0
+v 5 1 0
+f 8 9 10
+usemtl Black
+f 8 10 9
+o Body
+usemtl Chrome
+f 1 3 8
+`
+
+const partsMtl = `newmtl Red
+Kd 1 0 0
+newmtl Black
+Kd 0 0 0
+newmtl Blue
+Kd 0 0 1
+newmtl Chrome
+Kd 0.9 0.9 0.9
+`
+
+func writePartsObj(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "parts.obj"), []byte(partsObj), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "parts.mtl"), []byte(partsMtl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "parts.obj")
+}
+
+func materialNames(mesh *Mesh) []string {
+	names := make([]string, len(mesh.Materials))
+	for i, material := range mesh.Materials {
+		names[i] = material.Name
+	}
+	return names
+}
+
+func TestLoadObjMeshes(t *testing.T) {
+	meshes, _, err := LoadObjMeshes(writePartsObj(t), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		vertices  []Vec3
+		tris      []int
+		materials []string
+	}{
+		{
+			name:      "Body",
+			vertices:  []Vec3{{}, {X: 2}, {X: 2, Y: 2}, {Y: 2}, {X: 8}},
+			tris:      []int{0, 1, 2, 0, 2, 3, 0, 2, 4},
+			materials: []string{"Red", "Red", "Chrome"},
+		},
+		{
+			name:      "Wheel",
+			vertices:  []Vec3{{X: 4}, {X: 6}, {X: 6, Y: 2}},
+			tris:      []int{0, 1, 2},
+			materials: []string{"Black"},
+		},
+		{
+			name:      "Door",
+			vertices:  []Vec3{{X: 8}, {X: 10}, {X: 10, Y: 2}},
+			tris:      []int{0, 1, 2, 0, 2, 1},
+			materials: []string{"Blue", "Black"},
+		},
+	}
+	if len(meshes) != len(tests) {
+		var names []string
+		for _, mesh := range meshes {
+			names = append(names, mesh.Name)
+		}
+		t.Fatalf("got meshes %q, want Body, Wheel and Door", names)
+	}
+	for i, test := range tests {
+		mesh := meshes[i]
+		if mesh.Name != test.name {
+			t.Errorf("mesh %d is %q, want %q", i, mesh.Name, test.name)
+			continue
+		}
+		if !slices.Equal(mesh.Vertices, test.vertices) {
+			t.Errorf("%s has vertices %v, want %v", test.name, mesh.Vertices, test.vertices)
+		}
+		if !slices.Equal(mesh.Tris, test.tris) {
+			t.Errorf("%s has triangles %v, want %v", test.name, mesh.Tris, test.tris)
+		}
+		if got := materialNames(mesh); !slices.Equal(got, test.materials) {
+			t.Errorf("%s has materials %v, want %v", test.name, got, test.materials)
+		}
+		if len(mesh.Normals) != len(mesh.Tris) {
+			t.Errorf("%s has %d normals for %d corners", test.name, len(mesh.Normals), len(mesh.Tris))
+		}
+	}
+}
+
+func TestMergeMeshes(t *testing.T) {
+	meshes, _, err := LoadObjMeshes(writePartsObj(t), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if MergeMeshes(meshes[:1]) != meshes[0] {
+		t.Error("merging one mesh made a copy")
+	}
+
+	merged := MergeMeshes(meshes)
+	if merged.Name != "" {
+		t.Errorf("merged mesh is named %q", merged.Name)
+	}
+	var vertices, tris, triangles int
+	for _, mesh := range meshes {
+		for i, index := range mesh.Tris {
+			if got := merged.Tris[tris+i]; got != vertices+index {
+				t.Errorf("corner %d of %s is vertex %d of the merged mesh, want %d", i, mesh.Name, got, vertices+index)
+			}
+			if merged.Normals[tris+i] != mesh.Normals[i] {
+				t.Errorf("corner %d of %s lost its normal", i, mesh.Name)
+			}
+		}
+		for i, material := range mesh.Materials {
+			if merged.Materials[triangles+i] != material {
+				t.Errorf("triangle %d of %s lost its material", i, mesh.Name)
+			}
+		}
+		vertices += len(mesh.Vertices)
+		tris += len(mesh.Tris)
+		triangles += len(mesh.Materials)
+	}
+	if len(merged.Vertices) != vertices || len(merged.Tris) != tris || len(merged.Materials) != triangles {
+		t.Errorf("merged mesh has %d vertices, %d corners and %d triangles, want %d, %d and %d",
+			len(merged.Vertices), len(merged.Tris), len(merged.Materials), vertices, tris, triangles)
+	}
+}
+
+func TestSceneFileObjParts(t *testing.T) {
+	dir := filepath.Dir(writePartsObj(t))
+	load := func(objects string) (*Scene, error) {
+		path := filepath.Join(dir, "scene.json")
+		scene := `{ "camera": { "position": [0, 0, -5] }, "objects": [` + objects + `] }`
+		if err := os.WriteFile(path, []byte(scene), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadSceneFile(path, nil)
+	}
+
+	scene, err := load(`
+    { "obj": "parts.obj", "object": "Wheel", "position": [1, 0, 0] },
+    { "obj": "parts.obj", "split": true, "position": [0, 1, 0] },
+    { "obj": "parts.obj" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name     string
+		position Vec3
+	}{
+		{"Wheel", Vec3{X: 1}},
+		{"Body", Vec3{Y: 1}},
+		{"Wheel", Vec3{Y: 1}},
+		{"Door", Vec3{Y: 1}},
+		// The whole file, merged.
+		{"", Vec3{}},
+	}
+	if len(scene.Meshes) != len(want) {
+		t.Fatalf("scene has %d objects, want %d", len(scene.Meshes), len(want))
+	}
+	for i, w := range want {
+		object := scene.Meshes[i]
+		if object.Mesh.Name != w.name || object.Position != w.position {
+			t.Errorf("object %d is %q at %v, want %q at %v", i, object.Mesh.Name, object.Position, w.name, w.position)
+		}
+	}
+	if scene.Meshes[0].Mesh != scene.Meshes[2].Mesh {
+		t.Error("the named and the split Wheel load the file twice")
+	}
+	if got := len(scene.Meshes[4].Mesh.Materials); got != 6 {
+		t.Errorf("merged mesh has %d triangles, want all 6", got)
+	}
+
+	_, err = load(`{ "obj": "parts.obj", "object": "Bonnet" }`)
+	if err == nil {
+		t.Fatal("unknown object loaded")
+	}
+	for _, s := range []string{`"Bonnet"`, `"Body", "Wheel", "Door"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %s", err, s)
+		}
+	}
+	if strings.Contains(err.Error(), "Empty") {
+		t.Errorf("error %q lists the group without faces", err)
+	}
+}
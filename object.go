@@ -4,4 +4,20 @@ type GameObject[T any] struct {
 	Position Vec3
 	Mesh     *Mesh
 	Object   T
+
+	// Transform, if set, places the mesh before it is moved to Position,
+	// e.g. to rotate or scale it.
+	Transform *Mat4
+
+	// Material, if set, replaces all of the mesh's materials.
+	Material *Material
+}
+
+// ObjectToWorld is the object's Transform followed by its Position.
+func (o *GameObject[T]) ObjectToWorld() Mat4 {
+	m := Translation(o.Position)
+	if o.Transform != nil {
+		m = m.Mul(*o.Transform)
+	}
+	return m
 }
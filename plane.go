@@ -1,6 +1,86 @@
 package main
 
+import (
+	"github.com/chewxy/math32"
+)
+
+// Plane is an infinite plane through Position or, with a width and height,
+// a rectangle centred on it. Its texture runs along the tangent in world
+// units on an infinite plane, and once across a rectangle.
 type Plane struct {
-	Position Vec3
-	Normal   Vec3
+	Position, Normal Vec3
+	Width, Height    float32 // Both zero for an infinite plane
+
+	tangent, bitangent Vec3
+}
+
+// NewPlane orients the plane's width along tangent, projected onto the
+// plane; a zero tangent picks any direction.
+func NewPlane(position, normal, tangent Vec3, width, height float32) *Plane {
+	p := &Plane{Position: position, Normal: normal.Normalize(), Width: width, Height: height}
+	p.tangent, p.bitangent = orthonormalBasis(p.Normal, tangent)
+	return p
+}
+
+// Infinite reports whether the plane has no size. A plane with only one
+// dimension is a degenerate rectangle, not an infinite plane.
+func (p *Plane) Infinite() bool {
+	return p.Width <= 0 && p.Height <= 0
+}
+
+func (p *Plane) Bounds() (Vec3, Vec3) {
+	if p.Infinite() {
+		inf := math32.Inf(1)
+		return Vec3{X: -inf, Y: -inf, Z: -inf}, Vec3{X: inf, Y: inf, Z: inf}
+	}
+	u, v := p.tangent.Scale(p.Width/2), p.bitangent.Scale(p.Height/2)
+	extent := Vec3{
+		X: math32.Abs(u.X) + math32.Abs(v.X),
+		Y: math32.Abs(u.Y) + math32.Abs(v.Y),
+		Z: math32.Abs(u.Z) + math32.Abs(v.Z),
+	}
+	return p.Position.Sub(extent), p.Position.Add(extent)
+}
+
+func (p *Plane) Intersect(ray Ray, tMax float32) (bool, float32) {
+	denominator := p.Normal.Dot(ray.Direction)
+	if math32.Abs(denominator) < 1e-8 {
+		return false, 0
+	}
+	t := p.Normal.Dot(p.Position.Sub(ray.Origin)) / denominator
+	if t <= primitiveEpsilon || t > tMax {
+		return false, 0
+	}
+	if p.Infinite() {
+		return true, t
+	}
+
+	local := ray.Origin.Add(ray.Direction.Scale(t)).Sub(p.Position)
+	if math32.Abs(local.Dot(p.tangent)) > p.Width/2 || math32.Abs(local.Dot(p.bitangent)) > p.Height/2 {
+		return false, 0
+	}
+	return true, t
+}
+
+func (p *Plane) Surface(point Vec3) (Vec3, float32, float32) {
+	local := point.Sub(p.Position)
+	u, v := local.Dot(p.tangent), local.Dot(p.bitangent)
+	if !p.Infinite() {
+		u, v = u/p.Width+0.5, v/p.Height+0.5
+	}
+	return p.Normal, u, v
+}
+
+func (p *Plane) Area() float32 {
+	if p.Infinite() {
+		return math32.Inf(1)
+	}
+	return p.Width * p.Height
+}
+
+func (p *Plane) Sample(u, v float32) (Vec3, Vec3) {
+	point := p.Position.
+		Add(p.tangent.Scale((u - 0.5) * p.Width)).
+		Add(p.bitangent.Scale((v - 0.5) * p.Height))
+	return point, p.Normal
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/widget"
+)
+
+// pointerLayer is a transparent widget laid over the render that passes
+// taps and drags on, in image pixels.
+type pointerLayer struct {
+	widget.BaseWidget
+
+	onTap  func(x, y float32)
+	onDrag func(dx, dy float32)
+}
+
+func newPointerLayer(onTap func(x, y float32), onDrag func(dx, dy float32)) *pointerLayer {
+	l := &pointerLayer{onTap: onTap, onDrag: onDrag}
+	l.ExtendBaseWidget(l)
+	return l
+}
+
+func (l *pointerLayer) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(canvas.NewRectangle(color.Transparent))
+}
+
+func (l *pointerLayer) Tapped(event *fyne.PointEvent) {
+	l.onTap(event.Position.X, event.Position.Y)
+}
+
+func (l *pointerLayer) Dragged(event *fyne.DragEvent) {
+	l.onDrag(event.Dragged.DX, event.Dragged.DY)
+}
+
+func (l *pointerLayer) DragEnd() {}
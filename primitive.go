@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/chewxy/math32"
+)
+
+// Primitive is an analytic shape the BVH holds next to triangles. Distances
+// are in units of the ray direction's length, like IntersectSegmentTriangle.
+type Primitive interface {
+	// Bounds returns the corners of the box around the shape. Unbounded
+	// shapes such as infinite planes return infinite corners; they are
+	// tested on their own instead of being put in the tree.
+	Bounds() (lo, hi Vec3)
+
+	// Intersect returns the closest hit along the ray in (0, tMax].
+	Intersect(ray Ray, tMax float32) (bool, float32)
+
+	// Surface returns the normal and the texture coordinates at a point on
+	// the surface.
+	Surface(p Vec3) (normal Vec3, u, v float32)
+
+	Area() float32
+
+	// Sample maps two uniform numbers to a point spread evenly over the
+	// surface, with its normal.
+	Sample(u, v float32) (point, normal Vec3)
+}
+
+// PrimitiveObject places a primitive in the scene with its material.
+type PrimitiveObject struct {
+	Name     string
+	Shape    Primitive
+	Material *Material
+}
+
+// primitiveEpsilon keeps a ray from hitting the surface it leaves.
+const primitiveEpsilon = 1e-4
+
+func isUnbounded(p Primitive) bool {
+	lo, hi := p.Bounds()
+	return math32.IsInf(lo.X, 0) || math32.IsInf(lo.Y, 0) || math32.IsInf(lo.Z, 0) ||
+		math32.IsInf(hi.X, 0) || math32.IsInf(hi.Y, 0) || math32.IsInf(hi.Z, 0)
+}
+
+// orthonormalBasis returns two unit vectors perpendicular to n and each
+// other. A non-zero hint is projected onto the plane to become the first.
+func orthonormalBasis(n, hint Vec3) (Vec3, Vec3) {
+	tangent := hint.Sub(n.Scale(hint.Dot(n)))
+	if tangent.Length() < 1e-6 {
+		up := Vec3{Y: 1}
+		if math32.Abs(n.Y) > 0.9 {
+			up = Vec3{X: 1}
+		}
+		tangent = up.Cross(n)
+	}
+	tangent._Normalize()
+	return tangent, n.Cross(tangent)
+}
+
+// ------------------------------------------------------------
+
+// Intersect tests the ray against the triangle or the primitive of the
+// entry.
+func (tri *BVHTriangle) Intersect(ray Ray, tMax float32) (bool, float32) {
+	if tri.Primitive != nil {
+		return tri.Primitive.Intersect(ray, tMax)
+	}
+	return IntersectSegmentTriangle(ray.Origin, ray.Direction, tMax, tri.A, tri.B, tri.C)
+}
+
+// Occludes reports whether anything of the entry lies on the ray within
+// tMax.
+func (tri *BVHTriangle) Occludes(ray Ray, tMax float32) bool {
+	if tri.Primitive != nil {
+		hit, _ := tri.Primitive.Intersect(ray, tMax)
+		return hit
+	}
+	return FastIntersectShadowTriangle(ray.Origin, ray.Direction, tMax, tri.A, tri.B, tri.C)
+}
+
+// GeometricNormal returns the unit normal of the entry's surface itself at
+// p, not interpolated like the shading normal, for offsetting rays off it.
+func (tri *BVHTriangle) GeometricNormal(p Vec3) Vec3 {
+	if tri.Primitive != nil {
+		n, _, _ := tri.Primitive.Surface(p)
+		return n.Normalize()
+	}
+	return tri.B.Sub(tri.A).Cross(tri.C.Sub(tri.A)).Normalize()
+}
+
+// SurfacePoint moves p, a hit found a distance along a ray, onto the plane
+// of the triangle. Its error then depends on the size of the corners
+// rather than on how far the ray went, as OffsetRayOrigin assumes.
+// Primitives return p.
+func (tri *BVHTriangle) SurfacePoint(p Vec3) Vec3 {
+	if tri.Primitive != nil {
+		return p
+	}
+	n := tri.GeometricNormal(p)
+	d := p.Sub(tri.A)
+	return p.Sub(n.Scale(d.Dot(n)))
+}
+
+func (tri *BVHTriangle) Area() float32 {
+	if tri.Primitive != nil {
+		return tri.Primitive.Area()
+	}
+	return TriangleArea(tri.A, tri.B, tri.C)
+}
+
+// appendPrimitives gives every primitive a triangle slot after the first
+// slots, so materials, UVs and AOV indices are looked up the same way for
+// both, and returns their BVH entries. Unbounded primitives are returned
+// separately as they cannot go into the tree.
+func (vnmu *VNMU) appendPrimitives(slot int, primitives []*PrimitiveObject) (bounded, unbounded []*BVHTriangle) {
+	for _, primitive := range primitives {
+		vnmu.Normals = append(vnmu.Normals, Vec3{}, Vec3{}, Vec3{})
+		vnmu.UVs = append(vnmu.UVs, 0, 0, 0, 0, 0, 0)
+		vnmu.Materials = append(vnmu.Materials, primitive.Material)
+
+		emissive := primitive.Material.Emissive
+		if emissive.R > 0 || emissive.G > 0 || emissive.B > 0 {
+			vnmu.EmissiveTriangles = append(vnmu.EmissiveTriangles, EmissiveTriangle{
+				MaterialIndex: slot,
+				Primitive:     primitive.Shape,
+			})
+		}
+
+		lo, hi := primitive.Shape.Bounds()
+		entry := &BVHTriangle{
+			Index:     slot * 3,
+			Primitive: primitive.Shape,
+			Centroid:  lo.Add(hi).Scale(0.5),
+			MinX:      lo.X, MaxX: hi.X,
+			MinY: lo.Y, MaxY: hi.Y,
+			MinZ: lo.Z, MaxZ: hi.Z,
+		}
+		if isUnbounded(primitive.Shape) {
+			unbounded = append(unbounded, entry)
+		} else {
+			bounded = append(bounded, entry)
+		}
+		slot++
+	}
+	return bounded, unbounded
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+func near(a, b Vec3) bool {
+	return a.Sub(b).Length() < 1e-4
+}
+
+func TestPrimitiveIntersect(t *testing.T) {
+	sphere := &Sphere{Radius: 1}
+	rectangle := NewPlane(Vec3{}, Vec3{Y: 1}, Vec3{X: 1}, 2, 2)
+	infinite := NewPlane(Vec3{}, Vec3{Y: 1}, Vec3{}, 0, 0)
+	disc := NewDisc(Vec3{}, Vec3{Y: 1}, Vec3{}, 1)
+	box := &Cuboid{Min: Vec3{X: -1, Y: -1, Z: -1}, Max: Vec3{X: 1, Y: 1, Z: 1}}
+	down, forward := Vec3{Y: -1}, Vec3{Z: 1}
+
+	tests := []struct {
+		name       string
+		shape      Primitive
+		ray        Ray
+		tMax       float32
+		hit        bool
+		t          float32
+		wantNormal Vec3
+	}{
+		{"sphere/hit", sphere, Ray{Origin: Vec3{Z: -5}, Direction: forward}, 100, true, 4, Vec3{Z: -1}},
+		{"sphere/miss", sphere, Ray{Origin: Vec3{Y: 2, Z: -5}, Direction: forward}, 100, false, 0, Vec3{}},
+		{"sphere/behind", sphere, Ray{Origin: Vec3{Z: 5}, Direction: forward}, 100, false, 0, Vec3{}},
+		{"sphere/beyond tMax", sphere, Ray{Origin: Vec3{Z: -5}, Direction: forward}, 3, false, 0, Vec3{}},
+		{"sphere/inside", sphere, Ray{Origin: Vec3{}, Direction: forward}, 100, true, 1, Vec3{Z: 1}},
+
+		{"rectangle/hit", rectangle, Ray{Origin: Vec3{X: 0.5, Y: 3, Z: 0.5}, Direction: down}, 100, true, 3, Vec3{Y: 1}},
+		{"rectangle/miss", rectangle, Ray{Origin: Vec3{X: 3, Y: 3}, Direction: down}, 100, false, 0, Vec3{}},
+		{"rectangle/parallel", rectangle, Ray{Origin: Vec3{Y: 1}, Direction: forward}, 100, false, 0, Vec3{}},
+		{"rectangle/on surface", rectangle, Ray{Origin: Vec3{}, Direction: down}, 100, false, 0, Vec3{}},
+
+		{"infinite plane/far away", infinite, Ray{Origin: Vec3{X: 1000, Y: 3}, Direction: down}, 100, true, 3, Vec3{Y: 1}},
+		{"infinite plane/from below", infinite, Ray{Origin: Vec3{Y: -3}, Direction: Vec3{Y: 1}}, 100, true, 3, Vec3{Y: 1}},
+		{"infinite plane/on surface", infinite, Ray{Origin: Vec3{}, Direction: down}, 100, false, 0, Vec3{}},
+
+		{"disc/hit", disc, Ray{Origin: Vec3{X: 0.5, Y: 2}, Direction: down}, 100, true, 2, Vec3{Y: 1}},
+		{"disc/corner of its square", disc, Ray{Origin: Vec3{X: 0.9, Y: 2, Z: 0.9}, Direction: down}, 100, false, 0, Vec3{}},
+		{"disc/on surface", disc, Ray{Origin: Vec3{}, Direction: down}, 100, false, 0, Vec3{}},
+
+		{"box/hit", box, Ray{Origin: Vec3{Z: -5}, Direction: forward}, 100, true, 4, Vec3{Z: -1}},
+		{"box/miss", box, Ray{Origin: Vec3{X: 2, Z: -5}, Direction: forward}, 100, false, 0, Vec3{}},
+		{"box/behind", box, Ray{Origin: Vec3{Z: 5}, Direction: forward}, 100, false, 0, Vec3{}},
+		{"box/inside", box, Ray{Origin: Vec3{}, Direction: forward}, 100, true, 1, Vec3{Z: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hit, tHit := test.shape.Intersect(test.ray, test.tMax)
+			if hit != test.hit {
+				t.Fatalf("Intersect = %v, %g; want hit %v", hit, tHit, test.hit)
+			}
+			entry := &BVHTriangle{Primitive: test.shape}
+			if occluded := entry.Occludes(test.ray, test.tMax); occluded != test.hit {
+				t.Errorf("Occludes = %v, want %v", occluded, test.hit)
+			}
+			if !hit {
+				return
+			}
+			if math32.Abs(tHit-test.t) > 1e-4 {
+				t.Errorf("t = %g, want %g", tHit, test.t)
+			}
+			normal, _, _ := test.shape.Surface(test.ray.Origin.Add(test.ray.Direction.Scale(tHit)))
+			if !near(normal, test.wantNormal) {
+				t.Errorf("normal = %v, want %v", normal, test.wantNormal)
+			}
+		})
+	}
+}
+
+// Sampled points must lie on the surface, with the normal Surface gives
+// there.
+func TestPrimitiveSample(t *testing.T) {
+	shapes := map[string]struct {
+		shape Primitive
+		on    func(p Vec3) bool
+	}{
+		"sphere": {&Sphere{Position: Vec3{X: 1}, Radius: 2}, func(p Vec3) bool {
+			return math32.Abs(p.Sub(Vec3{X: 1}).Length()-2) < 1e-4
+		}},
+		"rectangle": {NewPlane(Vec3{Y: 1}, Vec3{Y: 1}, Vec3{X: 1}, 4, 2), func(p Vec3) bool {
+			return math32.Abs(p.Y-1) < 1e-5 && math32.Abs(p.X) <= 2+1e-5 && math32.Abs(p.Z) <= 1+1e-5
+		}},
+		"disc": {NewDisc(Vec3{}, Vec3{Z: 1}, Vec3{}, 3), func(p Vec3) bool {
+			return math32.Abs(p.Z) < 1e-5 && p.Length() <= 3+1e-4
+		}},
+		"box": {&Cuboid{Min: Vec3{}, Max: Vec3{X: 1, Y: 2, Z: 3}}, func(p Vec3) bool {
+			inside := p.X >= -1e-5 && p.X <= 1+1e-5 && p.Y >= -1e-5 && p.Y <= 2+1e-5 && p.Z >= -1e-5 && p.Z <= 3+1e-5
+			onFace := min(math32.Abs(p.X), math32.Abs(p.X-1), math32.Abs(p.Y), math32.Abs(p.Y-2), math32.Abs(p.Z), math32.Abs(p.Z-3)) < 1e-5
+			return inside && onFace
+		}},
+	}
+	for name, test := range shapes {
+		t.Run(name, func(t *testing.T) {
+			for i := range 10 {
+				for j := range 10 {
+					u, v := (float32(i)+0.5)/10, (float32(j)+0.5)/10
+					point, normal := test.shape.Sample(u, v)
+					if !test.on(point) {
+						t.Fatalf("Sample(%g, %g) = %v, off the surface", u, v, point)
+					}
+					if surface, _, _ := test.shape.Surface(point); !near(surface, normal) {
+						t.Fatalf("Sample(%g, %g) normal %v, Surface says %v", u, v, normal, surface)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPlaneInfinite(t *testing.T) {
+	tests := []struct {
+		width, height float32
+		infinite      bool
+	}{
+		{0, 0, true},
+		{2, 3, false},
+		// A half-specified size is a degenerate rectangle, not a typo
+		// that silently makes the plane unbounded.
+		{2, 0, false},
+		{0, 3, false},
+	}
+	for _, test := range tests {
+		plane := NewPlane(Vec3{}, Vec3{Y: 1}, Vec3{}, test.width, test.height)
+		if got := plane.Infinite(); got != test.infinite {
+			t.Errorf("%gx%g plane: Infinite() = %v, want %v", test.width, test.height, got, test.infinite)
+		}
+		if got := isUnbounded(plane); got != test.infinite {
+			t.Errorf("%gx%g plane: isUnbounded = %v, want %v", test.width, test.height, got, test.infinite)
+		}
+	}
+}
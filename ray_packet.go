@@ -0,0 +1,346 @@
+package main
+
+import (
+	"math/bits"
+
+	"github.com/chewxy/math32"
+)
+
+// packetSize is the most rays a packet holds, an 8x8 block.
+const packetSize = 64
+
+// rayPacket is a group of coherent rays, such as the camera rays through a
+// pixel or the shadow rays from their hits towards the sun, traced through
+// the trees together: each node is fetched once for the packet, and a
+// node the packet's frustum misses is skipped without testing the rays
+// one by one. A ray is only tested against the nodes and entries a single
+// ray would be, and closest hits are ranked the same way, so the results
+// are bit-identical to tracing the rays alone.
+type rayPacket struct {
+	n       int
+	queries [packetSize]rayQuery
+
+	// hits holds the closest hit of each ray so far; its t is how far the
+	// ray looks. done marks rays that need no more tests, such as shadow
+	// rays already found blocked.
+	hits [packetSize]nearestHit
+	done [packetSize]bool
+
+	// coherent is whether the rays fit a frustum; if not they are traced
+	// one by one.
+	coherent bool
+	frustum  packetFrustum
+
+	// visits counts the nodes traverse visits for each ray in their range,
+	// as differences: +1 at the first ray and -1 past the last. They are
+	// added up into the queries' costs at the end.
+	visits [packetSize + 1]int32
+}
+
+// reset fills the packet with rays looking as far as tMax.
+func (p *rayPacket) reset(rays []Ray, tMax float32) {
+	p.n = len(rays)
+	for i, ray := range rays {
+		p.queries[i] = newRayQuery(ray)
+		p.hits[i] = nearestHit{t: tMax, rank: noHit}
+		p.done[i] = false
+	}
+	clear(p.visits[:])
+	p.coherent = p.frustum.bound(p.queries[:p.n])
+}
+
+// limit returns the longest distance a ray still looks, and the ray that
+// looks that far.
+func (p *rayPacket) limit() (float32, int) {
+	limit, ray := float32(-INF), 0
+	for i := range p.n {
+		if !p.done[i] && p.hits[i].t > limit {
+			limit, ray = p.hits[i].t, i
+		}
+	}
+	return limit, ray
+}
+
+// hitsNode is the box test a single ray would make of node.
+func (p *rayPacket) hitsNode(node *LinearBVHNode, i int) bool {
+	return !p.done[i] && node.intersectAABB(&p.queries[i], p.hits[i].t) != INF
+}
+
+// packetEntry is a node waiting on a packet's traversal stack, with the
+// range of rays from the first to the last that hit its parent.
+type packetEntry struct {
+	node        uint32
+	first, last uint8
+}
+
+// traverse walks nodes with the packet and calls leaf with every leaf and
+// the mask of the rays that hit it. Like a single ray, it does not test
+// the root, whose box holds all the entries anyway.
+func (p *rayPacket) traverse(nodes []LinearBVHNode, leaf func(node *LinearBVHNode, rays uint64)) {
+	var stack [64]packetEntry
+	stack[0] = packetEntry{node: 0, first: 0, last: uint8(p.n)}
+	nptr := 1
+	limit, limitRay := p.limit()
+
+	for nptr > 0 {
+		entry := stack[nptr-1]
+		nptr--
+		node := &nodes[entry.node]
+		first, last := int(entry.first), int(entry.last)
+
+		if entry.node != 0 {
+			if p.frustum.misses(node, limit) {
+				continue
+			}
+			// Narrow the range to the first and last rays that hit the
+			// node; the rays between are tested again further down.
+			for first < last && !p.hitsNode(node, first) {
+				first++
+			}
+			for last > first && !p.hitsNode(node, last-1) {
+				last--
+			}
+			if first == last {
+				continue
+			}
+		}
+		p.visits[first]++
+		p.visits[last]--
+
+		if node.IsLeaf() {
+			var rays uint64
+			for i := first; i < last; i++ {
+				if entry.node == 0 && !p.done[i] || p.hitsNode(node, i) {
+					rays |= 1 << i
+				}
+			}
+			if rays != 0 {
+				leaf(node, rays)
+				// Only the rays of the leaf can have come closer.
+				if rays&(1<<limitRay) != 0 {
+					limit, limitRay = p.limit()
+				}
+			}
+			continue
+		}
+
+		// Visit first the child the first ray enters first.
+		near, far := entry.node+1, node.Offset
+		q, t := &p.queries[first], p.hits[first].t
+		if nodes[far].intersectAABB(q, t) < nodes[near].intersectAABB(q, t) {
+			near, far = far, near
+		}
+		stack[nptr] = packetEntry{node: far, first: uint8(first), last: uint8(last)}
+		stack[nptr+1] = packetEntry{node: near, first: uint8(first), last: uint8(last)}
+		nptr += 2
+	}
+
+	visits := int32(0)
+	for i := range p.n {
+		visits += p.visits[i]
+		p.queries[i].cost.Nodes += visits
+	}
+	clear(p.visits[:])
+}
+
+// ------------------------------------------------------------
+
+// packetFrustum bounds the rays of a packet with intervals holding every
+// ray's origin and the reciprocal of its direction. Rounding never
+// reverses the order of two numbers, so a box test made with the ends of
+// the intervals bounds the results of the same test made by each ray: a
+// box the frustum misses, every ray misses.
+type packetFrustum struct {
+	originLo, originHi   Vec3
+	inverseLo, inverseHi Vec3
+	negative             [3]bool
+}
+
+// bound fits the frustum around the queries and reports whether they are
+// coherent enough for one: they must point the same way along every axis
+// and none may be parallel to an axis plane.
+func (f *packetFrustum) bound(queries []rayQuery) bool {
+	if len(queries) == 0 {
+		return false
+	}
+	first := &queries[0]
+	f.negative = first.Negative
+	f.originLo, f.originHi = first.Origin, first.Origin
+	f.inverseLo, f.inverseHi = first.InverseDirection, first.InverseDirection
+	for i := range queries {
+		q := &queries[i]
+		inv := q.InverseDirection
+		if q.Negative != f.negative || math32.IsInf(inv.X, 0) || math32.IsInf(inv.Y, 0) || math32.IsInf(inv.Z, 0) {
+			return false
+		}
+		f.originLo = Vec3{X: min(f.originLo.X, q.Origin.X), Y: min(f.originLo.Y, q.Origin.Y), Z: min(f.originLo.Z, q.Origin.Z)}
+		f.originHi = Vec3{X: max(f.originHi.X, q.Origin.X), Y: max(f.originHi.Y, q.Origin.Y), Z: max(f.originHi.Z, q.Origin.Z)}
+		f.inverseLo = Vec3{X: min(f.inverseLo.X, inv.X), Y: min(f.inverseLo.Y, inv.Y), Z: min(f.inverseLo.Z, inv.Z)}
+		f.inverseHi = Vec3{X: max(f.inverseHi.X, inv.X), Y: max(f.inverseHi.Y, inv.Y), Z: max(f.inverseHi.Z, inv.Z)}
+	}
+	return true
+}
+
+// misses reports whether every ray misses the box within limit, the
+// longest any of them looks.
+func (f *packetFrustum) misses(l *LinearBVHNode, limit float32) bool {
+	nearX, farX := l.MinBounds.X, l.MaxBounds.X
+	if f.negative[0] {
+		nearX, farX = farX, nearX
+	}
+	nearY, farY := l.MinBounds.Y, l.MaxBounds.Y
+	if f.negative[1] {
+		nearY, farY = farY, nearY
+	}
+	nearZ, farZ := l.MinBounds.Z, l.MaxBounds.Z
+	if f.negative[2] {
+		nearZ, farZ = farZ, nearZ
+	}
+
+	// The same differences and products as intersectAABB, over the
+	// intervals.
+	tMin := max(
+		lowestProduct(nearX-f.originHi.X, nearX-f.originLo.X, f.inverseLo.X, f.inverseHi.X),
+		lowestProduct(nearY-f.originHi.Y, nearY-f.originLo.Y, f.inverseLo.Y, f.inverseHi.Y),
+		lowestProduct(nearZ-f.originHi.Z, nearZ-f.originLo.Z, f.inverseLo.Z, f.inverseHi.Z),
+	)
+	tMax := min(
+		highestProduct(farX-f.originHi.X, farX-f.originLo.X, f.inverseLo.X, f.inverseHi.X),
+		highestProduct(farY-f.originHi.Y, farY-f.originLo.Y, f.inverseLo.Y, f.inverseHi.Y),
+		highestProduct(farZ-f.originHi.Z, farZ-f.originLo.Z, f.inverseLo.Z, f.inverseHi.Z),
+	)
+	return tMin > min(tMax, limit) || tMax < 0
+}
+
+// lowestProduct and highestProduct bound the rounded product of a number
+// in [aLo, aHi] and one in [bLo, bHi].
+func lowestProduct(aLo, aHi, bLo, bHi float32) float32 {
+	return min(aLo*bLo, aLo*bHi, aHi*bLo, aHi*bHi)
+}
+
+func highestProduct(aLo, aHi, bLo, bHi float32) float32 {
+	return max(aLo*bLo, aLo*bHi, aHi*bLo, aHi*bHi)
+}
+
+// ------------------------------------------------------------
+
+// nearestPacket is nearest for every ray of the packet.
+func (box *LinearBVH) nearestPacket(p *rayPacket, slot int) {
+	if !p.coherent || len(box.Nodes) == 0 {
+		for i := range p.n {
+			box.nearest(&p.queries[i], slot, &p.hits[i])
+		}
+		return
+	}
+	for i := range p.n {
+		box.nearestUnbounded(&p.queries[i], slot, &p.hits[i])
+	}
+	base := hitRank(slot, len(box.Unbounded))
+	p.traverse(box.Nodes, func(node *LinearBVHNode, rays uint64) {
+		for ; rays != 0; rays &= rays - 1 {
+			i := bits.TrailingZeros64(rays)
+			box.intersectLeaf(&p.queries[i], node.Offset, node.Count, base, &p.hits[i])
+		}
+	})
+}
+
+// occludesPacket marks done the rays of the packet that occludes finds
+// blocked within their hits' t.
+func (box *LinearBVH) occludesPacket(p *rayPacket) {
+	if !p.coherent || len(box.Nodes) == 0 {
+		for i := range p.n {
+			p.done[i] = p.done[i] || box.occludes(&p.queries[i], p.hits[i].t)
+		}
+		return
+	}
+	for i := range p.n {
+		p.done[i] = p.done[i] || box.occludesUnbounded(&p.queries[i], p.hits[i].t)
+	}
+	p.traverse(box.Nodes, func(node *LinearBVHNode, rays uint64) {
+		for ; rays != 0; rays &= rays - 1 {
+			i := bits.TrailingZeros64(rays)
+			p.done[i] = box.occludesLeaf(&p.queries[i], node.Offset, node.Count, p.hits[i].t)
+		}
+	})
+}
+
+// instancePacket gathers the rays of the mask that hit the instance's
+// bounds into object, moved into object space, with their places in p.
+func (inst *Instance) instancePacket(p *rayPacket, rays uint64, object *rayPacket, ids *[packetSize]uint8) {
+	object.n = 0
+	for ; rays != 0; rays &= rays - 1 {
+		i := bits.TrailingZeros64(rays)
+		if p.done[i] || inst.bounds.intersectAABB(&p.queries[i], p.hits[i].t) == INF {
+			continue
+		}
+		ids[object.n] = uint8(i)
+		object.queries[object.n] = inst.objectQuery(&p.queries[i])
+		object.hits[object.n] = p.hits[i]
+		object.done[object.n] = false
+		object.n++
+	}
+	object.coherent = object.frustum.bound(object.queries[:object.n])
+}
+
+// nearestPacket is nearest for the rays of the mask.
+func (inst *Instance) nearestPacket(p *rayPacket, rays uint64, slot int) {
+	var object rayPacket
+	var ids [packetSize]uint8
+	inst.instancePacket(p, rays, &object, &ids)
+	if object.n == 0 {
+		return
+	}
+	inst.BVH.nearestPacket(&object, slot)
+	for k := range object.n {
+		p.hits[ids[k]] = object.hits[k]
+		p.queries[ids[k]].cost.Add(object.queries[k].cost)
+	}
+}
+
+// occludesPacket is occludes for the rays of the mask.
+func (inst *Instance) occludesPacket(p *rayPacket, rays uint64) {
+	var object rayPacket
+	var ids [packetSize]uint8
+	inst.instancePacket(p, rays, &object, &ids)
+	if object.n == 0 {
+		return
+	}
+	inst.BVH.occludesPacket(&object)
+	for k := range object.n {
+		p.done[ids[k]] = object.done[k]
+	}
+}
+
+// nearestPacket finds for every ray of the packet the closest hit
+// CheckIntersection finds; TopLevelBVH.hit turns them into triangles.
+func (b *TopLevelBVH) nearestPacket(p *rayPacket) {
+	if !p.coherent {
+		for i := range p.n {
+			b.nearest(&p.queries[i], &p.hits[i])
+		}
+		return
+	}
+	b.Primitives.nearestPacket(p, 0)
+	p.traverse(b.Nodes, func(node *LinearBVHNode, rays uint64) {
+		for i, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+			instance.nearestPacket(p, rays, int(node.Offset)+i+1)
+		}
+	})
+}
+
+// occludesPacket marks done the rays of the packet QuickCheckIntersection
+// finds blocked within their hits' t.
+func (b *TopLevelBVH) occludesPacket(p *rayPacket) {
+	if !p.coherent {
+		for i := range p.n {
+			p.done[i] = p.done[i] || b.occludes(&p.queries[i], p.hits[i].t)
+		}
+		return
+	}
+	b.Primitives.occludesPacket(p)
+	p.traverse(b.Nodes, func(node *LinearBVHNode, rays uint64) {
+		for _, instance := range b.Instances[node.Offset : node.Offset+node.Count] {
+			instance.occludesPacket(p, rays)
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RenderSettings holds the knobs shared by the interactive viewer and the
+// headless renderer.
+type RenderSettings struct {
+	Width, Height      int
+	SamplesPerPixel    int // Samples taken every time a pixel is visited
+	MaxSamplesPerPixel int
+	Bounces            int
+	ScatterRays        int
+	MaxSteps           int
+	StepSize           float32
+	Ambient            float32
+	Threads            int
+
+	// Adaptive stops sampling pixels once they look converged. Without it
+	// every pixel gets exactly MaxSamplesPerPixel samples, which keeps noise
+	// consistent between animation frames.
+	Adaptive bool
+
+	// Sampler names the sample generator (see SamplerNames). With the same
+	// seed the image is identical whatever the thread count.
+	Sampler string
+	Seed    uint64
+
+	// ToneMapping is the initial display transform; see
+	// Renderer.SetToneMapping.
+	ToneMapping ToneMapping
+
+	// Packets traces the camera rays of a pixel, and their shadow rays
+	// towards the sun, as packets. The image is the same either way.
+	Packets bool
+
+	// BVH is how the scene's BVHs are built.
+	BVH BVHOptions
+}
+
+func DefaultRenderSettings() RenderSettings {
+	return RenderSettings{
+		Width:              512,
+		Height:             512,
+		SamplesPerPixel:    32,
+		MaxSamplesPerPixel: 32,
+		Bounces:            2,
+		ScatterRays:        1,
+		MaxSteps:           3000,
+		StepSize:           1.0,
+		Ambient:            0.0,
+		Threads:            threadCount,
+		Adaptive:           true,
+		Sampler:            "sobol",
+		ToneMapping:        DefaultToneMapping(),
+		Packets:            true,
+		BVH:                DefaultBVHOptions(),
+	}
+}
+
+func (s RenderSettings) Validate() error {
+	switch {
+	case s.Width <= 0 || s.Height <= 0:
+		return fmt.Errorf("invalid resolution %dx%d", s.Width, s.Height)
+	case s.SamplesPerPixel <= 0 || s.MaxSamplesPerPixel <= 0:
+		return errors.New("samples per pixel must be positive")
+	case s.Bounces < 0:
+		return errors.New("bounces cannot be negative")
+	case s.ScatterRays <= 0:
+		return errors.New("scatter rays must be positive")
+	case s.MaxSteps <= 0:
+		return errors.New("max steps must be positive")
+	case s.Threads <= 0:
+		return errors.New("thread count must be positive")
+	case !slices.Contains(SamplerNames, s.Sampler):
+		return fmt.Errorf("unknown sampler %q", s.Sampler)
+	case s.ToneMapping.Operator == nil:
+		return errors.New("no tone mapper set")
+	}
+	return s.BVH.Validate()
+}
+
+// TotalSamples is the sample budget of a full render.
+func (s RenderSettings) TotalSamples() int64 {
+	return int64(s.Width) * int64(s.Height) * int64(s.MaxSamplesPerPixel)
+}
+
+// Renderer owns the scene geometry and the progressive pixel buffer.
+type Renderer struct {
+	Settings RenderSettings
+	Scene    *Scene
+	BVH      *TopLevelBVH
+	VNMU     *VNMU
+
+	Pixels [][]Pixel
+	Tiles  []*Tile
+
+	Samples atomic.Int64
+
+	toneMapping atomic.Pointer[ToneMapping]
+	displayAOV  atomic.Int32
+	bounds      [2]Vec3 // Of the scene geometry, for displaying AOVs
+}
+
+func NewRenderer(scene *Scene, settings RenderSettings) (*Renderer, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	if scene == nil || scene.Camera == nil {
+		return nil, errors.New("scene has no camera")
+	}
+
+	vertices, tris, normals, materials, uvs, offsets := DecomposeObjects(scene.Meshes)
+	fmt.Println(len(vertices), "vertices,", len(tris)/3, "triangles in", len(offsets), "meshes,", len(scene.Meshes), "instances,", len(scene.Primitives), "primitives")
+
+	vnmu := &VNMU{
+		Vertices:  vertices,
+		Normals:   normals,
+		Materials: materials,
+		UVs:       uvs,
+	}
+
+	fmt.Println("BVH Building...")
+	bvhSt := time.Now()
+	instances, err := vnmu.buildInstances(scene.Meshes, tris, offsets, settings.BVH)
+	if err != nil {
+		return nil, err
+	}
+	primitives, unbounded := vnmu.appendPrimitives(len(tris)/3, scene.Primitives)
+	vnmu.assignIDs(instances, len(tris)/3)
+
+	primitiveBVH := ConstructLinearBVH(BuildBVH(primitives, settings.BVH))
+	primitiveBVH.Collapse(settings.BVH.Width)
+	primitiveBVH.Unbounded = unbounded
+	bvh := BuildTopLevelBVH(instances, primitiveBVH, settings.BVH)
+	fmt.Printf("BVH Built in %d ms, SAH cost %.2f\n", time.Since(bvhSt).Milliseconds(), bvh.SAHCost(settings.BVH))
+
+	r := &Renderer{
+		Settings: settings,
+		Scene:    scene,
+		BVH:      bvh,
+		VNMU:     vnmu,
+		bounds:   sceneBounds(instances, primitives),
+	}
+
+	r.Pixels = make([][]Pixel, settings.Height)
+	for i := range r.Pixels {
+		r.Pixels[i] = make([]Pixel, settings.Width)
+		for j := range r.Pixels[i] {
+			r.Pixels[i][j].X = uint32(j)
+			r.Pixels[i][j].Y = uint32(i)
+		}
+	}
+	r.Tiles = makeTiles(r.Pixels, settings.Threads)
+	r.SetToneMapping(settings.ToneMapping)
+	return r, nil
+}
+
+// makeTiles deals the rows of the buffer out to count tiles, so that each
+// tile gets a share of the busy and the empty parts of the frame.
+func makeTiles(pixels [][]Pixel, count int) []*Tile {
+	height := len(pixels)
+	width := 0
+	if height > 0 {
+		width = len(pixels[0])
+	}
+
+	tiles := make([]*Tile, count)
+	for i := range tiles {
+		tiles[i] = &Tile{
+			Width:  uint32(width),
+			Height: uint32(height),
+		}
+	}
+	for y := range pixels {
+		tile := tiles[y%count]
+		for x := range pixels[y] {
+			tile.Pixels = append(tile.Pixels, &pixels[y][x])
+		}
+	}
+	return tiles
+}
+
+// samplePixel traces one batch of camera rays through the pixel. With a
+// packet, the rays are traced in packets first; see samplePacket.
+func (r *Renderer) samplePixel(pixel *Pixel, sampler Sampler, packet *rayPacket) {
+	s := &r.Settings
+	if packet != nil {
+		count := min(s.SamplesPerPixel, max(1, s.MaxSamplesPerPixel-pixel.SampleCount))
+		for count > 0 {
+			n := min(count, packetSize)
+			r.samplePacket(pixel, sampler, packet, n)
+			count -= n
+		}
+		return
+	}
+
+	for range s.SamplesPerPixel {
+		sampler.StartSample(pixel.X, pixel.Y, pixel.SampleCount)
+		ray := r.cameraRay(pixel, sampler)
+		r.addSample(pixel, sampler, ray, nil)
+		if pixel.SampleCount >= s.MaxSamplesPerPixel {
+			break
+		}
+	}
+}
+
+// cameraRay generates the camera ray of the sample sampler has started.
+func (r *Renderer) cameraRay(pixel *Pixel, sampler Sampler) Ray {
+	s := &r.Settings
+	width, height := float32(s.Width), float32(s.Height)
+	aspect := width / height
+
+	jitterX, jitterY := sampler.Get2D()
+	lensU, lensV := sampler.Get2D()
+
+	rx := (float32(pixel.X) + jitterX) / width
+	ry := (float32(pixel.Y) + jitterY) / height
+
+	px := (rx - 0.5) * 2
+	py := (ry - 0.5) * 2
+	return r.Scene.Camera.GenerateRay(px, py, aspect, lensU, lensV)
+}
+
+// addSample traces the camera ray and adds its colour to the pixel.
+func (r *Renderer) addSample(pixel *Pixel, sampler Sampler, ray Ray, camera *cameraHit) {
+	s := &r.Settings
+	var aov AOVSample
+	rayColor := TraceRay(ray, sampler, s.StepSize, r.BVH, s.MaxSteps, s.Bounces, s.ScatterRays, r.VNMU, s.Ambient, r.Scene, 0, Vec3{}, false, NewRefractiveIndexTracker(1.0), 1.0, &aov, camera)
+
+	pixel.AddSample(rayColor)
+	pixel.AOV.Add(&aov, rayColor)
+	r.Samples.Add(1)
+}
+
+// Run renders every tile on its own goroutine until all of them converge or
+// stop is closed. onPixel, if set, is called after every pixel visit.
+func (r *Renderer) Run(stop <-chan struct{}, onPixel func(pixel *Pixel)) {
+	var wg sync.WaitGroup
+	for _, tile := range r.Tiles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sampler, _ := NewSampler(r.Settings.Sampler, r.Settings.Seed, r.Settings.MaxSamplesPerPixel)
+			var packet *rayPacket
+			if r.Settings.Packets {
+				packet = new(rayPacket)
+			}
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				var pixel *Pixel
+				if r.Settings.Adaptive {
+					pixel = tile.GetNoisiestPixel(r.Settings.MaxSamplesPerPixel)
+				} else {
+					pixel = tile.GetLeastSampledPixel(r.Settings.MaxSamplesPerPixel)
+				}
+				if pixel == nil {
+					return
+				}
+				r.samplePixel(pixel, sampler, packet)
+				if onPixel != nil {
+					onPixel(pixel)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Reset throws away every accumulated sample. Workers must be stopped.
+func (r *Renderer) Reset() {
+	for y := range r.Pixels {
+		for x := range r.Pixels[y] {
+			r.Pixels[y][x].Reset()
+		}
+	}
+	r.Samples.Store(0)
+}
+
+// ImageX maps a pixel column to its image column; the camera's right vector
+// points the other way from the image X axis.
+func (r *Renderer) ImageX(x uint32) int {
+	return r.Settings.Width - 1 - int(x)
+}
+
+// SetToneMapping changes the display transform. It only affects how the
+// accumulated radiance is shown, so it is safe to call while rendering.
+func (r *Renderer) SetToneMapping(t ToneMapping) {
+	r.toneMapping.Store(&t)
+}
+
+func (r *Renderer) ToneMapping() ToneMapping {
+	return *r.toneMapping.Load()
+}
+
+// PixelColor is the displayed colour of a pixel: the display AOV with the
+// current tone mapping.
+func (r *Renderer) PixelColor(pixel *Pixel) color.RGBA {
+	return r.AOVColor(r.DisplayAOV(), pixel)
+}
+
+// Draw resolves the whole pixel buffer into img as it is displayed.
+func (r *Renderer) Draw(img *image.RGBA) {
+	r.DrawAOV(img, r.DisplayAOV())
+}
+
+// Image resolves the beauty pass to an 8-bit image.
+func (r *Renderer) Image() *image.RGBA {
+	return r.AOVImage(AOVBeauty)
+}
+
+func (r *Renderer) AOVImage(aov AOV) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, r.Settings.Width, r.Settings.Height))
+	r.DrawAOV(img, aov)
+	return img
+}
@@ -19,6 +19,7 @@ type Pixel struct {
 	MinLuminance float32 // Add this
 	MaxLuminance float32 // Add this
 	Contrast     float32 // Add this
+	AOV          AOVAccumulator
 	Lock         sync.Mutex
 }
 
@@ -81,6 +82,30 @@ func (p *Pixel) AddSample(color Vec3) {
 	p.Contrast = p.MaxLuminance - p.MinLuminance
 }
 
+// Average is the running mean of the samples taken so far.
+func (p *Pixel) Average() Vec3 {
+	if p.SampleCount == 0 {
+		return Vec3{}
+	}
+	n := float32(p.SampleCount)
+	return Vec3{X: p.R / n, Y: p.G / n, Z: p.B / n}
+}
+
+// Reset clears the accumulated samples but keeps the pixel's position.
+func (p *Pixel) Reset() {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	p.R, p.G, p.B = 0, 0, 0
+	p.SampleCount = 0
+	p.Variance = 0
+	p.M2 = Vec3{}
+	p.Mean = Vec3{}
+	p.MinLuminance, p.MaxLuminance = 0, 0
+	p.Contrast = 0
+	p.AOV.Reset()
+}
+
 func colorToLuminance(color Vec3) float32 {
 	return 0.2126*color.X + 0.7152*color.Y + 0.0722*color.Z
 }
@@ -168,6 +193,18 @@ func SampleBumpMap(img *CachedImage, x, y float32, strength float32) Vec3 {
 	return normal.Normalize() // This is essential!
 }
 
+// SampleNormalMap returns the tangent-space normal stored in img at x, y,
+// with the bitangent along +y, down the image, as TransformNormalToWorldSpace
+// takes it. The X and Y of the normal are scaled by scale.
+func SampleNormalMap(img *CachedImage, x, y float32, scale float32) Vec3 {
+	c := SampleDiffuseMap(img, x, y)
+	return Vec3{
+		X: (float32(c.R)/255*2 - 1) * scale,
+		Y: -(float32(c.G)/255*2 - 1) * scale,
+		Z: float32(c.B)/255*2 - 1,
+	}.Normalize()
+}
+
 func SampleBumpMap2(img *CachedImage, x, y float32, strength float32) Vec3 {
 	// x = (math.Mod(float32(x), 1))
 	// y = (math.Mod(float32(y), 1))
@@ -290,37 +327,30 @@ func TransformNormalToWorldSpace(tangentNormal, worldNormal Vec3, tri *BVHTriang
 	return worldSpaceNormal.Normalize()
 }
 
-func DecomposeObjects(objects []*GameObject[any]) ([]Vec3, []int, []Vec3, []*Material, []float32, []EmissiveTriangle) {
-	vertices := make([]Vec3, 0)
-	tris := make([]int, 0)
-	normals := make([]Vec3, 0)
-	materials := make([]*Material, 0)
-	uvs := make([]float32, 0)
-
+// DecomposeObjects lays the objects' meshes out in flat arrays, offsetting
+// each mesh's triangle indices by its first vertex. A mesh shared by several
+// objects is laid out once; offsets maps it to the position of its first
+// triangle in tris. UVs are padded so every triangle has six.
+func DecomposeObjects(objects []*GameObject[any]) (vertices []Vec3, tris []int, normals []Vec3, materials []*Material, uvs []float32, offsets map[*Mesh]int) {
+	offsets = make(map[*Mesh]int)
 	for _, object := range objects {
-		for _, v := range object.Mesh.Vertices {
-			vertices = append(vertices, v.Add(object.Position))
+		mesh := object.Mesh
+		if _, ok := offsets[mesh]; ok {
+			continue
 		}
+		offsets[mesh] = len(tris)
 
-		tris = append(tris, object.Mesh.Tris...)
-		normals = append(normals, object.Mesh.Normals...)
-		materials = append(materials, object.Mesh.Materials...)
-		uvs = append(uvs, object.Mesh.UVs...)
-	}
-
-	emissives := make([]EmissiveTriangle, 0)
-	for i := 0; i < len(tris); i += 3 {
-		if materials[i/3].Emissive.R > 0 || materials[i/3].Emissive.G > 0 || materials[i/3].Emissive.B > 0 {
-			tri := EmissiveTriangle{
-				VertexIndices: [3]int{tris[i], tris[i+1], tris[i+2]},
-				NormalIndices: [3]int{i, i + 1, i + 2},
-				MaterialIndex: i / 3,
-			}
-			emissives = append(emissives, tri)
+		base := len(vertices)
+		vertices = append(vertices, mesh.Vertices...)
+		for _, index := range mesh.Tris {
+			tris = append(tris, base+index)
 		}
+		normals = append(normals, mesh.Normals...)
+		materials = append(materials, mesh.Materials...)
+		uvs = append(uvs, mesh.UVs...)
+		uvs = append(uvs, make([]float32, max(0, len(tris)/3*6-len(uvs)))...)
 	}
-
-	return vertices, tris, normals, materials, uvs, emissives
+	return vertices, tris, normals, materials, uvs, offsets
 }
 
 func MISWeight(pdf1, pdf2 float32) float32 {
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Sampler supplies the random numbers of one camera sample. StartSample
+// seeds it for a pixel and sample index; after that every Get1D or Get2D
+// call consumes the next dimension. The same seed, pixel and index always
+// give the same sequence, no matter which goroutine draws it.
+//
+// A Sampler is not safe for concurrent use; every render goroutine owns one.
+type Sampler interface {
+	StartSample(x, y uint32, index int)
+	Get1D() float32
+	Get2D() (float32, float32)
+}
+
+// SamplerNames lists the samplers accepted by NewSampler.
+var SamplerNames = []string{"independent", "stratified", "halton", "sobol"}
+
+// NewSampler creates a sampler of the named kind. samplesPerPixel is the
+// expected sample count, used by the stratified sampler to size its strata.
+func NewSampler(name string, seed uint64, samplesPerPixel int) (Sampler, error) {
+	switch strings.ToLower(name) {
+	case "independent":
+		return &IndependentSampler{Seed: seed}, nil
+	case "stratified":
+		return &StratifiedSampler{Seed: seed, SamplesPerPixel: max(1, samplesPerPixel)}, nil
+	case "halton":
+		return &HaltonSampler{Seed: seed}, nil
+	case "sobol":
+		return &SobolSampler{Seed: seed}, nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q (want %s)", name, strings.Join(SamplerNames, ", "))
+}
+
+// ------------------------------------------------------------
+
+// hash64 is the SplitMix64 finaliser.
+func hash64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// pixelHash mixes the seed, pixel and an extra value into one key.
+func pixelHash(seed uint64, x, y uint32, extra uint64) uint64 {
+	h := hash64(seed ^ 0x9e3779b97f4a7c15)
+	h = hash64(h ^ (uint64(x) | uint64(y)<<32))
+	return hash64(h ^ extra)
+}
+
+// toFloat maps 32 random bits to [0, 1) without ever reaching 1.
+func toFloat(bits uint32) float32 {
+	return float32(bits>>8) * (1.0 / (1 << 24))
+}
+
+// rng is a PCG32 generator.
+type rng struct {
+	state uint64
+}
+
+func (r *rng) seed(s uint64) {
+	r.state = hash64(s)
+}
+
+func (r *rng) Uint32() uint32 {
+	old := r.state
+	r.state = old*6364136223846793005 + 1442695040888963407
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	rot := uint32(old >> 59)
+	return bits.RotateLeft32(xorshifted, -int(rot))
+}
+
+func (r *rng) Float32() float32 {
+	return toFloat(r.Uint32())
+}
+
+// ------------------------------------------------------------
+
+// IndependentSampler draws uniform random numbers from a generator seeded
+// per pixel and sample.
+type IndependentSampler struct {
+	Seed uint64
+	rng  rng
+}
+
+func (s *IndependentSampler) StartSample(x, y uint32, index int) {
+	s.rng.seed(pixelHash(s.Seed, x, y, uint64(index)))
+}
+
+func (s *IndependentSampler) Get1D() float32 { return s.rng.Float32() }
+
+func (s *IndependentSampler) Get2D() (float32, float32) {
+	return s.rng.Float32(), s.rng.Float32()
+}
+
+// ------------------------------------------------------------
+
+// StratifiedSampler jitters samples inside strata. Every dimension splits
+// [0, 1) into SamplesPerPixel strata (a square grid for 2D draws) and visits
+// them in a per-pixel, per-dimension random order, so each run of
+// SamplesPerPixel consecutive samples covers every stratum once.
+type StratifiedSampler struct {
+	Seed            uint64
+	SamplesPerPixel int
+
+	x, y      uint32
+	index     int
+	dimension uint64
+	jitter    rng
+}
+
+func (s *StratifiedSampler) StartSample(x, y uint32, index int) {
+	s.x, s.y, s.index = x, y, index
+	s.dimension = 0
+	s.jitter.seed(pixelHash(s.Seed, x, y, uint64(index)))
+}
+
+// stratum returns this sample's stratum out of count for the next
+// dimension.
+func (s *StratifiedSampler) stratum(count int) uint32 {
+	key := pixelHash(s.Seed, s.x, s.y, s.dimension|1<<63)
+	s.dimension++
+
+	// Each pass over the strata gets its own order.
+	pass := uint64(s.index / count)
+	return permute(uint32(s.index%count), uint32(count), uint32(hash64(key^pass)))
+}
+
+func (s *StratifiedSampler) Get1D() float32 {
+	n := s.SamplesPerPixel
+	stratum := s.stratum(n)
+	return min((float32(stratum)+s.jitter.Float32())/float32(n), oneMinusEpsilon)
+}
+
+func (s *StratifiedSampler) Get2D() (float32, float32) {
+	side := 1
+	for (side+1)*(side+1) <= s.SamplesPerPixel {
+		side++
+	}
+	stratum := s.stratum(side * side)
+	sx, sy := int(stratum)%side, int(stratum)/side
+	u := (float32(sx) + s.jitter.Float32()) / float32(side)
+	v := (float32(sy) + s.jitter.Float32()) / float32(side)
+	return min(u, oneMinusEpsilon), min(v, oneMinusEpsilon)
+}
+
+const oneMinusEpsilon = 0x1.fffffep-1
+
+// permute returns element i of a random permutation of [0, n), chosen by
+// seed. This is Kensler's hash-based permutation from "Correlated
+// Multi-Jittered Sampling".
+func permute(i, n, seed uint32) uint32 {
+	w := n - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	for {
+		i ^= seed
+		i *= 0xe170893d
+		i ^= seed >> 16
+		i ^= (i & w) >> 4
+		i ^= seed >> 8
+		i *= 0x0929eb3f
+		i ^= seed >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | seed>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < n {
+			break
+		}
+	}
+	return (i + seed) % n
+}
+
+// ------------------------------------------------------------
+
+// haltonPrimes are the bases of the Halton dimensions. Dimensions past the
+// table fall back to independent random numbers.
+var haltonPrimes = [...]uint32{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53,
+	59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+}
+
+// HaltonSampler uses the Halton sequence indexed by sample number, with a
+// per-pixel Cranley-Patterson rotation so neighbouring pixels do not share
+// the same points.
+type HaltonSampler struct {
+	Seed uint64
+
+	x, y      uint32
+	index     int
+	dimension int
+	fallback  rng
+}
+
+func (s *HaltonSampler) StartSample(x, y uint32, index int) {
+	s.x, s.y, s.index = x, y, index
+	s.dimension = 0
+	s.fallback.seed(pixelHash(s.Seed, x, y, uint64(index)))
+}
+
+func (s *HaltonSampler) Get1D() float32 {
+	d := s.dimension
+	s.dimension++
+	if d >= len(haltonPrimes) {
+		return s.fallback.Float32()
+	}
+
+	value := radicalInverse(uint64(s.index), haltonPrimes[d])
+	shift := float64(toFloat(uint32(pixelHash(s.Seed, s.x, s.y, uint64(d)|1<<62))))
+	value += shift
+	if value >= 1 {
+		value -= 1
+	}
+	return min(float32(value), oneMinusEpsilon)
+}
+
+func (s *HaltonSampler) Get2D() (float32, float32) {
+	return s.Get1D(), s.Get1D()
+}
+
+func radicalInverse(index uint64, base uint32) float64 {
+	b := uint64(base)
+	inverse := 1.0 / float64(base)
+	var reversed uint64
+	factor := 1.0
+	for index > 0 {
+		next := index / b
+		reversed = reversed*b + (index - next*b)
+		factor *= inverse
+		index = next
+	}
+	return float64(reversed) * factor
+}
+
+// ------------------------------------------------------------
+
+// SobolSampler draws every pair of dimensions from the first two Sobol
+// dimensions, Owen-scrambled and index-shuffled with hashes of the pixel and
+// dimension (Burley, "Practical Hash-based Owen Scrambling", 2020). This
+// keeps the stratification of (0, 2)-sequences in every 2D projection
+// without needing high-dimensional direction numbers.
+type SobolSampler struct {
+	Seed uint64
+
+	x, y      uint32
+	index     uint32
+	dimension uint64
+}
+
+func (s *SobolSampler) StartSample(x, y uint32, index int) {
+	s.x, s.y, s.index = x, y, uint32(index)
+	s.dimension = 0
+}
+
+func (s *SobolSampler) next() (uint32, uint32) {
+	key := pixelHash(s.Seed, s.x, s.y, s.dimension|1<<61)
+	s.dimension++
+
+	index := nestedUniformScramble(s.index, uint32(key))
+	a := nestedUniformScramble(sobol0(index), uint32(hash64(key^1)))
+	b := nestedUniformScramble(sobol1(index), uint32(hash64(key^2)))
+	return a, b
+}
+
+func (s *SobolSampler) Get1D() float32 {
+	a, _ := s.next()
+	return toFloat(a)
+}
+
+func (s *SobolSampler) Get2D() (float32, float32) {
+	a, b := s.next()
+	return toFloat(a), toFloat(b)
+}
+
+// sobol0 is the first Sobol dimension, the base-2 van der Corput sequence.
+func sobol0(index uint32) uint32 {
+	return bits.Reverse32(index)
+}
+
+// sobol1 is the second Sobol dimension, generated by the polynomial x + 1.
+func sobol1(index uint32) uint32 {
+	var result uint32
+	for v := uint32(1 << 31); index != 0; index >>= 1 {
+		if index&1 != 0 {
+			result ^= v
+		}
+		v ^= v >> 1
+	}
+	return result
+}
+
+func laineKarrasPermutation(x, seed uint32) uint32 {
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return x
+}
+
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x = laineKarrasPermutation(x, seed)
+	return bits.Reverse32(x)
+}
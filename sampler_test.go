@@ -0,0 +1,235 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// draws returns the first dimensions of a sample: three 1D draws and three
+// 2D draws, interleaved as the tracer does.
+func draws(s Sampler, x, y uint32, index int) [9]float32 {
+	s.StartSample(x, y, index)
+	var values [9]float32
+	for i := 0; i < len(values); i += 3 {
+		values[i] = s.Get1D()
+		values[i+1], values[i+2] = s.Get2D()
+	}
+	return values
+}
+
+func newTestSampler(t *testing.T, name string, seed uint64, samplesPerPixel int) Sampler {
+	t.Helper()
+	sampler, err := NewSampler(name, seed, samplesPerPixel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sampler
+}
+
+func TestNewSampler(t *testing.T) {
+	for _, name := range SamplerNames {
+		if _, err := NewSampler(name, 1, 16); err != nil {
+			t.Errorf("NewSampler(%q): %v", name, err)
+		}
+	}
+	if _, err := NewSampler("Sobol", 1, 16); err != nil {
+		t.Errorf("NewSampler is case sensitive: %v", err)
+	}
+	for _, name := range []string{"", "random", "pmj02"} {
+		if _, err := NewSampler(name, 1, 16); err == nil {
+			t.Errorf("NewSampler(%q) succeeded", name)
+		}
+	}
+}
+
+func TestSamplerRange(t *testing.T) {
+	pixels := [][2]uint32{{0, 0}, {1, 0}, {639, 479}, {math.MaxUint32, math.MaxUint32}}
+	for _, name := range SamplerNames {
+		sampler := newTestSampler(t, name, 7, 16)
+		for _, pixel := range pixels {
+			for _, index := range []int{0, 1, 15, 16, 1000, 1 << 20} {
+				sampler.StartSample(pixel[0], pixel[1], index)
+				// Past the Halton table, into its fallback.
+				for dimension := range 80 {
+					var values []float32
+					if dimension%2 == 0 {
+						values = []float32{sampler.Get1D()}
+					} else {
+						u, v := sampler.Get2D()
+						values = []float32{u, v}
+					}
+					for _, v := range values {
+						if !(v >= 0 && v < 1) {
+							t.Fatalf("%s: pixel %v, sample %d, dimension %d gives %g", name, pixel, index, dimension, v)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// A sample's numbers depend on the seed, pixel and index alone, not on
+// what the sampler drew before, so renders do not depend on how pixels are
+// shared between goroutines.
+func TestSamplerDeterministic(t *testing.T) {
+	type key struct {
+		x, y  uint32
+		index int
+	}
+	var keys []key
+	for y := range uint32(3) {
+		for x := range uint32(3) {
+			for index := range 20 {
+				keys = append(keys, key{x, y, index})
+			}
+		}
+	}
+
+	for _, name := range SamplerNames {
+		inOrder := newTestSampler(t, name, 3, 16)
+		want := make(map[key][9]float32)
+		for _, k := range keys {
+			want[k] = draws(inOrder, k.x, k.y, k.index)
+		}
+
+		// Shuffled, with a sample abandoned halfway now and then.
+		shuffled := newTestSampler(t, name, 3, 16)
+		rng := rand.New(rand.NewPCG(1, 1))
+		for _, i := range rng.Perm(len(keys)) {
+			k := keys[i]
+			if i%5 == 0 {
+				shuffled.StartSample(k.y, k.x, k.index+1)
+				shuffled.Get2D()
+			}
+			if got := draws(shuffled, k.x, k.y, k.index); got != want[k] {
+				t.Fatalf("%s: pixel %d,%d sample %d gives %v out of order, %v in order", name, k.x, k.y, k.index, got, want[k])
+			}
+		}
+
+		other := newTestSampler(t, name, 4, 16)
+		if draws(other, 0, 0, 0) == want[key{0, 0, 0}] {
+			t.Errorf("%s: seeds 3 and 4 give the same sample", name)
+		}
+	}
+}
+
+// Pixels must not share their points: for a given sample index and
+// dimension, the values across pixels are spread uniformly, and the
+// sequences of neighbouring pixels are uncorrelated on average.
+func TestSamplerDecorrelatesPixels(t *testing.T) {
+	const width, height, samples = 32, 32, 64
+	for _, name := range SamplerNames {
+		sampler := newTestSampler(t, name, 5, samples)
+		values := make([][][9]float32, width*height)
+		for p := range values {
+			values[p] = make([][9]float32, samples)
+			for index := range samples {
+				values[p][index] = draws(sampler, uint32(p%width), uint32(p/width), index)
+			}
+		}
+
+		for _, index := range []int{0, 1, samples - 1} {
+			for dimension := range 9 {
+				var bins [8]int
+				for p := range values {
+					bins[int(values[p][index][dimension]*8)]++
+				}
+				for bin, count := range bins {
+					if expected := width * height / 8; count < expected/2 || count > expected*3/2 {
+						t.Errorf("%s: sample %d, dimension %d: %d of %d pixels in bin %d",
+							name, index, dimension, count, width*height, bin)
+					}
+				}
+			}
+		}
+
+		for dimension := range 9 {
+			var sum float64
+			for p := range values {
+				neighbour := p ^ 1
+				sum += correlation(values[p], values[neighbour], dimension)
+			}
+			if mean := sum / float64(len(values)); math.Abs(mean) > 0.05 {
+				t.Errorf("%s: dimension %d: neighbouring pixels correlate by %.3f on average", name, dimension, mean)
+			}
+		}
+	}
+}
+
+// correlation is Pearson's correlation of one dimension of two sequences
+// of samples.
+func correlation(a, b [][9]float32, dimension int) float64 {
+	var sumA, sumB, sumAB, sumAA, sumBB float64
+	for i := range a {
+		x, y := float64(a[i][dimension]), float64(b[i][dimension])
+		sumA += x
+		sumB += y
+		sumAB += x * y
+		sumAA += x * x
+		sumBB += y * y
+	}
+	n := float64(len(a))
+	return (n*sumAB - sumA*sumB) / math.Sqrt((n*sumAA-sumA*sumA)*(n*sumBB-sumB*sumB))
+}
+
+// N samples of the stratified, Halton and Sobol samplers put exactly one
+// point in each 1/N interval of the first dimension.
+func TestSamplerStratification(t *testing.T) {
+	for _, name := range []string{"stratified", "halton", "sobol"} {
+		for _, n := range []int{4, 16, 64, 256} {
+			sampler := newTestSampler(t, name, 9, n)
+			for _, pixel := range [][2]uint32{{0, 0}, {17, 3}, {1000, 2000}} {
+				// The stratified sampler's second pass is a new permutation
+				// of the same strata.
+				passes := 1
+				if name == "stratified" {
+					passes = 2
+				}
+				for pass := range passes {
+					seen := make([]bool, n)
+					for index := pass * n; index < (pass+1)*n; index++ {
+						sampler.StartSample(pixel[0], pixel[1], index)
+						interval := int(sampler.Get1D() * float32(n))
+						if seen[interval] {
+							t.Fatalf("%s, %d samples: pixel %v has two points in interval %d", name, n, pixel, interval)
+						}
+						seen[interval] = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// Every 2D draw of the Sobol sampler is a (0, m, 2)-net: with 2^m samples
+// each elementary interval of area 2^-m holds exactly one point.
+func TestSobolElementaryIntervals(t *testing.T) {
+	const m = 6
+	const n = 1 << m
+	sampler := newTestSampler(t, "sobol", 11, n)
+	for _, pixel := range [][2]uint32{{0, 0}, {5, 9}} {
+		for dimension := range 4 {
+			points := make([][2]float32, n)
+			for index := range points {
+				sampler.StartSample(pixel[0], pixel[1], index)
+				for range dimension {
+					sampler.Get2D()
+				}
+				points[index][0], points[index][1] = sampler.Get2D()
+			}
+			for a := 0; a <= m; a++ {
+				columns, rows := 1<<a, 1<<(m-a)
+				seen := make([]bool, n)
+				for _, p := range points {
+					cell := int(p[1]*float32(rows))*columns + int(p[0]*float32(columns))
+					if seen[cell] {
+						t.Fatalf("pixel %v, draw %d: two points in a cell of the %dx%d grid", pixel, dimension, columns, rows)
+					}
+					seen[cell] = true
+				}
+			}
+		}
+	}
+}
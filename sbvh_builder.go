@@ -0,0 +1,452 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/chewxy/math32"
+)
+
+// spatialBox is a box with its bounds indexed by axis, as the spatial
+// split builder cuts along any of them. Empty boxes have lo above hi.
+type spatialBox struct {
+	lo, hi [3]float32
+}
+
+func emptySpatialBox() spatialBox {
+	return spatialBox{lo: [3]float32{INF, INF, INF}, hi: [3]float32{-INF, -INF, -INF}}
+}
+
+func entrySpatialBox(entry *BVHTriangle) spatialBox {
+	return spatialBox{
+		lo: [3]float32{entry.MinX, entry.MinY, entry.MinZ},
+		hi: [3]float32{entry.MaxX, entry.MaxY, entry.MaxZ},
+	}
+}
+
+func (b *spatialBox) empty() bool {
+	return b.lo[0] > b.hi[0] || b.lo[1] > b.hi[1] || b.lo[2] > b.hi[2]
+}
+
+// grow and intersect compare rather than use min and max, which are
+// slower for the NaNs they have to handle; they are most of the build.
+func (b *spatialBox) grow(lo, hi [3]float32) {
+	for axis := range 3 {
+		if lo[axis] < b.lo[axis] {
+			b.lo[axis] = lo[axis]
+		}
+		if hi[axis] > b.hi[axis] {
+			b.hi[axis] = hi[axis]
+		}
+	}
+}
+
+func (b *spatialBox) union(other spatialBox) {
+	b.grow(other.lo, other.hi)
+}
+
+func (b *spatialBox) intersect(other spatialBox) {
+	for axis := range 3 {
+		if other.lo[axis] > b.lo[axis] {
+			b.lo[axis] = other.lo[axis]
+		}
+		if other.hi[axis] < b.hi[axis] {
+			b.hi[axis] = other.hi[axis]
+		}
+	}
+}
+
+func (b *spatialBox) area() float32 {
+	if b.empty() {
+		return 0
+	}
+	dx, dy, dz := b.hi[0]-b.lo[0], b.hi[1]-b.lo[1], b.hi[2]-b.lo[2]
+	return 2 * (dx*dy + dy*dz + dz*dx)
+}
+
+func (b *spatialBox) center(axis int) float32 {
+	return (b.lo[axis] + b.hi[axis]) * 0.5
+}
+
+// spatialRef is the part of an entry within box. Spatial splits clip
+// entries, so one entry can have several references.
+type spatialRef struct {
+	entry *BVHTriangle
+	box   spatialBox
+}
+
+// clipMargin widens the boxes of clipped triangles by this fraction of
+// the coordinates involved, so rounding while clipping never leaves part
+// of a triangle outside its box.
+const clipMargin = 1e-6
+
+// split returns the parts of ref on either side of the plane at pos along
+// axis. A part is empty if nothing of the entry lies on its side. Only
+// triangles are clipped exactly; other entries keep the part of their box.
+func (ref *spatialRef) split(axis int, pos float32) (left, right spatialRef) {
+	left, right = spatialRef{entry: ref.entry, box: ref.box}, spatialRef{entry: ref.entry, box: ref.box}
+	if ref.entry.Primitive != nil {
+		left.box.hi[axis] = min(left.box.hi[axis], pos)
+		right.box.lo[axis] = max(right.box.lo[axis], pos)
+		return left, right
+	}
+
+	leftBox, rightBox := emptySpatialBox(), emptySpatialBox()
+	corners := [3][3]float32{
+		{ref.entry.A.X, ref.entry.A.Y, ref.entry.A.Z},
+		{ref.entry.B.X, ref.entry.B.Y, ref.entry.B.Z},
+		{ref.entry.C.X, ref.entry.C.Y, ref.entry.C.Z},
+	}
+	for i, p := range corners {
+		if p[axis] <= pos {
+			leftBox.grow(p, p)
+		}
+		if p[axis] >= pos {
+			rightBox.grow(p, p)
+		}
+		q := corners[(i+1)%3]
+		if !(p[axis] < pos && pos < q[axis]) && !(q[axis] < pos && pos < p[axis]) {
+			continue
+		}
+		// The edge crosses the plane.
+		t := (pos - p[axis]) / (q[axis] - p[axis])
+		var lo, hi [3]float32
+		for k := range 3 {
+			x := p[k] + t*(q[k]-p[k])
+			margin := clipMargin * (math32.Abs(x) + math32.Abs(q[k]-p[k]))
+			lo[k], hi[k] = x-margin, x+margin
+		}
+		lo[axis], hi[axis] = pos, pos
+		leftBox.grow(lo, hi)
+		rightBox.grow(lo, hi)
+	}
+	left.box.intersect(leftBox)
+	right.box.intersect(rightBox)
+	return left, right
+}
+
+// spatialSplitOverlap is how much the children of the best object split
+// must overlap, relative to the root's area, before spatial splits are
+// tried; below it they rarely pay off.
+const spatialSplitOverlap = 1e-5
+
+// splitChoice is a candidate split of a node along axis: of the centroids
+// after bin split, or of space at plane. The boxes and counts are those of
+// the children.
+type splitChoice struct {
+	axis        int
+	split       int
+	plane       float32
+	cost        float32
+	left, right spatialBox
+	leftCount   int
+	rightCount  int
+}
+
+// spatialBins is the scratch space of one building goroutine: bins along
+// each axis, with the references that start and end in them.
+type spatialBins struct {
+	bins           [3][]spatialBox
+	entries, exits [3][]int
+	rightBox       []spatialBox
+	rightCount     []int
+}
+
+func (o *BVHOptions) newSpatialBins() *spatialBins {
+	s := &spatialBins{rightBox: make([]spatialBox, o.Bins), rightCount: make([]int, o.Bins)}
+	for axis := range 3 {
+		s.bins[axis] = make([]spatialBox, o.Bins)
+		s.entries[axis] = make([]int, o.Bins)
+		s.exits[axis] = make([]int, o.Bins)
+	}
+	return s
+}
+
+func (s *spatialBins) reset() {
+	for axis := range 3 {
+		for i := range s.bins[axis] {
+			s.bins[axis][i] = emptySpatialBox()
+		}
+		clear(s.entries[axis])
+		clear(s.exits[axis])
+	}
+}
+
+// spatialBuilder builds a BVH with spatial splits, after Stich, Friedrich
+// and Dietrich, "Spatial Splits in Bounding Volume Hierarchies" (2009).
+type spatialBuilder struct {
+	*BVHOptions
+	rootArea float32
+}
+
+// buildSpatial is BuildBVH with spatial splits: a node may also be split
+// by a plane, with the entries that cross it clipped into both children,
+// whichever of the two is cheaper by the SAH. Boxes fit long, thin or
+// slanted triangles much tighter, at the price of entries appearing in
+// more than one leaf; at most SpatialSplitBudget times the entries are
+// added. The budget is shared out between the children of every node in
+// proportion to their size, so the tree does not depend on the order
+// subtrees are built in.
+func (o *BVHOptions) buildSpatial(entries []*BVHTriangle) *Box {
+	refs := make([]spatialRef, len(entries))
+	root := emptySpatialBox()
+	for i, entry := range entries {
+		refs[i] = spatialRef{entry: entry, box: entrySpatialBox(entry)}
+		root.union(refs[i].box)
+	}
+	b := &spatialBuilder{BVHOptions: o, rootArea: root.area()}
+	budget := int(o.SpatialSplitBudget * float32(len(entries)))
+	return b.build(refs, budget, 0, o.newSpatialBins())
+}
+
+func (b *spatialBuilder) build(refs []spatialRef, budget, depth int, scratch *spatialBins) *Box {
+	bounds, centroids := emptySpatialBox(), emptySpatialBox()
+	for _, ref := range refs {
+		bounds.union(ref.box)
+		c := [3]float32{ref.box.center(0), ref.box.center(1), ref.box.center(2)}
+		centroids.grow(c, c)
+	}
+
+	box := &Box{}
+	if len(refs) > 0 {
+		box.X1, box.Y1, box.Z1 = bounds.lo[0], bounds.lo[1], bounds.lo[2]
+		box.X2, box.Y2, box.Z2 = bounds.hi[0], bounds.hi[1], bounds.hi[2]
+	}
+	leaf := func() *Box {
+		box.IsLeaf = true
+		box.Trianges = make([]*BVHTriangle, len(refs))
+		for i, ref := range refs {
+			box.Trianges[i] = ref.entry
+		}
+		return box
+	}
+
+	n := len(refs)
+	if n <= 1 || depth >= maxBVHDepth {
+		return leaf()
+	}
+	area := bounds.area()
+	best := b.objectSplit(refs, area, centroids, scratch)
+	spatial := false
+	if budget > 0 && b.rootArea > 0 {
+		overlap := best.left
+		overlap.intersect(best.right)
+		if best.axis < 0 || overlap.area()/b.rootArea > spatialSplitOverlap {
+			if choice := b.spatialSplit(refs, bounds, area, budget, scratch); choice.axis >= 0 && choice.cost < best.cost {
+				best, spatial = choice, true
+			}
+		}
+	}
+	if best.axis < 0 || (best.cost >= b.IntersectionCost*float32(n) && n <= b.MaxLeafSize) {
+		return leaf()
+	}
+
+	var left, right []spatialRef
+	if spatial {
+		var added int
+		left, right, added = b.partitionSpatial(refs, best, budget)
+		budget -= added
+	} else {
+		left, right = b.partitionObject(refs, best, centroids)
+	}
+	if len(left) == 0 || len(right) == 0 {
+		return leaf()
+	}
+	leftBudget := budget * len(left) / (len(left) + len(right))
+
+	box.Children = make([]*Box, 2)
+	if n >= parallelBuildSize {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			box.Children[0] = b.build(left, leftBudget, depth+1, b.newSpatialBins())
+		}()
+		box.Children[1] = b.build(right, budget-leftBudget, depth+1, scratch)
+		wg.Wait()
+	} else {
+		box.Children[0] = b.build(left, leftBudget, depth+1, scratch)
+		box.Children[1] = b.build(right, budget-leftBudget, depth+1, scratch)
+	}
+	return box
+}
+
+// objectSplit finds the cheapest split between bins of the references'
+// centroids, like findSplit. Its axis is -1 if the centroids cannot be
+// told apart.
+func (b *spatialBuilder) objectSplit(refs []spatialRef, area float32, centroids spatialBox, scratch *spatialBins) splitChoice {
+	scratch.reset()
+	var scale [3]float32
+	for axis := range 3 {
+		if extent := centroids.hi[axis] - centroids.lo[axis]; extent > 0 {
+			scale[axis] = float32(b.Bins) / extent
+		}
+	}
+	for _, ref := range refs {
+		for axis := range 3 {
+			i := b.bin(ref.box.center(axis), centroids.lo[axis], scale[axis])
+			scratch.bins[axis][i].union(ref.box)
+			scratch.entries[axis][i]++
+		}
+	}
+
+	best := splitChoice{axis: -1, cost: INF, left: emptySpatialBox(), right: emptySpatialBox()}
+	for axis := range 3 {
+		if scale[axis] > 0 {
+			b.sweep(axis, area, scratch.entries[axis], scratch.entries[axis], len(refs), scratch, &best)
+		}
+	}
+	return best
+}
+
+// spatialSplit finds the cheapest plane between bins of the node's bounds,
+// counting the references that cross it on both sides, among the planes
+// that add at most budget references.
+func (b *spatialBuilder) spatialSplit(refs []spatialRef, bounds spatialBox, area float32, budget int, scratch *spatialBins) splitChoice {
+	scratch.reset()
+	var scale [3]float32
+	for axis := range 3 {
+		if extent := bounds.hi[axis] - bounds.lo[axis]; extent > 0 {
+			scale[axis] = float32(b.Bins) / extent
+		}
+	}
+	for _, ref := range refs {
+		for axis := range 3 {
+			if scale[axis] == 0 {
+				continue
+			}
+			first := b.bin(ref.box.lo[axis], bounds.lo[axis], scale[axis])
+			last := b.bin(ref.box.hi[axis], bounds.lo[axis], scale[axis])
+			rest := ref
+			for i := first; i < last; i++ {
+				part, next := rest.split(axis, b.plane(bounds, axis, i+1))
+				scratch.bins[axis][i].union(part.box)
+				rest = next
+			}
+			scratch.bins[axis][last].union(rest.box)
+			scratch.entries[axis][first]++
+			scratch.exits[axis][last]++
+		}
+	}
+
+	best := splitChoice{axis: -1, cost: INF}
+	for axis := range 3 {
+		if scale[axis] > 0 && b.sweep(axis, area, scratch.entries[axis], scratch.exits[axis], len(refs)+budget, scratch, &best) {
+			best.plane = b.plane(bounds, axis, best.split+1)
+		}
+	}
+	return best
+}
+
+// sweep updates best with the cheapest split of the bins along axis, and
+// reports whether it did. A bin's references are counted on the left from
+// the bin entries counts them in, and on the right up to the bin exits
+// counts them in; splits with more than limit references in all are
+// skipped.
+func (b *spatialBuilder) sweep(axis int, area float32, entries, exits []int, limit int, scratch *spatialBins, best *splitChoice) bool {
+	bins := scratch.bins[axis]
+	right, count := emptySpatialBox(), 0
+	for i := b.Bins - 1; i > 0; i-- {
+		right.union(bins[i])
+		count += exits[i]
+		scratch.rightBox[i], scratch.rightCount[i] = right, count
+	}
+	found := false
+	left, leftCount := emptySpatialBox(), 0
+	for i := 0; i < b.Bins-1; i++ {
+		left.union(bins[i])
+		leftCount += entries[i]
+		rightCount := scratch.rightCount[i+1]
+		if leftCount == 0 || rightCount == 0 || leftCount+rightCount > limit {
+			continue
+		}
+		c := b.TraversalCost + b.IntersectionCost*
+			(float32(leftCount)*left.area()+float32(rightCount)*scratch.rightBox[i+1].area())/area
+		if c < best.cost {
+			*best = splitChoice{
+				axis: axis, split: i, cost: c,
+				left: left, right: scratch.rightBox[i+1],
+				leftCount: leftCount, rightCount: rightCount,
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+func (b *spatialBuilder) bin(x, lo, scale float32) int {
+	return min(max(int((x-lo)*scale), 0), b.Bins-1)
+}
+
+// plane is the position of the plane before bin i of bounds along axis.
+func (b *spatialBuilder) plane(bounds spatialBox, axis, i int) float32 {
+	return bounds.lo[axis] + (bounds.hi[axis]-bounds.lo[axis])*float32(i)/float32(b.Bins)
+}
+
+// partitionObject moves the references left of the split to the front,
+// in place.
+func (b *spatialBuilder) partitionObject(refs []spatialRef, split splitChoice, centroids spatialBox) (left, right []spatialRef) {
+	scale := float32(b.Bins) / (centroids.hi[split.axis] - centroids.lo[split.axis])
+	mid := 0
+	for i, ref := range refs {
+		if b.bin(ref.box.center(split.axis), centroids.lo[split.axis], scale) <= split.split {
+			refs[i], refs[mid] = refs[mid], refs[i]
+			mid++
+		}
+	}
+	return refs[:mid], refs[mid:]
+}
+
+// partitionSpatial divides the references at the split's plane and clips
+// those that cross it. A crossing reference stays whole on one side
+// instead if that is cheaper, or if budget references have been added
+// already. It returns the number of references added.
+func (b *spatialBuilder) partitionSpatial(refs []spatialRef, split splitChoice, budget int) (left, right []spatialRef, added int) {
+	axis, plane := split.axis, split.plane
+	leftBox, rightBox := split.left, split.right
+	leftCount, rightCount := float32(split.leftCount), float32(split.rightCount)
+	left = make([]spatialRef, 0, split.leftCount)
+	right = make([]spatialRef, 0, split.rightCount)
+	for _, ref := range refs {
+		switch {
+		case ref.box.hi[axis] <= plane:
+			left = append(left, ref)
+			continue
+		case ref.box.lo[axis] >= plane:
+			right = append(right, ref)
+			continue
+		}
+
+		l, r := ref.split(axis, plane)
+		switch {
+		case l.box.empty():
+			right = append(right, r)
+			continue
+		case r.box.empty():
+			left = append(left, l)
+			continue
+		}
+
+		// Reference unsplitting: compare clipping with keeping the whole
+		// entry on either side.
+		wholeLeft, wholeRight := leftBox, rightBox
+		wholeLeft.union(ref.box)
+		wholeRight.union(ref.box)
+		splitCost := leftBox.area()*leftCount + rightBox.area()*rightCount
+		leftCost := wholeLeft.area()*leftCount + rightBox.area()*(rightCount-1)
+		rightCost := leftBox.area()*(leftCount-1) + wholeRight.area()*rightCount
+		switch {
+		case added < budget && splitCost <= min(leftCost, rightCost):
+			left, right = append(left, l), append(right, r)
+			added++
+		case leftCost <= rightCost:
+			left = append(left, ref)
+			leftBox = wholeLeft
+			rightCount--
+		default:
+			right = append(right, ref)
+			rightBox = wholeRight
+			leftCount--
+		}
+	}
+	return left, right, added
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// slantedEntries are long, thin triangles running diagonally through a
+// 20-unit cube, the kind spatial splits are for, followed by primitive
+// entries: spheres, boxes and discs of all sizes, many of them crossing
+// the triangles.
+func slantedEntries(rng *rand.Rand) []*BVHTriangle {
+	random := func(scale float32) Vec3 {
+		return Vec3{X: rng.Float32()*2 - 1, Y: rng.Float32()*2 - 1, Z: rng.Float32()*2 - 1}.Scale(scale)
+	}
+	mesh := &Mesh{}
+	for range 400 {
+		a, b := random(10), random(10)
+		c := a.Add(random(0.3))
+		mesh.Vertices = append(mesh.Vertices, a, b, c)
+		base := len(mesh.Vertices) - 3
+		mesh.Tris = append(mesh.Tris, base, base+1, base+2)
+	}
+	entries := TriangleEntries(mesh.Vertices, mesh.Tris, 0, len(mesh.Tris))
+
+	for i := range 60 {
+		var shape Primitive
+		switch i % 3 {
+		case 0:
+			shape = &Sphere{Position: random(8), Radius: 0.2 + 3*rng.Float32()}
+		case 1:
+			lo := random(8)
+			shape = &Cuboid{Min: lo, Max: lo.Add(Vec3{X: 6 * rng.Float32(), Y: 0.1, Z: 6 * rng.Float32()})}
+		case 2:
+			shape = NewDisc(random(8), random(1), Vec3{}, 0.5+4*rng.Float32())
+		}
+		lo, hi := shape.Bounds()
+		entries = append(entries, &BVHTriangle{
+			Index:     len(mesh.Tris) + 3*i,
+			Primitive: shape,
+			Centroid:  lo.Add(hi).Scale(0.5),
+			MinX:      lo.X, MaxX: hi.X,
+			MinY: lo.Y, MaxY: hi.Y,
+			MinZ: lo.Z, MaxZ: hi.Z,
+		})
+	}
+	return entries
+}
+
+// randomRays are rays from a shell around the 20-unit cube and from
+// inside it, towards random points in it.
+func randomRays(rng *rand.Rand, n int) []Ray {
+	point := func(radius float32) Vec3 {
+		v := Vec3{X: rng.Float32()*2 - 1, Y: rng.Float32()*2 - 1, Z: rng.Float32()*2 - 1}
+		return v.Normalize().Scale(radius * rng.Float32())
+	}
+	rays := make([]Ray, n)
+	for i := range rays {
+		origin := point(1).Normalize().Scale(40)
+		if i%2 == 1 {
+			origin = point(12)
+		}
+		rays[i] = Ray{Origin: origin, Direction: point(12).Sub(origin).Normalize()}
+	}
+	return rays
+}
+
+// references counts how often each entry appears in the leaves of bvh.
+func references(bvh *LinearBVH) map[*BVHTriangle]int {
+	counts := make(map[*BVHTriangle]int)
+	for _, entry := range bvh.Triangles {
+		counts[entry]++
+	}
+	return counts
+}
+
+// Spatial splits change the tree, never what a ray hits, for triangles
+// clipped into several leaves as well as for primitives, which are split
+// by their boxes alone.
+func TestSpatialSplitsMatchPlainBVH(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	entries := slantedEntries(rng)
+	plain := ConstructLinearBVH(BuildBVH(slices.Clone(entries), DefaultBVHOptions()))
+	options := DefaultBVHOptions()
+	options.SpatialSplitBudget = 1
+	spatial := ConstructLinearBVH(BuildBVH(slices.Clone(entries), options))
+
+	if len(spatial.Triangles) <= len(entries) || len(spatial.Triangles) > 2*len(entries) {
+		t.Fatalf("%d references to %d entries; want some duplicated, within the budget", len(spatial.Triangles), len(entries))
+	}
+	duplicatedTriangles, duplicatedPrimitives := 0, 0
+	for entry, count := range references(spatial) {
+		if count > 1 && entry.Primitive != nil {
+			duplicatedPrimitives++
+		} else if count > 1 {
+			duplicatedTriangles++
+		}
+	}
+	if duplicatedTriangles == 0 || duplicatedPrimitives == 0 {
+		t.Fatalf("%d triangles and %d primitives are in more than one leaf; the test needs both",
+			duplicatedTriangles, duplicatedPrimitives)
+	}
+	if got := len(references(spatial)); got != len(entries) {
+		t.Fatalf("the tree holds %d of the %d entries", got, len(entries))
+	}
+
+	rays, hits := randomRays(rng, 20000), 0
+	for i, ray := range rays {
+		wantHit, wantT, wantEntry := plain.CheckIntersection(ray, 100)
+		if wantHit {
+			hits++
+		}
+		hit, tHit, entry := spatial.CheckIntersection(ray, 100)
+		if hit != wantHit || tHit != wantT || entry != wantEntry {
+			t.Fatalf("ray %d hits %v at %g (%p) with spatial splits, %v at %g (%p) without",
+				i, hit, tHit, entry, wantHit, wantT, wantEntry)
+		}
+		for _, tMax := range []float32{wantT, 5} {
+			if got, want := spatial.QuickCheckIntersection(ray, tMax), plain.QuickCheckIntersection(ray, tMax); got != want {
+				t.Fatalf("ray %d is occluded within %g: %v with spatial splits, %v without", i, tMax, got, want)
+			}
+		}
+	}
+	if hits < len(rays)/10 || hits > len(rays)*19/20 {
+		t.Errorf("%d of %d rays hit; the rays do not test much", hits, len(rays))
+	}
+}
+
+func TestSpatialRefSplit(t *testing.T) {
+	triangle := TriangleEntries([]Vec3{{}, {X: 4}, {Y: 4}}, []int{0, 1, 2}, 0, 3)[0]
+	sphere := &Sphere{Radius: 2}
+	lo, hi := sphere.Bounds()
+	primitive := &BVHTriangle{Primitive: sphere, MinX: lo.X, MaxX: hi.X, MinY: lo.Y, MaxY: hi.Y, MinZ: lo.Z, MaxZ: hi.Z}
+
+	tests := []struct {
+		name        string
+		entry       *BVHTriangle
+		axis        int
+		pos         float32
+		left, right spatialBox
+	}{
+		// The triangle's corner above y = 1 is cut off on the left, and
+		// the part right of x = 1 is a smaller triangle.
+		{
+			"triangle", triangle, 0, 1,
+			spatialBox{lo: [3]float32{0, 0, 0}, hi: [3]float32{1, 4, 0}},
+			spatialBox{lo: [3]float32{1, 0, 0}, hi: [3]float32{4, 3, 0}},
+		},
+		{
+			"triangle past its corner", triangle, 1, 5,
+			spatialBox{lo: [3]float32{0, 0, 0}, hi: [3]float32{4, 4, 0}},
+			emptySpatialBox(),
+		},
+		// Primitives keep their box on either side of the plane.
+		{
+			"primitive", primitive, 2, 0.5,
+			spatialBox{lo: [3]float32{-2, -2, -2}, hi: [3]float32{2, 2, 0.5}},
+			spatialBox{lo: [3]float32{-2, -2, 0.5}, hi: [3]float32{2, 2, 2}},
+		},
+	}
+	sameBox := func(a, b spatialBox) bool {
+		if a.empty() || b.empty() {
+			return a.empty() == b.empty()
+		}
+		for axis := range 3 {
+			if d := a.lo[axis] - b.lo[axis]; d*d > 1e-10 {
+				return false
+			}
+			if d := a.hi[axis] - b.hi[axis]; d*d > 1e-10 {
+				return false
+			}
+		}
+		return true
+	}
+	for _, test := range tests {
+		ref := spatialRef{entry: test.entry, box: entrySpatialBox(test.entry)}
+		left, right := ref.split(test.axis, test.pos)
+		if left.entry != test.entry || right.entry != test.entry {
+			t.Errorf("%s: the parts lost their entry", test.name)
+		}
+		if !sameBox(left.box, test.left) || !sameBox(right.box, test.right) {
+			t.Errorf("%s: split into %v and %v, want %v and %v", test.name, left.box, right.box, test.left, test.right)
+		}
+	}
+}
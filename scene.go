@@ -3,7 +3,29 @@ package main
 type Scene struct {
 	Camera     *Camera
 	Meshes     []*GameObject[any]
+	Primitives []*PrimitiveObject
 	Lights     []*GameObject[Light]
 	Skybox     Skybox
 	BlackHoles []*BlackHole
+
+	// Orbit is set when the camera was placed on an orbit around a point.
+	Orbit *Orbit
+
+	// Animation is the camera path for frame sequences, if any.
+	Animation *Timeline
+
+	// Description is the canonical JSON of the scene file the scene was
+	// built from, if any. It feeds the checkpoint fingerprint.
+	Description []byte
+}
+
+// Orbit describes a camera position on a sphere around Center.
+type Orbit struct {
+	Center     Vec3
+	Radius     float32
+	Theta, Phi float32 // Radians
+}
+
+func (o *Orbit) Apply(camera *Camera) {
+	camera.SphericalAround(o.Center, o.Radius, o.Theta, o.Phi)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/chewxy/math32"
+)
+
+// Pick returns the object seen at the image pixel (x, y) and the depth of
+// the point hit along the camera's forward axis, or -1 if the pixel shows
+// a primitive or nothing. The ray goes through the centres of the pixel
+// and of the lens and is not bent by black holes.
+func (r *Renderer) Pick(x, y int) (int, float32) {
+	s := &r.Settings
+	camera := *r.Scene.Camera
+	camera.ApertureRadius = 0
+
+	// ImageX is its own inverse.
+	px := (float32(r.ImageX(uint32(x)))+0.5)/float32(s.Width)*2 - 1
+	py := (float32(y)+0.5)/float32(s.Height)*2 - 1
+	ray := camera.GenerateRay(px, py, float32(s.Width)/float32(s.Height), 0, 0)
+	hit, t, tri := r.BVH.CheckIntersection(ray, INF)
+	if !hit || tri.Instance == nil {
+		return -1, 0
+	}
+	return int(tri.Instance.ObjectID), t * ray.Direction.Dot(camera.Forward)
+}
+
+// ScreenOffset is the move, parallel to the image plane, that shifts a
+// point at depth by (dx, dy) image pixels.
+func (r *Renderer) ScreenOffset(dx, dy, depth float32) Vec3 {
+	camera := r.Scene.Camera
+	aspect := float32(r.Settings.Width) / float32(r.Settings.Height)
+	pixel := 2 * math32.Tan(camera.VerticalFOV(aspect)/2) * depth / float32(r.Settings.Height)
+	// Image X runs against Right and image Y along Up; see ImageX.
+	return camera.Right.Scale(-dx * pixel).Add(camera.Up.Scale(dy * pixel))
+}
+
+// MoveObject moves an object of the scene by offset and updates the BVH
+// without rebuilding the meshes'. Workers must be stopped, and the
+// accumulated samples are stale afterwards.
+func (r *Renderer) MoveObject(object int, offset Vec3) error {
+	if object < 0 || object >= len(r.Scene.Meshes) {
+		return fmt.Errorf("no object %d", object)
+	}
+	var instance *Instance
+	for _, candidate := range r.BVH.Instances {
+		if candidate.ObjectID == int32(object) {
+			instance = candidate
+			break
+		}
+	}
+	if instance == nil {
+		return fmt.Errorf("object %d has no instance", object)
+	}
+
+	moved := *r.Scene.Meshes[object]
+	moved.Position = moved.Position.Add(offset)
+	if err := instance.SetTransform(moved.ObjectToWorld()); err != nil {
+		return fmt.Errorf("object %d: %w", object, err)
+	}
+	r.Scene.Meshes[object].Position = moved.Position
+
+	if r.BVH.Update(r.Settings.BVH) {
+		fmt.Println("Rebuilt the top-level BVH")
+	}
+	r.bounds = sceneBounds(r.BVH.Instances, r.BVH.Primitives.Triangles)
+	return nil
+}
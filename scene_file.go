@@ -0,0 +1,900 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aquilax/go-perlin"
+	"github.com/chewxy/math32"
+	g3nmath "github.com/g3n/engine/math32"
+)
+
+// SceneFile is the JSON description of a scene. Vectors and colours are
+// written as [x, y, z] arrays and angles are in degrees. Relative paths are
+// resolved against the directory of the scene file.
+type SceneFile struct {
+	Camera     CameraDesc      `json:"camera"`
+	Objects    []ObjectDesc    `json:"objects"`
+	Primitives []PrimitiveDesc `json:"primitives"`
+	Lights     []LightDesc     `json:"lights"`
+	Skybox     *SkyboxDesc     `json:"skybox"`
+	BlackHoles []BlackHoleDesc `json:"black_holes"`
+	Animation  *AnimationDesc  `json:"animation"`
+}
+
+type CameraDesc struct {
+	Position         [3]float32  `json:"position"`
+	Forward          *[3]float32 `json:"forward"`
+	Right            *[3]float32 `json:"right"`
+	Up               *[3]float32 `json:"up"`
+	FrustrumDistance float32     `json:"frustrum_distance"`
+
+	// Projection; see Camera. Sensor is [width, height] in mm.
+	FOV         float32     `json:"fov"`
+	FocalLength float32     `json:"focal_length"`
+	Sensor      *[2]float32 `json:"sensor"`
+
+	// Depth of field, off unless aperture_radius is set.
+	ApertureRadius float32 `json:"aperture_radius"`
+	FocusDistance  float32 `json:"focus_distance"`
+	ApertureBlades int     `json:"aperture_blades"`
+	BladeRotation  float32 `json:"blade_rotation"`
+
+	// Rotation is applied with Camera.ApplyRotation after the basis is set.
+	Rotation *struct {
+		Yaw   float32 `json:"yaw"`
+		Pitch float32 `json:"pitch"`
+	} `json:"rotation"`
+
+	// Orbit places the camera on a sphere looking at its center, replacing
+	// the position and basis above.
+	Orbit *OrbitDesc `json:"orbit"`
+
+	// Gltf takes the position, basis and, unless set above, the field of
+	// view from the first camera of the glTF objects, placed with its
+	// object. Without one the camera looks at the glTF objects from +z.
+	Gltf bool `json:"gltf"`
+}
+
+type OrbitDesc struct {
+	Center [3]float32 `json:"center"`
+	Radius float32    `json:"radius"`
+	Theta  float32    `json:"theta"`
+	Phi    float32    `json:"phi"`
+}
+
+// ObjectDesc places an OBJ model or a glTF scene. Objects that load the
+// same file at the same scale and crease angle share one copy of the mesh
+// and its BVH.
+//
+// The OBJ model is every object and group of the file merged into one
+// mesh, unless Object names the one to load or Split places each as an
+// object of its own, all with the same position, transform and material.
+//
+// A glTF scene keeps its meshes, node transforms, lights and cameras (see
+// ImportGLTF); the position, transform and scale place it as a whole.
+type ObjectDesc struct {
+	Name      string         `json:"name"`
+	Obj       string         `json:"obj"`
+	Gltf      string         `json:"gltf"`
+	Object    string         `json:"object"`
+	Split     bool           `json:"split"`
+	Scale     float32        `json:"scale"`
+	Position  [3]float32     `json:"position"`
+	Transform *TransformDesc `json:"transform"`
+
+	// Material, if set, replaces every material of the model.
+	Material *MaterialDesc `json:"material"`
+
+	// CreaseAngle, in degrees, is the sharpest angle between faces that
+	// generated normals smooth over; 0 means DefaultCreaseAngle.
+	CreaseAngle float32 `json:"crease_angle"`
+}
+
+// TransformDesc is applied to an object before it is moved to its
+// position: first the scale, then the rotation and last the matrix.
+type TransformDesc struct {
+	Scale    *[3]float32    `json:"scale"`
+	Rotation [3]float32     `json:"rotation"` // Degrees about x, then y, then z
+	Matrix   *[4][4]float32 `json:"matrix"`   // Row-major, affine
+}
+
+func (t *TransformDesc) Matrix4() Mat4 {
+	m := Identity()
+	if t.Scale != nil {
+		m = Scaling(vec(*t.Scale))
+	}
+	const degrees = math.Pi / 180
+	m = Rotation(t.Rotation[0]*degrees, t.Rotation[1]*degrees, t.Rotation[2]*degrees).Mul(m)
+	if t.Matrix != nil {
+		m = Mat4(*t.Matrix).Mul(m)
+	}
+	return m
+}
+
+// PrimitiveDesc is an analytic shape. Position is the centre; a plane
+// without a size is infinite.
+type PrimitiveDesc struct {
+	Type     string       `json:"type"` // "sphere", "plane", "disc" or "box"
+	Name     string       `json:"name"`
+	Position [3]float32   `json:"position"`
+	Radius   float32      `json:"radius"`  // sphere and disc
+	Normal   *[3]float32  `json:"normal"`  // plane and disc, [0, 1, 0] if unset
+	Tangent  *[3]float32  `json:"tangent"` // plane and disc, orients the texture
+	Size     []float32    `json:"size"`    // plane: [width, height]; box: [x, y, z]
+	Material MaterialDesc `json:"material"`
+}
+
+// MaterialDesc describes a material like an MTL entry. Materials whose name
+// starts with "Glass" refract with the given index of refraction.
+type MaterialDesc struct {
+	Name      string      `json:"name"`
+	Diffuse   *[3]float32 `json:"diffuse"` // [0.7, 0.7, 0.7] if unset
+	Specular  [3]float32  `json:"specular"`
+	Emissive  [3]float32  `json:"emissive"`
+	Shininess float32     `json:"shininess"`
+	IOR       float32     `json:"ior"`
+	Texture   string      `json:"texture"`
+	Bump      string      `json:"bump"`
+}
+
+type LightDesc struct {
+	Type      string     `json:"type"` // "sun" or "point"
+	Color     [3]float32 `json:"color"`
+	Intensity float32    `json:"intensity"`
+	Direction [3]float32 `json:"direction"` // sun only
+	Position  [3]float32 `json:"position"`  // point only
+}
+
+type SkyboxDesc struct {
+	Type      string     `json:"type"` // "solid", "gradient" or "image"
+	Color     [3]float32 `json:"color"`
+	Ground    [3]float32 `json:"ground"`
+	Horizon   [3]float32 `json:"horizon"`
+	Zenith    [3]float32 `json:"zenith"`
+	Image     string     `json:"image"`
+	Intensity float32    `json:"intensity"`
+}
+
+type BlackHoleDesc struct {
+	Position      [3]float32         `json:"position"`
+	Rs            float32            `json:"rs"`
+	AccretionDisk *AccretionDiskDesc `json:"accretion_disk"`
+}
+
+type AccretionDiskDesc struct {
+	InnerRadius float32 `json:"inner_radius"`
+	OuterRadius float32 `json:"outer_radius"`
+	// Perlin noise parameters; n defaults to 4 with alpha = beta = 2.
+	Noise struct {
+		Alpha float64 `json:"alpha"`
+		Beta  float64 `json:"beta"`
+		N     int32   `json:"n"`
+		Seed  int64   `json:"seed"`
+	} `json:"noise"`
+}
+
+// AnimationDesc is a keyframed camera path. Each keyframe's interpolation
+// ("linear", "catmull-rom" or "ease") applies until the next keyframe.
+type AnimationDesc struct {
+	FPS       float32        `json:"fps"`
+	Keyframes []KeyframeDesc `json:"keyframes"`
+}
+
+type KeyframeDesc struct {
+	Time          float32     `json:"time"` // Seconds
+	Position      [3]float32  `json:"position"`
+	Target        [3]float32  `json:"target"`
+	Up            *[3]float32 `json:"up"` // [0, 1, 0] if unset
+	FOV           float32     `json:"fov"`
+	Interpolation string      `json:"interpolation"`
+}
+
+func vec(a [3]float32) Vec3 {
+	return Vec3{X: a[0], Y: a[1], Z: a[2]}
+}
+
+func (o OrbitDesc) Orbit() *Orbit {
+	return &Orbit{
+		Center: vec(o.Center),
+		Radius: o.Radius,
+		Theta:  o.Theta * 0.0174533,
+		Phi:    o.Phi * 0.0174533,
+	}
+}
+
+// LoadSceneFile reads, validates and builds the scene described by path.
+// Meshes are loaded through cache, which may be nil.
+//
+// A .gltf or .glb file is loaded as a scene of its own, seen through its
+// first camera under a gradient sky.
+func LoadSceneFile(path string, cache *MeshCache) (*Scene, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".gltf" || ext == ".glb" {
+		desc := SceneFile{
+			Camera:  CameraDesc{Gltf: true},
+			Objects: []ObjectDesc{{Gltf: filepath.Base(path)}},
+			Skybox: &SkyboxDesc{
+				Type:    "gradient",
+				Ground:  [3]float32{0.3, 0.3, 0.3},
+				Horizon: [3]float32{0.8, 0.9, 1},
+				Zenith:  [3]float32{0.2, 0.5, 1},
+			},
+		}
+		return desc.load(path, cache)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading scene: %w", err)
+	}
+
+	var desc SceneFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&desc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return desc.load(path, cache)
+}
+
+// load validates and builds the description read from path.
+func (s *SceneFile) load(path string, cache *MeshCache) (*Scene, error) {
+	dir := filepath.Dir(path)
+	if err := s.Validate(dir); err != nil {
+		return nil, fmt.Errorf("%s: invalid scene:\n%w", path, err)
+	}
+
+	scene, err := s.Build(dir, cache)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if scene.Description, err = json.Marshal(s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return scene, nil
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Validate reports every problem with the description at once, so a scene
+// file can be fixed in one go.
+func (s *SceneFile) Validate(dir string) error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	mustExist := func(field, path string) {
+		if path == "" {
+			fail(field, "missing path")
+			return
+		}
+		if _, err := os.Stat(resolvePath(dir, path)); err != nil {
+			fail(field, "%v", err)
+		}
+	}
+	checkMaterial := func(field string, material *MaterialDesc) {
+		if material.Texture != "" {
+			mustExist(field+".texture", material.Texture)
+		}
+		if material.Bump != "" {
+			mustExist(field+".bump", material.Bump)
+		}
+		if material.IOR < 0 {
+			fail(field+".ior", "must not be negative")
+		}
+	}
+
+	camera := s.Camera
+	if camera.Orbit != nil {
+		if camera.Orbit.Radius <= 0 {
+			fail("camera.orbit.radius", "must be positive")
+		}
+	}
+	if camera.Gltf {
+		hasGltf := false
+		for _, object := range s.Objects {
+			hasGltf = hasGltf || object.Gltf != ""
+		}
+		if !hasGltf {
+			fail("camera.gltf", "needs an object with a gltf file")
+		}
+		if camera.Orbit != nil || camera.Rotation != nil {
+			fail("camera.gltf", "cannot be combined with orbit or rotation")
+		}
+	}
+	if camera.FrustrumDistance < 0 {
+		fail("camera.frustrum_distance", "must be positive")
+	}
+	if camera.FOV < 0 || camera.FOV >= 180 {
+		fail("camera.fov", "must be between 0 and 180 degrees")
+	}
+	if camera.FocalLength < 0 {
+		fail("camera.focal_length", "must not be negative")
+	}
+	if camera.Sensor != nil && (camera.Sensor[0] <= 0 || camera.Sensor[1] <= 0) {
+		fail("camera.sensor", "width and height must be positive")
+	}
+	if camera.ApertureRadius < 0 {
+		fail("camera.aperture_radius", "must not be negative")
+	}
+	if camera.ApertureRadius > 0 && camera.FocusDistance <= 0 {
+		fail("camera.focus_distance", "must be positive when aperture_radius is set")
+	}
+	if camera.ApertureBlades < 0 || camera.ApertureBlades == 1 || camera.ApertureBlades == 2 {
+		fail("camera.aperture_blades", "need 3 or more blades, or 0 for a round aperture")
+	}
+	axes := []struct {
+		name string
+		axis *[3]float32
+	}{{"forward", camera.Forward}, {"right", camera.Right}, {"up", camera.Up}}
+	for _, a := range axes {
+		if a.axis != nil && vec(*a.axis).Length() == 0 {
+			fail("camera."+a.name, "must not be a zero vector")
+		}
+	}
+
+	for i, object := range s.Objects {
+		field := fmt.Sprintf("objects[%d]", i)
+		switch {
+		case object.Obj != "" && object.Gltf != "":
+			fail(field, "set one of obj and gltf, not both")
+		case object.Gltf != "":
+			mustExist(field+".gltf", object.Gltf)
+			if object.Object != "" || object.Split || object.CreaseAngle != 0 {
+				fail(field, "object, split and crease_angle are for obj files only")
+			}
+		default:
+			mustExist(field+".obj", object.Obj)
+		}
+		if object.Object != "" && object.Split {
+			fail(field+".split", "cannot be combined with object")
+		}
+		if object.Scale < 0 {
+			fail(field+".scale", "must not be negative")
+		}
+		if object.CreaseAngle < 0 || object.CreaseAngle > 180 {
+			fail(field+".crease_angle", "must be between 0 and 180 degrees")
+		}
+		if transform := object.Transform; transform != nil {
+			if transform.Matrix != nil && !Mat4(*transform.Matrix).Affine() {
+				fail(field+".transform.matrix", "last row must be [0, 0, 0, 1]")
+			} else if _, ok := transform.Matrix4().Inverse(); !ok {
+				fail(field+".transform", "must not flatten the object (zero scale or singular matrix)")
+			}
+		}
+		if object.Material != nil {
+			checkMaterial(field+".material", object.Material)
+		}
+	}
+
+	for i, primitive := range s.Primitives {
+		field := fmt.Sprintf("primitives[%d]", i)
+		switch primitive.Type {
+		case "sphere", "disc":
+			if primitive.Radius <= 0 {
+				fail(field+".radius", "must be positive")
+			}
+		case "plane":
+			if len(primitive.Size) != 0 && (len(primitive.Size) != 2 || primitive.Size[0] <= 0 || primitive.Size[1] <= 0) {
+				fail(field+".size", "must be a positive [width, height], or unset for an infinite plane")
+			}
+			emissive := primitive.Material.Emissive
+			if len(primitive.Size) == 0 && (emissive[0] > 0 || emissive[1] > 0 || emissive[2] > 0) {
+				fail(field+".material.emissive", "an infinite plane cannot emit light")
+			}
+		case "box":
+			if len(primitive.Size) != 3 || primitive.Size[0] <= 0 || primitive.Size[1] <= 0 || primitive.Size[2] <= 0 {
+				fail(field+".size", "must be a positive [x, y, z]")
+			}
+		case "":
+			fail(field+".type", "missing primitive type")
+		default:
+			fail(field+".type", "unknown primitive type %q (want sphere, plane, disc or box)", primitive.Type)
+		}
+		if primitive.Normal != nil && vec(*primitive.Normal).Length() == 0 {
+			fail(field+".normal", "must not be a zero vector")
+		}
+		checkMaterial(field+".material", &primitive.Material)
+	}
+
+	for i, light := range s.Lights {
+		field := fmt.Sprintf("lights[%d]", i)
+		switch light.Type {
+		case "sun":
+			if vec(light.Direction).Length() == 0 {
+				fail(field+".direction", "must not be a zero vector")
+			}
+		case "point":
+		case "":
+			fail(field+".type", "missing light type")
+		default:
+			fail(field+".type", "unknown light type %q (want sun or point)", light.Type)
+		}
+		if light.Intensity < 0 {
+			fail(field+".intensity", "must not be negative")
+		}
+	}
+
+	if s.Skybox != nil {
+		switch s.Skybox.Type {
+		case "solid", "gradient":
+		case "image":
+			mustExist("skybox.image", s.Skybox.Image)
+		case "":
+			fail("skybox.type", "missing skybox type")
+		default:
+			fail("skybox.type", "unknown skybox type %q (want solid, gradient or image)", s.Skybox.Type)
+		}
+	}
+
+	for i, hole := range s.BlackHoles {
+		field := fmt.Sprintf("black_holes[%d]", i)
+		if hole.Rs <= 0 {
+			fail(field+".rs", "must be positive")
+		}
+		if disk := hole.AccretionDisk; disk != nil {
+			if disk.InnerRadius < 0 || disk.OuterRadius <= disk.InnerRadius {
+				fail(field+".accretion_disk", "need 0 <= inner_radius < outer_radius")
+			}
+		}
+	}
+	if len(s.BlackHoles) > 1 {
+		fail("black_holes", "only one black hole is supported, got %d", len(s.BlackHoles))
+	}
+
+	if anim := s.Animation; anim != nil {
+		if anim.FPS <= 0 {
+			fail("animation.fps", "must be positive")
+		}
+		if len(anim.Keyframes) == 0 {
+			fail("animation.keyframes", "need at least one keyframe")
+		}
+		times := map[float32]bool{}
+		withFOV := 0
+		for i, key := range anim.Keyframes {
+			field := fmt.Sprintf("animation.keyframes[%d]", i)
+			if times[key.Time] {
+				fail(field+".time", "another keyframe is already at %gs", key.Time)
+			}
+			times[key.Time] = true
+			if key.Position == key.Target {
+				fail(field+".target", "must differ from position")
+			}
+			if key.Up != nil && vec(*key.Up).Length() == 0 {
+				fail(field+".up", "must not be a zero vector")
+			}
+			if key.FOV < 0 || key.FOV >= 180 {
+				fail(field+".fov", "must be between 0 and 180 degrees")
+			}
+			if key.FOV > 0 {
+				withFOV++
+			}
+			if _, err := ParseInterpolation(key.Interpolation); err != nil {
+				fail(field+".interpolation", "%v", err)
+			}
+		}
+		if withFOV != 0 && withFOV != len(anim.Keyframes) {
+			fail("animation.keyframes", "set fov on every keyframe or on none")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// meshNamed returns the mesh of the OBJ object or group called name.
+func meshNamed(meshes []*Mesh, name string) (*Mesh, error) {
+	names := make([]string, len(meshes))
+	for i, mesh := range meshes {
+		if mesh.Name == name {
+			return mesh, nil
+		}
+		names[i] = fmt.Sprintf("%q", mesh.Name)
+	}
+	return nil, fmt.Errorf("no object or group %q in the file (it has %s)", name, strings.Join(names, ", "))
+}
+
+// Build loads the referenced assets, the meshes through cache, which may
+// be nil, and assembles the scene. The description is assumed to be valid.
+func (s *SceneFile) Build(dir string, cache *MeshCache) (*Scene, error) {
+	scene := &Scene{}
+
+	camera := &Camera{
+		Position:         vec(s.Camera.Position),
+		Forward:          Vec3{Z: 1},
+		Right:            Vec3{X: -1},
+		Up:               Vec3{Y: -1},
+		FrustrumDistance: s.Camera.FrustrumDistance,
+		FOV:              s.Camera.FOV,
+		FocalLength:      s.Camera.FocalLength,
+		ApertureRadius:   s.Camera.ApertureRadius,
+		FocusDistance:    s.Camera.FocusDistance,
+		ApertureBlades:   s.Camera.ApertureBlades,
+		BladeRotation:    s.Camera.BladeRotation,
+	}
+	if sensor := s.Camera.Sensor; sensor != nil {
+		camera.SensorWidth, camera.SensorHeight = sensor[0], sensor[1]
+	}
+	if camera.FrustrumDistance == 0 {
+		camera.FrustrumDistance = 2
+	}
+	if s.Camera.Forward != nil {
+		camera.Forward = vec(*s.Camera.Forward).Normalize()
+	}
+	if s.Camera.Right != nil {
+		camera.Right = vec(*s.Camera.Right).Normalize()
+	}
+	if s.Camera.Up != nil {
+		camera.Up = vec(*s.Camera.Up).Normalize()
+	}
+	if rotation := s.Camera.Rotation; rotation != nil {
+		camera.ApplyRotation(rotation.Yaw*0.0174533, rotation.Pitch*0.0174533)
+	}
+	if s.Camera.Orbit != nil {
+		scene.Orbit = s.Camera.Orbit.Orbit()
+		scene.Orbit.Apply(camera)
+	}
+	scene.Camera = camera
+
+	type meshKey struct {
+		path        string
+		scale       float32
+		creaseAngle float32
+	}
+	merged := make(map[meshKey]*Mesh)
+	split := make(map[meshKey][]*Mesh)
+	imported := make(map[string]*Scene)
+	var gltfCamera *Camera
+	for i, object := range s.Objects {
+		if object.Gltf != "" {
+			path := resolvePath(dir, object.Gltf)
+			gltf, ok := imported[path]
+			if !ok {
+				var err error
+				if gltf, err = ImportGLTF(path); err != nil {
+					return nil, fmt.Errorf("objects[%d]: %w", i, err)
+				}
+				imported[path] = gltf
+			}
+			var material *Material
+			if object.Material != nil {
+				var err error
+				if material, err = object.Material.Build(dir); err != nil {
+					return nil, fmt.Errorf("objects[%d].material: %w", i, err)
+				}
+			}
+			object.addGLTF(scene, gltf, material)
+			if gltfCamera == nil && gltf.Camera != nil {
+				gltfCamera = object.placeGLTFCamera(gltf.Camera)
+			}
+			continue
+		}
+
+		key := meshKey{resolvePath(dir, object.Obj), object.Scale, object.CreaseAngle}
+		if key.scale == 0 {
+			key.scale = 1
+		}
+		if key.creaseAngle == 0 {
+			key.creaseAngle = DefaultCreaseAngle
+		}
+
+		var meshes []*Mesh
+		if object.Object == "" && !object.Split {
+			mesh, ok := merged[key]
+			if !ok {
+				var err error
+				if mesh, err = cache.LoadObj(key.path, key.scale, key.creaseAngle); err != nil {
+					return nil, fmt.Errorf("objects[%d]: %w", i, err)
+				}
+				merged[key] = mesh
+			}
+			meshes = []*Mesh{mesh}
+		} else {
+			parts, ok := split[key]
+			if !ok {
+				var err error
+				if parts, err = cache.LoadObjMeshes(key.path, key.scale, key.creaseAngle); err != nil {
+					return nil, fmt.Errorf("objects[%d]: %w", i, err)
+				}
+				split[key] = parts
+			}
+			meshes = parts
+			if object.Object != "" {
+				mesh, err := meshNamed(parts, object.Object)
+				if err != nil {
+					return nil, fmt.Errorf("objects[%d].object: %w", i, err)
+				}
+				meshes = []*Mesh{mesh}
+			}
+		}
+
+		var material *Material
+		if object.Material != nil {
+			var err error
+			if material, err = object.Material.Build(dir); err != nil {
+				return nil, fmt.Errorf("objects[%d].material: %w", i, err)
+			}
+		}
+		for _, mesh := range meshes {
+			gameObject := &GameObject[any]{
+				Position: vec(object.Position),
+				Mesh:     mesh,
+				Material: material,
+			}
+			if object.Transform != nil {
+				transform := object.Transform.Matrix4()
+				gameObject.Transform = &transform
+			}
+			scene.Meshes = append(scene.Meshes, gameObject)
+		}
+	}
+
+	if s.Camera.Gltf {
+		if gltfCamera == nil {
+			gltfCamera = frameMeshes(scene.Meshes)
+		}
+		camera.Position, camera.Forward, camera.Right, camera.Up = gltfCamera.Position, gltfCamera.Forward, gltfCamera.Right, gltfCamera.Up
+		if camera.FOV == 0 && camera.FocalLength == 0 {
+			camera.FOV = gltfCamera.FOV
+		}
+	}
+
+	for i, desc := range s.Primitives {
+		primitive, err := desc.Build(dir)
+		if err != nil {
+			return nil, fmt.Errorf("primitives[%d]: %w", i, err)
+		}
+		scene.Primitives = append(scene.Primitives, primitive)
+	}
+
+	for _, light := range s.Lights {
+		switch light.Type {
+		case "sun":
+			scene.Lights = append(scene.Lights, &GameObject[Light]{
+				Object: &Sun{
+					Color:     vec(light.Color),
+					Direction: vec(light.Direction).Normalize(),
+					Intensity: light.Intensity,
+				},
+			})
+		case "point":
+			scene.Lights = append(scene.Lights, &GameObject[Light]{
+				Position: vec(light.Position),
+				Object: &PointLight{
+					Color:     vec(light.Color),
+					Intensity: light.Intensity,
+				},
+			})
+		}
+	}
+
+	if sky := s.Skybox; sky != nil {
+		intensity := sky.Intensity
+		if intensity == 0 {
+			intensity = 1
+		}
+		switch sky.Type {
+		case "solid":
+			scene.Skybox = &SolidColorSkybox{Color: vec(sky.Color)}
+		case "gradient":
+			scene.Skybox = &GradientSkybox{
+				GroundColor:  vec(sky.Ground),
+				HorizonColor: vec(sky.Horizon),
+				ZenithColor:  vec(sky.Zenith),
+				Intensity:    intensity,
+			}
+		case "image":
+			skybox, err := NewImageSkybox(resolvePath(dir, sky.Image), intensity)
+			if err != nil {
+				return nil, fmt.Errorf("skybox: %w", err)
+			}
+			scene.Skybox = skybox
+		}
+	}
+
+	for _, hole := range s.BlackHoles {
+		blackHole := &BlackHole{
+			Position: vec(hole.Position),
+			Rs:       hole.Rs,
+		}
+		if disk := hole.AccretionDisk; disk != nil {
+			noise := disk.Noise
+			if noise.N == 0 {
+				noise.Alpha, noise.Beta, noise.N = 2, 2, 4
+			}
+			blackHole.AccretionDisk = &AccretionDisk{
+				InnerRadius: disk.InnerRadius,
+				OuterRadius: disk.OuterRadius,
+				NoiseGen:    perlin.NewPerlin(noise.Alpha, noise.Beta, noise.N, noise.Seed),
+			}
+		}
+		scene.BlackHoles = append(scene.BlackHoles, blackHole)
+	}
+
+	if anim := s.Animation; anim != nil {
+		keyframes := make([]Keyframe, len(anim.Keyframes))
+		for i, key := range anim.Keyframes {
+			up := Vec3{Y: 1}
+			if key.Up != nil {
+				up = vec(*key.Up)
+			}
+			interpolation, _ := ParseInterpolation(key.Interpolation)
+			keyframes[i] = Keyframe{
+				Time:          key.Time,
+				Position:      vec(key.Position),
+				Target:        vec(key.Target),
+				Up:            up,
+				FOV:           key.FOV,
+				Interpolation: interpolation,
+			}
+		}
+		scene.Animation = NewTimeline(anim.FPS, keyframes)
+		scene.Animation.Apply(camera, 0)
+	}
+
+	return scene, nil
+}
+
+// gltfTransform scales and transforms a glTF scene before it is moved to
+// the object's position.
+func (o *ObjectDesc) gltfTransform() Mat4 {
+	m := Identity()
+	if o.Scale != 0 {
+		m = Scaling(Vec3{X: o.Scale, Y: o.Scale, Z: o.Scale})
+	}
+	if o.Transform != nil {
+		m = o.Transform.Matrix4().Mul(m)
+	}
+	return m
+}
+
+// placement moves a glTF scene from its own space into the scene's.
+func (o *ObjectDesc) placement() Mat4 {
+	return Translation(vec(o.Position)).Mul(o.gltfTransform())
+}
+
+// addGLTF adds the meshes and lights of the imported glTF scene to scene,
+// placed by the object. A material, if set, replaces the glTF materials.
+func (o *ObjectDesc) addGLTF(scene, gltf *Scene, material *Material) {
+	placement, transform := o.placement(), o.gltfTransform()
+	for _, imported := range gltf.Meshes {
+		world := transform.Mul(imported.ObjectToWorld())
+		scene.Meshes = append(scene.Meshes, &GameObject[any]{
+			Position:  vec(o.Position),
+			Mesh:      imported.Mesh,
+			Transform: &world,
+			Material:  material,
+		})
+	}
+	for _, imported := range gltf.Lights {
+		light := &GameObject[Light]{Position: placement.Point(imported.Position), Object: imported.Object}
+		if sun, ok := imported.Object.(*Sun); ok {
+			placed := *sun
+			placed.Direction = placement.Vector(sun.Direction).Normalize()
+			light.Object = &placed
+		}
+		scene.Lights = append(scene.Lights, light)
+	}
+}
+
+// placeGLTFCamera returns a copy of camera, from a glTF scene placed by the
+// object.
+func (o *ObjectDesc) placeGLTFCamera(camera *Camera) *Camera {
+	placement := o.placement()
+	placed := *camera
+	placed.Position = placement.Point(camera.Position)
+	placed.Forward = placement.Vector(camera.Forward).Normalize()
+	placed.Right = placement.Vector(camera.Right).Normalize()
+	placed.Up = placement.Vector(camera.Up).Normalize()
+	return &placed
+}
+
+// frameMeshes returns a camera looking down -z at the bounds of objects,
+// far enough back to fit them in its field of view.
+func frameMeshes(objects []*GameObject[any]) *Camera {
+	center, radius := Vec3{}, float32(1)
+	if len(objects) > 0 {
+		inf := math32.Inf(1)
+		lo, hi := Vec3{X: inf, Y: inf, Z: inf}, Vec3{X: -inf, Y: -inf, Z: -inf}
+		for _, object := range objects {
+			toWorld := object.ObjectToWorld()
+			for _, v := range object.Mesh.Vertices {
+				p := toWorld.Point(v)
+				lo = Vec3{X: min(lo.X, p.X), Y: min(lo.Y, p.Y), Z: min(lo.Z, p.Z)}
+				hi = Vec3{X: max(hi.X, p.X), Y: max(hi.Y, p.Y), Z: max(hi.Z, p.Z)}
+			}
+		}
+		center, radius = lo.Add(hi).Scale(0.5), max(hi.Sub(lo).Length()/2, 0.001)
+	}
+	const fov = 40
+	distance := radius / math32.Sin(fov/2*math32.Pi/180)
+	return &Camera{
+		Position: center.Add(Vec3{Z: distance}),
+		Forward:  Vec3{Z: -1},
+		Right:    Vec3{X: -1},
+		Up:       Vec3{Y: -1},
+		FOV:      fov,
+	}
+}
+
+func (p *PrimitiveDesc) Build(dir string) (*PrimitiveObject, error) {
+	material, err := p.Material.Build(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	position := vec(p.Position)
+	normal := Vec3{Y: 1}
+	if p.Normal != nil {
+		normal = vec(*p.Normal)
+	}
+	var tangent Vec3
+	if p.Tangent != nil {
+		tangent = vec(*p.Tangent)
+	}
+
+	var shape Primitive
+	switch p.Type {
+	case "sphere":
+		shape = &Sphere{Position: position, Radius: p.Radius}
+	case "plane":
+		var width, height float32
+		if len(p.Size) == 2 {
+			width, height = p.Size[0], p.Size[1]
+		}
+		shape = NewPlane(position, normal, tangent, width, height)
+	case "disc":
+		shape = NewDisc(position, normal, tangent, p.Radius)
+	case "box":
+		half := Vec3{X: p.Size[0], Y: p.Size[1], Z: p.Size[2]}.Scale(0.5)
+		shape = &Cuboid{Min: position.Sub(half), Max: position.Add(half)}
+	}
+	return &PrimitiveObject{Name: p.Name, Shape: shape, Material: material}, nil
+}
+
+func (m *MaterialDesc) Build(dir string) (*Material, error) {
+	color := func(c [3]float32) g3nmath.Color { return g3nmath.Color{R: c[0], G: c[1], B: c[2]} }
+
+	material := &Material{
+		Name:       m.Name,
+		Diffuse:    g3nmath.Color{R: 0.7, G: 0.7, B: 0.7},
+		Specular:   color(m.Specular),
+		Emissive:   color(m.Emissive),
+		Shininess:  m.Shininess,
+		Refraction: m.IOR,
+	}
+	if m.Diffuse != nil {
+		material.Diffuse = color(*m.Diffuse)
+	}
+	if material.Refraction == 0 {
+		material.Refraction = 1.5
+	}
+
+	if m.Texture != "" {
+		texture, err := loadTexture(resolvePath(dir, m.Texture))
+		if err != nil {
+			return nil, err
+		}
+		material.DiffuseImage = &texture
+		material.HasImage = true
+	}
+	if m.Bump != "" {
+		bump, err := loadTexture(resolvePath(dir, m.Bump))
+		if err != nil {
+			return nil, err
+		}
+		material.BumpImage = &bump
+		material.HasImage = true
+	}
+	return material, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"os"
 
@@ -50,21 +51,22 @@ type ImageSkybox struct {
 
 func (s *ImageSkybox) isSkybox() {}
 
-func NewImageSkybox(path string, intensity float32) *ImageSkybox {
+func NewImageSkybox(path string, intensity float32) (*ImageSkybox, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("loading skybox: %w", err)
 	}
+	defer f.Close()
 
 	img, _, err := image.Decode(f)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("decoding skybox %s: %w", path, err)
 	}
 
 	return &ImageSkybox{
 		img:       &img,
 		Intensity: intensity,
-	}
+	}, nil
 }
 
 func (s *ImageSkybox) Sample(direction Vec3) Vec3 {
@@ -1,6 +1,60 @@
 package main
 
+import (
+	"math"
+
+	"github.com/chewxy/math32"
+)
+
 type Sphere struct {
 	Position Vec3
-	Radius   float64
+	Radius   float32
+}
+
+func (s *Sphere) Bounds() (Vec3, Vec3) {
+	r := Vec3{X: s.Radius, Y: s.Radius, Z: s.Radius}
+	return s.Position.Sub(r), s.Position.Add(r)
+}
+
+func (s *Sphere) Intersect(ray Ray, tMax float32) (bool, float32) {
+	oc := ray.Origin.Sub(s.Position)
+	a := ray.Direction.Dot(ray.Direction)
+	halfB := oc.Dot(ray.Direction)
+	c := oc.Dot(oc) - s.Radius*s.Radius
+
+	discriminant := halfB*halfB - a*c
+	if discriminant < 0 {
+		return false, 0
+	}
+	root := math32.Sqrt(discriminant)
+
+	// The near root, or the far one from inside the sphere.
+	t := (-halfB - root) / a
+	if t <= primitiveEpsilon {
+		t = (-halfB + root) / a
+	}
+	if t <= primitiveEpsilon || t > tMax {
+		return false, 0
+	}
+	return true, t
+}
+
+// Surface maps longitude to u and latitude to v, with v = 0 at the top.
+func (s *Sphere) Surface(p Vec3) (Vec3, float32, float32) {
+	n := p.Sub(s.Position).Normalize()
+	u := 0.5 + math32.Atan2(n.Z, n.X)/(2*math.Pi)
+	v := math32.Acos(max(-1, min(1, n.Y))) / math.Pi
+	return n, u, v
+}
+
+func (s *Sphere) Area() float32 {
+	return 4 * math.Pi * s.Radius * s.Radius
+}
+
+func (s *Sphere) Sample(u, v float32) (Vec3, Vec3) {
+	y := 1 - 2*u
+	r := math32.Sqrt(max(0, 1-y*y))
+	phi := 2 * math.Pi * v
+	n := Vec3{X: r * math32.Cos(phi), Y: y, Z: r * math32.Sin(phi)}
+	return s.Position.Add(n.Scale(s.Radius)), n
 }
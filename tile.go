@@ -1,7 +1,6 @@
 package main
 
 import (
-	"math"
 	"math/rand"
 
 	"github.com/chewxy/math32"
@@ -16,15 +15,16 @@ type Tile struct {
 	Pixels []*Pixel
 }
 
+// GetLeastSampledPixel returns nil once every pixel has maxSamples.
 func (t *Tile) GetLeastSampledPixel(maxSamples int) *Pixel {
 	// Find the pixel with the least samples
-	leastSampledPixel := &Pixel{SampleCount: math.MaxInt64}
+	var leastSampledPixel *Pixel
 	for _, pixel := range t.Pixels {
 		if pixel.SampleCount >= maxSamples {
 			continue
 		}
 
-		if pixel.SampleCount < leastSampledPixel.SampleCount {
+		if leastSampledPixel == nil || pixel.SampleCount < leastSampledPixel.SampleCount {
 			leastSampledPixel = pixel
 		}
 	}
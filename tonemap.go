@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/chewxy/math32"
+)
+
+// ToneMapper compresses linear scene radiance into the [0, 1] display range.
+// The result is still linear; sRGB encoding happens afterwards.
+type ToneMapper interface {
+	Name() string
+	Map(c Vec3) Vec3
+}
+
+// ToneMapping is the full display transform: exposure, tone mapping and the
+// sRGB transfer function.
+type ToneMapping struct {
+	Operator ToneMapper
+	Exposure float32 // In stops
+}
+
+func DefaultToneMapping() ToneMapping {
+	return ToneMapping{Operator: ACESToneMapper{}}
+}
+
+func (t ToneMapping) String() string {
+	return fmt.Sprintf("%s %+.1f EV", t.Operator.Name(), t.Exposure)
+}
+
+// Apply maps a linear radiance value to an 8-bit sRGB colour.
+func (t ToneMapping) Apply(c Vec3) color.RGBA {
+	c = c.Scale(math32.Exp2(t.Exposure))
+	c = Vec3{X: max(0, c.X), Y: max(0, c.Y), Z: max(0, c.Z)}
+	c = t.Operator.Map(c)
+
+	return color.RGBA{
+		R: quantize(EncodeSRGB(c.X)),
+		G: quantize(EncodeSRGB(c.Y)),
+		B: quantize(EncodeSRGB(c.Z)),
+		A: 255,
+	}
+}
+
+// EncodeSRGB applies the piecewise sRGB transfer function to a linear value,
+// clamping it to [0, 1] first.
+func EncodeSRGB(x float32) float32 {
+	x = Clamp01(x)
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math32.Pow(x, 1/2.4) - 0.055
+}
+
+// DecodeSRGB is the inverse of EncodeSRGB.
+func DecodeSRGB(x float32) float32 {
+	x = Clamp01(x)
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math32.Pow((x+0.055)/1.055, 2.4)
+}
+
+func quantize(x float32) uint8 {
+	return uint8(Clamp01(x)*255 + 0.5)
+}
+
+// ToneMapperNames lists the operators accepted by ParseToneMapper, in the
+// order the viewer cycles through them.
+var ToneMapperNames = []string{"linear", "reinhard", "aces", "agx"}
+
+func ParseToneMapper(name string) (ToneMapper, error) {
+	switch strings.ToLower(name) {
+	case "linear":
+		return LinearToneMapper{}, nil
+	case "reinhard":
+		return ReinhardToneMapper{WhitePoint: 4}, nil
+	case "aces":
+		return ACESToneMapper{}, nil
+	case "agx":
+		return AgXToneMapper{}, nil
+	}
+	return nil, fmt.Errorf("unknown tone mapper %q (want %s)", name, strings.Join(ToneMapperNames, ", "))
+}
+
+// NextToneMapper returns the operator after t in ToneMapperNames.
+func NextToneMapper(t ToneMapper) ToneMapper {
+	for i, name := range ToneMapperNames {
+		if name == t.Name() {
+			next, _ := ParseToneMapper(ToneMapperNames[(i+1)%len(ToneMapperNames)])
+			return next
+		}
+	}
+	return LinearToneMapper{}
+}
+
+// ------------------------------------------------------------
+
+// LinearToneMapper only clips; exposure does all the work.
+type LinearToneMapper struct{}
+
+func (LinearToneMapper) Name() string { return "linear" }
+
+func (LinearToneMapper) Map(c Vec3) Vec3 {
+	return Vec3{X: Clamp01(c.X), Y: Clamp01(c.Y), Z: Clamp01(c.Z)}
+}
+
+// ------------------------------------------------------------
+
+// ReinhardToneMapper is the extended Reinhard operator applied to luminance,
+// so hues are preserved. Luminance at WhitePoint maps to pure white.
+type ReinhardToneMapper struct {
+	WhitePoint float32
+}
+
+func (ReinhardToneMapper) Name() string { return "reinhard" }
+
+func (r ReinhardToneMapper) Map(c Vec3) Vec3 {
+	l := colorToLuminance(c)
+	if l <= 0 {
+		return Vec3{}
+	}
+	white2 := r.WhitePoint * r.WhitePoint
+	mapped := l * (1 + l/white2) / (1 + l)
+	c = c.Scale(mapped / l)
+	return Vec3{X: Clamp01(c.X), Y: Clamp01(c.Y), Z: Clamp01(c.Z)}
+}
+
+// ------------------------------------------------------------
+
+// ACESToneMapper is Stephen Hill's fit of the ACES reference rendering and
+// sRGB output transforms.
+type ACESToneMapper struct{}
+
+func (ACESToneMapper) Name() string { return "aces" }
+
+func (ACESToneMapper) Map(c Vec3) Vec3 {
+	c = mulMat3(acesInput, c)
+	c = Vec3{X: acesRRTAndODT(c.X), Y: acesRRTAndODT(c.Y), Z: acesRRTAndODT(c.Z)}
+	c = mulMat3(acesOutput, c)
+	return Vec3{X: Clamp01(c.X), Y: Clamp01(c.Y), Z: Clamp01(c.Z)}
+}
+
+var acesInput = [3][3]float32{
+	{0.59719, 0.35458, 0.04823},
+	{0.07600, 0.90834, 0.01566},
+	{0.02840, 0.13383, 0.83777},
+}
+
+var acesOutput = [3][3]float32{
+	{1.60475, -0.53108, -0.07367},
+	{-0.10208, 1.10813, -0.00605},
+	{-0.00327, -0.07276, 1.07602},
+}
+
+func acesRRTAndODT(v float32) float32 {
+	a := v*(v+0.0245786) - 0.000090537
+	b := v*(0.983729*v+0.4329510) + 0.238081
+	return a / b
+}
+
+// ------------------------------------------------------------
+
+// AgXToneMapper is the minimal AgX approximation: an inset into a wider
+// working space, a log2 encoding and a polynomial fit of the sigmoid. It
+// desaturates highlights instead of skewing their hue.
+type AgXToneMapper struct{}
+
+func (AgXToneMapper) Name() string { return "agx" }
+
+const (
+	agxMinEV = -12.47393
+	agxMaxEV = 4.026069
+)
+
+func (AgXToneMapper) Map(c Vec3) Vec3 {
+	c = mulMat3(agxInset, c)
+	c = Vec3{X: agxCurve(c.X), Y: agxCurve(c.Y), Z: agxCurve(c.Z)}
+	c = mulMat3(agxOutset, c)
+
+	// The curve produces display-encoded values; linearise them again so
+	// the shared sRGB encoding does not apply twice.
+	return Vec3{
+		X: math32.Pow(Clamp01(c.X), 2.2),
+		Y: math32.Pow(Clamp01(c.Y), 2.2),
+		Z: math32.Pow(Clamp01(c.Z), 2.2),
+	}
+}
+
+var agxInset = [3][3]float32{
+	{0.842479062253094, 0.0784335999999992, 0.0792237451477643},
+	{0.0423282422610123, 0.878468636469772, 0.0791661274605434},
+	{0.0423756549057051, 0.0784336, 0.879142973793104},
+}
+
+var agxOutset = [3][3]float32{
+	{1.19687900512017, -0.0980208811401368, -0.0990297440797205},
+	{-0.0528968517574562, 1.15190312990417, -0.0989611768448433},
+	{-0.0529716355144438, -0.0980434501171241, 1.15107367264116},
+}
+
+func agxCurve(v float32) float32 {
+	v = min(max(math32.Log2(max(v, 1e-10)), agxMinEV), agxMaxEV)
+	x := (v - agxMinEV) / (agxMaxEV - agxMinEV)
+
+	x2 := x * x
+	x4 := x2 * x2
+	return 15.5*x4*x2 - 40.14*x4*x + 31.96*x4 - 6.868*x2*x + 0.4298*x2 + 0.1191*x - 0.00232
+}
+
+func mulMat3(m [3][3]float32, v Vec3) Vec3 {
+	return Vec3{
+		X: m[0][0]*v.X + m[0][1]*v.Y + m[0][2]*v.Z,
+		Y: m[1][0]*v.X + m[1][1]*v.Y + m[1][2]*v.Z,
+		Z: m[2][0]*v.X + m[2][1]*v.Y + m[2][2]*v.Z,
+	}
+}
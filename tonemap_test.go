@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+func TestSRGBTransfer(t *testing.T) {
+	// Both pieces meet at the knee.
+	const knee, encodedKnee = 0.0031308, 0.04045
+	if got := EncodeSRGB(knee); math32.Abs(got-encodedKnee) > 1e-6 {
+		t.Errorf("EncodeSRGB(%g) = %g, want %g", knee, got, encodedKnee)
+	}
+	if got := 1.055*math32.Pow(knee, 1/2.4) - 0.055; math32.Abs(got-encodedKnee) > 1e-5 {
+		t.Errorf("the curve reaches %g at the knee, the line %g", got, encodedKnee)
+	}
+	if got := DecodeSRGB(encodedKnee); math32.Abs(got-knee) > 1e-7 {
+		t.Errorf("DecodeSRGB(%g) = %g, want %g", encodedKnee, got, knee)
+	}
+
+	tests := []struct{ linear, encoded float32 }{
+		{0, 0},
+		{1, 1},
+		{0.001, 0.01292},
+		{0.5, 0.735357},
+		{0.214041, 0.5},
+	}
+	for _, test := range tests {
+		if got := EncodeSRGB(test.linear); math32.Abs(got-test.encoded) > 1e-5 {
+			t.Errorf("EncodeSRGB(%g) = %g, want %g", test.linear, got, test.encoded)
+		}
+		if got := DecodeSRGB(test.encoded); math32.Abs(got-test.linear) > 1e-5 {
+			t.Errorf("DecodeSRGB(%g) = %g, want %g", test.encoded, got, test.linear)
+		}
+	}
+	if EncodeSRGB(-1) != 0 || EncodeSRGB(2) != EncodeSRGB(1) || DecodeSRGB(-1) != 0 || DecodeSRGB(2) != DecodeSRGB(1) {
+		t.Error("values outside [0, 1] are not clamped")
+	}
+
+	for i := range 1001 {
+		x := float32(i) / 1000
+		if got := DecodeSRGB(EncodeSRGB(x)); math32.Abs(got-x) > 1e-5 {
+			t.Fatalf("%g encodes and decodes to %g", x, got)
+		}
+		if got := EncodeSRGB(DecodeSRGB(x)); math32.Abs(got-x) > 1e-5 {
+			t.Fatalf("%g decodes and encodes to %g", x, got)
+		}
+	}
+}
+
+func TestReinhardWhitePoint(t *testing.T) {
+	for _, white := range []float32{1, 4, 11.2} {
+		reinhard := ReinhardToneMapper{WhitePoint: white}
+		grey := Vec3{X: white, Y: white, Z: white}
+		if got := reinhard.Map(grey); !near(got, Vec3{X: 1, Y: 1, Z: 1}) {
+			t.Errorf("white point %g maps to %v, want white", white, got)
+		}
+		if got := reinhard.Map(grey.Scale(2)); got != (Vec3{X: 1, Y: 1, Z: 1}) {
+			t.Errorf("white point %g: twice the white point maps to %v, want white", white, got)
+		}
+	}
+
+	// Below white, colours are scaled by their luminance's curve and keep
+	// their hue.
+	reinhard := ReinhardToneMapper{WhitePoint: 4}
+	c := Vec3{X: 0.4, Y: 0.2, Z: 0.1}
+	l := colorToLuminance(c)
+	want := c.Scale((1 + l/16) / (1 + l))
+	if got := reinhard.Map(c); !near(got, want) {
+		t.Errorf("%v maps to %v, want %v", c, got, want)
+	}
+}
+
+func TestToneMappersMonotonic(t *testing.T) {
+	for _, name := range ToneMapperNames {
+		operator, err := ParseToneMapper(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := operator.Map(Vec3{}); got != (Vec3{}) {
+			t.Errorf("%s maps black to %v", name, got)
+		}
+		// Grey ramps, from deep shadow to far past white, rise in every
+		// channel. Coloured ones rise in luminance; once AgX's curve clips
+		// the saturated channel, its outset matrix takes a trace of it off
+		// again as the others keep rising.
+		for _, hue := range []Vec3{{X: 1, Y: 1, Z: 1}, {X: 1, Y: 0.5, Z: 0.1}, {X: 0.05, Y: 0.2, Z: 1}} {
+			previous := Vec3{}
+			for i := range 2000 {
+				x := math32.Exp2(float32(i)/100 - 12)
+				v := operator.Map(hue.Scale(x))
+				if hue.X == hue.Z && (v.X < previous.X || v.Y < previous.Y || v.Z < previous.Z) {
+					t.Fatalf("%s of grey falls from %v to %v at %g", name, previous, v, x)
+				}
+				if colorToLuminance(v) < colorToLuminance(previous)-1e-4 {
+					t.Fatalf("%s of %v falls in luminance from %v to %v at %g", name, hue, previous, v, x)
+				}
+				if v.X > 1 || v.Y > 1 || v.Z > 1 {
+					t.Fatalf("%s of %v maps to %v, past white", name, hue, v)
+				}
+				previous = v
+			}
+		}
+	}
+}
+
+func TestExposure(t *testing.T) {
+	c := Vec3{X: 0.02, Y: 0.1, Z: 0.3}
+	for _, name := range ToneMapperNames {
+		operator, _ := ParseToneMapper(name)
+		for _, stops := range []float32{-2, -1, 0.5, 1, 3} {
+			exposed := ToneMapping{Operator: operator, Exposure: stops}.Apply(c)
+			scaled := ToneMapping{Operator: operator}.Apply(c.Scale(math32.Exp2(stops)))
+			if exposed != scaled {
+				t.Errorf("%s at %+g EV gives %v, the colour scaled by %g %v", name, stops, exposed, math32.Exp2(stops), scaled)
+			}
+		}
+	}
+
+	// With the linear operator, +2 EV is a factor of four.
+	linear := ToneMapping{Operator: LinearToneMapper{}, Exposure: 2}
+	if got, want := linear.Apply(Vec3{X: 0.1, Y: 0.2}), (ToneMapping{Operator: LinearToneMapper{}}).Apply(Vec3{X: 0.4, Y: 0.8}); got != want {
+		t.Errorf("+2 EV of (0.1, 0.2) is %v, want %v", got, want)
+	}
+}
+
+func TestParseToneMapper(t *testing.T) {
+	for _, name := range ToneMapperNames {
+		for _, spelling := range []string{name, string(append([]byte{name[0] - 'a' + 'A'}, name[1:]...))} {
+			operator, err := ParseToneMapper(spelling)
+			if err != nil {
+				t.Errorf("ParseToneMapper(%q): %v", spelling, err)
+			} else if operator.Name() != name {
+				t.Errorf("ParseToneMapper(%q) is %s", spelling, operator.Name())
+			}
+		}
+	}
+	for _, name := range []string{"", "filmic", "aces2", " aces"} {
+		if operator, err := ParseToneMapper(name); err == nil {
+			t.Errorf("ParseToneMapper(%q) = %s, want an error", name, operator.Name())
+		}
+	}
+
+	// The viewer cycles through every operator and back to the first.
+	operator, _ := ParseToneMapper(ToneMapperNames[0])
+	for i := range ToneMapperNames {
+		operator = NextToneMapper(operator)
+		if want := ToneMapperNames[(i+1)%len(ToneMapperNames)]; operator.Name() != want {
+			t.Errorf("step %d goes to %s, want %s", i+1, operator.Name(), want)
+		}
+	}
+}
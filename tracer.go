@@ -2,7 +2,6 @@ package main
 
 import (
 	"math"
-	"math/rand"
 	"strings"
 	"sync/atomic"
 
@@ -12,7 +11,7 @@ import (
 var raysTraced atomic.Int64 = atomic.Int64{}
 var recentRaysTraced atomic.Int64 = atomic.Int64{}
 
-func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scatterRays int, vnmu *VNMU, ambient float32, scene *Scene, bounceIndex int, lastSuraceNormal Vec3, isSpecular bool, refractiveIndex *RefractiveIndexTracker, energy float32) Vec3 {
+func TraceRay(ray Ray, sampler Sampler, stepSize float32, bvh *TopLevelBVH, maxSteps, bounces, scatterRays int, vnmu *VNMU, ambient float32, scene *Scene, bounceIndex int, lastSuraceNormal Vec3, isSpecular bool, refractiveIndex *RefractiveIndexTracker, energy float32, aov *AOVSample, camera *cameraHit) Vec3 {
 	if energy < 1e-2 || bounces < 0 {
 		return Vec3{}
 	}
@@ -30,22 +29,39 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 		V_t_initial = rayState.V_t
 	}
 
+	cameraPosition := ray.Origin
 	rayPosition := ray.Origin
 	for range maxSteps {
-		intersects, t, tri := bvh.CheckIntersection(ray, stepSize)
+		var intersects bool
+		var t float32
+		var hit BVHTriangle
+		switch {
+		case camera != nil:
+			intersects, t, hit = camera.hit, camera.t, camera.tri
+			if aov != nil {
+				aov.Cost.Add(camera.cost)
+			}
+		case aov != nil:
+			intersects, t, hit = bvh.CountedIntersection(ray, stepSize, &aov.Cost)
+		default:
+			intersects, t, hit = bvh.CheckIntersection(ray, stepSize)
+		}
 		if intersects {
-			intersection_point := rayPosition.Add(ray.Direction.Scale(t))
-			normal := InterpolateNormal(
-				intersection_point,
-				tri.A,
-				tri.B,
-				tri.C,
-				vnmu.Normals[tri.Index],
-				vnmu.Normals[tri.Index+1],
-				vnmu.Normals[tri.Index+2],
-			).Normalize()
-
-			material := vnmu.Materials[tri.Index/3]
+			tri := &hit
+			intersection_point := tri.SurfacePoint(rayPosition.Add(ray.Direction.Scale(t)))
+			normal := vnmu.ShadingNormal(tri, intersection_point).Normalize()
+
+			material := vnmu.Material(tri)
+			if aov != nil {
+				aov.Hit = true
+				aov.Position = intersection_point
+				aov.Normal = normal
+				aov.Depth = intersection_point.Sub(cameraPosition).Length()
+				aov.Material = vnmu.MaterialID(tri)
+				aov.Object = vnmu.ObjectID(tri)
+				aov.Albedo = FromColor(material.Diffuse)
+			}
+
 			if strings.HasPrefix(material.Name, "Glass") {
 				ri := float32(material.Refraction)
 				goingOut := false
@@ -56,12 +72,14 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 				}
 
 				refractedRayDir, tir := GetRefractedRay(ray.Direction, normal, refractiveIndex.GetCurrentIndex(), ri)
+				refractionOrigin := OffsetRayOrigin(intersection_point, tri.GeometricNormal(intersection_point), refractedRayDir)
 				if tir {
 					refractionComponent = TraceRay(
 						Ray{
-							Origin:    intersection_point.Add(refractedRayDir.Scale(0.001)),
+							Origin:    refractionOrigin,
 							Direction: refractedRayDir,
 						},
+						sampler,
 						stepSize,
 						bvh,
 						maxSteps,
@@ -75,10 +93,12 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 						isSpecular,
 						refractiveIndex,
 						energy*0.95,
+						nil,
+						nil,
 					).Scale(energy)
 				} else {
 					refractedRay := Ray{
-						Origin:    intersection_point.Add(refractedRayDir.Scale(0.001)),
+						Origin:    refractionOrigin,
 						Direction: refractedRayDir,
 					}
 					if goingOut {
@@ -86,7 +106,7 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 					} else {
 						refractiveIndex.UpdateIndex(ri)
 					}
-					refractionComponent = TraceRay(refractedRay, stepSize, bvh, maxSteps, bounces-1, scatterRays, vnmu, ambient, scene, bounceIndex, lastSuraceNormal, isSpecular, refractiveIndex, energy*0.95).Scale(energy)
+					refractionComponent = TraceRay(refractedRay, sampler, stepSize, bvh, maxSteps, bounces-1, scatterRays, vnmu, ambient, scene, bounceIndex, lastSuraceNormal, isSpecular, refractiveIndex, energy*0.95, nil, nil).Scale(energy)
 				}
 			}
 
@@ -121,6 +141,7 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 					// Handle low reflectivity
 					dc, isIndirectEmissive := HandleDiffuseMaterial(
 						ray,
+						sampler,
 						stepSize,
 						bvh,
 						maxSteps,
@@ -138,13 +159,15 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 						lastSuraceNormal,
 						refractiveIndex,
 						energy,
+						aov,
+						camera,
 					)
 
 					if isIndirectEmissive && !isSpecular {
 						// Do MIS
 						pdf_brdf := ray.Direction.Dot(lastSuraceNormal) / math.Pi
 
-						triangle_area := TriangleArea(tri.A, tri.B, tri.C)
+						triangle_area := tri.Area()
 						pdf_NEE_area := 1.0 / (float32(len(vnmu.EmissiveTriangles)) * triangle_area)
 
 						lightNormal := normal
@@ -160,12 +183,16 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 					diffuseComponent = dc
 				case reflectivity < 0.9:
 					// Handle medium reflectivity
-					isReflectiveRay := rand.Float32() < reflectivity
+					isReflectiveRay := sampler.Get1D() < reflectivity
 					if isReflectiveRay {
-						reflectiveComponent = HandleReflectiveMaterial(ray.Origin, ray.Direction, stepSize, bvh, maxSteps, bounces, scatterRays, vnmu, ambient, scene, true, tri, intersection_point, rayPosition, normal, bounceIndex, refractiveIndex, energy).Add(refractionComponent)
+						if aov != nil {
+							aov.Albedo = FromColor(material.Specular)
+						}
+						reflectiveComponent = HandleReflectiveMaterial(ray.Origin, ray.Direction, sampler, stepSize, bvh, maxSteps, bounces, scatterRays, vnmu, ambient, scene, true, tri, intersection_point, rayPosition, normal, bounceIndex, refractiveIndex, energy).Add(refractionComponent)
 					} else {
 						dc, isIndirectEmissive := HandleDiffuseMaterial(
 							ray,
+							sampler,
 							stepSize,
 							bvh,
 							maxSteps,
@@ -183,13 +210,15 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 							lastSuraceNormal,
 							refractiveIndex,
 							energy,
+							aov,
+							camera,
 						)
 
 						if isIndirectEmissive && !isSpecular {
 							// Do MIS
 							pdf_brdf := ray.Direction.Dot(lastSuraceNormal) / math.Pi
 
-							triangle_area := TriangleArea(tri.A, tri.B, tri.C)
+							triangle_area := tri.Area()
 							pdf_NEE_area := 1.0 / (float32(len(vnmu.EmissiveTriangles)) * triangle_area)
 
 							lightNormal := normal
@@ -208,7 +237,10 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 					// os.Exit(1)
 					// return Vec3{}
 					// Handle high reflectivity\
-					reflectiveComponent = HandleReflectiveMaterial(ray.Origin, ray.Direction, stepSize, bvh, maxSteps, bounces, scatterRays, vnmu, ambient, scene, true, tri, intersection_point, rayPosition, normal, bounceIndex, refractiveIndex, energy).Add(refractionComponent)
+					if aov != nil {
+						aov.Albedo = FromColor(material.Specular)
+					}
+					reflectiveComponent = HandleReflectiveMaterial(ray.Origin, ray.Direction, sampler, stepSize, bvh, maxSteps, bounces, scatterRays, vnmu, ambient, scene, true, tri, intersection_point, rayPosition, normal, bounceIndex, refractiveIndex, energy).Add(refractionComponent)
 				}
 				// case 4:
 				// 	return refractiveComponent
@@ -220,9 +252,14 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 				diffuseScale = 0.1
 			}
 			final := diffuseComponent.Scale(diffuseScale).Add(specularComponent).Add(reflectiveComponent).Add(refractionComponent)
+			if aov != nil {
+				aov.Direct._Scale(diffuseScale * dopplerFactor * gravitationalFactor)
+				aov.Emission._Scale(diffuseScale * dopplerFactor * gravitationalFactor)
+			}
 			return final.Scale(float32(dopplerFactor * gravitationalFactor))
 		}
 
+		camera = nil
 		if rayState == nil {
 			rayPosition = rayPosition.Add(ray.Direction.Scale(stepSize))
 			ray.Origin = rayPosition
@@ -260,7 +297,11 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 	if scene.Skybox == nil {
 		return Vec3{}
 	}
-	return scene.Skybox.Sample(ray.Direction)
+	sky := scene.Skybox.Sample(ray.Direction)
+	if aov != nil {
+		aov.Emission = sky
+	}
+	return sky
 }
 
 // func HandleRefractiveMaterial(
@@ -349,8 +390,9 @@ func TraceRay(ray Ray, stepSize float32, bvh *LinearBVH, maxSteps, bounces, scat
 
 func HandleAmbientMaterial(
 	ray Ray,
+	sampler Sampler,
 	stepSize float32,
-	bvh *LinearBVH,
+	bvh *TopLevelBVH,
 	maxSteps, bounces, scatterRays int,
 	vnmu *VNMU,
 	ambient float32,
@@ -361,7 +403,7 @@ func HandleAmbientMaterial(
 	bounceIndex int,
 	lastSurfaceNormal Vec3,
 ) (Vec3, bool) {
-	material := vnmu.Materials[tri.Index/3]
+	material := vnmu.Material(tri)
 
 	// Get base diffuse color (albedo)
 	diffuseColor := material.Diffuse
@@ -369,31 +411,7 @@ func HandleAmbientMaterial(
 	// Calculate UV coordinates
 	var x, y float32
 	if material.HasImage {
-		triangleIndex := tri.Index / 3
-		baseUVIndex := triangleIndex * 6
-
-		uv0_x, uv0_y := vnmu.UVs[baseUVIndex], vnmu.UVs[baseUVIndex+1]
-		uv1_x, uv1_y := vnmu.UVs[baseUVIndex+2], vnmu.UVs[baseUVIndex+3]
-		uv2_x, uv2_y := vnmu.UVs[baseUVIndex+4], vnmu.UVs[baseUVIndex+5]
-
-		// Calculate barycentric coordinates
-		v0 := tri.B.Sub(tri.A)
-		v1 := tri.C.Sub(tri.A)
-		v2 := intersection_point.Sub(tri.A)
-
-		dot00 := v0.Dot(v0)
-		dot01 := v0.Dot(v1)
-		dot02 := v0.Dot(v2)
-		dot11 := v1.Dot(v1)
-		dot12 := v1.Dot(v2)
-
-		invDenom := 1.0 / (dot00*dot11 - dot01*dot01)
-		u := (dot11*dot02 - dot01*dot12) * invDenom
-		v := (dot00*dot12 - dot01*dot02) * invDenom
-		w := 1.0 - u - v
-
-		x = tile(w*uv0_x + u*uv1_x + v*uv2_x)
-		y = tile(w*uv0_y + u*uv1_y + v*uv2_y)
+		x, y = vnmu.TexCoords(tri, intersection_point)
 	}
 
 	// Sample texture if available
@@ -411,6 +429,10 @@ func HandleAmbientMaterial(
 		bumpNormal := SampleBumpMap(material.BumpImage, x, y, 1.0)
 		normal = TransformNormalToWorldSpace(bumpNormal, normal, tri, intersection_point, vnmu.UVs).Normalize()
 	}
+	if material.NormalImage != nil {
+		mapNormal := SampleNormalMap(material.NormalImage, x, y, material.NormalScale)
+		normal = TransformNormalToWorldSpace(mapNormal, normal, tri, intersection_point, vnmu.UVs).Normalize()
+	}
 
 	// Create albedo vector
 	albedo := Vec3{
@@ -426,7 +448,7 @@ func HandleAmbientMaterial(
 	// From Skybox
 	if scene.Skybox != nil {
 		for range 1 {
-			randomNormal := GetCosineWeighedHemisphereSampling(normal)
+			randomNormal := GetCosineWeighedHemisphereSampling(sampler, normal)
 			// ray := Ray{
 			// 	Origin:    rayOrigin,
 			// 	Direction: randomNormal,
@@ -442,8 +464,9 @@ func HandleAmbientMaterial(
 
 func HandleDiffuseMaterial(
 	ray Ray,
+	sampler Sampler,
 	stepSize float32,
-	bvh *LinearBVH,
+	bvh *TopLevelBVH,
 	maxSteps, bounces, scatterRays int,
 	vnmu *VNMU,
 	ambient float32,
@@ -455,8 +478,10 @@ func HandleDiffuseMaterial(
 	lastSurfaceNormal Vec3,
 	ri *RefractiveIndexTracker,
 	ni float32,
+	aov *AOVSample,
+	camera *cameraHit,
 ) (Vec3, bool) {
-	material := vnmu.Materials[tri.Index/3]
+	material := vnmu.Material(tri)
 	emissiveColor := material.Emissive
 	if bounceIndex > 0 { // This is an indirect ray
 		if emissiveColor.R > 0 || emissiveColor.G > 0 || emissiveColor.B > 0 {
@@ -470,31 +495,7 @@ func HandleDiffuseMaterial(
 	// Calculate UV coordinates
 	var x, y float32
 	if material.HasImage {
-		triangleIndex := tri.Index / 3
-		baseUVIndex := triangleIndex * 6
-
-		uv0_x, uv0_y := vnmu.UVs[baseUVIndex], vnmu.UVs[baseUVIndex+1]
-		uv1_x, uv1_y := vnmu.UVs[baseUVIndex+2], vnmu.UVs[baseUVIndex+3]
-		uv2_x, uv2_y := vnmu.UVs[baseUVIndex+4], vnmu.UVs[baseUVIndex+5]
-
-		// Calculate barycentric coordinates
-		v0 := tri.B.Sub(tri.A)
-		v1 := tri.C.Sub(tri.A)
-		v2 := intersection_point.Sub(tri.A)
-
-		dot00 := v0.Dot(v0)
-		dot01 := v0.Dot(v1)
-		dot02 := v0.Dot(v2)
-		dot11 := v1.Dot(v1)
-		dot12 := v1.Dot(v2)
-
-		invDenom := 1.0 / (dot00*dot11 - dot01*dot01)
-		u := (dot11*dot02 - dot01*dot12) * invDenom
-		v := (dot00*dot12 - dot01*dot02) * invDenom
-		w := 1.0 - u - v
-
-		x = tile(w*uv0_x + u*uv1_x + v*uv2_x)
-		y = tile(w*uv0_y + u*uv1_y + v*uv2_y)
+		x, y = vnmu.TexCoords(tri, intersection_point)
 	}
 
 	// Sample texture if available
@@ -512,6 +513,10 @@ func HandleDiffuseMaterial(
 		bumpNormal := SampleBumpMap(material.BumpImage, x, y, 1.0)
 		normal = TransformNormalToWorldSpace(bumpNormal, normal, tri, intersection_point, vnmu.UVs).Normalize()
 	}
+	if material.NormalImage != nil {
+		mapNormal := SampleNormalMap(material.NormalImage, x, y, material.NormalScale)
+		normal = TransformNormalToWorldSpace(mapNormal, normal, tri, intersection_point, vnmu.UVs).Normalize()
+	}
 
 	// Create albedo vector
 	albedo := Vec3{
@@ -525,14 +530,14 @@ func HandleDiffuseMaterial(
 
 	// Calculate direct lighting
 	var directContribution Vec3
-	rayOrigin := intersection_point.Add(normal.Scale(0.001))
+	geometricNormal := tri.GeometricNormal(intersection_point)
 
 	// From Skybox
 	if scene.Skybox != nil {
 		for range 1 {
-			randomNormal := GetCosineWeighedHemisphereSampling(normal)
+			randomNormal := GetCosineWeighedHemisphereSampling(sampler, normal)
 			ray := Ray{
-				Origin:    rayOrigin,
+				Origin:    OffsetRayOrigin(intersection_point, geometricNormal, randomNormal),
 				Direction: randomNormal,
 			}
 			if !bvh.QuickCheckIntersection(ray, 100000.0) {
@@ -542,39 +547,38 @@ func HandleDiffuseMaterial(
 	}
 
 	// From lights
-	for _, light := range scene.Lights {
+	for i, light := range scene.Lights {
 		var lightDirection Vec3
 		sun, isSun := light.Object.(*Sun)
 		if isSun {
 			lightDirection = sun.Direction
 		} else {
-			lightDirection = light.Position.Sub(rayOrigin).Normalize()
+			lightDirection = light.Position.Sub(intersection_point).Normalize()
 		}
 		lightRay := Ray{
-			Origin:    rayOrigin,
+			Origin:    OffsetRayOrigin(intersection_point, geometricNormal, lightDirection),
 			Direction: lightDirection,
 		}
-		contribution := light.Object.Sample(lightRay, normal, bvh, stepSize, light.Position)
+		var contribution Vec3
+		if camera != nil && i == camera.sunLight {
+			contribution = sun.Shade(lightRay, normal, camera.sunShadowed)
+		} else {
+			contribution = light.Object.Sample(lightRay, normal, bvh, stepSize, light.Position)
+		}
 		// Apply lighting to albedo (not as multiplication but as proper lighting)
 		directContribution._Add(albedo.ComponentMul(contribution))
 	}
 	// Now for emissive surfaces
 	if len(vnmu.EmissiveTriangles) > 0 {
-		rayOrigin := intersection_point.Add(normal.Scale(0.01))
 		emissiveContribution := func() Vec3 {
-			choice := rand.Intn(len(vnmu.EmissiveTriangles))
-			i0 := vnmu.EmissiveTriangles[choice].VertexIndices[0]
-			i1 := vnmu.EmissiveTriangles[choice].VertexIndices[1]
-			i2 := vnmu.EmissiveTriangles[choice].VertexIndices[2]
-
-			n0 := vnmu.EmissiveTriangles[choice].NormalIndices[0]
-			n1 := vnmu.EmissiveTriangles[choice].NormalIndices[1]
-			n2 := vnmu.EmissiveTriangles[choice].NormalIndices[2]
-
-			lightPoint := SampleTrianglePoint(vnmu.Vertices[i0], vnmu.Vertices[i1], vnmu.Vertices[i2])
-			lightSurfaceNormal := InterpolateNormal(
-				lightPoint, vnmu.Vertices[i0], vnmu.Vertices[i1], vnmu.Vertices[i2], vnmu.Normals[n0], vnmu.Normals[n1], vnmu.Normals[n2])
-			toLight := lightPoint.Sub(rayOrigin)
+			choice := min(int(sampler.Get1D()*float32(len(vnmu.EmissiveTriangles))), len(vnmu.EmissiveTriangles)-1)
+			lightPoint, lightSurfaceNormal, lightArea := vnmu.SampleEmitter(sampler, &vnmu.EmissiveTriangles[choice])
+			toLight := lightPoint.Sub(intersection_point).Normalize()
+			// Both ends are moved off their surfaces, so the shadow ray
+			// hits neither the surface it leaves nor the emitter.
+			rayOrigin := OffsetRayOrigin(intersection_point, geometricNormal, toLight)
+			target := OffsetRayOrigin(lightPoint, lightSurfaceNormal, toLight.Scale(-1))
+			toLight = target.Sub(rayOrigin)
 			distance := toLight.Length()
 			toLight._Normalize()
 
@@ -587,7 +591,7 @@ func HandleDiffuseMaterial(
 				Origin:    rayOrigin,
 				Direction: toLight,
 			}
-			shadow := bvh.QuickCheckIntersection(shadowRay, distance-0.01)
+			shadow := bvh.QuickCheckIntersection(shadowRay, distance)
 			if shadow {
 				return Vec3{}
 			}
@@ -597,8 +601,7 @@ func HandleDiffuseMaterial(
 			}
 
 			geometryTerm := ndotl * sndorl / (distance * distance)
-			pdf := TriangleArea(vnmu.Vertices[i0], vnmu.Vertices[i1], vnmu.Vertices[i2])
-			pdf = 1.0 / (pdf * float32(len(vnmu.EmissiveTriangles)))
+			pdf := 1.0 / (lightArea * float32(len(vnmu.EmissiveTriangles)))
 
 			pdf_brdf := normal.Dot(toLight) / math.Pi
 			pdf_solidAngle := pdf * (distance * distance) / sndorl
@@ -606,7 +609,7 @@ func HandleDiffuseMaterial(
 			// Calculate MIS weight
 			weight := MISWeight(pdf_solidAngle, pdf_brdf)
 
-			lightMaterial := FromColor(vnmu.Materials[vnmu.EmissiveTriangles[choice].MaterialIndex].Emissive) //) vnmu.Materials[vnmu.EmissiveTriangles[choice*3]]
+			lightMaterial := FromColor(vnmu.EmitterMaterial(&vnmu.EmissiveTriangles[choice]).Emissive) //) vnmu.Materials[vnmu.EmissiveTriangles[choice*3]]
 			// lightEmission := FromColor(lightMaterial.Emissive)
 			lightEmission := lightMaterial
 			brdf := albedo.Scale(1.0 / math.Pi)
@@ -634,10 +637,10 @@ func HandleDiffuseMaterial(
 		tangent2 := normal.Cross(tangent1)
 
 		for range scatterRays {
-			dir = GetCosineWeighedHemisphereSampling2(normal, tangent1, tangent2)
+			dir = GetCosineWeighedHemisphereSampling2(sampler, normal, tangent1, tangent2)
 
-			ray := NewRay(intersection_point.Add(normal.Scale(0.001)), dir)
-			contribution := TraceRay(ray, stepSize, bvh, maxSteps, bounces-1, scatterRays, vnmu, ambient, scene, bounceIndex+1, normal, false, ri, ni)
+			ray := NewRay(OffsetRayOrigin(intersection_point, geometricNormal, dir), dir)
+			contribution := TraceRay(ray, sampler, stepSize, bvh, maxSteps, bounces-1, scatterRays, vnmu, ambient, scene, bounceIndex+1, normal, false, ri, ni, nil, nil)
 			// lambert := dir.Dot(normal)
 
 			// Apply albedo to incoming light, not as multiplication
@@ -663,14 +666,24 @@ func HandleDiffuseMaterial(
 		final._Add(FromColor(emissiveColor))
 	}
 
+	if aov != nil {
+		aov.Albedo = albedo
+		aov.Normal = normal
+		aov.Direct = directContribution.Add(ambientContribution)
+		if bounceIndex == 0 {
+			aov.Emission = FromColor(emissiveColor)
+		}
+	}
+
 	raysTraced.Add(1)
 	return final, false
 }
 
 func HandleReflectiveMaterial(
 	rayOrigin, rayDirection Vec3,
+	sampler Sampler,
 	stepSize float32,
-	bvh *LinearBVH,
+	bvh *TopLevelBVH,
 	maxSteps, bounces, scatterRays int,
 	vnmu *VNMU,
 	ambient float32,
@@ -682,7 +695,7 @@ func HandleReflectiveMaterial(
 	ri *RefractiveIndexTracker,
 	ni float32,
 ) Vec3 {
-	material := vnmu.Materials[tri.Index/3]
+	material := vnmu.Material(tri)
 
 	// Convert shininess to roughness (higher shininess = lower roughness)
 	roughness := 1.0 / (1.0 + material.Shininess/100.0)
@@ -699,18 +712,19 @@ func HandleReflectiveMaterial(
 	reflectionDirection := rayDirection.Sub(normal.Scale(2 * dotProduct)).Normalize()
 
 	var reflectionContribution Vec3
+	geometricNormal := tri.GeometricNormal(intersection_point)
 
 	for range scatterRays {
-		sampledDir := SampleGlossyReflection(reflectionDirection, normal, float32(roughness))
-		ray := NewRay(intersection_point.Add(normal.Scale(0.001)), sampledDir)
+		sampledDir := SampleGlossyReflection(sampler, reflectionDirection, normal, float32(roughness))
+		ray := NewRay(OffsetRayOrigin(intersection_point, geometricNormal, sampledDir), sampledDir)
 		contribution := TraceRay(
-			ray,
+			ray, sampler,
 			stepSize, bvh, maxSteps, bounces-1, scatterRays,
 			vnmu,
 			ambient, scene, bounceIndex+1,
 			normal,
 			true,
-			ri, ni,
+			ri, ni, nil, nil,
 		)
 		reflectionContribution._Add(contribution)
 	}
@@ -721,7 +735,7 @@ func HandleReflectiveMaterial(
 	return reflectionContribution
 }
 
-func SampleGlossyReflection(reflectionDir, normal Vec3, roughness float32) Vec3 {
+func SampleGlossyReflection(sampler Sampler, reflectionDir, normal Vec3, roughness float32) Vec3 {
 	// Build coordinate system around reflection direction
 	var up Vec3
 	if math32.Abs(reflectionDir.Y) < 0.9 {
@@ -734,9 +748,9 @@ func SampleGlossyReflection(reflectionDir, normal Vec3, roughness float32) Vec3
 	tangent2 := reflectionDir.Cross(tangent1).Normalize()
 
 	// Sample within cone - tighter cone for smoother materials
-	theta := rand.Float32() * 2 * math.Pi
+	u, v := sampler.Get2D()
+	theta := v * 2 * math.Pi
 	alpha := roughness * roughness
-	u := rand.Float32()
 	phi := math32.Atan(alpha * math32.Sqrt(u) / math32.Sqrt(1.0-u))
 
 	x := math32.Cos(theta) * math32.Sin(phi)
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/chewxy/math32"
+)
+
+// Mat4 is an affine transform in row-major order, applied to column
+// vectors: the translation is the last column and the last row is 0 0 0 1.
+type Mat4 [4][4]float32
+
+func Identity() Mat4 {
+	return Mat4{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+}
+
+func Translation(v Vec3) Mat4 {
+	return Mat4{{1, 0, 0, v.X}, {0, 1, 0, v.Y}, {0, 0, 1, v.Z}, {0, 0, 0, 1}}
+}
+
+func Scaling(v Vec3) Mat4 {
+	return Mat4{{v.X, 0, 0, 0}, {0, v.Y, 0, 0}, {0, 0, v.Z, 0}, {0, 0, 0, 1}}
+}
+
+// Rotation rotates by the Euler angles in radians: about x first, then y,
+// then z.
+func Rotation(x, y, z float32) Mat4 {
+	sx, cx := math32.Sincos(x)
+	sy, cy := math32.Sincos(y)
+	sz, cz := math32.Sincos(z)
+	rx := Mat4{{1, 0, 0, 0}, {0, cx, -sx, 0}, {0, sx, cx, 0}, {0, 0, 0, 1}}
+	ry := Mat4{{cy, 0, sy, 0}, {0, 1, 0, 0}, {-sy, 0, cy, 0}, {0, 0, 0, 1}}
+	rz := Mat4{{cz, -sz, 0, 0}, {sz, cz, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	return rz.Mul(ry).Mul(rx)
+}
+
+// Mul returns m·n, which applies n first.
+func (m Mat4) Mul(n Mat4) Mat4 {
+	var out Mat4
+	for i := range 4 {
+		for j := range 4 {
+			out[i][j] = m[i][0]*n[0][j] + m[i][1]*n[1][j] + m[i][2]*n[2][j] + m[i][3]*n[3][j]
+		}
+	}
+	return out
+}
+
+func (m Mat4) IsIdentity() bool {
+	return m == Identity()
+}
+
+// Affine reports whether the last row is 0 0 0 1.
+func (m Mat4) Affine() bool {
+	return m[3] == [4]float32{0, 0, 0, 1}
+}
+
+func (m *Mat4) Point(p Vec3) Vec3 {
+	return Vec3{
+		X: m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z + m[0][3],
+		Y: m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z + m[1][3],
+		Z: m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z + m[2][3],
+	}
+}
+
+func (m *Mat4) Vector(v Vec3) Vec3 {
+	return Vec3{
+		X: m[0][0]*v.X + m[0][1]*v.Y + m[0][2]*v.Z,
+		Y: m[1][0]*v.X + m[1][1]*v.Y + m[1][2]*v.Z,
+		Z: m[2][0]*v.X + m[2][1]*v.Y + m[2][2]*v.Z,
+	}
+}
+
+// TransposedVector multiplies by the transpose of the linear part. Called
+// on the inverse of a transform it carries normals through the transform.
+func (m *Mat4) TransposedVector(v Vec3) Vec3 {
+	return Vec3{
+		X: m[0][0]*v.X + m[1][0]*v.Y + m[2][0]*v.Z,
+		Y: m[0][1]*v.X + m[1][1]*v.Y + m[2][1]*v.Z,
+		Z: m[0][2]*v.X + m[1][2]*v.Y + m[2][2]*v.Z,
+	}
+}
+
+// Determinant of the linear part.
+func (m Mat4) Determinant() float32 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// Inverse inverts an affine transform. It reports false if the transform
+// is not affine or collapses space onto a plane, line or point.
+func (m Mat4) Inverse() (Mat4, bool) {
+	det := m.Determinant()
+	if !m.Affine() || det == 0 || math32.IsNaN(det) || math32.IsInf(det, 0) {
+		return Mat4{}, false
+	}
+
+	inv := 1 / det
+	var out Mat4
+	out[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) * inv
+	out[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) * inv
+	out[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) * inv
+	out[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) * inv
+	out[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) * inv
+	out[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) * inv
+	out[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) * inv
+	out[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) * inv
+	out[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) * inv
+
+	t := out.Vector(Vec3{X: m[0][3], Y: m[1][3], Z: m[2][3]})
+	out[0][3], out[1][3], out[2][3] = -t.X, -t.Y, -t.Z
+	out[3] = [4]float32{0, 0, 0, 1}
+	return out, true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+
+	"github.com/chewxy/math32"
+)
+
+// rayShear moves a ray onto the z axis for the watertight triangle test:
+// the axes are renamed so the direction's largest component is z, and the
+// corners sheared so the direction becomes (0, 0, 1). A triangle is then
+// hit if its corners wind around the origin of the xy plane. A ray along
+// an edge or through a corner shared by several triangles computes the
+// same edge values for all of them, so it hits at least one.
+type rayShear struct {
+	kx, ky, kz int
+	sx, sy, sz float32
+}
+
+func newRayShear(d Vec3) rayShear {
+	kz := 0
+	if math32.Abs(d.Y) > math32.Abs(d.axis(kz)) {
+		kz = 1
+	}
+	if math32.Abs(d.Z) > math32.Abs(d.axis(kz)) {
+		kz = 2
+	}
+	kx, ky := (kz+1)%3, (kz+2)%3
+	// Keep the winding of the triangles.
+	if d.axis(kz) < 0 {
+		kx, ky = ky, kx
+	}
+	dz := d.axis(kz)
+	return rayShear{kx: kx, ky: ky, kz: kz, sx: d.axis(kx) / dz, sy: d.axis(ky) / dz, sz: 1 / dz}
+}
+
+func (v Vec3) axis(k int) float32 {
+	switch k {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
+
+// intersectWatertight returns the distance in (0, tMax] at which the ray
+// from origin, sheared by s, hits the triangle ABC from either side
+// (Woop, Benthin and Wald, "Watertight Ray/Triangle Intersection", 2013).
+func intersectWatertight(origin Vec3, s *rayShear, tMax float32, A, B, C Vec3) (bool, float32) {
+	a, b, c := A.Sub(origin), B.Sub(origin), C.Sub(origin)
+	az, bz, cz := a.axis(s.kz), b.axis(s.kz), c.axis(s.kz)
+	ax, ay := a.axis(s.kx)-s.sx*az, a.axis(s.ky)-s.sy*az
+	bx, by := b.axis(s.kx)-s.sx*bz, b.axis(s.ky)-s.sy*bz
+	cx, cy := c.axis(s.kx)-s.sx*cz, c.axis(s.ky)-s.sy*cz
+
+	// The edge functions, twice the signed areas of the triangles the
+	// origin makes with each edge.
+	u := cx*by - cy*bx
+	v := ax*cy - ay*cx
+	w := bx*ay - by*ax
+	// A zero may be rounding of a ray passing just beside an edge; only
+	// double precision tells which side.
+	if u == 0 || v == 0 || w == 0 {
+		u = float32(float64(cx)*float64(by) - float64(cy)*float64(bx))
+		v = float32(float64(ax)*float64(cy) - float64(ay)*float64(cx))
+		w = float32(float64(bx)*float64(ay) - float64(by)*float64(ax))
+	}
+	if (u < 0 || v < 0 || w < 0) && (u > 0 || v > 0 || w > 0) {
+		return false, 0
+	}
+	det := u + v + w
+	if det == 0 {
+		return false, 0
+	}
+
+	t := (u*az + v*bz + w*cz) * s.sz / det
+	if !(t > 0 && t <= tMax) {
+		return false, 0
+	}
+
+	// Rounding may have made t positive for a triangle just behind the
+	// origin, such as the one a ray leaves; bound its error as Pharr, Jakob
+	// and Humphreys do in Physically Based Rendering, section 6.8, and
+	// only accept t beyond it.
+	// The directions here are not normalized, so unlike PBRT the shear of
+	// x and y is bounded with the z distances before they are scaled to t.
+	maxDepth := max(math32.Abs(az), math32.Abs(bz), math32.Abs(cz))
+	maxZ := maxDepth * math32.Abs(s.sz)
+	maxX := max(math32.Abs(ax), math32.Abs(bx), math32.Abs(cx))
+	maxY := max(math32.Abs(ay), math32.Abs(by), math32.Abs(cy))
+	deltaZ := gamma(3) * maxZ
+	deltaX := gamma(5) * (maxX + math32.Abs(s.sx)*maxDepth)
+	deltaY := gamma(5) * (maxY + math32.Abs(s.sy)*maxDepth)
+	deltaE := 2 * (gamma(2)*maxX*maxY + deltaY*maxX + deltaX*maxY)
+	maxE := max(math32.Abs(u), math32.Abs(v), math32.Abs(w))
+	deltaT := 3 * (gamma(3)*maxE*maxZ + deltaE*maxZ + deltaZ*maxE) / math32.Abs(det)
+	if t <= deltaT {
+		return false, 0
+	}
+	return true, t
+}
+
+// gamma bounds the relative error of n floating-point operations.
+func gamma(n float32) float32 {
+	const epsilon = 0x1p-24
+	return n * epsilon / (1 - n*epsilon)
+}
+
+// IntersectSegmentTriangle returns the distance in (0, stepSize] at which
+// the ray hits the triangle ABC, in units of the direction's length.
+func IntersectSegmentTriangle(origin, direction Vec3, stepSize float32, A, B, C Vec3) (bool, float32) {
+	s := newRayShear(direction)
+	return intersectWatertight(origin, &s, stepSize, A, B, C)
+}
+
+// FastIntersectShadowTriangle reports whether the ray hits the triangle
+// ABC within (0, tmax).
+func FastIntersectShadowTriangle(origin, dir Vec3, tmax float32, A, B, C Vec3) bool {
+	s := newRayShear(dir)
+	hit, t := intersectWatertight(origin, &s, tmax, A, B, C)
+	return hit && t < tmax
+}
+
+// Ray origin offsets, after Wächter and Binder, "A Fast and Robust Method
+// for Avoiding Self-Intersection", Ray Tracing Gems, 2019.
+const (
+	// offsetULPs is how many units in the last place a point is moved,
+	// which covers the error of a hit point computed in single precision.
+	offsetULPs = 256
+	// Within offsetOrigin of zero the units in the last place get too small
+	// to move a point off its surface, so it is moved by a fixed distance
+	// of offsetNearOrigin instead.
+	offsetOrigin     = 1.0 / 32
+	offsetNearOrigin = 1.0 / 65536
+)
+
+// OffsetRayOrigin moves p, a point on a surface with geometric normal n,
+// off the surface to the side dir leaves towards, so a ray from it does
+// not hit the surface again. The distance grows with the magnitude of p,
+// as the rounding error of p does.
+func OffsetRayOrigin(p, n, dir Vec3) Vec3 {
+	if n.Dot(dir) < 0 {
+		n = n.Scale(-1)
+	}
+	return Vec3{X: offsetCoordinate(p.X, n.X), Y: offsetCoordinate(p.Y, n.Y), Z: offsetCoordinate(p.Z, n.Z)}
+}
+
+func offsetCoordinate(p, n float32) float32 {
+	if math32.Abs(p) < offsetOrigin {
+		return p + offsetNearOrigin*n
+	}
+	ulps := int32(offsetULPs * n)
+	if p < 0 {
+		ulps = -ulps
+	}
+	return math.Float32frombits(uint32(int32(math.Float32bits(p)) + ulps))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+// fan returns a closed fan of n triangles around center in the plane
+// spanned by u and v, its rim jittered so no two triangles are alike. With
+// n of 4 or more no triangle spans half a turn, so none folds over.
+func fan(rng *rand.Rand, center, u, v Vec3, radius float32, n int) [][3]Vec3 {
+	rim := make([]Vec3, n)
+	for i := range rim {
+		angle := (float32(i) + 0.8*rng.Float32() - 0.4) / float32(n) * 2 * math32.Pi
+		r := radius * (0.5 + rng.Float32())
+		rim[i] = center.Add(u.Scale(r * math32.Cos(angle))).Add(v.Scale(r * math32.Sin(angle)))
+	}
+	triangles := make([][3]Vec3, n)
+	for i := range triangles {
+		triangles[i] = [3]Vec3{center, rim[i], rim[(i+1)%n]}
+	}
+	return triangles
+}
+
+func randomDirection(rng *rand.Rand) Vec3 {
+	for {
+		d := Vec3{X: rng.Float32()*2 - 1, Y: rng.Float32()*2 - 1, Z: rng.Float32()*2 - 1}
+		if l := d.Length(); l > 0.1 && l <= 1 {
+			return d.Scale(1 / l)
+		}
+	}
+}
+
+// Rays through the centre of a fan or along the edges between its
+// triangles must hit at least one of them: nothing may slip through the
+// cracks.
+func TestWatertightFan(t *testing.T) {
+	rng := rand.New(rand.NewPCG(15, 16))
+	planes := []struct{ u, v Vec3 }{
+		{Vec3{X: 1}, Vec3{Y: 1}},
+		{Vec3{X: 1}, Vec3{Z: 1}},
+		{Vec3{X: 1, Y: 1}.Normalize(), Vec3{Z: 1}},
+	}
+	for range 20 {
+		u := randomDirection(rng)
+		planes = append(planes, struct{ u, v Vec3 }{u, u.Cross(randomDirection(rng)).Normalize()})
+	}
+
+	for _, scale := range []float32{1e-3, 1, 1e4} {
+		for _, plane := range planes {
+			center := randomDirection(rng).Scale(scale * 3 * rng.Float32())
+			triangles := fan(rng, center, plane.u, plane.v, scale, 4+rng.IntN(13))
+
+			// The vertex all triangles share, and points along the edges
+			// between triangles. The rim is open, so rays through it may
+			// miss.
+			var targets []Vec3
+			targets = append(targets, center)
+			for _, tri := range triangles {
+				edge := tri[1].Sub(center)
+				targets = append(targets, center.Add(edge.Scale(0.5)), center.Add(edge.Scale(rng.Float32())))
+			}
+			for _, target := range targets {
+				for range 20 {
+					origin := target.Add(randomDirection(rng).Scale(scale * (0.1 + 10*rng.Float32())))
+					direction := target.Sub(origin)
+					unit := direction.Normalize()
+					if math32.Abs(unit.Dot(plane.u.Cross(plane.v))) < 0.05 {
+						continue // Grazing the plane, the ray may miss the fan's rim.
+					}
+					hits := 0
+					for _, tri := range triangles {
+						if hit, _ := IntersectSegmentTriangle(origin, direction, 2, tri[0], tri[1], tri[2]); hit {
+							hits++
+						}
+					}
+					if hits == 0 {
+						t.Fatalf("scale %g: ray from %v through %v slips through a fan of %d triangles",
+							scale, origin, target, len(triangles))
+					}
+				}
+			}
+		}
+	}
+}
+
+// A ray leaving a triangle from an offset origin never hits it again, at
+// any distance from the world origin and however it leaves.
+func TestOffsetRayOrigin(t *testing.T) {
+	rng := rand.New(rand.NewPCG(17, 18))
+	for _, magnitude := range []float32{0, 1e-3, 1, 1e4} {
+		for range 2000 {
+			center := randomDirection(rng).Scale(magnitude * (0.5 + rng.Float32()))
+			size := max(magnitude, 1) * (0.01 + rng.Float32())
+			a := center.Add(randomDirection(rng).Scale(size))
+			b := center.Add(randomDirection(rng).Scale(size))
+			c := center.Add(randomDirection(rng).Scale(size))
+			n := b.Sub(a).Cross(c.Sub(a))
+			if n.Length() < 1e-6*size*size {
+				continue
+			}
+			n = n.Normalize()
+
+			// A point on the triangle, with the rounding of a hit point.
+			s, r := rng.Float32(), rng.Float32()
+			if s+r > 1 {
+				s, r = 1-s, 1-r
+			}
+			p := a.Add(b.Sub(a).Scale(s)).Add(c.Sub(a).Scale(r))
+
+			dir := randomDirection(rng)
+			if rng.IntN(4) == 0 {
+				// Grazing.
+				dir = dir.Sub(n.Scale(dir.Dot(n) * (1 - 1e-3))).Normalize()
+			}
+			origin := OffsetRayOrigin(p, n, dir)
+			if hit, tHit := IntersectSegmentTriangle(origin, dir, 1e30, a, b, c); hit {
+				t.Fatalf("magnitude %g: ray from %v (offset from %v) along %v hits its own triangle at %g",
+					magnitude, origin, p, dir, tHit)
+			}
+			if FastIntersectShadowTriangle(origin, dir, 1e30, a, b, c) {
+				t.Fatalf("magnitude %g: shadow ray from %v along %v hits its own triangle", magnitude, origin, dir)
+			}
+
+			// The offset stays within rounding distance of the surface.
+			if moved, bound := origin.Sub(p).Length(), max(1e-4*(center.Length()+size), 2*offsetNearOrigin); moved > bound {
+				t.Fatalf("magnitude %g: origin moved by %g, more than %g", magnitude, moved, bound)
+			}
+			if offset := origin.Sub(p); offset.Dot(n)*dir.Dot(n) < 0 {
+				t.Fatalf("magnitude %g: origin moved to the other side of the triangle from %v", magnitude, dir)
+			}
+		}
+	}
+}
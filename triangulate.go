@@ -0,0 +1,111 @@
+package main
+
+import "github.com/chewxy/math32"
+
+// triangulate splits the polygon with the given corners into triangles of
+// corner indices, wound like the polygon. Convex polygons are fanned from
+// their first corner; concave ones are cut up by ear clipping, in the plane
+// that best fits the corners, so slightly non-planar faces work too.
+// Degenerate polygons are fanned.
+func triangulate(corners []Vec3) [][3]int {
+	n := len(corners)
+	if n < 3 {
+		return nil
+	}
+	fan := func() [][3]int {
+		tris := make([][3]int, 0, n-2)
+		for i := 1; i+1 < n; i++ {
+			tris = append(tris, [3]int{0, i, i + 1})
+		}
+		return tris
+	}
+	if n == 3 {
+		return fan()
+	}
+
+	// Newell's method: the normal of the plane fitting the polygon, whose
+	// corners run counter-clockwise around it.
+	var normal Vec3
+	for i, p := range corners {
+		q := corners[(i+1)%n]
+		normal.X += (p.Y - q.Y) * (p.Z + q.Z)
+		normal.Y += (p.Z - q.Z) * (p.X + q.X)
+		normal.Z += (p.X - q.X) * (p.Y + q.Y)
+	}
+	if normal.Length() == 0 {
+		return fan()
+	}
+
+	// Work in 2D, dropping the axis the normal is largest along and
+	// flipping the result so the polygon runs counter-clockwise.
+	u, v, axis := 1, 2, 0
+	if math32.Abs(normal.Y) > math32.Abs(normal.axis(axis)) {
+		u, v, axis = 2, 0, 1
+	}
+	if math32.Abs(normal.Z) > math32.Abs(normal.axis(axis)) {
+		u, v, axis = 0, 1, 2
+	}
+	points := make([][2]float32, n)
+	for i, p := range corners {
+		points[i] = [2]float32{p.axis(u), p.axis(v)}
+		if normal.axis(axis) < 0 {
+			points[i][0], points[i][1] = points[i][1], points[i][0]
+		}
+	}
+	cross := func(a, b, c [2]float32) float32 {
+		return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	}
+
+	convex := true
+	for i := range n {
+		if cross(points[i], points[(i+1)%n], points[(i+2)%n]) < 0 {
+			convex = false
+			break
+		}
+	}
+	if convex {
+		return fan()
+	}
+
+	// Ear clipping: cut off a convex corner whose triangle holds no other
+	// corner, until a triangle is left.
+	remaining := make([]int, n)
+	for i := range remaining {
+		remaining[i] = i
+	}
+	tris := make([][3]int, 0, n-2)
+	for len(remaining) > 3 {
+		m := len(remaining)
+		ear := -1
+		for i := range m {
+			a, b, c := remaining[(i+m-1)%m], remaining[i], remaining[(i+1)%m]
+			if cross(points[a], points[b], points[c]) <= 0 {
+				continue
+			}
+			inside := false
+			for _, p := range remaining {
+				if p == a || p == b || p == c {
+					continue
+				}
+				if cross(points[a], points[b], points[p]) >= 0 &&
+					cross(points[b], points[c], points[p]) >= 0 &&
+					cross(points[c], points[a], points[p]) >= 0 {
+					inside = true
+					break
+				}
+			}
+			if !inside {
+				ear = i
+				break
+			}
+		}
+		if ear < 0 {
+			// Self-intersecting or collinear corners leave no ear; cut the
+			// first corner anyway so every corner ends up in a triangle.
+			ear = 0
+		}
+		tris = append(tris, [3]int{remaining[(ear+m-1)%m], remaining[ear], remaining[(ear+1)%m]})
+		remaining = append(remaining[:ear], remaining[ear+1:]...)
+	}
+	return append(tris, [3]int{remaining[0], remaining[1], remaining[2]})
+}
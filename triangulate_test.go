@@ -0,0 +1,87 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+// inPlane maps 2D corners onto the plane spanned by u and v, so the
+// polygon faces u × v when the corners run counter-clockwise.
+func inPlane(corners [][2]float32, u, v Vec3) []Vec3 {
+	out := make([]Vec3, len(corners))
+	for i, c := range corners {
+		out[i] = u.Scale(c[0]).Add(v.Scale(c[1]))
+	}
+	return out
+}
+
+func TestTriangulate(t *testing.T) {
+	// An L with its notch at the top right, counter-clockwise; area 3.
+	l := [][2]float32{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+	reversed := slices.Clone(l)
+	slices.Reverse(reversed)
+	x, y, z := Vec3{X: 1}, Vec3{Y: 1}, Vec3{Z: 1}
+
+	tests := []struct {
+		name    string
+		corners []Vec3
+		area    float32
+	}{
+		{"triangle", inPlane([][2]float32{{0, 0}, {1, 0}, {0, 1}}, x, y), 0.5},
+		{"convex pentagon", inPlane([][2]float32{{0, 0}, {2, 0}, {3, 1}, {1, 3}, {-1, 1}}, x, y), 7},
+		{"concave L", inPlane(l, x, y), 3},
+		{"clockwise L", inPlane(reversed, x, y), 3},
+		{"L facing -x", inPlane(l, z, y), 3},
+		{"L facing -y", inPlane(l, x, z), 3},
+		{"L facing -z", inPlane(l, y, x), 3},
+		{"clockwise L facing -x", inPlane(reversed, z, y), 3},
+		{"arrow", inPlane([][2]float32{{0, 0}, {2, 1}, {4, 0}, {2, 3}}, x, z), 4},
+		{"non-planar quad", []Vec3{{}, {X: 1}, {X: 1, Y: 1, Z: 0.05}, {Y: 1}}, 1},
+		{"non-planar concave", []Vec3{{}, {X: 2}, {X: 2, Y: 1, Z: 0.02}, {X: 1, Y: 1}, {X: 1, Y: 2, Z: -0.02}, {Y: 2}}, 3},
+		{"collinear corner", inPlane([][2]float32{{0, 0}, {1, 0}, {2, 0}, {2, 2}, {0, 2}}, x, y), 4},
+		{"collinear corner in an L", inPlane([][2]float32{{0, 0}, {1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}, x, y), 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tris := triangulate(test.corners)
+			n := len(test.corners)
+			if len(tris) != n-2 {
+				t.Fatalf("%d triangles, want %d: %v", len(tris), n-2, tris)
+			}
+
+			// The polygon's own normal, by Newell's method.
+			var normal Vec3
+			for i, p := range test.corners {
+				q := test.corners[(i+1)%n]
+				normal = normal.Add(p.Cross(q))
+			}
+			normal = normal.Normalize()
+
+			var area float32
+			for _, tri := range tris {
+				a, b, c := test.corners[tri[0]], test.corners[tri[1]], test.corners[tri[2]]
+				cross := b.Sub(a).Cross(c.Sub(a))
+				if cross.Dot(normal) < -1e-6 {
+					t.Errorf("triangle %v is wound against the polygon", tri)
+				}
+				area += cross.Length() / 2
+			}
+			if math32.Abs(area-test.area) > 0.01*test.area {
+				t.Errorf("triangles cover %g, want %g", area, test.area)
+			}
+		})
+	}
+}
+
+func TestTriangulateDegenerate(t *testing.T) {
+	if tris := triangulate([]Vec3{{}, {X: 1}}); tris != nil {
+		t.Errorf("two corners give %v, want nothing", tris)
+	}
+	// All corners on a line: no plane to work in, so the polygon is fanned.
+	line := []Vec3{{}, {X: 1}, {X: 2}, {X: 3}}
+	if tris := triangulate(line); len(tris) != 2 {
+		t.Errorf("collinear corners give %v, want a fan of 2", tris)
+	}
+}
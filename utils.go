@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"math"
-	"math/rand"
 
 	"github.com/chewxy/math32"
 )
@@ -60,70 +59,6 @@ func IntersectSegmentTriangle1(origin, direction Vec3, stepSize float32, A, B, C
 	return false, 0
 }
 
-func IntersectSegmentTriangle(origin, direction Vec3, stepSize float32, A, B, C Vec3) (bool, float32) {
-	const EPSILON = 1e-6 // Increased precision for better accuracy
-
-	// Normalize direction vector to ensure consistent distance calculations
-	// direction := dir
-
-	// Find vectors for two edges sharing vertex A.
-	edge1 := B.Sub(A)
-	edge2 := C.Sub(A)
-
-	// Step 1: Calculate the determinant.
-	// This involves a vector triple product. If the determinant is near zero,
-	// the ray is parallel to the plane of the triangle.
-	// pvec := direction.Cross(edge2)
-	pvec := Vec3{
-		X: direction.Y*edge2.Z - direction.Z*edge2.Y,
-		Y: direction.Z*edge2.X - direction.X*edge2.Z,
-		Z: direction.X*edge2.Y - direction.Y*edge2.X,
-	}
-	determinant := edge1.Dot(pvec)
-
-	// If the determinant is close to 0, the ray lies in the plane of the triangle or is parallel to it.
-	if math32.Abs(determinant) < EPSILON {
-		return false, 0
-	}
-	invDeterminant := 1.0 / determinant
-
-	// Step 2: Calculate the first barycentric coordinate (u).
-	// This checks if the intersection point is between the C-A and A-B edges.
-	tvec := origin.Sub(A)
-	u := tvec.Dot(pvec) * invDeterminant
-
-	// Check the u-bound with small tolerance for floating-point errors
-	if u < -EPSILON || u > 1.0+EPSILON {
-		return false, 0
-	}
-
-	// Step 3: Calculate the second barycentric coordinate (v).
-	// This checks if the intersection point is between the A-B and B-C edges.
-	// qvec := tvec.Cross(edge1)
-	qvec := Vec3{
-		X: tvec.Y*edge1.Z - tvec.Z*edge1.Y,
-		Y: tvec.Z*edge1.X - tvec.X*edge1.Z,
-		Z: tvec.X*edge1.Y - tvec.Y*edge1.X,
-	}
-	v := direction.Dot(qvec) * invDeterminant
-
-	// Check the v-bound and the u+v bound with tolerance
-	if v < -EPSILON || u+v > 1.0+EPSILON {
-		return false, 0
-	}
-
-	// Step 4: Calculate t, the distance from the ray origin to the intersection point.
-	t := edge2.Dot(qvec) * invDeterminant
-
-	// Step 5: The final and crucial check for a LINE SEGMENT.
-	// We confirm that the intersection point 't' lies within the segment's length.
-	// It must be a forward intersection (t > EPSILON) and within the stepSize.
-	if t <= EPSILON || t > stepSize {
-		return false, 0
-	}
-	return true, t
-}
-
 func InterpolateNormal(p, a, b, c Vec3, nA, nB, nC Vec3) Vec3 {
 	v0 := b.Sub(a)
 	v1 := c.Sub(a)
@@ -214,9 +149,9 @@ func InterpolateNormal2(p, a, b, c Vec3, nA, nB, nC Vec3) Vec3 {
 	return interpolatedNormal.Normalize()
 }
 
-func SampleTrianglePoint(A, B, C Vec3) Vec3 {
-	u := rand.Float32()
-	v := rand.Float32() * (1 - u)
+func SampleTrianglePoint(sampler Sampler, A, B, C Vec3) Vec3 {
+	u, v := sampler.Get2D()
+	v *= 1 - u
 	w := 1 - u - v
 
 	A._Scale(w)
@@ -239,7 +174,7 @@ func Clamp01(val float32) float32 {
 	return max(0, min(1, val))
 }
 
-func GetCosineWeighedHemisphereSampling(normal Vec3) Vec3 {
+func GetCosineWeighedHemisphereSampling(sampler Sampler, normal Vec3) Vec3 {
 	var up Vec3
 	if math32.Abs(normal.Y) < 0.999 {
 		up = Vec3{X: 0, Y: 1, Z: 0}
@@ -252,8 +187,7 @@ func GetCosineWeighedHemisphereSampling(normal Vec3) Vec3 {
 
 	tangent2 := normal.Cross(tangent1)
 
-	u1 := rand.Float32()
-	u2 := rand.Float32()
+	u1, u2 := sampler.Get2D()
 
 	r := math32.Sqrt(u1)
 	theta := 2 * math.Pi * u2
@@ -270,9 +204,8 @@ func GetCosineWeighedHemisphereSampling(normal Vec3) Vec3 {
 	return dir
 }
 
-func GetCosineWeighedHemisphereSampling2(normal, tangent1, tangent2 Vec3) Vec3 {
-	u1 := rand.Float32()
-	u2 := rand.Float32()
+func GetCosineWeighedHemisphereSampling2(sampler Sampler, normal, tangent1, tangent2 Vec3) Vec3 {
+	u1, u2 := sampler.Get2D()
 
 	r := math32.Sqrt(u1)
 	theta := 2 * math.Pi * u2
@@ -92,17 +92,10 @@ func (v *Vec3) _Cross(other Vec3) {
 	v.Z = newZ
 }
 
+// ToRGBA clips the colour to [0, 1] and sRGB encodes it. Use a ToneMapping
+// for rendered radiance.
 func (v Vec3) ToRGBA() color.RGBA {
-	r := Clamp01(math32.Sqrt(max(0.0, v.X))) * 255
-	g := Clamp01(math32.Sqrt(max(0.0, v.Y))) * 255
-	b := Clamp01(math32.Sqrt(max(0.0, v.Z))) * 255
-
-	return color.RGBA{
-		R: uint8(r),
-		G: uint8(g),
-		B: uint8(b),
-		A: 255,
-	}
+	return ToneMapping{Operator: LinearToneMapper{}}.Apply(v)
 }
 
 func (v Vec3) ComponentMul(other Vec3) Vec3 {
@@ -3,6 +3,12 @@ package main
 type EmissiveTriangle struct {
 	VertexIndices, NormalIndices [3]int
 	MaterialIndex                int
+
+	// Primitive is set for emissive primitives, which have no vertices.
+	Primitive Primitive
+
+	// Instance places the triangle, whose vertices are in object space.
+	Instance *Instance
 }
 
 type VNMU struct {
@@ -10,4 +16,120 @@ type VNMU struct {
 	Materials         []*Material
 	UVs               []float32
 	EmissiveTriangles []EmissiveTriangle
+
+	// Per triangle, for the object and material AOVs. Mesh triangles take
+	// their object from the instance they are hit through.
+	ObjectIDs, MaterialIDs []int32
+}
+
+// Material returns the material of the triangle or primitive hit, taking
+// instance overrides into account.
+func (vnmu *VNMU) Material(tri *BVHTriangle) *Material {
+	if tri.Instance != nil && tri.Instance.Material != nil {
+		return tri.Instance.Material
+	}
+	return vnmu.Materials[tri.Index/3]
+}
+
+func (vnmu *VNMU) MaterialID(tri *BVHTriangle) int32 {
+	if tri.Instance != nil && tri.Instance.Material != nil {
+		return tri.Instance.MaterialID
+	}
+	return vnmu.MaterialIDs[tri.Index/3]
+}
+
+func (vnmu *VNMU) ObjectID(tri *BVHTriangle) int32 {
+	if tri.Instance != nil {
+		return tri.Instance.ObjectID
+	}
+	return vnmu.ObjectIDs[tri.Index/3]
+}
+
+// EmitterMaterial is Material for an emissive triangle or primitive.
+func (vnmu *VNMU) EmitterMaterial(emitter *EmissiveTriangle) *Material {
+	if emitter.Instance != nil && emitter.Instance.Material != nil {
+		return emitter.Instance.Material
+	}
+	return vnmu.Materials[emitter.MaterialIndex]
+}
+
+// ShadingNormal returns the normal at p on the triangle or primitive hit,
+// not normalised.
+func (vnmu *VNMU) ShadingNormal(tri *BVHTriangle, p Vec3) Vec3 {
+	if tri.Primitive != nil {
+		normal, _, _ := tri.Primitive.Surface(p)
+		return normal
+	}
+	normal := InterpolateNormal(
+		p,
+		tri.A,
+		tri.B,
+		tri.C,
+		vnmu.Normals[tri.Index],
+		vnmu.Normals[tri.Index+1],
+		vnmu.Normals[tri.Index+2],
+	)
+	if tri.Instance != nil {
+		// The barycentric weights are the same in world and object space.
+		normal = tri.Instance.NormalToWorld(normal)
+	}
+	return normal
+}
+
+// TexCoords returns the texture coordinates at p, wrapped to [0, 1).
+func (vnmu *VNMU) TexCoords(tri *BVHTriangle, p Vec3) (float32, float32) {
+	if tri.Primitive != nil {
+		_, u, v := tri.Primitive.Surface(p)
+		return tile(u), tile(v)
+	}
+
+	triangleIndex := tri.Index / 3
+	baseUVIndex := triangleIndex * 6
+
+	uv0_x, uv0_y := vnmu.UVs[baseUVIndex], vnmu.UVs[baseUVIndex+1]
+	uv1_x, uv1_y := vnmu.UVs[baseUVIndex+2], vnmu.UVs[baseUVIndex+3]
+	uv2_x, uv2_y := vnmu.UVs[baseUVIndex+4], vnmu.UVs[baseUVIndex+5]
+
+	// Calculate barycentric coordinates
+	v0 := tri.B.Sub(tri.A)
+	v1 := tri.C.Sub(tri.A)
+	v2 := p.Sub(tri.A)
+
+	dot00 := v0.Dot(v0)
+	dot01 := v0.Dot(v1)
+	dot02 := v0.Dot(v2)
+	dot11 := v1.Dot(v1)
+	dot12 := v1.Dot(v2)
+
+	invDenom := 1.0 / (dot00*dot11 - dot01*dot01)
+	u := (dot11*dot02 - dot01*dot12) * invDenom
+	v := (dot00*dot12 - dot01*dot02) * invDenom
+	w := 1.0 - u - v
+
+	return tile(w*uv0_x + u*uv1_x + v*uv2_x), tile(w*uv0_y + u*uv1_y + v*uv2_y)
+}
+
+// SampleEmitter picks a point on the emissive triangle or primitive,
+// returning it with its normal and the emitter's area.
+func (vnmu *VNMU) SampleEmitter(sampler Sampler, emitter *EmissiveTriangle) (point, normal Vec3, area float32) {
+	if emitter.Primitive != nil {
+		point, normal = emitter.Primitive.Sample(sampler.Get2D())
+		return point, normal, emitter.Primitive.Area()
+	}
+
+	a := vnmu.Vertices[emitter.VertexIndices[0]]
+	b := vnmu.Vertices[emitter.VertexIndices[1]]
+	c := vnmu.Vertices[emitter.VertexIndices[2]]
+	instance := emitter.Instance
+	if instance != nil {
+		a, b, c = instance.ObjectToWorld.Point(a), instance.ObjectToWorld.Point(b), instance.ObjectToWorld.Point(c)
+	}
+
+	n0, n1, n2 := emitter.NormalIndices[0], emitter.NormalIndices[1], emitter.NormalIndices[2]
+	point = SampleTrianglePoint(sampler, a, b, c)
+	normal = InterpolateNormal(point, a, b, c, vnmu.Normals[n0], vnmu.Normals[n1], vnmu.Normals[n2])
+	if instance != nil && !instance.identity {
+		normal = instance.NormalToWorld(normal).Normalize()
+	}
+	return point, normal, TriangleArea(a, b, c)
 }
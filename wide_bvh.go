@@ -0,0 +1,210 @@
+package main
+
+import "slices"
+
+// WideBVH is a LinearBVH's tree collapsed into nodes of Width children,
+// which cuts the depth of a traversal by a factor of two or three and
+// tests the children of a node in one loop. The children are stored
+// structure of arrays: the values of node i's children are at
+// [i*Width, (i+1)*Width) of each slice. Leaves refer to the entries of
+// the LinearBVH, whose order is unchanged.
+type WideBVH struct {
+	Width int
+
+	MinX, MinY, MinZ []float32
+	MaxX, MaxY, MaxZ []float32
+
+	// Child is the node of an inner child, or the first entry of a leaf.
+	// Count is the number of entries of a leaf, innerNode for inner
+	// children and 0 for unused slots, whose bounds are empty.
+	Child, Count []uint32
+}
+
+// Collapse builds the wide tree of width 4 or 8; a width of 2 removes it
+// and traversal uses the binary tree. So does a tree that is a single
+// leaf, which has nothing to collapse.
+func (box *LinearBVH) Collapse(width int) {
+	box.Wide = nil
+	if width <= 2 || len(box.Nodes) == 0 || box.Nodes[0].IsLeaf() {
+		return
+	}
+	wide := &WideBVH{Width: width}
+	wide.collapse(box.Nodes, 0)
+	box.Wide = wide
+}
+
+// collapse adds the wide node for the binary inner node at ptr and
+// returns its number.
+func (w *WideBVH) collapse(nodes []LinearBVHNode, ptr uint32) uint32 {
+	children := []uint32{ptr + 1, nodes[ptr].Offset}
+	// Open the largest inner child until the node is full.
+	for len(children) < w.Width {
+		largest, area := -1, float32(-1)
+		for i, child := range children {
+			if !nodes[child].IsLeaf() && nodes[child].Area() > area {
+				largest, area = i, nodes[child].Area()
+			}
+		}
+		if largest < 0 {
+			break
+		}
+		child := children[largest]
+		children[largest] = child + 1
+		children = slices.Insert(children, largest+1, nodes[child].Offset)
+	}
+
+	n := uint32(len(w.Child) / w.Width)
+	for range w.Width {
+		w.MinX, w.MinY, w.MinZ = append(w.MinX, INF), append(w.MinY, INF), append(w.MinZ, INF)
+		w.MaxX, w.MaxY, w.MaxZ = append(w.MaxX, -INF), append(w.MaxY, -INF), append(w.MaxZ, -INF)
+		w.Child, w.Count = append(w.Child, 0), append(w.Count, 0)
+	}
+	for i, child := range children {
+		node := &nodes[child]
+		slot := int(n)*w.Width + i
+		w.MinX[slot], w.MinY[slot], w.MinZ[slot] = node.MinBounds.X, node.MinBounds.Y, node.MinBounds.Z
+		w.MaxX[slot], w.MaxY[slot], w.MaxZ[slot] = node.MaxBounds.X, node.MaxBounds.Y, node.MaxBounds.Z
+		if node.IsLeaf() {
+			w.Child[slot], w.Count[slot] = node.Offset, node.Count
+		} else {
+			w.Count[slot] = innerNode
+			w.Child[slot] = w.collapse(nodes, child)
+		}
+	}
+	return n
+}
+
+// intersectChildren stores the entry distance of each child of node in
+// dist, INF for those the ray misses within stepSize.
+func (w *WideBVH) intersectChildren(node uint32, q *rayQuery, stepSize float32, dist *[8]float32) {
+	base := int(node) * w.Width
+	end := base + w.Width
+	// Pick the near and far planes per axis once for all children.
+	nearX, farX := w.MinX[base:end], w.MaxX[base:end]
+	if q.Negative[0] {
+		nearX, farX = farX, nearX
+	}
+	nearY, farY := w.MinY[base:end], w.MaxY[base:end]
+	if q.Negative[1] {
+		nearY, farY = farY, nearY
+	}
+	nearZ, farZ := w.MinZ[base:end], w.MaxZ[base:end]
+	if q.Negative[2] {
+		nearZ, farZ = farZ, nearZ
+	}
+	farX, nearY, farY, nearZ, farZ = farX[:len(nearX)], nearY[:len(nearX)], farY[:len(nearX)], nearZ[:len(nearX)], farZ[:len(nearX)]
+
+	o, inv := q.Origin, q.InverseDirection
+	for i := range nearX {
+		tMin := max((nearX[i]-o.X)*inv.X, (nearY[i]-o.Y)*inv.Y, (nearZ[i]-o.Z)*inv.Z)
+		tMax := min((farX[i]-o.X)*inv.X, (farY[i]-o.Y)*inv.Y, (farZ[i]-o.Z)*inv.Z)
+		if tMin > min(tMax, stepSize) || tMax < 0 {
+			dist[i&7] = INF
+		} else {
+			dist[i&7] = max(0, tMin)
+		}
+	}
+}
+
+// wideEntry is a child slot waiting on the traversal stack, with the
+// distance at which the ray enters it.
+type wideEntry struct {
+	slot uint32
+	dist float32
+}
+
+// wideStackSize is enough for all but degenerate trees; the stack grows
+// on the heap beyond it.
+const wideStackSize = 64
+
+// nearest records in h hits on the entries of box, whose ranks start at
+// base. Children, leaves and inner nodes alike, are visited nearest first
+// and skipped once a closer hit is known.
+func (w *WideBVH) nearest(box *LinearBVH, q *rayQuery, base uint64, h *nearestHit) {
+	var buf [wideStackSize]wideEntry
+	stack := buf[:0]
+
+	var dist [8]float32
+	var order [8]int
+	node := uint32(0)
+	q.cost.Nodes++
+	for {
+		w.intersectChildren(node, q, h.t, &dist)
+
+		// Sort the children that were hit by distance.
+		hits := 0
+		for i := range w.Width {
+			if dist[i] == INF {
+				continue
+			}
+			j := hits
+			for j > 0 && dist[order[j-1]] > dist[i] {
+				order[j] = order[j-1]
+				j--
+			}
+			order[j] = i
+			hits++
+		}
+		first := uint32(node) * uint32(w.Width)
+		for k := hits - 1; k > 0; k-- {
+			stack = append(stack, wideEntry{slot: first + uint32(order[k]), dist: dist[order[k]]})
+		}
+
+		// Go straight on to the nearest child, then test leaves until
+		// the next inner node.
+		if hits > 0 {
+			slot := first + uint32(order[0])
+			q.cost.Nodes++
+			if w.Count[slot] == innerNode {
+				node = w.Child[slot]
+				continue
+			}
+			box.intersectLeaf(q, w.Child[slot], w.Count[slot], base, h)
+		}
+		for {
+			if len(stack) == 0 {
+				return
+			}
+			entry := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if entry.dist > h.t {
+				continue
+			}
+			q.cost.Nodes++
+			if w.Count[entry.slot] == innerNode {
+				node = w.Child[entry.slot]
+				break
+			}
+			box.intersectLeaf(q, w.Child[entry.slot], w.Count[entry.slot], base, h)
+		}
+	}
+}
+
+// occludes reports whether any entry of box lies on the ray within
+// stepSize.
+func (w *WideBVH) occludes(box *LinearBVH, q *rayQuery, stepSize float32) bool {
+	var buf [wideStackSize]uint32
+	stack := append(buf[:0], 0)
+
+	var dist [8]float32
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		w.intersectChildren(node, q, stepSize, &dist)
+
+		base := int(node) * w.Width
+		for i := range w.Width {
+			if dist[i] == INF {
+				continue
+			}
+			if count := w.Count[base+i]; count != innerNode {
+				if box.occludesLeaf(q, w.Child[base+i], count, stepSize) {
+					return true
+				}
+			} else {
+				stack = append(stack, w.Child[base+i])
+			}
+		}
+	}
+	return false
+}